@@ -1,17 +1,27 @@
 package main
 
 import (
+	"context"
 	"encoding/xml"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
-	"text/tabwriter"
+	"time"
 
 	"csvql"
+	"csvql/db"
+	"csvql/loader"
+	"csvql/output"
+	"csvql/server"
 
 	"github.com/google/uuid"
 )
@@ -21,17 +31,94 @@ func main() {
 		dir       = flag.String("dir", ".", "Directory to scan for CSV/TSV files")
 		dbPath    = flag.String("db", "", "SQLite database path (default: .csvql.db in target dir)")
 		query     = flag.String("q", "", "Execute a single query and exit")
+		format    = flag.String("format", "table", "Output format for -q: "+strings.Join(output.Formats(), "|"))
+		outFile   = flag.String("o", "", "Write -q results to a file instead of stdout")
+		header    = flag.Bool("header", true, "Include column names in query output")
 		jetbrains = flag.Bool("jetbrains", false, "Create JetBrains IDE datasource configuration")
+		jsonDepth = flag.Int("json-depth", 0, "Levels of nested JSON objects flattened into parent_child columns (0 = all)")
+		addr      = flag.String("addr", ":8080", "Address the serve command listens on")
+		timeout   = flag.Duration("timeout", server.DefaultTimeout, "Maximum duration of a query run by the serve command or over -pg")
+		pgAddr    = flag.String("pg", "", "Also serve the tables over the PostgreSQL wire protocol on this `address` (e.g. :5432)")
+		pgPass    = flag.String("pg-password", os.Getenv("CSVQL_PG_PASSWORD"), "Password required by -pg (default $CSVQL_PG_PASSWORD, none if empty)")
+		strict    = flag.Bool("strict", false, "Fail a file on its first malformed row instead of recording it in _csvql_errors")
+		writes    = flag.Bool("allow-writes", false, "Allow queries, including over HTTP and -pg, to modify the database (edits are lost on the next reload unless -write-back)")
+		writeBack = flag.Bool("write-back", false, "Write tables edited in the database back to their CSV/TSV files")
+		encodings = encodingFlag{}
+		unions    unionFlag
+		includes  patternFlag
+		excludes  patternFlag
+		headers   = headerFlag{}
+		columns   = columnsFlag{}
 	)
-	flag.Parse()
+	flag.Var(&unions, "union", "Load all files matching a pattern into one table, as `table=pattern` (repeatable; e.g. sales=sales/*.csv)")
+	flag.Var(&includes, "include", "Only load files matching a `pattern` (repeatable; .csvqlignore syntax, e.g. data/**/*.csv)")
+	flag.Var(&excludes, "exclude", "Skip files and directories matching a `pattern` (repeatable; .csvqlignore syntax, e.g. node_modules/)")
+	flag.Var(headers, "input-header", "Whether files start with a header row instead of detecting it, as `[pattern=]true|false` (repeatable)")
+	flag.Var(columns, "columns", "Name the columns of files, as `[pattern=]name,name,...` (repeatable; e.g. dump/*.csv=id,name,amount)")
+	flag.Var(encodings, "encoding", "Force the encoding of files, as `[pattern=]name` (repeatable; e.g. cp1252 or legacy/*.csv=latin1)")
+	flag.Usage = usage
+
+	// "csvql serve [flags]" runs the HTTP server instead of the shell
+	args := os.Args[1:]
+	serve := len(args) > 0 && args[0] == "serve"
+	if serve {
+		args = args[1:]
+	}
+	flag.CommandLine.Parse(args)
+	if serve && *query != "" {
+		fmt.Fprintln(os.Stderr, "Error: serve does not take -q")
+		os.Exit(1)
+	}
+	if *pgAddr != "" && *query != "" {
+		fmt.Fprintln(os.Stderr, "Error: -pg does not take -q")
+		os.Exit(1)
+	}
+
+	// -o results.parquet implies -format parquet unless a format is given
+	formatSet := false
+	flag.Visit(func(f *flag.Flag) { formatSet = formatSet || f.Name == "format" })
+	if !formatSet && strings.EqualFold(filepath.Ext(*outFile), ".parquet") {
+		*format = "parquet"
+	}
+
+	if _, err := output.New(*format, io.Discard, output.Options{}); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if output.IsBinary(*format) && (*query == "" || *outFile == "") {
+		fmt.Fprintf(os.Stderr, "Error: -format %s needs -q and -o\n", *format)
+		os.Exit(1)
+	}
+
+	// Set once csvql.New returns, so events of the watcher can report counts
+	var current atomic.Pointer[csvql.CSVQL]
 
 	opts := csvql.Options{
 		RootDir: *dir,
 		DBPath:  *dbPath,
-		Watch:   true,
+		Watch:   *query == "",
 		OnChange: func(event, path string) {
+			if event == "ERRORS" {
+				// The banner reports the bad rows of the initial scan
+				if c := current.Load(); c != nil {
+					n, _ := c.DB.FileRowErrorCount(path)
+					fmt.Printf("[ERRORS] %s: %d malformed row(s), see _csvql_errors\n", path, n)
+				}
+				return
+			}
 			fmt.Printf("[%s] %s\n", event, path)
 		},
+		OnProgress:  progressPrinter(os.Stderr),
+		Encodings:   encodings,
+		Unions:      unions,
+		JSONDepth:   *jsonDepth,
+		Include:     includes,
+		Exclude:     excludes,
+		Headers:     headers,
+		Columns:     columns,
+		Strict:      *strict,
+		AllowWrites: *writes,
+		WriteBack:   *writeBack,
 	}
 
 	c, err := csvql.New(opts)
@@ -40,6 +127,27 @@ func main() {
 		os.Exit(1)
 	}
 	defer c.Close()
+	current.Store(c)
+
+	// Single query mode: results only, so the output can be piped
+	if *query != "" {
+		out := io.Writer(os.Stdout)
+		if *outFile != "" {
+			f, err := os.Create(*outFile)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			defer f.Close()
+			out = f
+		}
+		if err := executeQuery(c, *query, out, *format, output.Options{Header: *header}); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", queryError(err))
+			c.Close()
+			os.Exit(1)
+		}
+		return
+	}
 
 	// List loaded tables
 	tables, err := c.ListTables()
@@ -50,10 +158,15 @@ func main() {
 
 	fmt.Printf("CSVQL - CSV/TSV to SQLite\n")
 	fmt.Printf("Database: %s\n", c.DBPath)
+	badRows, _ := c.RowErrorCounts()
 	fmt.Printf("Loaded %d table(s):\n", len(tables))
 	for _, t := range tables {
 		cols, _ := c.GetTableInfo(t)
-		fmt.Printf("  - %s (%d columns)\n", t, len(cols))
+		if n := badRows[t]; n > 0 {
+			fmt.Printf("  - %s (%d columns, %d bad rows)\n", t, len(cols), n)
+		} else {
+			fmt.Printf("  - %s (%d columns)\n", t, len(cols))
+		}
 	}
 	fmt.Println()
 
@@ -64,9 +177,30 @@ func main() {
 		}
 	}
 
-	// Single query mode
-	if *query != "" {
-		executeQuery(c, *query)
+	// PostgreSQL endpoint, alongside any of the modes below
+	if *pgAddr != "" {
+		pg, err := startPostgres(c, *pgAddr, *pgPass, *timeout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			c.Close()
+			os.Exit(1)
+		}
+		defer pg.Close()
+	}
+
+	// Server mode - HTTP API while the watcher keeps tables in sync
+	if serve {
+		if err := runServer(c, *addr, *timeout); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			c.Close()
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Interactive mode - SQL shell while the watcher keeps tables in sync
+	if isTerminal() {
+		runREPL(c, *format, *header)
 		return
 	}
 
@@ -81,38 +215,183 @@ func main() {
 	fmt.Println("\nStopping...")
 }
 
-func executeQuery(c *csvql.CSVQL, query string) {
-	columns, rows, err := c.Query(query)
+func executeQuery(c *csvql.CSVQL, query string, out io.Writer, format string, opts output.Options) error {
+	w, err := output.New(format, out, opts)
 	if err != nil {
-		fmt.Printf("Error: %v\n", err)
-		return
+		return err
 	}
 
-	if len(rows) == 0 {
-		fmt.Println("(no results)")
-		return
+	rows, err := c.QueryContext(context.Background(), query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if tw, ok := w.(output.TypedWriter); ok {
+		tw.SetColumnTypes(rows.ColumnTypes())
+	}
+	if err := w.WriteHeader(rows.Columns()); err != nil {
+		return err
+	}
+	for values, err := range rows.All() {
+		if err != nil {
+			return err
+		}
+		if err := w.WriteRow(values); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+// queryError explains how to run a statement refused as a write
+func queryError(err error) error {
+	if errors.Is(err, db.ErrReadOnly) {
+		return fmt.Errorf("%w (queries are read-only; run with -allow-writes to modify the database)", err)
+	}
+	return err
+}
+
+// encodingFlag collects -encoding overrides keyed by file pattern; a bare
+// encoding name applies to every file
+type encodingFlag map[string]string
+
+func (e encodingFlag) String() string {
+	var parts []string
+	for pattern, name := range e {
+		parts = append(parts, pattern+"="+name)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (e encodingFlag) Set(value string) error {
+	pattern, name, ok := strings.Cut(value, "=")
+	if !ok {
+		pattern, name = "*", value
+	}
+	if _, err := loader.NormalizeEncoding(name); err != nil {
+		return err
+	}
+	e[pattern] = name
+	return nil
+}
+
+// headerFlag collects -input-header overrides keyed by file pattern; a bare
+// value applies to every file
+type headerFlag map[string]bool
+
+func (h headerFlag) String() string {
+	var parts []string
+	for pattern, hasHeader := range h {
+		parts = append(parts, fmt.Sprintf("%s=%t", pattern, hasHeader))
+	}
+	return strings.Join(parts, ",")
+}
+
+func (h headerFlag) Set(value string) error {
+	pattern, text, ok := strings.Cut(value, "=")
+	if !ok {
+		pattern, text = "*", value
+	}
+	hasHeader, err := strconv.ParseBool(text)
+	if err != nil {
+		return fmt.Errorf("expected true or false, got %q", text)
+	}
+	h[pattern] = hasHeader
+	return nil
+}
+
+// columnsFlag collects -columns names keyed by file pattern; a bare list
+// applies to every file
+type columnsFlag map[string][]string
+
+func (c columnsFlag) String() string {
+	var parts []string
+	for pattern, names := range c {
+		parts = append(parts, pattern+"="+strings.Join(names, ","))
+	}
+	return strings.Join(parts, " ")
+}
+
+func (c columnsFlag) Set(value string) error {
+	pattern, list, ok := strings.Cut(value, "=")
+	if !ok {
+		pattern, list = "*", value
+	}
+	var names []string
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			return fmt.Errorf("empty column name in %q", value)
+		}
+		names = append(names, name)
 	}
+	c[pattern] = names
+	return nil
+}
 
-	// Print results as table
-	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+// unionFlag collects -union rules in the order given
+type unionFlag []loader.UnionRule
 
-	// Header
-	fmt.Fprintln(w, strings.Join(columns, "\t"))
+func (u *unionFlag) String() string {
+	var parts []string
+	for _, rule := range *u {
+		parts = append(parts, rule.Table+"="+rule.Pattern)
+	}
+	return strings.Join(parts, ",")
+}
 
-	// Separator
-	sep := make([]string, len(columns))
-	for i := range sep {
-		sep[i] = strings.Repeat("-", len(columns[i]))
+func (u *unionFlag) Set(value string) error {
+	table, pattern, ok := strings.Cut(value, "=")
+	if !ok || table == "" || pattern == "" {
+		return fmt.Errorf("expected table=pattern, got %q", value)
 	}
-	fmt.Fprintln(w, strings.Join(sep, "\t"))
+	*u = append(*u, loader.UnionRule{Table: table, Pattern: pattern})
+	return nil
+}
+
+// patternFlag collects the patterns of a repeatable flag, also accepting
+// comma-separated lists
+type patternFlag []string
 
-	// Rows
-	for _, row := range rows {
-		fmt.Fprintln(w, strings.Join(row, "\t"))
+func (p *patternFlag) String() string {
+	return strings.Join(*p, ",")
+}
+
+func (p *patternFlag) Set(value string) error {
+	for _, pattern := range strings.Split(value, ",") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			*p = append(*p, pattern)
+		}
 	}
+	return nil
+}
+
+// progressMinBytes is the file size above which load progress is shown
+const progressMinBytes = 16 << 20
+
+// progressPrinter returns a callback that reports the load progress of large
+// files on a single, periodically refreshed line
+func progressPrinter(out io.Writer) loader.ProgressFunc {
+	var mu sync.Mutex
+	var last time.Time
+	return func(p loader.Progress) {
+		if p.Total < progressMinBytes {
+			return
+		}
 
-	w.Flush()
-	fmt.Printf("\n(%d rows)\n", len(rows))
+		mu.Lock()
+		defer mu.Unlock()
+
+		if !p.Done && time.Since(last) < 250*time.Millisecond {
+			return
+		}
+		last = time.Now()
+
+		fmt.Fprintf(out, "\rLoading %s: %d rows (%d%%)", filepath.Base(p.Path), p.Rows, p.Bytes*100/p.Total)
+		if p.Done {
+			fmt.Fprintln(out)
+		}
+	}
 }
 
 // JetBrains dataSources.xml structures
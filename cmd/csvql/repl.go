@@ -0,0 +1,418 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"csvql"
+	"csvql/output"
+
+	"github.com/peterh/liner"
+)
+
+const (
+	promptMain     = "csvql> "
+	promptContinue = "   ...> "
+	historyFile    = ".csvql_history"
+)
+
+// dotCommands lists the REPL meta-commands, used for .help and completion
+var dotCommands = []struct {
+	name string
+	help string
+}{
+	{".tables", "List loaded tables"},
+	{".schema", "Show CREATE statements (.schema [table])"},
+	{".files", "Show the file behind each table"},
+	{".mode", "Set output mode (.mode " + strings.Join(output.Formats(), "|") + ")"},
+	{".headers", "Show column names in results (.headers on|off)"},
+	{".timer", "Show query execution time (.timer on|off)"},
+	{".reload", "Rescan the directory and reload changed files"},
+	{".help", "Show this help"},
+	{".quit", "Exit csvql"},
+}
+
+// repl is an interactive SQL shell over a CSVQL instance
+type repl struct {
+	c       *csvql.CSVQL
+	line    *liner.State
+	out     io.Writer
+	mode    string
+	headers bool
+	timer   bool
+}
+
+// isTerminal reports whether stdin is attached to an interactive terminal
+func isTerminal() bool {
+	info, err := os.Stdin.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// historyPath returns the history file location in the user's home directory
+func historyPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, historyFile)
+}
+
+// runREPL reads statements and dot-commands until .quit or EOF
+func runREPL(c *csvql.CSVQL, mode string, headers bool) {
+	line := liner.NewLiner()
+	defer line.Close()
+
+	line.SetCtrlCAborts(true)
+	line.SetMultiLineMode(true)
+
+	r := &repl{c: c, line: line, out: os.Stdout, mode: mode, headers: headers}
+	line.SetWordCompleter(r.complete)
+
+	histPath := historyPath()
+	if histPath != "" {
+		if f, err := os.Open(histPath); err == nil {
+			line.ReadHistory(f)
+			f.Close()
+		}
+		defer func() {
+			if f, err := os.Create(histPath); err == nil {
+				line.WriteHistory(f)
+				f.Close()
+			}
+		}()
+	}
+
+	fmt.Println(`Enter SQL statements terminated with ";" or ".help" for commands`)
+
+	var stmts statementReader
+	for {
+		prompt := promptMain
+		if stmts.pending() {
+			prompt = promptContinue
+		}
+
+		input, err := line.Prompt(prompt)
+		if errors.Is(err, liner.ErrPromptAborted) {
+			stmts.reset()
+			continue
+		}
+		if err != nil {
+			if err != io.EOF {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			}
+			fmt.Println()
+			return
+		}
+
+		command, statement := stmts.add(input)
+		switch {
+		case command != "":
+			line.AppendHistory(command)
+			if !r.runCommand(command) {
+				return
+			}
+		case statement != "":
+			line.AppendHistory(statement)
+			r.runQuery(statement)
+		}
+	}
+}
+
+// statementReader assembles input lines into dot-commands and statements,
+// which may span lines until one ends with ";" outside quotes and comments
+type statementReader struct {
+	buf   strings.Builder
+	quote byte // the ' or " of a literal left open at the end of a line
+	block bool // inside a /* */ comment left open at the end of a line
+}
+
+// pending reports whether a statement is waiting for more lines
+func (s *statementReader) pending() bool {
+	return s.buf.Len() > 0
+}
+
+// reset drops the statement read so far
+func (s *statementReader) reset() {
+	s.buf.Reset()
+	s.quote, s.block = 0, false
+}
+
+// add reads a line and returns a dot-command or a complete statement, or
+// neither while the statement goes on
+func (s *statementReader) add(input string) (command, statement string) {
+	trimmed := strings.TrimSpace(input)
+	open := s.quote != 0 || s.block
+	if trimmed == "" && !open {
+		return "", ""
+	}
+
+	// Dot-commands are only recognised at the start of a statement
+	if !s.pending() && strings.HasPrefix(trimmed, ".") {
+		return trimmed, ""
+	}
+
+	if s.pending() {
+		s.buf.WriteString("\n")
+	}
+	s.buf.WriteString(input)
+
+	if !s.scan(input) {
+		return "", ""
+	}
+
+	statement = strings.TrimSpace(s.buf.String())
+	s.reset()
+	return "", statement
+}
+
+// scan follows quotes and comments through a line and reports whether the
+// line ends the statement: its last character outside them is ";"
+func (s *statementReader) scan(line string) bool {
+	ends := false
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		next := byte(0)
+		if i+1 < len(line) {
+			next = line[i+1]
+		}
+		switch {
+		case s.block:
+			if c == '*' && next == '/' {
+				s.block = false
+				i++
+			}
+		case s.quote != 0:
+			// A doubled quote closes and reopens the literal
+			if c == s.quote {
+				s.quote = 0
+			}
+		case c == '\'' || c == '"':
+			s.quote = c
+			ends = false
+		case c == '-' && next == '-':
+			return ends
+		case c == '/' && next == '*':
+			s.block = true
+			i++
+		case c == ';':
+			ends = true
+		case c != ' ' && c != '\t' && c != '\r':
+			ends = false
+		}
+	}
+	return ends && s.quote == 0 && !s.block
+}
+
+// runCommand executes a dot-command; it returns false when the REPL should exit
+func (r *repl) runCommand(input string) bool {
+	fields := strings.Fields(input)
+	cmd, args := fields[0], fields[1:]
+
+	switch cmd {
+	case ".quit", ".exit", ".q":
+		return false
+
+	case ".help":
+		w := tabwriter.NewWriter(r.out, 0, 0, 2, ' ', 0)
+		for _, dc := range dotCommands {
+			fmt.Fprintf(w, "%s\t%s\n", dc.name, dc.help)
+		}
+		w.Flush()
+
+	case ".tables":
+		tables, err := r.c.ListTables()
+		if err != nil {
+			fmt.Fprintf(r.out, "Error: %v\n", err)
+			break
+		}
+		for _, t := range tables {
+			fmt.Fprintln(r.out, t)
+		}
+
+	case ".schema":
+		r.showSchema(args)
+
+	case ".files":
+		r.showFiles()
+
+	case ".mode":
+		if len(args) == 0 {
+			fmt.Fprintf(r.out, "current output mode: %s\n", r.mode)
+			break
+		}
+		if _, err := output.New(args[0], io.Discard, output.Options{}); err != nil {
+			fmt.Fprintf(r.out, "Error: %v\n", err)
+			break
+		}
+		if output.IsBinary(args[0]) {
+			fmt.Fprintf(r.out, "Error: %s output needs a file; use csvql -q ... -o\n", args[0])
+			break
+		}
+		r.mode = args[0]
+
+	case ".headers":
+		if len(args) != 1 || (args[0] != "on" && args[0] != "off") {
+			fmt.Fprintln(r.out, "Usage: .headers on|off")
+			break
+		}
+		r.headers = args[0] == "on"
+
+	case ".timer":
+		if len(args) != 1 || (args[0] != "on" && args[0] != "off") {
+			fmt.Fprintln(r.out, "Usage: .timer on|off")
+			break
+		}
+		r.timer = args[0] == "on"
+
+	case ".reload":
+		if err := r.c.Scan(); err != nil {
+			fmt.Fprintf(r.out, "Error: %v\n", err)
+			break
+		}
+		tables, _ := r.c.ListTables()
+		fmt.Fprintf(r.out, "Reloaded %d table(s)\n", len(tables))
+
+	default:
+		fmt.Fprintf(r.out, "Error: unknown command %s (try .help)\n", cmd)
+	}
+	return true
+}
+
+// runQuery executes a statement and prints its results in the current mode
+func (r *repl) runQuery(statement string) {
+	start := time.Now()
+	err := executeQuery(r.c, statement, r.out, r.mode, output.Options{Header: r.headers})
+	elapsed := time.Since(start)
+	if err != nil {
+		fmt.Fprintf(r.out, "Error: %v\n", queryError(err))
+		return
+	}
+
+	if r.timer {
+		fmt.Fprintf(r.out, "Run Time: %s\n", elapsed.Round(time.Microsecond))
+	}
+}
+
+// showSchema prints the CREATE statement of one table, or of all loaded tables
+func (r *repl) showSchema(args []string) {
+	tables := args
+	if len(tables) == 0 {
+		var err error
+		if tables, err = r.c.ListTables(); err != nil {
+			fmt.Fprintf(r.out, "Error: %v\n", err)
+			return
+		}
+	}
+
+	for _, t := range tables {
+		schema, err := r.c.GetTableSchema(t)
+		if err != nil {
+			fmt.Fprintf(r.out, "Error: %v\n", err)
+			continue
+		}
+		fmt.Fprintf(r.out, "%s;\n", schema)
+	}
+}
+
+// showFiles prints the table -> file mapping stored in _csvql_metadata
+func (r *repl) showFiles() {
+	mappings, err := r.c.DB.GetAllTableMappings()
+	if err != nil {
+		fmt.Fprintf(r.out, "Error: %v\n", err)
+		return
+	}
+
+	tables := make([]string, 0, len(mappings))
+	files := make(map[string]string, len(mappings))
+	for path, table := range mappings {
+		tables = append(tables, table)
+		if rel, err := filepath.Rel(r.c.RootDir, path); err == nil {
+			path = rel
+		}
+		files[table] = path
+	}
+	sort.Strings(tables)
+
+	w := tabwriter.NewWriter(r.out, 0, 0, 2, ' ', 0)
+	for _, t := range tables {
+		fmt.Fprintf(w, "%s\t%s\n", t, files[t])
+	}
+	w.Flush()
+}
+
+// complete offers dot-commands, table names and column names for the word
+// under the cursor; liner counts pos in runes
+func (r *repl) complete(line string, pos int) (string, []string, string) {
+	runes := []rune(line)
+	head, tail := string(runes[:pos]), string(runes[pos:])
+	start := strings.LastIndexFunc(head, func(ch rune) bool {
+		return !(ch == '_' || ch == '.' || unicode.IsLetter(ch) || unicode.IsDigit(ch))
+	})
+	if start >= 0 {
+		_, size := utf8.DecodeRuneInString(head[start:])
+		start += size
+	} else {
+		start = 0
+	}
+	word := head[start:]
+	head = head[:start]
+	if word == "" {
+		return head, nil, tail
+	}
+
+	// Complete the column part of qualified names such as e.na
+	if dot := strings.LastIndex(word, "."); dot > 0 {
+		head += word[:dot+1]
+		word = word[dot+1:]
+	}
+
+	var candidates []string
+	if head == "" && strings.HasPrefix(word, ".") {
+		for _, dc := range dotCommands {
+			candidates = append(candidates, dc.name)
+		}
+	} else {
+		candidates = r.identifiers()
+	}
+
+	var completions []string
+	lower := strings.ToLower(word)
+	for _, cand := range candidates {
+		if strings.HasPrefix(strings.ToLower(cand), lower) {
+			completions = append(completions, cand)
+		}
+	}
+	return head, completions, tail
+}
+
+// identifiers returns the sorted, de-duplicated table and column names
+func (r *repl) identifiers() []string {
+	seen := make(map[string]bool)
+	var names []string
+	add := func(name string) {
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+
+	tables, _ := r.c.ListTables()
+	for _, t := range tables {
+		add(t)
+		cols, _ := r.c.GetTableInfo(t)
+		for _, col := range cols {
+			add(col)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"csvql"
+)
+
+func TestStatementReader(t *testing.T) {
+	var s statementReader
+	steps := []struct {
+		input     string
+		command   string
+		statement string
+	}{
+		{"", "", ""},
+		{"  .mode csv  ", ".mode csv", ""},
+		{"SELECT *", "", ""},
+		{"  .name", "", ""}, // part of the statement, not a command
+		{"FROM t;", "", "SELECT *\n  .name\nFROM t;"},
+		{"SELECT 1;  ", "", "SELECT 1;"},
+		{"SELECT 'a;'", "", ""},
+		{"", "", ""},
+		{";", "", "SELECT 'a;'\n;"},
+		{"INSERT INTO t VALUES ('a;", "", ""}, // open literal
+		{"", "", ""},
+		{"b', \"c;\"\"d;", "", ""},
+		{"\");", "", "INSERT INTO t VALUES ('a;\n\nb', \"c;\"\"d;\n\");"},
+		{"SELECT 1; -- done;", "", "SELECT 1; -- done;"},
+		{"SELECT 2 -- not yet;", "", ""},
+		{"/* still;", "", ""},
+		{"   open */;", "", "SELECT 2 -- not yet;\n/* still;\n   open */;"},
+		{"SELECT 'it''s';", "", "SELECT 'it''s';"},
+	}
+	for i, step := range steps {
+		command, statement := s.add(step.input)
+		if command != step.command || statement != step.statement {
+			t.Errorf("Step %d (%q): expected %q %q, got %q %q", i, step.input, step.command, step.statement, command, statement)
+		}
+	}
+	if s.pending() {
+		t.Error("Expected no pending statement")
+	}
+
+	s.add("SELECT 'x;")
+	if !s.pending() {
+		t.Fatal("Expected a pending statement")
+	}
+	s.reset()
+	if _, statement := s.add("SELECT 3;"); statement != "SELECT 3;" {
+		t.Errorf("Expected the aborted statement to be dropped, got %q", statement)
+	}
+}
+
+func TestComplete(t *testing.T) {
+	tmpDir := t.TempDir()
+	os.WriteFile(filepath.Join(tmpDir, "employees.csv"), []byte("id,name,città\n1,Alice,Roma\n"), 0644)
+
+	c, err := csvql.New(csvql.Options{RootDir: tmpDir})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer c.Close()
+	r := &repl{c: c}
+
+	tests := []struct {
+		line        string
+		pos         int
+		head        string
+		completions []string
+		tail        string
+	}{
+		{".ta", 3, "", []string{".tables"}, ""},
+		{"SELECT na", 9, "SELECT ", []string{"name"}, ""},
+		{"SELECT NA", 9, "SELECT ", []string{"name"}, ""},
+		{"SELECT e.na", 11, "SELECT e.", []string{"name"}, ""},
+		{"SELECT cit", 10, "SELECT ", []string{"città"}, ""},
+		{"SELECT città, na FROM e", 16, "SELECT città, ", []string{"name"}, " FROM e"}, // pos counts runes
+		{"SELECT * FROM em WHERE 1", 16, "SELECT * FROM ", []string{"employees"}, " WHERE 1"},
+		{"SELECT ", 7, "SELECT ", nil, ""},
+		{"SELECT .ta", 10, "SELECT ", nil, ""},
+	}
+	for _, tt := range tests {
+		head, completions, tail := r.complete(tt.line, tt.pos)
+		if head != tt.head || !reflect.DeepEqual(completions, tt.completions) || tail != tt.tail {
+			t.Errorf("complete(%q, %d) = %q %v %q, want %q %v %q", tt.line, tt.pos, head, completions, tail, tt.head, tt.completions, tt.tail)
+		}
+	}
+}
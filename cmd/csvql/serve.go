@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"csvql"
+	"csvql/pgwire"
+	"csvql/server"
+)
+
+// usage prints the commands and flags
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage:\n")
+	fmt.Fprintf(out, "  csvql [flags]         load and watch a directory, with a SQL shell on a terminal\n")
+	fmt.Fprintf(out, "  csvql serve [flags]   also answer queries over HTTP on -addr\n")
+	fmt.Fprintf(out, "  csvql -pg :5432       also accept PostgreSQL clients, in any mode\n\n")
+	fmt.Fprintf(out, "Flags:\n")
+	flag.PrintDefaults()
+}
+
+// runServer serves the HTTP API until SIGINT or SIGTERM, then lets running
+// requests finish
+func runServer(c *csvql.CSVQL, addr string, timeout time.Duration) error {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           server.New(c, server.Options{Timeout: timeout}),
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       30 * time.Second,
+		IdleTimeout:       2 * time.Minute,
+	}
+
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- srv.ListenAndServe()
+	}()
+	fmt.Printf("Serving HTTP on %s (Ctrl+C to stop)\n", addr)
+	fmt.Println()
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case err := <-errChan:
+		return err
+	case <-sigChan:
+	}
+
+	fmt.Println("\nStopping...")
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// startPostgres listens on addr and serves the PostgreSQL wire protocol in
+// the background until the returned server is closed
+func startPostgres(c *csvql.CSVQL, addr, password string, timeout time.Duration) (*pgwire.Server, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for PostgreSQL clients: %w", err)
+	}
+	pg := pgwire.New(c, pgwire.Options{Password: password, Timeout: timeout})
+	go pg.Serve(l)
+	fmt.Printf("Serving PostgreSQL on %s (e.g. psql -h localhost -p %s)\n", l.Addr(), port(l.Addr()))
+	return pg, nil
+}
+
+// port returns the port of a listener address
+func port(addr net.Addr) string {
+	if tcp, ok := addr.(*net.TCPAddr); ok {
+		return fmt.Sprint(tcp.Port)
+	}
+	return addr.String()
+}
@@ -2,8 +2,10 @@
 package csvql
 
 import (
+	"context"
 	"fmt"
 	"path/filepath"
+	"sync"
 
 	"csvql/db"
 	"csvql/loader"
@@ -12,11 +14,26 @@ import (
 
 // CSVQL is the main interface for CSV/TSV to SQLite operations
 type CSVQL struct {
-	RootDir   string
-	DBPath    string
-	DB        *db.Manager
-	Watcher   *watcher.Watcher
-	OnChange  func(event string, path string)
+	RootDir    string
+	DBPath     string
+	DB         *db.Manager
+	Watcher    *watcher.Watcher
+	OnChange   func(event string, path string)
+	OnProgress loader.ProgressFunc
+	Encodings  map[string]string
+	Unions     []loader.UnionRule
+	JSONDepth  int
+	Headers    map[string]bool
+	Columns    map[string][]string
+	Strict     bool
+	TextOnly   bool
+
+	filter *loader.Filter
+
+	writeBackMu   sync.Mutex // serialises WriteBack
+	stopWriteBack chan struct{}
+	writeBackDone chan struct{}
+	stopOnce      sync.Once
 }
 
 // Options for creating a new CSVQL instance
@@ -25,6 +42,50 @@ type Options struct {
 	DBPath   string
 	Watch    bool
 	OnChange func(event string, path string)
+	// OnProgress, if set, is called after each batch of rows while a file loads
+	OnProgress loader.ProgressFunc
+	// Encodings forces the character encoding of matching files instead of
+	// detecting it. Keys are paths relative to RootDir or glob patterns
+	// matched against the relative path or the file name, e.g. "*.csv".
+	Encodings map[string]string
+	// Unions loads all files matching a pattern into one table, with a
+	// _source_file column. Files below key=value directories are unioned
+	// without a rule, with one column per partition key.
+	Unions []loader.UnionRule
+	// JSONDepth limits how many levels of nested JSON objects are flattened
+	// into parent_child columns; deeper objects are stored as JSON text.
+	// Zero flattens all levels.
+	JSONDepth int
+	// Include, if set, limits loading to files matching one of its
+	// patterns; Exclude skips matching files and directories. Both use
+	// .csvqlignore syntax relative to RootDir, e.g. "node_modules/" or
+	// "data/**/*.csv", and skipped directories are neither scanned nor watched.
+	Include []string
+	Exclude []string
+	// Headers forces whether matching files start with a header row instead
+	// of detecting it; Columns names the first columns of matching files,
+	// replacing the header row or the generated c1..cN names. Keys are
+	// patterns as in Encodings, and both override the files' sidecars.
+	Headers map[string]bool
+	Columns map[string][]string
+	// Strict fails a file on its first malformed row. By default malformed
+	// rows are left out and recorded in the _csvql_errors table, and
+	// OnChange receives an "ERRORS" event for the file.
+	Strict bool
+	// TextOnly loads every column as TEXT instead of inferring INTEGER,
+	// REAL, BOOLEAN, DATE and DATETIME columns
+	TextOnly bool
+	// AllowWrites lets Query, QueryContext and QueryValues, the servers and
+	// driver connections run statements that modify the database. By
+	// default they fail with db.ErrReadOnly, as an edited table no longer
+	// matches its file and is overwritten by the next reload.
+	AllowWrites bool
+	// WriteBack writes tables edited by any connection to the database back
+	// to their CSV, TSV and PSV files, keeping the delimiter, quoting and
+	// header, every 500ms and on Close. The watcher does not reload the
+	// written files. Edits from Query and the servers still need
+	// AllowWrites; tools opening the database file directly can always edit.
+	WriteBack bool
 }
 
 // New creates a new CSVQL instance
@@ -38,6 +99,12 @@ func New(opts Options) (*CSVQL, error) {
 		return nil, fmt.Errorf("failed to get absolute path: %w", err)
 	}
 
+	for pattern, encoding := range opts.Encodings {
+		if _, err := loader.NormalizeEncoding(encoding); err != nil {
+			return nil, fmt.Errorf("invalid encoding for %s: %w", pattern, err)
+		}
+	}
+
 	if opts.DBPath == "" {
 		opts.DBPath = filepath.Join(absRoot, ".csvql.db")
 	}
@@ -46,12 +113,26 @@ func New(opts Options) (*CSVQL, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to create database: %w", err)
 	}
+	dbManager.SetAllowWrites(opts.AllowWrites)
+	if err := dbManager.SetWriteBack(opts.WriteBack); err != nil {
+		dbManager.Close()
+		return nil, err
+	}
 
 	c := &CSVQL{
-		RootDir:  absRoot,
-		DBPath:   opts.DBPath,
-		DB:       dbManager,
-		OnChange: opts.OnChange,
+		RootDir:    absRoot,
+		DBPath:     opts.DBPath,
+		DB:         dbManager,
+		OnChange:   opts.OnChange,
+		OnProgress: opts.OnProgress,
+		Encodings:  opts.Encodings,
+		Unions:     opts.Unions,
+		JSONDepth:  opts.JSONDepth,
+		Headers:    opts.Headers,
+		Columns:    opts.Columns,
+		Strict:     opts.Strict,
+		TextOnly:   opts.TextOnly,
+		filter:     loader.NewFilter(absRoot, opts.Include, opts.Exclude),
 	}
 
 	// Initial scan and load
@@ -70,35 +151,46 @@ func New(opts Options) (*CSVQL, error) {
 		if opts.OnChange != nil {
 			w.SetOnChange(opts.OnChange)
 		}
+		if opts.OnProgress != nil {
+			w.SetOnProgress(opts.OnProgress)
+		}
+		w.SetFileConfig(c.fileConfig)
+		w.SetUnionRules(opts.Unions)
+		if err := w.SetFilter(c.filter); err != nil {
+			w.Stop()
+			dbManager.Close()
+			return nil, fmt.Errorf("failed to create watcher: %w", err)
+		}
 		w.Start()
 		c.Watcher = w
 	}
 
+	if opts.WriteBack {
+		c.startWriteBack()
+	}
+
 	return c, nil
 }
 
 // Scan finds and loads all CSV/TSV files
 func (c *CSVQL) Scan() error {
-	files, err := loader.ScanDirectory(c.RootDir)
+	c.filter.Reload()
+	defer loader.ReleaseArchives()
+	files, err := loader.ScanDirectoryFilter(c.RootDir, c.filter)
 	if err != nil {
 		return fmt.Errorf("failed to scan directory: %w", err)
 	}
 
-	// Resolve table names with conflict detection
-	desiredNames := loader.ResolveTableNames(files, c.RootDir)
+	// Group union sets and resolve table names with conflict detection
+	singles, sets := loader.GroupFiles(files, c.RootDir, c.Unions)
+	desiredNames := loader.ResolveSetNames(singles, sets, c.RootDir)
 
 	// Get current mappings from DB
 	currentMappings, _ := c.DB.GetAllTableMappings()
 
-	// Build set of current files for quick lookup
-	currentFiles := make(map[string]bool)
-	for _, f := range files {
-		currentFiles[f] = true
-	}
-
-	// Remove tables for files that no longer exist
+	// Remove tables for files (or file sets) that no longer exist
 	for filePath := range currentMappings {
-		if !currentFiles[filePath] {
+		if _, exists := desiredNames[filePath]; !exists {
 			c.DB.RemoveTableByPath(filePath)
 		}
 	}
@@ -116,32 +208,124 @@ func (c *CSVQL) Scan() error {
 	}
 
 	// Load new or modified files
-	for _, file := range files {
-		tableName := desiredNames[file]
-		parsed, err := loader.ParseFile(file, c.RootDir, tableName)
-		if err != nil {
-			fmt.Printf("Warning: failed to parse %s: %v\n", file, err)
-			continue
-		}
+	for _, file := range singles {
+		c.loadFile(file, desiredNames[file])
+	}
+	for _, set := range sets {
+		c.syncSet(set, desiredNames[set.Key])
+	}
 
-		if !c.DB.NeedsUpdate(parsed.Info.TableName, parsed.Info.ModTime) {
-			continue
-		}
+	return nil
+}
 
-		if err := c.DB.LoadFile(parsed); err != nil {
-			fmt.Printf("Warning: failed to load %s: %v\n", file, err)
-			continue
+// syncSet loads the changed files of a union set into its table
+func (c *CSVQL) syncSet(set *loader.FileSet, tableName string) {
+	var readers []*loader.Reader
+	open := func(path string) (*loader.Reader, error) {
+		reader, err := loader.OpenFileConfig(path, c.RootDir, c.fileConfig(path), tableName)
+		if err == nil {
+			readers = append(readers, reader)
+			if c.OnProgress != nil {
+				reader.SetProgress(c.OnProgress)
+			}
 		}
+		return reader, err
+	}
+	if _, _, err := c.DB.SyncUnion(set, tableName, c.RootDir, open); err != nil {
+		fmt.Printf("Warning: failed to load %s: %v\n", set.Key, err)
+		return
 	}
+	for _, reader := range readers {
+		c.reportBadRows(reader)
+	}
+}
 
-	return nil
+// reportBadRows sends an ERRORS event for a loaded file with malformed rows
+func (c *CSVQL) reportBadRows(reader *loader.Reader) {
+	if reader.BadRows() > 0 && c.OnChange != nil {
+		c.OnChange("ERRORS", reader.Info.Path)
+	}
+}
+
+// loadFile streams a single file into its table if it changed since the last load
+func (c *CSVQL) loadFile(file, tableName string) {
+	// Skip unchanged files before opening them, which would decompress them
+	if modTime, err := loader.FileModTime(file); err == nil && !c.DB.NeedsUpdate(tableName, modTime) {
+		return
+	}
+
+	reader, err := loader.OpenFileConfig(file, c.RootDir, c.fileConfig(file), tableName)
+	if err != nil {
+		fmt.Printf("Warning: failed to parse %s: %v\n", file, err)
+		return
+	}
+	defer reader.Close()
+
+	if !c.DB.NeedsUpdate(reader.Info.TableName, reader.Info.ModTime) {
+		return
+	}
+
+	if c.OnProgress != nil {
+		reader.SetProgress(c.OnProgress)
+	}
+
+	if err := c.DB.LoadReader(reader); err != nil {
+		fmt.Printf("Warning: failed to load %s: %v\n", file, err)
+		return
+	}
+	c.reportBadRows(reader)
+}
+
+// fileConfig returns the format overrides configured for a file
+func (c *CSVQL) fileConfig(path string) loader.FileConfig {
+	cfg := loader.FileConfig{FlattenDepth: c.JSONDepth}
+	cfg.Encoding, _ = longestMatch(c.Encodings, path, c.RootDir)
+	if hasHeader, ok := longestMatch(c.Headers, path, c.RootDir); ok {
+		cfg.HasHeader = &hasHeader
+	}
+	cfg.Columns, _ = longestMatch(c.Columns, path, c.RootDir)
+	cfg.Strict = c.Strict
+	cfg.TextOnly = c.TextOnly
+	return cfg
+}
+
+// longestMatch returns the value of the longest pattern matching a file,
+// the most specific one
+func longestMatch[V any](patterns map[string]V, path, rootDir string) (V, bool) {
+	var value V
+	best := -1
+	for pattern, v := range patterns {
+		if len(pattern) > best && loader.MatchPath(pattern, path, rootDir) {
+			value = v
+			best = len(pattern)
+		}
+	}
+	return value, best >= 0
 }
 
-// Query executes a SQL query
+// Query executes a SQL query; see Options.AllowWrites
 func (c *CSVQL) Query(sql string) ([]string, [][]string, error) {
 	return c.DB.Query(sql)
 }
 
+// QueryContext executes a SQL query with bind parameters and streams typed rows.
+// The returned Rows must be closed. Statements that would modify the
+// database fail with db.ErrReadOnly unless Options.AllowWrites is set.
+func (c *CSVQL) QueryContext(ctx context.Context, sql string, args ...interface{}) (*db.Rows, error) {
+	return c.DB.QueryContext(ctx, sql, args...)
+}
+
+// QueryValues executes a SQL query and returns typed values
+func (c *CSVQL) QueryValues(sql string) ([]string, [][]interface{}, error) {
+	return c.DB.QueryValues(sql)
+}
+
+// RowErrorCounts returns the number of malformed rows left out of each
+// table that has any; the rows are in the _csvql_errors table
+func (c *CSVQL) RowErrorCounts() (map[string]int64, error) {
+	return c.DB.RowErrorCounts()
+}
+
 // ListTables returns all loaded tables
 func (c *CSVQL) ListTables() ([]string, error) {
 	return c.DB.ListTables()
@@ -152,10 +336,31 @@ func (c *CSVQL) GetTableInfo(tableName string) ([]string, error) {
 	return c.DB.GetTableInfo(tableName)
 }
 
-// Close cleans up resources
+// GetTableSchema returns the CREATE TABLE statement for a table
+func (c *CSVQL) GetTableSchema(tableName string) (string, error) {
+	return c.DB.GetTableSchema(tableName)
+}
+
+// Close cleans up resources, writing back the last edits when write-back is on
 func (c *CSVQL) Close() error {
+	stopped := false
+	if c.stopWriteBack != nil {
+		c.stopOnce.Do(func() {
+			close(c.stopWriteBack)
+			stopped = true
+		})
+		<-c.writeBackDone
+	}
 	if c.Watcher != nil {
 		c.Watcher.Stop()
 	}
-	return c.DB.Close()
+
+	var err error
+	if stopped {
+		err = c.WriteBack()
+	}
+	if closeErr := c.DB.Close(); closeErr != nil {
+		return closeErr
+	}
+	return err
 }
@@ -1,10 +1,20 @@
 package csvql
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"os"
 	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
 	"testing"
 	"time"
+
+	"csvql/db"
+	"csvql/loader"
+	"csvql/parquet"
 )
 
 func TestNew_BasicUsage(t *testing.T) {
@@ -424,3 +434,504 @@ func TestQuery_NonExistentTable(t *testing.T) {
 		t.Error("Expected error for non-existent table")
 	}
 }
+
+func TestQueryContext_BindParams(t *testing.T) {
+	tmpDir := t.TempDir()
+	os.WriteFile(filepath.Join(tmpDir, "people.csv"), []byte("id,name,score\n1,Alice,9.5\n2,Bob,7\n3,Carol,"), 0644)
+
+	c, err := New(Options{RootDir: tmpDir})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer c.Close()
+
+	rows, err := c.QueryContext(context.Background(), "SELECT id, name, score FROM people WHERE id >= ? ORDER BY id", 2)
+	if err != nil {
+		t.Fatalf("QueryContext failed: %v", err)
+	}
+
+	var got [][]interface{}
+	for values, err := range rows.All() {
+		if err != nil {
+			t.Fatalf("iteration failed: %v", err)
+		}
+		got = append(got, values)
+	}
+
+	if len(got) != 2 {
+		t.Fatalf("Expected 2 rows, got %d", len(got))
+	}
+	if got[0][0] != int64(2) || got[0][1] != "Bob" || got[0][2] != float64(7) {
+		t.Errorf("Unexpected typed row: %#v", got[0])
+	}
+	if got[1][2] != nil {
+		t.Errorf("Expected nil for empty cell, got %#v", got[1][2])
+	}
+}
+
+func TestQueryContext_Cancelled(t *testing.T) {
+	tmpDir := t.TempDir()
+	os.WriteFile(filepath.Join(tmpDir, "data.csv"), []byte("col\nval"), 0644)
+
+	c, err := New(Options{RootDir: tmpDir})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := c.QueryContext(ctx, "SELECT * FROM data"); err == nil {
+		t.Error("Expected error for cancelled context")
+	}
+
+	// The read lock must have been released: a load still works
+	if err := c.Scan(); err != nil {
+		t.Errorf("Scan after cancelled query failed: %v", err)
+	}
+}
+
+func TestQueryStructs(t *testing.T) {
+	tmpDir := t.TempDir()
+	os.WriteFile(filepath.Join(tmpDir, "employees.csv"), []byte(
+		"id,full_name,salary,active,hire_date,manager_id\n"+
+			"1,Alice,95000.5,true,2020-03-15,\n"+
+			"2,Bob,75000,false,2019-07-22,1\n"), 0644)
+
+	c, err := New(Options{RootDir: tmpDir})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer c.Close()
+
+	type employee struct {
+		ID        int
+		Name      string `csvql:"full_name"`
+		Salary    float64
+		Active    bool
+		HireDate  time.Time
+		ManagerID *int64
+		Ignored   string `csvql:"-"`
+	}
+
+	people, err := QueryStructs[employee](context.Background(), c, "SELECT * FROM employees ORDER BY id")
+	if err != nil {
+		t.Fatalf("QueryStructs failed: %v", err)
+	}
+
+	if len(people) != 2 {
+		t.Fatalf("Expected 2 employees, got %d", len(people))
+	}
+
+	alice, bob := people[0], people[1]
+	if alice.ID != 1 || alice.Name != "Alice" || alice.Salary != 95000.5 || !alice.Active {
+		t.Errorf("Unexpected first employee: %+v", alice)
+	}
+	if alice.HireDate.Format("2006-01-02") != "2020-03-15" {
+		t.Errorf("Unexpected hire date: %v", alice.HireDate)
+	}
+	if alice.ManagerID != nil {
+		t.Errorf("Expected nil manager for NULL, got %v", *alice.ManagerID)
+	}
+	if bob.ManagerID == nil || *bob.ManagerID != 1 {
+		t.Errorf("Expected manager 1, got %v", bob.ManagerID)
+	}
+}
+
+func TestQueryStructs_Overflow(t *testing.T) {
+	tmpDir := t.TempDir()
+	os.WriteFile(filepath.Join(tmpDir, "counts.csv"), []byte("id,n\n1,300\n"), 0644)
+
+	c, err := New(Options{RootDir: tmpDir})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer c.Close()
+
+	type small struct {
+		N int8
+	}
+	if _, err := QueryStructs[small](context.Background(), c, "SELECT n FROM counts"); err == nil || !strings.Contains(err.Error(), "overflows int8") {
+		t.Errorf("Expected an overflow error, got %v", err)
+	}
+
+	// Every kind checks the range of its field
+	var i8 int8
+	var u8 uint8
+	var u16 uint16
+	var f32 float32
+	tests := []struct {
+		field reflect.Value
+		value interface{}
+	}{
+		{reflect.ValueOf(&i8).Elem(), "-129"},
+		{reflect.ValueOf(&i8).Elem(), 200.0},
+		{reflect.ValueOf(&u8).Elem(), int64(256)},
+		{reflect.ValueOf(&u16).Elem(), int64(70000)},
+		{reflect.ValueOf(&f32).Elem(), 1e300},
+	}
+	for _, tt := range tests {
+		if err := assignValue(tt.field, tt.value); err == nil {
+			t.Errorf("Expected an overflow error for %v into %s", tt.value, tt.field.Type())
+		}
+	}
+	if i8 != 0 || u8 != 0 || u16 != 0 || f32 != 0 {
+		t.Errorf("Expected fields left unset, got %d %d %d %g", i8, u8, u16, f32)
+	}
+	if err := assignValue(reflect.ValueOf(&u8).Elem(), int64(255)); err != nil || u8 != 255 {
+		t.Errorf("Expected 255 to fit uint8, got %d (%v)", u8, err)
+	}
+}
+
+func TestNew_Encodings(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	os.MkdirAll(filepath.Join(tmpDir, "legacy"), 0755)
+	// "Ã©" in UTF-8 happens to be valid, so only the override reads it as Latin-1
+	os.WriteFile(filepath.Join(tmpDir, "legacy", "names.csv"), []byte("name\n\xC3\xA9\n"), 0644)
+
+	c, err := New(Options{
+		RootDir:   tmpDir,
+		Encodings: map[string]string{"*": "utf-8", "legacy/*.csv": "latin1"},
+	})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer c.Close()
+
+	_, rows, err := c.Query("SELECT name FROM names")
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(rows) != 1 || rows[0][0] != "Ã©" {
+		t.Errorf("Expected Latin-1 decoded value, got %v", rows)
+	}
+
+	if _, err := New(Options{RootDir: tmpDir, Encodings: map[string]string{"*": "bogus"}}); err == nil {
+		t.Error("Expected error for unknown encoding")
+	}
+}
+
+func TestNew_UnionTables(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	os.MkdirAll(filepath.Join(tmpDir, "sales"), 0755)
+	os.WriteFile(filepath.Join(tmpDir, "sales", "2024-01.csv"), []byte("id,amount\n1,10\n2,20"), 0644)
+	os.WriteFile(filepath.Join(tmpDir, "sales", "2024-02.csv"), []byte("id,amount\n3,30"), 0644)
+
+	os.MkdirAll(filepath.Join(tmpDir, "events", "year=2024"), 0755)
+	os.WriteFile(filepath.Join(tmpDir, "events", "year=2024", "part-0.csv"), []byte("id\n1\n2"), 0644)
+
+	c, err := New(Options{
+		RootDir: tmpDir,
+		Unions:  []loader.UnionRule{{Table: "sales", Pattern: "sales/*.csv"}},
+	})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer c.Close()
+
+	tables, _ := c.ListTables()
+	if len(tables) != 2 || tables[0] != "events" || tables[1] != "sales" {
+		t.Fatalf("Expected events and sales, got %v", tables)
+	}
+
+	_, rows, err := c.Query("SELECT _source_file, SUM(amount) FROM sales GROUP BY 1 ORDER BY 1")
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(rows) != 2 || rows[0][0] != "sales/2024-01.csv" || rows[0][1] != "30" {
+		t.Errorf("Unexpected sales rows: %v", rows)
+	}
+
+	_, rows, _ = c.Query("SELECT COUNT(*) FROM events WHERE year = 2024")
+	if rows[0][0] != "2" {
+		t.Errorf("Expected 2 events in year 2024, got %v", rows)
+	}
+
+	// A rescan with nothing changed keeps the tables
+	if err := c.Scan(); err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+	if tables, _ := c.ListTables(); len(tables) != 2 {
+		t.Errorf("Expected 2 tables after rescan, got %v", tables)
+	}
+}
+
+func TestNew_JSONFiles(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	os.WriteFile(filepath.Join(tmpDir, "posts.json"), []byte(`[{"id":1,"author":{"name":"ann"},"tags":["go","sql"]},{"id":2,"author":{"name":"bob"},"tags":[]}]`), 0644)
+	os.WriteFile(filepath.Join(tmpDir, "log.ndjson"), []byte("{\"id\":1}\n{\"id\":2}\n"), 0644)
+
+	c, err := New(Options{RootDir: tmpDir})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer c.Close()
+
+	tables, _ := c.ListTables()
+	if len(tables) != 2 {
+		t.Fatalf("Expected 2 tables, got %v", tables)
+	}
+
+	_, rows, err := c.Query("SELECT p.author_name, t.value FROM posts p, json_each(p.tags) t ORDER BY t.value")
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(rows) != 2 || rows[0][0] != "ann" || rows[0][1] != "go" || rows[1][1] != "sql" {
+		t.Errorf("Unexpected rows: %v", rows)
+	}
+}
+
+func TestNew_ParquetFiles(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	f, _ := os.Create(filepath.Join(tmpDir, "lake.parquet"))
+	w, err := parquet.NewWriter(f, []parquet.Column{
+		{Name: "id", Kind: parquet.Int64},
+		{Name: "amount", Kind: parquet.Double, Optional: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]interface{}{int64(1), 2.5})
+	w.Write([]interface{}{int64(2), nil})
+	w.Close()
+	f.Close()
+
+	c, err := New(Options{RootDir: tmpDir})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer c.Close()
+
+	schema, err := c.GetTableSchema("lake")
+	if err != nil {
+		t.Fatalf("GetTableSchema failed: %v", err)
+	}
+	if !strings.Contains(schema, `"id" INTEGER`) || !strings.Contains(schema, `"amount" REAL`) {
+		t.Errorf("Expected schema types from the file, got %s", schema)
+	}
+
+	_, rows, err := c.Query("SELECT SUM(amount), COUNT(amount) FROM lake")
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if rows[0][0] != "2.5" || rows[0][1] != "1" {
+		t.Errorf("Unexpected rows: %v", rows)
+	}
+}
+
+func TestNew_IncludeExclude(t *testing.T) {
+	tmpDir := t.TempDir()
+	for _, name := range []string{"data/a.csv", "data/b.tsv", "node_modules/x.csv", "c.csv"} {
+		path := filepath.Join(tmpDir, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		os.WriteFile(path, []byte("id\n1\n"), 0644)
+	}
+
+	c, err := New(Options{RootDir: tmpDir, Include: []string{"*.csv"}, Exclude: []string{"node_modules/"}})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer c.Close()
+
+	tables, _ := c.ListTables()
+	sort.Strings(tables)
+	if !reflect.DeepEqual(tables, []string{"a", "c"}) {
+		t.Errorf("Expected tables a and c, got %v", tables)
+	}
+}
+
+func TestNew_HeadersAndColumns(t *testing.T) {
+	tmpDir := t.TempDir()
+	os.WriteFile(filepath.Join(tmpDir, "people.csv"), []byte("ann,rome\nbob,oslo\n"), 0644)
+
+	c, err := New(Options{
+		RootDir: tmpDir,
+		Headers: map[string]bool{"*.csv": false},
+		Columns: map[string][]string{"people.csv": {"name", "city"}},
+	})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer c.Close()
+
+	_, rows, err := c.Query("SELECT name FROM people WHERE city = 'rome'")
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(rows) != 1 || rows[0][0] != "ann" {
+		t.Errorf("Expected ann, got %v", rows)
+	}
+}
+
+func TestNew_BadRows(t *testing.T) {
+	tmpDir := t.TempDir()
+	csvPath := filepath.Join(tmpDir, "orders.csv")
+	os.WriteFile(csvPath, []byte("id,amount\n1,10\n2,20,x\n3,30\n"), 0644)
+
+	var events []string
+	c, err := New(Options{
+		RootDir: tmpDir,
+		OnChange: func(event, path string) {
+			events = append(events, event+" "+filepath.Base(path))
+		},
+	})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer c.Close()
+
+	if !reflect.DeepEqual(events, []string{"ERRORS orders.csv"}) {
+		t.Errorf("Expected an ERRORS event, got %v", events)
+	}
+
+	_, rows, err := c.Query("SELECT COUNT(*) FROM orders")
+	if err != nil || rows[0][0] != "2" {
+		t.Errorf("Expected the 2 good rows loaded, got %v (%v)", rows, err)
+	}
+
+	_, rows, err = c.Query("SELECT table_name, line, raw, reason FROM _csvql_errors")
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	want := [][]string{{"orders", "3", "2,20,x", "expected 2 fields, got 3"}}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("Expected %v, got %v", want, rows)
+	}
+	if counts, _ := c.RowErrorCounts(); counts["orders"] != 1 {
+		t.Errorf("Expected 1 bad row for orders, got %v", counts)
+	}
+
+	// Fixing the file clears its errors
+	time.Sleep(10 * time.Millisecond) // Ensure different mtime
+	os.WriteFile(csvPath, []byte("id,amount\n1,10\n2,20\n"), 0644)
+	if err := c.Scan(); err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+	if counts, _ := c.RowErrorCounts(); len(counts) != 0 {
+		t.Errorf("Expected no bad rows after the fix, got %v", counts)
+	}
+}
+
+func TestNew_Strict(t *testing.T) {
+	tmpDir := t.TempDir()
+	os.WriteFile(filepath.Join(tmpDir, "orders.csv"), []byte("id,amount\n1,10\n2,20,x\n"), 0644)
+
+	c, err := New(Options{RootDir: tmpDir, Strict: true})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer c.Close()
+
+	if tables, _ := c.ListTables(); len(tables) != 0 {
+		t.Errorf("Expected the malformed file not to load in strict mode, got %v", tables)
+	}
+}
+
+func TestQuery_ReadOnly(t *testing.T) {
+	tmpDir := t.TempDir()
+	os.WriteFile(filepath.Join(tmpDir, "employees.csv"), []byte("id,name\n1,Alice\n"), 0644)
+
+	c, err := New(Options{RootDir: tmpDir})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	for _, q := range []string{"DROP TABLE employees", "DELETE FROM _csvql_metadata"} {
+		if _, _, err := c.Query(q); !errors.Is(err, db.ErrReadOnly) {
+			t.Errorf("Expected db.ErrReadOnly for %q, got %v", q, err)
+		}
+	}
+	if tables, _ := c.ListTables(); !reflect.DeepEqual(tables, []string{"employees"}) {
+		t.Errorf("Expected employees untouched, got %v", tables)
+	}
+	c.Close()
+
+	c, err = New(Options{RootDir: tmpDir, AllowWrites: true})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer c.Close()
+	if _, _, err := c.Query("UPDATE employees SET name = 'Bob'"); err != nil {
+		t.Fatalf("Expected writes to be allowed, got %v", err)
+	}
+	if _, rows, _ := c.Query("SELECT name FROM employees"); rows[0][0] != "Bob" {
+		t.Errorf("Expected the update, got %v", rows)
+	}
+}
+
+func TestWriteBack(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "stock.csv")
+	os.WriteFile(path, []byte("sku;qty;price;note\r\nA-1;5;1.50;\"first; best\"\r\nB-2;7;2.00;\r\n"), 0644)
+
+	events := make(chan string, 10)
+	c, err := New(Options{
+		RootDir:   tmpDir,
+		Watch:     true,
+		WriteBack: true,
+		OnChange: func(event, path string) {
+			events <- event
+		},
+	})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	// An edit from another connection, such as an IDE, reaches the file
+	other, err := sql.Open("sqlite3", c.DBPath)
+	if err != nil {
+		t.Fatalf("sql.Open failed: %v", err)
+	}
+	defer other.Close()
+	if _, err := other.Exec("UPDATE stock SET qty = qty + 1 WHERE sku = 'A-1'; INSERT INTO stock VALUES ('C-3', 1, 0.5, 'new')"); err != nil {
+		t.Fatalf("Edit failed: %v", err)
+	}
+	select {
+	case event := <-events:
+		if event != "WRITE" {
+			t.Errorf("Expected a WRITE event, got %s", event)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("Timeout waiting for the write back")
+	}
+	// Only the edited and added rows are formatted from their values
+	want := "sku;qty;price;note\r\nA-1;6;1.5;\"first; best\"\r\nB-2;7;2.00;\r\nC-3;1;0.5;new\r\n"
+	if data, _ := os.ReadFile(path); string(data) != want {
+		t.Errorf("Expected %q, got %q", want, data)
+	}
+
+	// The watcher does not reload the written file
+	select {
+	case event := <-events:
+		t.Errorf("Expected no event for the written file, got %s", event)
+	case <-time.After(time.Second):
+	}
+
+	// External changes still reload the table
+	os.WriteFile(path, []byte("sku;qty;price;note\r\nZ-9;1;1.00;\r\n"), 0644)
+	select {
+	case event := <-events:
+		if event != "UPDATE" {
+			t.Errorf("Expected an UPDATE event, got %s", event)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("Timeout waiting for the reload")
+	}
+	if _, rows, _ := c.Query("SELECT sku FROM stock"); len(rows) != 1 || rows[0][0] != "Z-9" {
+		t.Errorf("Expected the reloaded table, got %v", rows)
+	}
+
+	// Close writes back the last edits
+	other.Exec("DELETE FROM stock")
+	if err := c.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "sku;qty;price;note\r\n" {
+		t.Errorf("Expected only the header left, got %q", data)
+	}
+}
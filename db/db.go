@@ -2,23 +2,38 @@
 package db
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
 	"fmt"
+	"io"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"csvql/loader"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
 )
 
 // Manager handles SQLite database operations
 type Manager struct {
 	db       *sql.DB
-	mu       sync.RWMutex
+	path     string
+	mu       sync.RWMutex     // guards metadata; query results rely on SQLite snapshots instead
+	writeMu  sync.Mutex       // serialises write transactions (loads, renames, removals)
 	metadata map[string]int64 // tableName -> modTime
+
+	allowWrites atomic.Bool // see SetAllowWrites
+
+	writeBack   bool             // see SetWriteBack; guarded by writeMu
+	writtenBack map[string]int64 // filePath -> modTime of the last write back
 }
 
+// shadowPrefix names the table a file is loaded into before it replaces the live table
+const shadowPrefix = "__csvql_tmp_"
+
 // New creates a new database manager
 func New(dbPath string) (*Manager, error) {
 	db, err := sql.Open("sqlite3", dbPath+"?_journal_mode=WAL&_synchronous=NORMAL")
@@ -39,9 +54,86 @@ func New(dbPath string) (*Manager, error) {
 		return nil, fmt.Errorf("failed to create metadata table: %w", err)
 	}
 
+	// Files of union tables, so changed files can be reloaded on their own
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS _csvql_partitions (
+			file_path TEXT PRIMARY KEY,
+			table_name TEXT NOT NULL,
+			mod_time INTEGER NOT NULL
+		)
+	`)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create partitions table: %w", err)
+	}
+
+	// Malformed rows left out of tables, see loader.RowError
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS _csvql_errors (
+			table_name TEXT NOT NULL,
+			file_path TEXT NOT NULL,
+			line INTEGER NOT NULL,
+			raw TEXT NOT NULL,
+			reason TEXT NOT NULL
+		)
+	`)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create errors table: %w", err)
+	}
+
+	// Tables edited since they were loaded, see SetWriteBack
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS _csvql_changes (
+			table_name TEXT PRIMARY KEY,
+			version INTEGER NOT NULL
+		)
+	`)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create changes table: %w", err)
+	}
+
+	// Rows edited since their table was loaded or written back, see ReadTable
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS _csvql_changed_rows (
+			table_name TEXT NOT NULL,
+			row_id INTEGER NOT NULL,
+			version INTEGER NOT NULL,
+			PRIMARY KEY (table_name, row_id)
+		)
+	`)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create changed rows table: %w", err)
+	}
+
+	// Runs of rowids of the rows a table's file was last written from, in
+	// the order of its records; see ReadTable
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS _csvql_file_rows (
+			table_name TEXT NOT NULL,
+			first_row INTEGER NOT NULL,
+			last_row INTEGER NOT NULL,
+			first_record INTEGER NOT NULL,
+			PRIMARY KEY (table_name, first_row)
+		)
+	`)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create file rows table: %w", err)
+	}
+
+	if err := migrateMetadata(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate metadata table: %w", err)
+	}
+
 	m := &Manager{
-		db:       db,
-		metadata: make(map[string]int64),
+		db:          db,
+		path:        dbPath,
+		metadata:    make(map[string]int64),
+		writtenBack: make(map[string]int64),
 	}
 
 	// Load existing metadata
@@ -53,6 +145,50 @@ func New(dbPath string) (*Manager, error) {
 	return m, nil
 }
 
+// metadataColumns are the per-file details recorded in _csvql_metadata in
+// addition to the table name, path and modification time. They are added to
+// databases created by older versions on open.
+var metadataColumns = []struct {
+	name       string
+	definition string
+}{
+	{"delimiter", "TEXT"},
+	{"quote", "TEXT"},
+	{"has_header", "INTEGER"},
+	{"encoding", "TEXT"},
+}
+
+// migrateMetadata adds any missing metadataColumns to _csvql_metadata
+func migrateMetadata(db *sql.DB) error {
+	rows, err := db.Query("SELECT name FROM pragma_table_info('_csvql_metadata')")
+	if err != nil {
+		return err
+	}
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		existing[name] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, col := range metadataColumns {
+		if existing[col.name] {
+			continue
+		}
+		if _, err := db.Exec(fmt.Sprintf("ALTER TABLE _csvql_metadata ADD COLUMN %s %s", col.name, col.definition)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // loadMetadata loads existing table metadata from database
 func (m *Manager) loadMetadata() error {
 	rows, err := m.db.Query("SELECT table_name, mod_time FROM _csvql_metadata")
@@ -72,6 +208,27 @@ func (m *Manager) loadMetadata() error {
 	return rows.Err()
 }
 
+// runeText stores a dialect character as text, or NULL when unknown
+func runeText(r rune) interface{} {
+	if r == 0 {
+		return nil
+	}
+	return string(r)
+}
+
+// nullIfEmpty stores an unknown (empty) text detail as NULL
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// quoteIdent quotes an SQLite identifier, doubling any embedded double quotes
+func quoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
 // NeedsUpdate checks if a file needs to be reloaded
 func (m *Manager) NeedsUpdate(tableName string, modTime int64) bool {
 	m.mu.RLock()
@@ -81,12 +238,66 @@ func (m *Manager) NeedsUpdate(tableName string, modTime int64) bool {
 	return !exists || existingModTime != modTime
 }
 
+// BatchReader supplies records in batches, returning io.EOF when exhausted
+type BatchReader interface {
+	ReadBatch() ([][]string, error)
+}
+
+// recordSlice serves the records of an in-memory ParsedFile as a single batch
+type recordSlice struct {
+	records   [][]string
+	rowErrors []loader.RowError
+	done      bool
+}
+
+func (r *recordSlice) TakeRowErrors() []loader.RowError {
+	rowErrors := r.rowErrors
+	r.rowErrors = nil
+	return rowErrors
+}
+
+func (r *recordSlice) ReadBatch() ([][]string, error) {
+	if r.done || len(r.records) == 0 {
+		return nil, io.EOF
+	}
+	r.done = true
+	return r.records, nil
+}
+
 // LoadFile loads a parsed CSV/TSV file into SQLite
 func (m *Manager) LoadFile(parsed *loader.ParsedFile) error {
-	m.mu.Lock()
-	defer m.mu.Unlock()
+	info := parsed.Info
 
-	tableName := parsed.Info.TableName
+	// Use the inferred column types, inferring them here if the loader did not
+	if len(info.ColumnTypes) != len(info.Headers) {
+		info.ColumnTypes = loader.InferColumnTypes(len(info.Headers), parsed.Records)
+	}
+
+	return m.LoadBatches(info, &recordSlice{records: parsed.Records, rowErrors: parsed.RowErrors})
+}
+
+// LoadReader streams a CSV/TSV file into SQLite batch by batch
+func (m *Manager) LoadReader(r *loader.Reader) error {
+	return m.LoadBatches(r.Info, r)
+}
+
+// LoadBatches creates the table described by info and inserts the records
+// from batches inside a single transaction. Only one batch is held in memory
+// at a time. The rows are written to a shadow table that replaces the live
+// table by rename at commit, so queries keep running against the previous
+// version until the new one is complete.
+func (m *Manager) LoadBatches(info loader.FileInfo, batches BatchReader) error {
+	m.writeMu.Lock()
+	defer m.writeMu.Unlock()
+
+	tableName := info.TableName
+	columnTypes := info.ColumnTypes
+	if len(columnTypes) != len(info.Headers) {
+		columnTypes = make([]loader.ColumnType, len(info.Headers))
+		for i := range columnTypes {
+			columnTypes[i] = loader.TypeText
+		}
+	}
 
 	// Start transaction
 	tx, err := m.db.Begin()
@@ -95,62 +306,67 @@ func (m *Manager) LoadFile(parsed *loader.ParsedFile) error {
 	}
 	defer tx.Rollback()
 
-	// Drop existing table
-	_, err = tx.Exec(fmt.Sprintf("DROP TABLE IF EXISTS %s", tableName))
+	shadowName := shadowPrefix + tableName
+	_, err = tx.Exec(fmt.Sprintf("DROP TABLE IF EXISTS %s", quoteIdent(shadowName)))
 	if err != nil {
-		return fmt.Errorf("failed to drop table %s: %w", tableName, err)
+		return fmt.Errorf("failed to drop table %s: %w", shadowName, err)
 	}
 
 	// Build column definitions
-	columns := make([]string, len(parsed.Info.Headers))
-	columnNames := make([]string, len(parsed.Info.Headers))
-	for i, header := range parsed.Info.Headers {
-		colName := loader.SanitizeColumnName(header)
-		// Handle duplicate column names
-		baseName := colName
-		counter := 1
-		for j := 0; j < i; j++ {
-			if columnNames[j] == colName {
-				colName = fmt.Sprintf("%s_%d", baseName, counter)
-				counter++
-			}
-		}
-		columnNames[i] = colName
-		columns[i] = fmt.Sprintf("%s TEXT", colName)
+	columnNames := loader.SanitizeColumnNames(info.Headers)
+	columns := make([]string, len(columnNames))
+	quotedNames := make([]string, len(columnNames))
+	for i, colName := range columnNames {
+		quotedNames[i] = quoteIdent(colName)
+		columns[i] = fmt.Sprintf("%s %s", quotedNames[i], columnTypes[i])
 	}
 
-	// Create table
-	createSQL := fmt.Sprintf("CREATE TABLE %s (%s)", tableName, strings.Join(columns, ", "))
+	// Create shadow table
+	createSQL := fmt.Sprintf("CREATE TABLE %s (%s)", quoteIdent(shadowName), strings.Join(columns, ", "))
 	_, err = tx.Exec(createSQL)
 	if err != nil {
 		return fmt.Errorf("failed to create table %s: %w", tableName, err)
 	}
 
 	// Insert data
-	if len(parsed.Records) > 0 {
-		placeholders := make([]string, len(columnNames))
-		for i := range placeholders {
-			placeholders[i] = "?"
-		}
-		insertSQL := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
-			tableName,
-			strings.Join(columnNames, ", "),
-			strings.Join(placeholders, ", "))
+	placeholders := make([]string, len(columnNames))
+	for i := range placeholders {
+		placeholders[i] = "?"
+	}
+	insertSQL := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
+		quoteIdent(shadowName),
+		strings.Join(quotedNames, ", "),
+		strings.Join(placeholders, ", "))
+
+	stmt, err := tx.Prepare(insertSQL)
+	if err != nil {
+		return fmt.Errorf("failed to prepare insert: %w", err)
+	}
+	defer stmt.Close()
+
+	if _, err := tx.Exec("DELETE FROM _csvql_errors WHERE table_name = ?", tableName); err != nil {
+		return fmt.Errorf("failed to clear bad rows of %s: %w", tableName, err)
+	}
 
-		stmt, err := tx.Prepare(insertSQL)
+	values := make([]interface{}, len(columnNames))
+	for {
+		batch, err := batches.ReadBatch()
+		if err == io.EOF {
+			break
+		}
 		if err != nil {
-			return fmt.Errorf("failed to prepare insert: %w", err)
+			return err
+		}
+		if err := recordRowErrors(tx, tableName, info.Path, batches); err != nil {
+			return err
 		}
-		defer stmt.Close()
 
-		for _, record := range parsed.Records {
-			// Pad or trim record to match column count
-			values := make([]interface{}, len(columnNames))
+		for _, record := range batch {
+			// Pad or trim record to match column count; missing cells become NULL
 			for i := range values {
+				values[i] = nil
 				if i < len(record) {
-					values[i] = record[i]
-				} else {
-					values[i] = ""
+					values[i] = loader.ConvertValue(record[i], columnTypes[i])
 				}
 			}
 			_, err = stmt.Exec(values...)
@@ -160,29 +376,125 @@ func (m *Manager) LoadFile(parsed *loader.ParsedFile) error {
 		}
 	}
 
+	stmt.Close()
+	if err := recordRowErrors(tx, tableName, info.Path, batches); err != nil {
+		return err
+	}
+
+	// Swap the shadow table in; readers are only held off for the swap itself
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, err = tx.Exec(fmt.Sprintf("DROP TABLE IF EXISTS %s", quoteIdent(tableName)))
+	if err != nil {
+		return fmt.Errorf("failed to drop table %s: %w", tableName, err)
+	}
+
+	_, err = tx.Exec(fmt.Sprintf("ALTER TABLE %s RENAME TO %s", quoteIdent(shadowName), quoteIdent(tableName)))
+	if err != nil {
+		return fmt.Errorf("failed to rename %s to %s: %w", shadowName, tableName, err)
+	}
+
 	// Update metadata
 	_, err = tx.Exec(`
-		INSERT OR REPLACE INTO _csvql_metadata (table_name, file_path, mod_time)
-		VALUES (?, ?, ?)
-	`, tableName, parsed.Info.Path, parsed.Info.ModTime)
+		INSERT OR REPLACE INTO _csvql_metadata (table_name, file_path, mod_time, delimiter, quote, has_header, encoding)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, tableName, info.Path, info.ModTime, runeText(info.Delimiter), runeText(info.Quote), info.HasHeader, nullIfEmpty(info.Encoding))
 	if err != nil {
 		return fmt.Errorf("failed to update metadata: %w", err)
 	}
 
+	// The table may have been a union table before
+	_, err = tx.Exec("DELETE FROM _csvql_partitions WHERE table_name = ?", tableName)
+	if err != nil {
+		return fmt.Errorf("failed to update partitions: %w", err)
+	}
+
+	// The file replaces any edits not yet written back
+	if err := clearChanges(tx, tableName); err != nil {
+		return err
+	}
+	if m.writeBack {
+		if err := createChangeTriggers(tx, tableName); err != nil {
+			return err
+		}
+	}
+
 	if err := tx.Commit(); err != nil {
 		return fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
-	m.metadata[tableName] = parsed.Info.ModTime
+	m.metadata[tableName] = info.ModTime
+	return nil
+}
+
+// rowErrorSource is implemented by readers that set malformed rows aside
+// instead of failing, such as loader.Reader
+type rowErrorSource interface {
+	TakeRowErrors() []loader.RowError
+}
+
+// recordRowErrors stores the malformed rows a reader set aside since the
+// last call in _csvql_errors
+func recordRowErrors(tx *sql.Tx, tableName, filePath string, reader interface{}) error {
+	src, ok := reader.(rowErrorSource)
+	if !ok {
+		return nil
+	}
+	tableName = strings.TrimPrefix(tableName, shadowPrefix)
+	for _, rowErr := range src.TakeRowErrors() {
+		_, err := tx.Exec("INSERT INTO _csvql_errors (table_name, file_path, line, raw, reason) VALUES (?, ?, ?, ?, ?)",
+			tableName, filePath, rowErr.Line, rowErr.Raw, rowErr.Reason)
+		if err != nil {
+			return fmt.Errorf("failed to record bad row of %s: %w", filePath, err)
+		}
+	}
 	return nil
 }
 
+// RowErrorCounts returns the number of malformed rows left out of each
+// table that has any
+func (m *Manager) RowErrorCounts() (map[string]int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	rows, err := m.db.Query("SELECT table_name, COUNT(*) FROM _csvql_errors GROUP BY table_name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int64)
+	for rows.Next() {
+		var tableName string
+		var n int64
+		if err := rows.Scan(&tableName, &n); err != nil {
+			return nil, err
+		}
+		counts[tableName] = n
+	}
+	return counts, rows.Err()
+}
+
+// FileRowErrorCount returns the number of malformed rows of a file that
+// were left out of its table
+func (m *Manager) FileRowErrorCount(filePath string) (int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var n int64
+	err := m.db.QueryRow("SELECT COUNT(*) FROM _csvql_errors WHERE file_path = ?", filePath).Scan(&n)
+	return n, err
+}
+
 // RemoveTable removes a table from the database
 func (m *Manager) RemoveTable(tableName string) error {
+	m.writeMu.Lock()
+	defer m.writeMu.Unlock()
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	_, err := m.db.Exec(fmt.Sprintf("DROP TABLE IF EXISTS %s", tableName))
+	_, err := m.db.Exec(fmt.Sprintf("DROP TABLE IF EXISTS %s", quoteIdent(tableName)))
 	if err != nil {
 		return err
 	}
@@ -192,12 +504,28 @@ func (m *Manager) RemoveTable(tableName string) error {
 		return err
 	}
 
+	_, err = m.db.Exec("DELETE FROM _csvql_partitions WHERE table_name = ?", tableName)
+	if err != nil {
+		return err
+	}
+
+	_, err = m.db.Exec("DELETE FROM _csvql_errors WHERE table_name = ?", tableName)
+	if err != nil {
+		return err
+	}
+
+	if err := clearChanges(m.db, tableName); err != nil {
+		return err
+	}
+
 	delete(m.metadata, tableName)
 	return nil
 }
 
 // RemoveTableByPath removes a table associated with the given file path
 func (m *Manager) RemoveTableByPath(filePath string) error {
+	m.writeMu.Lock()
+	defer m.writeMu.Unlock()
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
@@ -208,7 +536,7 @@ func (m *Manager) RemoveTableByPath(filePath string) error {
 		return fmt.Errorf("no table found for path %s: %w", filePath, err)
 	}
 
-	_, err = m.db.Exec(fmt.Sprintf("DROP TABLE IF EXISTS %s", tableName))
+	_, err = m.db.Exec(fmt.Sprintf("DROP TABLE IF EXISTS %s", quoteIdent(tableName)))
 	if err != nil {
 		return err
 	}
@@ -218,12 +546,28 @@ func (m *Manager) RemoveTableByPath(filePath string) error {
 		return err
 	}
 
+	_, err = m.db.Exec("DELETE FROM _csvql_partitions WHERE table_name = ?", tableName)
+	if err != nil {
+		return err
+	}
+
+	_, err = m.db.Exec("DELETE FROM _csvql_errors WHERE table_name = ?", tableName)
+	if err != nil {
+		return err
+	}
+
+	if err := clearChanges(m.db, tableName); err != nil {
+		return err
+	}
+
 	delete(m.metadata, tableName)
 	return nil
 }
 
 // RenameTable renames a table and updates metadata
 func (m *Manager) RenameTable(oldName, newName string) error {
+	m.writeMu.Lock()
+	defer m.writeMu.Unlock()
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
@@ -231,7 +575,7 @@ func (m *Manager) RenameTable(oldName, newName string) error {
 		return nil
 	}
 
-	_, err := m.db.Exec(fmt.Sprintf("ALTER TABLE %s RENAME TO %s", oldName, newName))
+	_, err := m.db.Exec(fmt.Sprintf("ALTER TABLE %s RENAME TO %s", quoteIdent(oldName), quoteIdent(newName)))
 	if err != nil {
 		return err
 	}
@@ -241,6 +585,39 @@ func (m *Manager) RenameTable(oldName, newName string) error {
 		return err
 	}
 
+	_, err = m.db.Exec("UPDATE _csvql_partitions SET table_name = ? WHERE table_name = ?", newName, oldName)
+	if err != nil {
+		return err
+	}
+
+	_, err = m.db.Exec("UPDATE _csvql_errors SET table_name = ? WHERE table_name = ?", newName, oldName)
+	if err != nil {
+		return err
+	}
+
+	for _, table := range changeTables {
+		_, err = m.db.Exec(fmt.Sprintf("UPDATE %s SET table_name = ? WHERE table_name = ?", table), newName, oldName)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Change triggers are named after the table and log its name
+	if err := dropChangeTriggers(m.db, oldName); err != nil {
+		return err
+	}
+	if m.writeBack {
+		partitions, err := m.partitionModTimes(newName)
+		if err != nil {
+			return err
+		}
+		if len(partitions) == 0 {
+			if err := createChangeTriggers(m.db, newName); err != nil {
+				return err
+			}
+		}
+	}
+
 	if modTime, exists := m.metadata[oldName]; exists {
 		delete(m.metadata, oldName)
 		m.metadata[newName] = modTime
@@ -273,44 +650,69 @@ func (m *Manager) GetAllTableMappings() (map[string]string, error) {
 
 // Query executes a SQL query and returns results
 func (m *Manager) Query(query string) ([]string, [][]string, error) {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-
-	rows, err := m.db.Query(query)
+	rows, err := m.QueryContext(context.Background(), query)
 	if err != nil {
 		return nil, nil, err
 	}
 	defer rows.Close()
 
-	columns, err := rows.Columns()
+	var results [][]string
+	for rows.Next() {
+		values := rows.Values()
+		row := make([]string, len(values))
+		for i, v := range values {
+			row[i] = formatValue(v)
+		}
+		results = append(results, row)
+	}
+
+	return rows.Columns(), results, rows.Err()
+}
+
+// QueryValues executes a SQL query and returns typed results: int64, float64,
+// bool, string or nil. Dates and times are returned as ISO-8601 strings.
+func (m *Manager) QueryValues(query string) ([]string, [][]interface{}, error) {
+	rows, err := m.QueryContext(context.Background(), query)
 	if err != nil {
 		return nil, nil, err
 	}
+	defer rows.Close()
 
-	var results [][]string
+	var results [][]interface{}
 	for rows.Next() {
-		values := make([]interface{}, len(columns))
-		valuePtrs := make([]interface{}, len(columns))
-		for i := range values {
-			valuePtrs[i] = &values[i]
-		}
+		results = append(results, rows.Values())
+	}
 
-		if err := rows.Scan(valuePtrs...); err != nil {
-			return nil, nil, err
-		}
+	return rows.Columns(), results, rows.Err()
+}
 
-		row := make([]string, len(columns))
-		for i, v := range values {
-			if v == nil {
-				row[i] = "NULL"
-			} else {
-				row[i] = fmt.Sprintf("%v", v)
-			}
+// normalizeValue converts driver values to plain Go types. The SQLite driver
+// returns DATE/DATETIME columns as time.Time, which are turned back into
+// ISO-8601 strings, and TEXT stored as bytes into strings.
+func normalizeValue(v interface{}, dbType string) interface{} {
+	switch val := v.(type) {
+	case []byte:
+		return string(val)
+	case time.Time:
+		switch {
+		case dbType == string(loader.TypeDate):
+			return val.Format("2006-01-02")
+		case val.Location() == time.UTC:
+			return val.Format("2006-01-02 15:04:05.999999999")
+		default:
+			return val.Format("2006-01-02 15:04:05.999999999-07:00")
 		}
-		results = append(results, row)
+	default:
+		return val
 	}
+}
 
-	return columns, results, rows.Err()
+// formatValue renders a normalised value as text, with NULL for nil
+func formatValue(v interface{}) string {
+	if v == nil {
+		return "NULL"
+	}
+	return fmt.Sprintf("%v", v)
 }
 
 // ListTables returns all loaded CSV/TSV tables
@@ -337,16 +739,35 @@ func (m *Manager) ListTables() ([]string, error) {
 
 // GetTableInfo returns column info for a table
 func (m *Manager) GetTableInfo(tableName string) ([]string, error) {
+	cols, err := m.GetTableColumns(tableName)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(cols))
+	for i, col := range cols {
+		names[i] = col.Name
+	}
+	return names, nil
+}
+
+// Column is a column of a table and its declared type, such as INTEGER
+type Column struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// GetTableColumns returns the columns of a table with their declared types
+func (m *Manager) GetTableColumns(tableName string) ([]Column, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
-	rows, err := m.db.Query(fmt.Sprintf("PRAGMA table_info(%s)", tableName))
+	rows, err := m.db.Query(fmt.Sprintf("PRAGMA table_info(%s)", quoteIdent(tableName)))
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var columns []string
+	var columns []Column
 	for rows.Next() {
 		var cid int
 		var name, ctype string
@@ -355,16 +776,48 @@ func (m *Manager) GetTableInfo(tableName string) ([]string, error) {
 		if err := rows.Scan(&cid, &name, &ctype, &notnull, &dfltValue, &pk); err != nil {
 			return nil, err
 		}
-		columns = append(columns, name)
+		columns = append(columns, Column{Name: name, Type: ctype})
 	}
 	return columns, rows.Err()
 }
 
+// GetTableSchema returns the CREATE TABLE statement for a table
+func (m *Manager) GetTableSchema(tableName string) (string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var schema string
+	err := m.db.QueryRow("SELECT sql FROM sqlite_master WHERE type = 'table' AND name = ?", tableName).Scan(&schema)
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("no such table: %s", tableName)
+	}
+	return schema, err
+}
+
 // Close closes the database connection
 func (m *Manager) Close() error {
 	return m.db.Close()
 }
 
+// OpenConn opens a new driver connection to the database file, for
+// database/sql drivers built on the manager. Loads committed by the manager
+// are visible to it once they commit; it waits up to busyTimeout for the
+// manager's write transactions to finish. Unless writes are allowed, it
+// refuses statements that would modify the database.
+func (m *Manager) OpenConn() (driver.Conn, error) {
+	conn, err := (&sqlite3.SQLiteDriver{}).Open(fmt.Sprintf("%s?_busy_timeout=%d", m.path, busyTimeout.Milliseconds()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database connection: %w", err)
+	}
+	if m.AllowWrites() {
+		return conn, nil
+	}
+	return newReadOnlyDriverConn(conn.(*sqlite3.SQLiteConn)), nil
+}
+
+// busyTimeout bounds how long an OpenConn connection waits for a lock
+const busyTimeout = 5 * time.Second
+
 // DB returns the underlying database connection (for testing)
 func (m *Manager) DB() *sql.DB {
 	return m.db
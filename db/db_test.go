@@ -1,10 +1,18 @@
 package db
 
 import (
+	"context"
 	"csvql/loader"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestNew(t *testing.T) {
@@ -110,6 +118,57 @@ func TestLoadFile_DuplicateColumns(t *testing.T) {
 	}
 }
 
+func TestLoadFile_ReservedWordIdentifiers(t *testing.T) {
+	tmpDir := t.TempDir()
+	dbPath := filepath.Join(tmpDir, "test.db")
+
+	m, err := New(dbPath)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer m.Close()
+
+	parsed := &loader.ParsedFile{
+		Info: loader.FileInfo{
+			Path:      "/test/order.csv",
+			TableName: "order",
+			Headers:   []string{"order", "group", "select", "a&b", "price$"},
+			ModTime:   12345,
+		},
+		Records: [][]string{{"1", "g", "s", "x", "9.5"}},
+	}
+
+	if err := m.LoadFile(parsed); err != nil {
+		t.Fatalf("LoadFile failed: %v", err)
+	}
+
+	cols, err := m.GetTableInfo("order")
+	if err != nil {
+		t.Fatalf("GetTableInfo failed: %v", err)
+	}
+	expected := []string{"order", "group", "select", "a_b", "price"}
+	for i, col := range cols {
+		if col != expected[i] {
+			t.Errorf("Expected column %q, got %q", expected[i], col)
+		}
+	}
+
+	if err := m.RenameTable("order", "group"); err != nil {
+		t.Fatalf("RenameTable failed: %v", err)
+	}
+	_, rows, err := m.Query(`SELECT "select", price FROM "group"`)
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(rows) != 1 || rows[0][0] != "s" {
+		t.Errorf("Unexpected rows: %v", rows)
+	}
+
+	if err := m.RemoveTable("group"); err != nil {
+		t.Fatalf("RemoveTable failed: %v", err)
+	}
+}
+
 func TestNeedsUpdate(t *testing.T) {
 	tmpDir := t.TempDir()
 	dbPath := filepath.Join(tmpDir, "test.db")
@@ -572,9 +631,9 @@ func TestLoadFile_UnevenRows(t *testing.T) {
 		t.Errorf("Expected 3 rows, got %d", len(rows))
 	}
 
-	// Missing values should be empty strings
-	if rows[1][2] != "" {
-		t.Errorf("Expected empty string for missing column, got %q", rows[1][2])
+	// Missing values should be NULL
+	if rows[1][2] != "NULL" {
+		t.Errorf("Expected NULL for missing column, got %q", rows[1][2])
 	}
 }
 
@@ -598,3 +657,714 @@ func TestDatabaseFile_Created(t *testing.T) {
 		t.Error("Database file should exist after creation")
 	}
 }
+
+func TestLoadFile_TypedColumns(t *testing.T) {
+	tmpDir := t.TempDir()
+	dbPath := filepath.Join(tmpDir, "test.db")
+
+	m, err := New(dbPath)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer m.Close()
+
+	parsed := &loader.ParsedFile{
+		Info: loader.FileInfo{
+			Path:      "/test/typed.csv",
+			TableName: "typed",
+			Headers:   []string{"id", "salary", "active", "hired", "note"},
+			ModTime:   12345,
+		},
+		Records: [][]string{
+			{"1", "900", "true", "2024-01-15", "x"},
+			{"2", "10000.5", "false", "2023-06-01", ""},
+			{"3", "95", "TRUE", "2022-12-31", "z"},
+		},
+	}
+
+	if err := m.LoadFile(parsed); err != nil {
+		t.Fatalf("LoadFile failed: %v", err)
+	}
+
+	// Numeric ordering, not lexical
+	_, rows, err := m.Query("SELECT id FROM typed ORDER BY salary")
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if rows[0][0] != "3" || rows[1][0] != "1" || rows[2][0] != "2" {
+		t.Errorf("Expected numeric ordering 3,1,2, got %v", rows)
+	}
+
+	// Declared types
+	var colType string
+	m.db.QueryRow("SELECT type FROM pragma_table_info('typed') WHERE name = 'salary'").Scan(&colType)
+	if colType != "REAL" {
+		t.Errorf("Expected salary REAL, got %q", colType)
+	}
+
+	// Empty cells are NULL, booleans and dates round-trip
+	_, rows, err = m.Query("SELECT note, active, hired FROM typed WHERE id = 2")
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if rows[0][0] != "NULL" {
+		t.Errorf("Expected NULL for empty cell, got %q", rows[0][0])
+	}
+	if rows[0][1] != "false" {
+		t.Errorf("Expected boolean false, got %q", rows[0][1])
+	}
+	if rows[0][2] != "2023-06-01" {
+		t.Errorf("Expected date 2023-06-01, got %q", rows[0][2])
+	}
+}
+
+func TestQueryValues_Typed(t *testing.T) {
+	tmpDir := t.TempDir()
+	dbPath := filepath.Join(tmpDir, "test.db")
+
+	m, err := New(dbPath)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer m.Close()
+
+	parsed := &loader.ParsedFile{
+		Info: loader.FileInfo{
+			Path:      "/test/values.csv",
+			TableName: "vals",
+			Headers:   []string{"n", "f", "b", "d", "s"},
+			ModTime:   12345,
+		},
+		Records: [][]string{{"7", "2.5", "true", "2024-03-01", ""}},
+	}
+	m.LoadFile(parsed)
+
+	_, rows, err := m.QueryValues("SELECT n, f, b, d, s FROM vals")
+	if err != nil {
+		t.Fatalf("QueryValues failed: %v", err)
+	}
+
+	expected := []interface{}{int64(7), 2.5, true, "2024-03-01", nil}
+	for i, want := range expected {
+		if rows[0][i] != want {
+			t.Errorf("column %d: got %#v, want %#v", i, rows[0][i], want)
+		}
+	}
+}
+
+func TestLoadReader_Streaming(t *testing.T) {
+	tmpDir := t.TempDir()
+	dbPath := filepath.Join(tmpDir, "test.db")
+
+	m, err := New(dbPath)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer m.Close()
+
+	csvPath := filepath.Join(tmpDir, "stream.csv")
+	content := "n,label\n"
+	for i := 1; i <= 250; i++ {
+		content += fmt.Sprintf("%d,row%d\n", i, i)
+	}
+	os.WriteFile(csvPath, []byte(content), 0644)
+
+	reader, err := loader.OpenFile(csvPath, tmpDir)
+	if err != nil {
+		t.Fatalf("OpenFile failed: %v", err)
+	}
+	defer reader.Close()
+	reader.BatchSize = 100
+
+	if err := m.LoadReader(reader); err != nil {
+		t.Fatalf("LoadReader failed: %v", err)
+	}
+
+	_, rows, err := m.Query("SELECT COUNT(*), SUM(n) FROM stream")
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if rows[0][0] != "250" || rows[0][1] != "31375" {
+		t.Errorf("Expected 250 rows summing to 31375, got %v", rows[0])
+	}
+}
+
+// blockingBatches hands out one batch, then waits for release before finishing
+type blockingBatches struct {
+	started chan struct{}
+	release chan struct{}
+	sent    bool
+}
+
+func (b *blockingBatches) ReadBatch() ([][]string, error) {
+	if b.sent {
+		return nil, io.EOF
+	}
+	b.sent = true
+	close(b.started)
+	<-b.release
+	return [][]string{{"new"}}, nil
+}
+
+func TestLoadBatches_QueriesSeeOldTableDuringLoad(t *testing.T) {
+	tmpDir := t.TempDir()
+	dbPath := filepath.Join(tmpDir, "test.db")
+
+	m, err := New(dbPath)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer m.Close()
+
+	info := loader.FileInfo{
+		Path:      "/test/swap.csv",
+		TableName: "swap",
+		Headers:   []string{"val"},
+		ModTime:   100,
+	}
+	if err := m.LoadFile(&loader.ParsedFile{Info: info, Records: [][]string{{"old"}}}); err != nil {
+		t.Fatalf("LoadFile failed: %v", err)
+	}
+
+	batches := &blockingBatches{started: make(chan struct{}), release: make(chan struct{})}
+	info.ModTime = 200
+	loadErr := make(chan error, 1)
+	go func() {
+		loadErr <- m.LoadBatches(info, batches)
+	}()
+	<-batches.started
+
+	// The load is in progress: queries must not block and must see the old rows
+	queried := make(chan [][]string, 1)
+	go func() {
+		_, rows, err := m.Query("SELECT val FROM swap")
+		if err != nil {
+			t.Errorf("Query during load failed: %v", err)
+		}
+		queried <- rows
+	}()
+
+	select {
+	case rows := <-queried:
+		if len(rows) != 1 || rows[0][0] != "old" {
+			t.Errorf("Expected old data during load, got %v", rows)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Query blocked while the table was loading")
+	}
+
+	close(batches.release)
+	if err := <-loadErr; err != nil {
+		t.Fatalf("LoadBatches failed: %v", err)
+	}
+
+	_, rows, _ := m.Query("SELECT val FROM swap")
+	if len(rows) != 1 || rows[0][0] != "new" {
+		t.Errorf("Expected new data after load, got %v", rows)
+	}
+
+	tables, _ := m.ListTables()
+	if len(tables) != 1 || tables[0] != "swap" {
+		t.Errorf("Expected only the swap table, got %v", tables)
+	}
+
+	var shadows int
+	m.db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name LIKE '\\_\\_csvql\\_tmp\\_%' ESCAPE '\\'").Scan(&shadows)
+	if shadows != 0 {
+		t.Errorf("Expected no leftover shadow tables, got %d", shadows)
+	}
+}
+
+func TestRows_DoNotBlockLoads(t *testing.T) {
+	tmpDir := t.TempDir()
+	m, err := New(filepath.Join(tmpDir, "test.db"))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer m.Close()
+
+	info := loader.FileInfo{Path: "/test/open.csv", TableName: "open", Headers: []string{"val"}, ModTime: 1}
+	if err := m.LoadFile(&loader.ParsedFile{Info: info, Records: [][]string{{"a"}, {"b"}}}); err != nil {
+		t.Fatalf("LoadFile failed: %v", err)
+	}
+
+	// A result left open, as by a slow client, holds no manager lock
+	rows, err := m.QueryContext(context.Background(), "SELECT val FROM open")
+	if err != nil {
+		t.Fatalf("QueryContext failed: %v", err)
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		t.Fatalf("Expected a row: %v", rows.Err())
+	}
+
+	loaded := make(chan error, 1)
+	go func() {
+		info.ModTime = 2
+		loaded <- m.LoadFile(&loader.ParsedFile{Info: info, Records: [][]string{{"new"}}})
+	}()
+	select {
+	case err := <-loaded:
+		if err != nil {
+			t.Fatalf("LoadFile failed: %v", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("LoadFile blocked on an open result")
+	}
+	if tables, err := m.ListTables(); err != nil || len(tables) != 1 {
+		t.Errorf("Expected the table listed, got %v (%v)", tables, err)
+	}
+
+	// The open result keeps reading its snapshot
+	if !rows.Next() || rows.Values()[0] != "b" {
+		t.Errorf("Expected the old second row, got %v (%v)", rows.Values(), rows.Err())
+	}
+	if _, result, _ := m.Query("SELECT val FROM open"); len(result) != 1 || result[0][0] != "new" {
+		t.Errorf("Expected the new table for new queries, got %v", result)
+	}
+}
+
+func TestLoadFile_DialectMetadata(t *testing.T) {
+	tmpDir := t.TempDir()
+	dbPath := filepath.Join(tmpDir, "test.db")
+
+	// A metadata table from an older version, without dialect columns
+	old, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	old.Exec("CREATE TABLE _csvql_metadata (table_name TEXT PRIMARY KEY, file_path TEXT NOT NULL, mod_time INTEGER NOT NULL)")
+	old.Close()
+
+	m, err := New(dbPath)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer m.Close()
+
+	parsed := &loader.ParsedFile{
+		Info: loader.FileInfo{
+			Path:      "/test/euro.csv",
+			TableName: "euro",
+			Delimiter: ';',
+			Quote:     '\'',
+			HasHeader: true,
+			Headers:   []string{"a"},
+			ModTime:   12345,
+		},
+		Records: [][]string{{"1"}},
+	}
+	if err := m.LoadFile(parsed); err != nil {
+		t.Fatalf("LoadFile failed: %v", err)
+	}
+
+	var delimiter, quote string
+	var hasHeader bool
+	err = m.db.QueryRow("SELECT delimiter, quote, has_header FROM _csvql_metadata WHERE table_name = 'euro'").
+		Scan(&delimiter, &quote, &hasHeader)
+	if err != nil {
+		t.Fatalf("Metadata query failed: %v", err)
+	}
+	if delimiter != ";" || quote != "'" || !hasHeader {
+		t.Errorf("Unexpected dialect metadata: %q %q %v", delimiter, quote, hasHeader)
+	}
+}
+
+func TestSyncUnion(t *testing.T) {
+	tmpDir := t.TempDir()
+	m, err := New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer m.Close()
+
+	dir := filepath.Join(tmpDir, "events")
+	jan := filepath.Join(dir, "year=2024", "month=01", "part-0.csv")
+	feb := filepath.Join(dir, "year=2024", "month=02", "part-0.csv")
+	for _, f := range []string{jan, feb} {
+		os.MkdirAll(filepath.Dir(f), 0755)
+	}
+	os.WriteFile(jan, []byte("id,kind\n1,click\n2,view"), 0644)
+	os.WriteFile(feb, []byte("id,kind\n3,click"), 0644)
+
+	set := &loader.FileSet{Key: dir, Dir: dir, Files: []string{jan, feb}}
+	open := func(path string) (*loader.Reader, error) {
+		return loader.OpenFile(path, tmpDir, "events")
+	}
+
+	loaded, _, err := m.SyncUnion(set, "events", tmpDir, open)
+	if err != nil {
+		t.Fatalf("SyncUnion failed: %v", err)
+	}
+	if len(loaded) != 2 {
+		t.Errorf("Expected both files loaded, got %v", loaded)
+	}
+
+	cols, _ := m.GetTableInfo("events")
+	expectedCols := []string{"id", "kind", "year", "month", "_source_file"}
+	if len(cols) != len(expectedCols) {
+		t.Fatalf("Expected columns %v, got %v", expectedCols, cols)
+	}
+	for i := range expectedCols {
+		if cols[i] != expectedCols[i] {
+			t.Errorf("Expected columns %v, got %v", expectedCols, cols)
+			break
+		}
+	}
+
+	_, rows, _ := m.Query("SELECT id, year, month, _source_file FROM events ORDER BY id")
+	if len(rows) != 3 || rows[2][2] != "02" || rows[2][3] != "events/year=2024/month=02/part-0.csv" {
+		t.Errorf("Unexpected rows: %v", rows)
+	}
+
+	// Only the changed partition is reloaded; a new column is added
+	os.WriteFile(feb, []byte("id,kind,user\n3,click,bob\n4,view,ann"), 0644)
+	future := time.Now().Add(time.Hour)
+	os.Chtimes(feb, future, future)
+
+	loaded, removed, err := m.SyncUnion(set, "events", tmpDir, open)
+	if err != nil {
+		t.Fatalf("SyncUnion failed: %v", err)
+	}
+	if len(loaded) != 1 || loaded[0] != feb || len(removed) != 0 {
+		t.Errorf("Expected only %s reloaded, got %v %v", feb, loaded, removed)
+	}
+
+	_, rows, _ = m.Query("SELECT COUNT(*), COUNT(user) FROM events")
+	if rows[0][0] != "4" || rows[0][1] != "2" {
+		t.Errorf("Expected 4 rows, 2 with user, got %v", rows)
+	}
+
+	// A file leaving the set has its rows deleted
+	set.Files = []string{feb}
+	loaded, removed, err = m.SyncUnion(set, "events", tmpDir, open)
+	if err != nil {
+		t.Fatalf("SyncUnion failed: %v", err)
+	}
+	if len(loaded) != 0 || len(removed) != 1 || removed[0] != jan {
+		t.Errorf("Expected %s removed, got %v %v", jan, loaded, removed)
+	}
+
+	_, rows, _ = m.Query("SELECT COUNT(*) FROM events")
+	if rows[0][0] != "2" {
+		t.Errorf("Expected 2 rows after removal, got %s", rows[0][0])
+	}
+
+	if table, ok := m.PartitionTable(feb); !ok || table != "events" {
+		t.Errorf("PartitionTable = %q %v", table, ok)
+	}
+}
+
+func TestSyncUnion_EmptyFiles(t *testing.T) {
+	tmpDir := t.TempDir()
+	m, err := New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer m.Close()
+
+	dir := filepath.Join(tmpDir, "sales")
+	os.MkdirAll(dir, 0755)
+	jan := filepath.Join(dir, "2024-01.csv")
+	feb := filepath.Join(dir, "2024-02.csv")
+	os.WriteFile(jan, []byte("id,amount\n1,10\n"), 0644)
+	os.WriteFile(feb, nil, 0644)
+
+	set := &loader.FileSet{Key: dir, Dir: dir, Table: "sales", Files: []string{jan, feb}}
+	open := func(path string) (*loader.Reader, error) {
+		return loader.OpenFile(path, tmpDir, "sales")
+	}
+
+	// An empty member, such as a part file being written, does not fail the table
+	if _, _, err := m.SyncUnion(set, "sales", tmpDir, open); err != nil {
+		t.Fatalf("SyncUnion failed: %v", err)
+	}
+	if _, rows, _ := m.Query("SELECT COUNT(*) FROM sales"); rows[0][0] != "1" {
+		t.Errorf("Expected 1 row, got %v", rows)
+	}
+
+	// Its modification time is recorded, so it is reloaded once written
+	loaded, _, err := m.SyncUnion(set, "sales", tmpDir, open)
+	if err != nil || len(loaded) != 0 {
+		t.Errorf("Expected nothing reloaded, got %v (%v)", loaded, err)
+	}
+	os.WriteFile(feb, []byte("id,amount\n2,20\n"), 0644)
+	future := time.Now().Add(time.Hour)
+	os.Chtimes(feb, future, future)
+	loaded, _, err = m.SyncUnion(set, "sales", tmpDir, open)
+	if err != nil || len(loaded) != 1 || loaded[0] != feb {
+		t.Errorf("Expected %s reloaded, got %v (%v)", feb, loaded, err)
+	}
+	if _, rows, _ := m.Query("SELECT SUM(amount) FROM sales"); rows[0][0] != "30" {
+		t.Errorf("Expected a total of 30, got %v", rows)
+	}
+
+	// Emptied again, its rows are removed
+	os.WriteFile(feb, nil, 0644)
+	future = future.Add(time.Hour)
+	os.Chtimes(feb, future, future)
+	if _, _, err := m.SyncUnion(set, "sales", tmpDir, open); err != nil {
+		t.Fatalf("SyncUnion failed: %v", err)
+	}
+	if _, rows, _ := m.Query("SELECT COUNT(*) FROM sales"); rows[0][0] != "1" {
+		t.Errorf("Expected 1 row, got %v", rows)
+	}
+}
+
+func TestQueryReadOnlyContext(t *testing.T) {
+	tmpDir := t.TempDir()
+	m, err := New(filepath.Join(tmpDir, "test.db"))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer m.Close()
+
+	parsed := &loader.ParsedFile{
+		Info: loader.FileInfo{
+			Path:      "/test/users.csv",
+			TableName: "users",
+			Delimiter: ',',
+			Headers:   []string{"id", "name"},
+			ModTime:   12345,
+		},
+		Records: [][]string{{"1", "Alice"}, {"2", "Bob"}},
+	}
+	if err := m.LoadFile(parsed); err != nil {
+		t.Fatalf("LoadFile failed: %v", err)
+	}
+
+	reads := []string{
+		"SELECT name FROM users WHERE id = 1",
+		"WITH RECURSIVE n(x) AS (SELECT 1 UNION ALL SELECT x + 1 FROM n WHERE x < 3) SELECT COUNT(*) FROM n",
+		"SELECT name FROM pragma_table_info('users')",
+		"PRAGMA table_info(users)",
+	}
+	for _, q := range reads {
+		rows, err := m.QueryReadOnlyContext(context.Background(), q)
+		if err != nil {
+			t.Errorf("Read-only query %q failed: %v", q, err)
+			continue
+		}
+		for _, err := range rows.All() {
+			if err != nil {
+				t.Errorf("Read-only query %q failed: %v", q, err)
+			}
+		}
+	}
+
+	writes := []string{
+		"DROP TABLE users",
+		"DELETE FROM _csvql_metadata",
+		"UPDATE users SET name = 'x'",
+		"SELECT 1; INSERT INTO users VALUES (3, 'Eve')",
+		"CREATE TABLE t (x)",
+		"PRAGMA user_version = 3",
+	}
+	for _, q := range writes {
+		rows, err := m.QueryReadOnlyContext(context.Background(), q)
+		if err == nil {
+			rows.Close()
+		}
+		if !errors.Is(err, ErrReadOnly) {
+			t.Errorf("Expected ErrReadOnly for %q, got %v", q, err)
+		}
+	}
+
+	// The connection is usable for writes again afterwards
+	_, rows, err := m.Query("SELECT COUNT(*) FROM users")
+	if err != nil || rows[0][0] != "2" {
+		t.Errorf("Expected users untouched, got %v (%v)", rows, err)
+	}
+	if err := m.RemoveTable("users"); err != nil {
+		t.Errorf("RemoveTable after read-only queries failed: %v", err)
+	}
+}
+
+func TestQueryContext_AllowWrites(t *testing.T) {
+	tmpDir := t.TempDir()
+	m, err := New(filepath.Join(tmpDir, "test.db"))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer m.Close()
+
+	parsed := &loader.ParsedFile{
+		Info: loader.FileInfo{
+			Path:      "/test/users.csv",
+			TableName: "users",
+			Headers:   []string{"id", "name"},
+		},
+		Records: [][]string{{"1", "Alice"}},
+	}
+	if err := m.LoadFile(parsed); err != nil {
+		t.Fatalf("LoadFile failed: %v", err)
+	}
+
+	// Queries are read-only by default, naming the refused statement
+	tests := map[string]string{
+		"DROP TABLE users":                  "DROP TABLE",
+		"CREATE INDEX i ON users(id)":       "CREATE INDEX",
+		"DELETE FROM _csvql_metadata":       "DELETE",
+		"CREATE TEMP VIEW v AS SELECT 1":    "CREATE VIEW",
+		"INSERT INTO users VALUES (2, 'x')": "INSERT",
+		"BEGIN":                             "BEGIN",
+		"SAVEPOINT sp":                      "SAVEPOINT",
+	}
+	for q, action := range tests {
+		_, _, err := m.Query(q)
+		if !errors.Is(err, ErrReadOnly) || !strings.HasSuffix(err.Error(), ": "+action) {
+			t.Errorf("Expected ErrReadOnly for %s on %q, got %v", action, q, err)
+		}
+	}
+
+	// VACUUM is refused while it runs
+	rows, err := m.QueryContext(context.Background(), "VACUUM")
+	if err == nil {
+		for _, err = range rows.All() {
+		}
+	}
+	if !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Expected ErrReadOnly for VACUUM, got %v", err)
+	}
+	if _, _, err := m.Query("SELECT * FROM nope"); err == nil || errors.Is(err, ErrReadOnly) {
+		t.Errorf("Expected a plain error after a refused statement, got %v", err)
+	}
+
+	conn, err := m.OpenConn()
+	if err != nil {
+		t.Fatalf("OpenConn failed: %v", err)
+	}
+	_, err = conn.(driver.ExecerContext).ExecContext(context.Background(), "DELETE FROM users", nil)
+	conn.Close()
+	if !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Expected ErrReadOnly from an OpenConn connection, got %v", err)
+	}
+
+	m.SetAllowWrites(true)
+	if _, _, err := m.Query("INSERT INTO users VALUES (2, 'Bob')"); err != nil {
+		t.Errorf("Expected writes to be allowed, got %v", err)
+	}
+	conn, err = m.OpenConn()
+	if err != nil {
+		t.Fatalf("OpenConn failed: %v", err)
+	}
+	defer conn.Close()
+	if _, err := conn.(driver.ExecerContext).ExecContext(context.Background(), "DELETE FROM users WHERE id = 2", nil); err != nil {
+		t.Errorf("Expected writes to be allowed on an OpenConn connection, got %v", err)
+	}
+
+	// QueryReadOnlyContext stays read-only
+	if _, err := m.QueryReadOnlyContext(context.Background(), "DELETE FROM users"); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Expected ErrReadOnly from QueryReadOnlyContext, got %v", err)
+	}
+}
+
+func TestWriteBack(t *testing.T) {
+	tmpDir := t.TempDir()
+	m, err := New(filepath.Join(tmpDir, "test.db"))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer m.Close()
+
+	parsed := &loader.ParsedFile{
+		Info: loader.FileInfo{
+			Path:      "/test/users.csv",
+			TableName: "users",
+			Headers:   []string{"id", "name"},
+			ModTime:   1,
+		},
+		Records: [][]string{{"1", "Alice"}, {"2", "Bob"}, {"3", "Carol"}, {"4", "Dave"}},
+	}
+	if err := m.LoadFile(parsed); err != nil {
+		t.Fatalf("LoadFile failed: %v", err)
+	}
+	if err := m.SetWriteBack(true); err != nil {
+		t.Fatalf("SetWriteBack failed: %v", err)
+	}
+
+	// Edits from any connection are logged, one version per row
+	other, err := sql.Open("sqlite3", filepath.Join(tmpDir, "test.db"))
+	if err != nil {
+		t.Fatalf("sql.Open failed: %v", err)
+	}
+	defer other.Close()
+	if _, err := other.Exec("UPDATE users SET name = upper(name) WHERE id = 2"); err != nil {
+		t.Fatalf("UPDATE failed: %v", err)
+	}
+	other.Exec("DELETE FROM users WHERE id = 3")
+	changes, err := m.Changes()
+	if err != nil || changes["users"] != 2 {
+		t.Fatalf("Expected users at version 2, got %v (%v)", changes, err)
+	}
+
+	// readTable returns the names of the rows and the records they are unchanged from
+	readTable := func() (*TableSnapshot, string) {
+		t.Helper()
+		snap, err := m.ReadTable(context.Background(), "users")
+		if err != nil {
+			t.Fatalf("ReadTable failed: %v", err)
+		}
+		var rows []string
+		for row, err := range snap.Rows() {
+			if err != nil {
+				t.Fatalf("Reading rows failed: %v", err)
+			}
+			rows = append(rows, fmt.Sprintf("%s:%d", row.Values[1], row.Record))
+		}
+		snap.Close()
+		return snap, strings.Join(rows, ",")
+	}
+	snap, rows := readTable()
+	if snap.Version != 2 || rows != "Alice:1,BOB:0,Dave:4" {
+		t.Errorf("Expected version 2 with Alice:1,BOB:0,Dave:4, got %d with %s", snap.Version, rows)
+	}
+
+	// Edits after the snapshot stay pending
+	other.Exec("DELETE FROM users WHERE id = 2")
+	if err := m.MarkWrittenBack("users", "/test/users.csv", 5, snap); err != nil {
+		t.Fatalf("MarkWrittenBack failed: %v", err)
+	}
+	// The file now holds Alice, BOB and Dave
+	if _, rows := readTable(); rows != "Alice:1,Dave:3" {
+		t.Errorf("Expected Alice:1,Dave:3 after the write, got %s", rows)
+	}
+	if !m.WrittenBack("/test/users.csv", 5) || m.WrittenBack("/test/users.csv", 6) || m.NeedsUpdate("users", 5) {
+		t.Error("Expected the written file to be recorded as the table's")
+	}
+	if changes, _ := m.Changes(); changes["users"] != 3 {
+		t.Errorf("Expected the later edit to stay pending, got %v", changes)
+	}
+
+	// Renamed tables keep logging under their new name
+	if err := m.RenameTable("users", "people"); err != nil {
+		t.Fatalf("RenameTable failed: %v", err)
+	}
+	other.Exec("INSERT INTO people VALUES (3, 'Carol')")
+	if changes, _ := m.Changes(); len(changes) != 1 || changes["people"] != 4 {
+		t.Errorf("Expected people at version 4, got %v", changes)
+	}
+
+	// Reloading the file drops the edits
+	parsed.Info.TableName = "people"
+	if err := m.LoadFile(parsed); err != nil {
+		t.Fatalf("LoadFile failed: %v", err)
+	}
+	if changes, _ := m.Changes(); len(changes) != 0 {
+		t.Errorf("Expected no changes after a reload, got %v", changes)
+	}
+	other.Exec("DELETE FROM people")
+	if changes, _ := m.Changes(); changes["people"] != 4 {
+		t.Errorf("Expected the reloaded table to be logged, got %v", changes)
+	}
+
+	if err := m.SetWriteBack(false); err != nil {
+		t.Fatalf("SetWriteBack failed: %v", err)
+	}
+	other.Exec("INSERT INTO people VALUES (4, 'Dan')")
+	var triggers int
+	m.db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger'").Scan(&triggers)
+	if changes, _ := m.Changes(); len(changes) != 0 || triggers != 0 {
+		t.Errorf("Expected no triggers or changes once turned off, got %d and %v", triggers, changes)
+	}
+}
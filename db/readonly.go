@@ -0,0 +1,333 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// ErrReadOnly is returned for a statement that would modify the database on
+// a read-only query
+var ErrReadOnly = errors.New("statement is not allowed on a read-only query")
+
+// sqliteRecursive is the authorizer action of WITH RECURSIVE, which the
+// driver does not export
+const sqliteRecursive = 33
+
+// introspectionPragmas are the pragmas that only read, even with an
+// argument such as a table name
+var introspectionPragmas = map[string]bool{
+	"table_info":       true,
+	"table_xinfo":      true,
+	"table_list":       true,
+	"index_info":       true,
+	"index_xinfo":      true,
+	"index_list":       true,
+	"foreign_key_list": true,
+	"database_list":    true,
+	"collation_list":   true,
+	"function_list":    true,
+	"module_list":      true,
+	"pragma_list":      true,
+	"compile_options":  true,
+}
+
+// readOnlyAction reports whether an authorizer action only reads. Pragmas
+// are allowed without an argument, which reads their value, or when they
+// are introspection pragmas.
+func readOnlyAction(action int, arg1, arg2 string) bool {
+	switch action {
+	case sqlite3.SQLITE_SELECT, sqlite3.SQLITE_READ, sqlite3.SQLITE_FUNCTION, sqliteRecursive:
+		return true
+	case sqlite3.SQLITE_PRAGMA:
+		return arg2 == "" || introspectionPragmas[strings.ToLower(arg1)]
+	}
+	return false
+}
+
+// actionNames describes the denied authorizer actions in errors
+var actionNames = map[int]string{
+	sqlite3.SQLITE_INSERT:              "INSERT",
+	sqlite3.SQLITE_UPDATE:              "UPDATE",
+	sqlite3.SQLITE_DELETE:              "DELETE",
+	sqlite3.SQLITE_CREATE_TABLE:        "CREATE TABLE",
+	sqlite3.SQLITE_CREATE_TEMP_TABLE:   "CREATE TABLE",
+	sqlite3.SQLITE_CREATE_INDEX:        "CREATE INDEX",
+	sqlite3.SQLITE_CREATE_TEMP_INDEX:   "CREATE INDEX",
+	sqlite3.SQLITE_CREATE_VIEW:         "CREATE VIEW",
+	sqlite3.SQLITE_CREATE_TEMP_VIEW:    "CREATE VIEW",
+	sqlite3.SQLITE_CREATE_TRIGGER:      "CREATE TRIGGER",
+	sqlite3.SQLITE_CREATE_TEMP_TRIGGER: "CREATE TRIGGER",
+	sqlite3.SQLITE_DROP_TABLE:          "DROP TABLE",
+	sqlite3.SQLITE_DROP_TEMP_TABLE:     "DROP TABLE",
+	sqlite3.SQLITE_DROP_INDEX:          "DROP INDEX",
+	sqlite3.SQLITE_DROP_TEMP_INDEX:     "DROP INDEX",
+	sqlite3.SQLITE_DROP_VIEW:           "DROP VIEW",
+	sqlite3.SQLITE_DROP_TEMP_VIEW:      "DROP VIEW",
+	sqlite3.SQLITE_DROP_TRIGGER:        "DROP TRIGGER",
+	sqlite3.SQLITE_DROP_TEMP_TRIGGER:   "DROP TRIGGER",
+	sqlite3.SQLITE_ALTER_TABLE:         "ALTER TABLE",
+	sqlite3.SQLITE_ATTACH:              "ATTACH",
+	sqlite3.SQLITE_DETACH:              "DETACH",
+	sqlite3.SQLITE_REINDEX:             "REINDEX",
+	sqlite3.SQLITE_ANALYZE:             "ANALYZE",
+	sqlite3.SQLITE_PRAGMA:              "PRAGMA",
+	sqlite3.SQLITE_SAVEPOINT:           "SAVEPOINT",
+}
+
+// guard refuses, as the SQLite authorizer of a connection, the statements
+// that would modify the database, and remembers the first action refused.
+// Transactions are refused too, except on OpenConn connections, since a
+// pooled connection left inside one would keep its snapshot and block
+// every load.
+type guard struct {
+	off          bool   // allow every action, while a connection is set up
+	transactions bool   // allow BEGIN, COMMIT, ROLLBACK and savepoints
+	denied       string // the action refused while preparing the last statement
+}
+
+func (g *guard) authorize(action int, arg1, arg2, _ string) int {
+	if g.off || readOnlyAction(action, arg1, arg2) || schemaWrite(action, arg1) ||
+		(g.transactions && (action == sqlite3.SQLITE_TRANSACTION || action == sqlite3.SQLITE_SAVEPOINT)) {
+		return sqlite3.SQLITE_OK
+	}
+	if g.denied == "" {
+		g.denied = actionNames[action]
+		if action == sqlite3.SQLITE_TRANSACTION {
+			g.denied = arg1 // BEGIN, COMMIT or ROLLBACK
+		}
+		if g.denied == "" {
+			g.denied = fmt.Sprintf("action %d", action)
+		}
+	}
+	return sqlite3.SQLITE_DENY
+}
+
+// schemaWrite reports whether an action writes SQLite's schema table. DDL
+// statements check these writes before their own action, which is denied
+// instead to name the statement; SQLite refuses direct writes to the table.
+func schemaWrite(action int, table string) bool {
+	switch action {
+	case sqlite3.SQLITE_INSERT, sqlite3.SQLITE_UPDATE, sqlite3.SQLITE_DELETE:
+		switch strings.ToLower(table) {
+		case "sqlite_master", "sqlite_schema", "sqlite_temp_master", "sqlite_temp_schema":
+			return true
+		}
+	}
+	return false
+}
+
+// check turns the error of a statement the guard refused into ErrReadOnly,
+// and resets the guard for the next statement
+func (g *guard) check(err error) error {
+	denied := g.denied
+	g.denied = ""
+	if err != nil && denied != "" {
+		return fmt.Errorf("%w: %s", ErrReadOnly, denied)
+	}
+	return err
+}
+
+// SetAllowWrites lets queries modify the database. By default QueryContext,
+// Query, QueryValues and the connections of Conn and OpenConn refuse such
+// statements with ErrReadOnly, since a dropped or edited table no longer
+// matches its file and _csvql_metadata until the next reload.
+func (m *Manager) SetAllowWrites(allow bool) {
+	m.allowWrites.Store(allow)
+}
+
+// AllowWrites reports whether queries may modify the database
+func (m *Manager) AllowWrites() bool {
+	return m.allowWrites.Load()
+}
+
+// QueryReadOnlyContext is QueryContext on a connection that refuses any
+// statement that would modify the database, returning ErrReadOnly, even if
+// writes are allowed. Every statement of a multi-statement query is checked
+// before it runs.
+func (m *Manager) QueryReadOnlyContext(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
+	return m.queryConn(ctx, true, query, args...)
+}
+
+// queryConn runs a query on a connection of its own, closed with the rows
+func (m *Manager) queryConn(ctx context.Context, readOnly bool, query string, args ...interface{}) (*Rows, error) {
+	conn, err := m.conn(ctx, readOnly)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	rows.done = func() { conn.Close() }
+	return rows, nil
+}
+
+// Conn is a dedicated connection, for clients that keep state such as
+// temporary views across queries. It must be closed.
+type Conn struct {
+	m       *Manager
+	conn    *sql.Conn
+	guard   *guard // nil if writes are allowed
+	private bool   // Setup ran, so the connection is not reused
+}
+
+// Conn takes a connection from the pool. Unless writes are allowed, it
+// refuses statements that would modify the database.
+func (m *Manager) Conn(ctx context.Context) (*Conn, error) {
+	return m.conn(ctx, !m.AllowWrites())
+}
+
+func (m *Manager) conn(ctx context.Context, readOnly bool) (*Conn, error) {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c := &Conn{m: m, conn: conn}
+	if !readOnly {
+		return c, nil
+	}
+	c.guard = &guard{}
+	if err := c.raw(func(sc *sqlite3.SQLiteConn) error {
+		sc.RegisterAuthorizer(c.guard.authorize)
+		return nil
+	}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// QueryContext runs a query on the connection, as Manager.QueryContext does
+func (c *Conn) QueryContext(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
+	if c.guard != nil {
+		c.guard.denied = "" // left by a statement refused while it ran
+	}
+	rows, err := c.m.queryRows(ctx, c.conn, query, args...)
+	if c.guard != nil {
+		err = c.guard.check(err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if c.guard != nil {
+		// Statements such as VACUUM are refused once they run
+		rows.check = c.guard.check
+	}
+	return rows, nil
+}
+
+// ExecContext runs a statement without results, such as BEGIN, on the
+// connection. Unless writes are allowed, it is refused with ErrReadOnly.
+func (c *Conn) ExecContext(ctx context.Context, query string, args ...interface{}) error {
+	if c.guard != nil {
+		c.guard.denied = ""
+	}
+	_, err := c.conn.ExecContext(ctx, query, args...)
+	if c.guard != nil {
+		err = c.guard.check(err)
+	}
+	return err
+}
+
+// ReadOnly reports whether the connection refuses statements that would
+// modify the database
+func (c *Conn) ReadOnly() bool {
+	return c.guard != nil
+}
+
+// Setup runs statements that create objects private to the connection, such
+// as TEMP views, and registers Go functions, as name to implementation, for
+// its queries. The connection is discarded rather than reused once closed.
+func (c *Conn) Setup(ctx context.Context, statements []string, funcs map[string]interface{}) error {
+	c.private = true
+	for name, impl := range funcs {
+		if err := c.raw(func(sc *sqlite3.SQLiteConn) error {
+			return sc.RegisterFunc(name, impl, true)
+		}); err != nil {
+			return fmt.Errorf("failed to register function %s: %w", name, err)
+		}
+	}
+
+	if c.guard != nil {
+		c.guard.off = true
+		defer func() { c.guard.off = false }()
+	}
+	for _, stmt := range statements {
+		if _, err := c.conn.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("failed to set up connection: %w", err)
+		}
+	}
+	return nil
+}
+
+// Close returns the connection to the pool, or discards it after Setup or
+// if a transaction is left open on it, which rolls the transaction back
+func (c *Conn) Close() error {
+	discard := c.private
+	if !discard {
+		c.raw(func(sc *sqlite3.SQLiteConn) error {
+			discard = !sc.AutoCommit()
+			return nil
+		})
+	}
+	if discard {
+		// Returning driver.ErrBadConn makes database/sql drop the connection
+		c.conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+	} else if c.guard != nil {
+		c.raw(func(sc *sqlite3.SQLiteConn) error {
+			sc.RegisterAuthorizer(nil)
+			return nil
+		})
+	}
+	return c.conn.Close()
+}
+
+// raw runs fn on the driver connection
+func (c *Conn) raw(fn func(*sqlite3.SQLiteConn) error) error {
+	return c.conn.Raw(func(driverConn interface{}) error {
+		sc, ok := driverConn.(*sqlite3.SQLiteConn)
+		if !ok {
+			return fmt.Errorf("unexpected driver connection %T", driverConn)
+		}
+		return fn(sc)
+	})
+}
+
+// readOnlyDriverConn is a driver connection of OpenConn whose statements
+// are checked by a guard
+type readOnlyDriverConn struct {
+	*sqlite3.SQLiteConn
+	guard guard
+}
+
+func newReadOnlyDriverConn(sc *sqlite3.SQLiteConn) *readOnlyDriverConn {
+	// database/sql does not reuse a connection before its transaction ends
+	c := &readOnlyDriverConn{SQLiteConn: sc, guard: guard{transactions: true}}
+	sc.RegisterAuthorizer(c.guard.authorize)
+	return c
+}
+
+func (c *readOnlyDriverConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	c.guard.denied = ""
+	stmt, err := c.SQLiteConn.PrepareContext(ctx, query)
+	return stmt, c.guard.check(err)
+}
+
+func (c *readOnlyDriverConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.guard.denied = ""
+	rows, err := c.SQLiteConn.QueryContext(ctx, query, args)
+	return rows, c.guard.check(err)
+}
+
+func (c *readOnlyDriverConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.guard.denied = ""
+	result, err := c.SQLiteConn.ExecContext(ctx, query, args)
+	return result, c.guard.check(err)
+}
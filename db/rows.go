@@ -0,0 +1,149 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"iter"
+	"sync"
+)
+
+// Rows is a streaming query result. Values are read one row at a time and
+// normalised to int64, float64, bool, string or nil. The rows come from one
+// snapshot of the database: the statement's read transaction, which tables
+// loaded or swapped in meanwhile do not affect, lasts until Close, so
+// callers must always close Rows.
+type Rows struct {
+	rows    *sql.Rows
+	columns []string
+	dbTypes []string
+	values  []interface{}
+	err     error
+	release sync.Once
+	done    func()            // run once on Close, such as returning a connection
+	check   func(error) error // maps the error ending iteration, see guard
+}
+
+// QueryContext executes a query with optional bind parameters and returns a
+// streaming result. Cancelling ctx interrupts the running statement. Unless
+// writes are allowed, statements that would modify the database fail with
+// ErrReadOnly.
+func (m *Manager) QueryContext(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
+	return m.queryConn(ctx, !m.AllowWrites(), query, args...)
+}
+
+// queryer is a *sql.DB or a *sql.Conn
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// queryRows runs a query and reads its column details
+func (m *Manager) queryRows(ctx context.Context, q queryer, query string, args ...interface{}) (*Rows, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	columns, err := rows.Columns()
+	if err != nil {
+		rows.Close()
+		return nil, err
+	}
+
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		rows.Close()
+		return nil, err
+	}
+
+	dbTypes := make([]string, len(columnTypes))
+	for i, ct := range columnTypes {
+		dbTypes[i] = ct.DatabaseTypeName()
+	}
+
+	return &Rows{
+		rows:    rows,
+		columns: columns,
+		dbTypes: dbTypes,
+	}, nil
+}
+
+// Columns returns the result column names
+func (r *Rows) Columns() []string {
+	return r.columns
+}
+
+// ColumnTypes returns the declared type of each result column, such as
+// INTEGER or DATE, or "" for computed columns
+func (r *Rows) ColumnTypes() []string {
+	return r.dbTypes
+}
+
+// Next advances to the next row, returning false at the end or on error
+func (r *Rows) Next() bool {
+	if !r.rows.Next() {
+		if r.check != nil {
+			r.err = r.check(r.rows.Err())
+		}
+		r.Close()
+		return false
+	}
+
+	values := make([]interface{}, len(r.columns))
+	valuePtrs := make([]interface{}, len(r.columns))
+	for i := range values {
+		valuePtrs[i] = &values[i]
+	}
+	if err := r.rows.Scan(valuePtrs...); err != nil {
+		r.err = err
+		r.values = nil
+		r.Close()
+		return false
+	}
+
+	for i, v := range values {
+		values[i] = normalizeValue(v, r.dbTypes[i])
+	}
+	r.values = values
+	return true
+}
+
+// Values returns the typed values of the current row. The slice is not
+// reused, so it may be retained by the caller.
+func (r *Rows) Values() []interface{} {
+	return r.values
+}
+
+// Err returns the error, if any, encountered during iteration
+func (r *Rows) Err() error {
+	if r.err != nil {
+		return r.err
+	}
+	return r.rows.Err()
+}
+
+// Close releases the result set and its snapshot. It is safe to call more
+// than once.
+func (r *Rows) Close() error {
+	err := r.rows.Close()
+	if r.done != nil {
+		r.release.Do(r.done)
+	}
+	return err
+}
+
+// All returns an iterator over the remaining rows. Iteration stops at the
+// first error, which is yielded with a nil row; the rows are closed when the
+// loop ends, including on break.
+func (r *Rows) All() iter.Seq2[[]interface{}, error] {
+	return func(yield func([]interface{}, error) bool) {
+		defer r.Close()
+		for r.Next() {
+			if !yield(r.Values(), nil) {
+				return
+			}
+		}
+		if err := r.Err(); err != nil {
+			yield(nil, err)
+		}
+	}
+}
@@ -0,0 +1,382 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"csvql/loader"
+)
+
+// OpenFunc opens a member file of a union table for streaming
+type OpenFunc func(path string) (*loader.Reader, error)
+
+// SyncUnion brings the table of a file set up to date. A missing table is
+// built from all files in a shadow table that replaces it at commit, like
+// LoadBatches. An existing one is updated in place: only the rows of files
+// whose modification time changed are replaced, and the rows of files that
+// left the set are deleted. Columns are the union of the files' columns,
+// one per partition key and loader.SourceFileColumn. It returns the files
+// that were loaded and those whose rows were removed.
+func (m *Manager) SyncUnion(set *loader.FileSet, tableName, rootDir string, open OpenFunc) (loaded, removed []string, err error) {
+	m.writeMu.Lock()
+	defer m.writeMu.Unlock()
+
+	modTimes := make(map[string]int64, len(set.Files))
+	for _, file := range set.Files {
+		modTime, err := loader.FileModTime(file)
+		if err != nil {
+			return nil, nil, err
+		}
+		modTimes[file] = modTime
+	}
+
+	recorded, err := m.partitionModTimes(tableName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Rebuild unless the table already holds this set
+	var recordedPath string
+	m.db.QueryRow("SELECT file_path FROM _csvql_metadata WHERE table_name = ?", tableName).Scan(&recordedPath)
+	if recordedPath != set.Key || len(recorded) == 0 {
+		if err := m.rebuildUnion(set, tableName, rootDir, modTimes, open); err != nil {
+			return nil, nil, err
+		}
+		return set.Files, nil, nil
+	}
+
+	var changed []string
+	for _, file := range set.Files {
+		if modTime, ok := recorded[file]; !ok || modTime != modTimes[file] {
+			changed = append(changed, file)
+		}
+	}
+	for file := range recorded {
+		if _, ok := modTimes[file]; !ok {
+			removed = append(removed, file)
+		}
+	}
+	if len(changed) == 0 && len(removed) == 0 {
+		return nil, nil, nil
+	}
+
+	tx, err := m.db.Begin()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	columns, err := tableColumns(tx, tableName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, file := range removed {
+		if err := deletePartition(tx, tableName, file, rootDir); err != nil {
+			return nil, nil, err
+		}
+	}
+	for _, file := range changed {
+		if err := deletePartition(tx, tableName, file, rootDir); err != nil {
+			return nil, nil, err
+		}
+		if err := loadPartition(tx, tableName, columns, set, file, rootDir, open); err != nil {
+			return nil, nil, err
+		}
+		if err := recordPartition(tx, tableName, file, modTimes[file]); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// Added columns change the schema seen by readers
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := recordUnion(tx, set, tableName, modTimes); err != nil {
+		return nil, nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	m.metadata[tableName] = maxModTime(modTimes)
+	return changed, removed, nil
+}
+
+// rebuildUnion loads every file of a set into a shadow table and swaps it in
+func (m *Manager) rebuildUnion(set *loader.FileSet, tableName, rootDir string, modTimes map[string]int64, open OpenFunc) error {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	shadowName := shadowPrefix + tableName
+	if _, err := tx.Exec(fmt.Sprintf("DROP TABLE IF EXISTS %s", quoteIdent(shadowName))); err != nil {
+		return fmt.Errorf("failed to drop table %s: %w", shadowName, err)
+	}
+
+	if _, err := tx.Exec("DELETE FROM _csvql_errors WHERE table_name = ?", tableName); err != nil {
+		return fmt.Errorf("failed to clear bad rows of %s: %w", tableName, err)
+	}
+
+	// The shadow table is created by the first file and extended by later ones
+	columns := make(map[string]bool)
+	for _, file := range set.Files {
+		if err := loadPartition(tx, shadowName, columns, set, file, rootDir, open); err != nil {
+			return err
+		}
+	}
+	if len(columns) == 0 {
+		return fmt.Errorf("%w: every file of %s", loader.ErrEmptyFile, set.Key)
+	}
+
+	// Swap the shadow table in; readers are only held off for the swap itself
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, err := tx.Exec(fmt.Sprintf("DROP TABLE IF EXISTS %s", quoteIdent(tableName))); err != nil {
+		return fmt.Errorf("failed to drop table %s: %w", tableName, err)
+	}
+	if _, err := tx.Exec(fmt.Sprintf("ALTER TABLE %s RENAME TO %s", quoteIdent(shadowName), quoteIdent(tableName))); err != nil {
+		return fmt.Errorf("failed to rename %s to %s: %w", shadowName, tableName, err)
+	}
+
+	if _, err := tx.Exec("DELETE FROM _csvql_partitions WHERE table_name = ?", tableName); err != nil {
+		return fmt.Errorf("failed to update partitions: %w", err)
+	}
+	for _, file := range set.Files {
+		if err := recordPartition(tx, tableName, file, modTimes[file]); err != nil {
+			return err
+		}
+	}
+	if err := recordUnion(tx, set, tableName, modTimes); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	m.metadata[tableName] = maxModTime(modTimes)
+	return nil
+}
+
+// loadPartition inserts the rows of one file, creating the table if columns
+// is empty and otherwise adding any columns it lacks. Partition values
+// override data columns of the same name. An empty file, such as a part
+// file still being written, adds nothing.
+func loadPartition(tx *sql.Tx, tableName string, columns map[string]bool, set *loader.FileSet, file, rootDir string, open OpenFunc) error {
+	reader, err := open(file)
+	if errors.Is(err, loader.ErrEmptyFile) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	info := reader.Info
+	names := loader.SanitizeColumnNames(info.Headers)
+	types := info.ColumnTypes
+	if len(types) != len(names) {
+		types = loader.InferColumnTypes(len(names), nil)
+	}
+
+	keys, values := set.Partitions(file)
+	fixedNames := make([]string, 0, len(keys)+1)
+	fixedTypes := make([]loader.ColumnType, 0, len(keys)+1)
+	fixedValues := make([]interface{}, 0, len(keys)+1)
+	for i, key := range keys {
+		valueType := loader.InferColumnTypes(1, [][]string{{values[i]}})[0]
+		fixedNames = append(fixedNames, loader.SanitizeColumnName(key))
+		fixedTypes = append(fixedTypes, valueType)
+		fixedValues = append(fixedValues, loader.ConvertValue(values[i], valueType))
+	}
+	fixedNames = append(fixedNames, loader.SourceFileColumn)
+	fixedTypes = append(fixedTypes, loader.TypeText)
+	fixedValues = append(fixedValues, loader.SourceFile(file, rootDir))
+
+	overridden := make(map[string]bool, len(fixedNames))
+	for _, name := range fixedNames {
+		overridden[strings.ToLower(name)] = true
+	}
+
+	// Data columns kept for this file, by index into the record
+	var dataIndexes []int
+	var insertNames []string
+	for i, name := range names {
+		if !overridden[strings.ToLower(name)] {
+			dataIndexes = append(dataIndexes, i)
+			insertNames = append(insertNames, name)
+		}
+	}
+	insertNames = append(insertNames, fixedNames...)
+
+	allTypes := make([]loader.ColumnType, 0, len(insertNames))
+	for _, i := range dataIndexes {
+		allTypes = append(allTypes, types[i])
+	}
+	allTypes = append(allTypes, fixedTypes...)
+
+	if len(columns) == 0 {
+		definitions := make([]string, len(insertNames))
+		for i, name := range insertNames {
+			definitions[i] = fmt.Sprintf("%s %s", quoteIdent(name), allTypes[i])
+			columns[strings.ToLower(name)] = true
+		}
+		createSQL := fmt.Sprintf("CREATE TABLE %s (%s)", quoteIdent(tableName), strings.Join(definitions, ", "))
+		if _, err := tx.Exec(createSQL); err != nil {
+			return fmt.Errorf("failed to create table %s: %w", tableName, err)
+		}
+	}
+
+	// Add missing columns, typed from the first file that has them
+	for i, name := range insertNames {
+		if columns[strings.ToLower(name)] {
+			continue
+		}
+		alterSQL := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", quoteIdent(tableName), quoteIdent(name), allTypes[i])
+		if _, err := tx.Exec(alterSQL); err != nil {
+			return fmt.Errorf("failed to add column %s to %s: %w", name, tableName, err)
+		}
+		columns[strings.ToLower(name)] = true
+	}
+
+	quotedNames := make([]string, len(insertNames))
+	placeholders := make([]string, len(insertNames))
+	for i, name := range insertNames {
+		quotedNames[i] = quoteIdent(name)
+		placeholders[i] = "?"
+	}
+	stmt, err := tx.Prepare(fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
+		quoteIdent(tableName), strings.Join(quotedNames, ", "), strings.Join(placeholders, ", ")))
+	if err != nil {
+		return fmt.Errorf("failed to prepare insert: %w", err)
+	}
+	defer stmt.Close()
+
+	row := make([]interface{}, len(insertNames))
+	copy(row[len(dataIndexes):], fixedValues)
+	for {
+		batch, err := reader.ReadBatch()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if err := recordRowErrors(tx, tableName, file, reader); err != nil {
+			return err
+		}
+
+		for _, record := range batch {
+			for j, i := range dataIndexes {
+				row[j] = nil
+				if i < len(record) {
+					row[j] = loader.ConvertValue(record[i], types[i])
+				}
+			}
+			if _, err := stmt.Exec(row...); err != nil {
+				return fmt.Errorf("failed to insert record: %w", err)
+			}
+		}
+	}
+	return recordRowErrors(tx, tableName, file, reader)
+}
+
+// deletePartition removes the rows loaded from a file and its partition record
+func deletePartition(tx *sql.Tx, tableName, file, rootDir string) error {
+	deleteSQL := fmt.Sprintf("DELETE FROM %s WHERE %s = ?", quoteIdent(tableName), quoteIdent(loader.SourceFileColumn))
+	if _, err := tx.Exec(deleteSQL, loader.SourceFile(file, rootDir)); err != nil {
+		return fmt.Errorf("failed to delete rows of %s: %w", file, err)
+	}
+	if _, err := tx.Exec("DELETE FROM _csvql_partitions WHERE file_path = ?", file); err != nil {
+		return fmt.Errorf("failed to update partitions: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM _csvql_errors WHERE file_path = ?", file); err != nil {
+		return fmt.Errorf("failed to clear bad rows of %s: %w", file, err)
+	}
+	return nil
+}
+
+// recordPartition stores the modification time a file was loaded at
+func recordPartition(tx *sql.Tx, tableName, file string, modTime int64) error {
+	_, err := tx.Exec("INSERT OR REPLACE INTO _csvql_partitions (file_path, table_name, mod_time) VALUES (?, ?, ?)",
+		file, tableName, modTime)
+	if err != nil {
+		return fmt.Errorf("failed to update partitions: %w", err)
+	}
+	return nil
+}
+
+// recordUnion stores the table's metadata row, keyed by the set's path
+func recordUnion(tx *sql.Tx, set *loader.FileSet, tableName string, modTimes map[string]int64) error {
+	_, err := tx.Exec("INSERT OR REPLACE INTO _csvql_metadata (table_name, file_path, mod_time) VALUES (?, ?, ?)",
+		tableName, set.Key, maxModTime(modTimes))
+	if err != nil {
+		return fmt.Errorf("failed to update metadata: %w", err)
+	}
+	return nil
+}
+
+// tableColumns returns the lower-cased column names of a table
+func tableColumns(tx *sql.Tx, tableName string) (map[string]bool, error) {
+	rows, err := tx.Query("SELECT name FROM pragma_table_info(?)", tableName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		columns[strings.ToLower(name)] = true
+	}
+	return columns, rows.Err()
+}
+
+// partitionModTimes returns the recorded modification time of each file of a union table
+func (m *Manager) partitionModTimes(tableName string) (map[string]int64, error) {
+	rows, err := m.db.Query("SELECT file_path, mod_time FROM _csvql_partitions WHERE table_name = ?", tableName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make(map[string]int64)
+	for rows.Next() {
+		var file string
+		var modTime int64
+		if err := rows.Scan(&file, &modTime); err != nil {
+			return nil, err
+		}
+		result[file] = modTime
+	}
+	return result, rows.Err()
+}
+
+// PartitionTable returns the union table a file was loaded into, if any
+func (m *Manager) PartitionTable(filePath string) (string, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var tableName string
+	err := m.db.QueryRow("SELECT table_name FROM _csvql_partitions WHERE file_path = ?", filePath).Scan(&tableName)
+	return tableName, err == nil
+}
+
+// maxModTime returns the latest of the files' modification times
+func maxModTime(modTimes map[string]int64) int64 {
+	var latest int64
+	for _, modTime := range modTimes {
+		latest = max(latest, modTime)
+	}
+	return latest
+}
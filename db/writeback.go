@@ -0,0 +1,356 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"iter"
+	"strings"
+
+	"csvql/loader"
+)
+
+// changeTriggerPrefix names the triggers that log edits of a table in
+// _csvql_changes, one per statement kind
+const changeTriggerPrefix = "_csvql_change_"
+
+// changeOps are the statements the change triggers fire on
+var changeOps = []string{"INSERT", "UPDATE", "DELETE"}
+
+// changeTables hold the edits of tables and the rows of their files, by
+// table name
+var changeTables = []string{"_csvql_changes", "_csvql_changed_rows", "_csvql_file_rows"}
+
+// execer is a *sql.DB or a *sql.Tx
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// quoteLiteral quotes an SQLite string literal, for statements such as
+// trigger bodies that cannot take bind parameters
+func quoteLiteral(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// createChangeTriggers logs every edit of a table in _csvql_changes, from
+// any connection to the database, by bumping the table's version. Added and
+// updated rows are logged in _csvql_changed_rows with the version.
+func createChangeTriggers(tx execer, tableName string) error {
+	for _, op := range changeOps {
+		logRow := ""
+		if op != "DELETE" {
+			logRow = fmt.Sprintf(`
+				INSERT OR REPLACE INTO _csvql_changed_rows (table_name, row_id, version)
+				SELECT table_name, NEW._rowid_, version FROM _csvql_changes WHERE table_name = %s;`, quoteLiteral(tableName))
+		}
+		_, err := tx.Exec(fmt.Sprintf(`
+			CREATE TRIGGER IF NOT EXISTS %s AFTER %s ON %s BEGIN
+				INSERT OR IGNORE INTO _csvql_changes (table_name, version) VALUES (%s, 0);
+				UPDATE _csvql_changes SET version = version + 1 WHERE table_name = %s;%s
+			END
+		`, quoteIdent(changeTriggerPrefix+tableName+"_"+strings.ToLower(op)), op, quoteIdent(tableName),
+			quoteLiteral(tableName), quoteLiteral(tableName), logRow))
+		if err != nil {
+			return fmt.Errorf("failed to create change trigger on %s: %w", tableName, err)
+		}
+	}
+	return nil
+}
+
+// dropChangeTriggers drops the change triggers named after a table. They
+// keep their name when the table is renamed, and go with it when it is
+// dropped.
+func dropChangeTriggers(tx execer, tableName string) error {
+	for _, op := range changeOps {
+		_, err := tx.Exec(fmt.Sprintf("DROP TRIGGER IF EXISTS %s", quoteIdent(changeTriggerPrefix+tableName+"_"+strings.ToLower(op))))
+		if err != nil {
+			return fmt.Errorf("failed to drop change trigger on %s: %w", tableName, err)
+		}
+	}
+	return nil
+}
+
+// clearChanges drops the pending changes of a table and the rows of its
+// file, which its reloaded rows match again
+func clearChanges(tx execer, tableName string) error {
+	for _, table := range changeTables {
+		if _, err := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE table_name = ?", table), tableName); err != nil {
+			return fmt.Errorf("failed to clear changes of %s: %w", tableName, err)
+		}
+	}
+	return nil
+}
+
+// SetWriteBack turns logging of table edits on or off. While it is on,
+// every INSERT, UPDATE and DELETE on a table loaded from a single file is
+// recorded in _csvql_changes, whichever connection runs it, so the table
+// can be written back to its file; see Changes. Turning it off drops the
+// triggers and the pending changes.
+func (m *Manager) SetWriteBack(enabled bool) error {
+	m.writeMu.Lock()
+	defer m.writeMu.Unlock()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tx, err := m.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Triggers may be left by an earlier run, under the names of renamed tables
+	rows, err := tx.Query("SELECT name FROM sqlite_master WHERE type = 'trigger' AND name GLOB ?", changeTriggerPrefix+"*")
+	if err != nil {
+		return fmt.Errorf("failed to list change triggers: %w", err)
+	}
+	var triggers []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		triggers = append(triggers, name)
+	}
+	rows.Close()
+	for _, name := range triggers {
+		if _, err := tx.Exec(fmt.Sprintf("DROP TRIGGER %s", quoteIdent(name))); err != nil {
+			return fmt.Errorf("failed to drop change trigger %s: %w", name, err)
+		}
+	}
+
+	if enabled {
+		tables, err := singleFileTables(tx)
+		if err != nil {
+			return err
+		}
+		for _, table := range tables {
+			if err := createChangeTriggers(tx, table); err != nil {
+				return err
+			}
+		}
+	} else {
+		for _, table := range changeTables[:2] {
+			if _, err := tx.Exec(fmt.Sprintf("DELETE FROM %s", table)); err != nil {
+				return fmt.Errorf("failed to clear changes: %w", err)
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	m.writeBack = enabled
+	return nil
+}
+
+// singleFileTables lists the loaded tables that are not union tables
+func singleFileTables(tx *sql.Tx) ([]string, error) {
+	rows, err := tx.Query(`
+		SELECT table_name FROM _csvql_metadata
+		WHERE table_name NOT IN (SELECT table_name FROM _csvql_partitions)
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tables: %w", err)
+	}
+	defer rows.Close()
+
+	var tables []string
+	for rows.Next() {
+		var table string
+		if err := rows.Scan(&table); err != nil {
+			return nil, err
+		}
+		tables = append(tables, table)
+	}
+	return tables, rows.Err()
+}
+
+// Changes returns the version of each table edited since it was loaded or
+// last written back. Versions grow with every edited row.
+func (m *Manager) Changes() (map[string]int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	rows, err := m.db.Query("SELECT table_name, version FROM _csvql_changes")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read changes: %w", err)
+	}
+	defer rows.Close()
+
+	changes := make(map[string]int64)
+	for rows.Next() {
+		var table string
+		var version int64
+		if err := rows.Scan(&table, &version); err != nil {
+			return nil, err
+		}
+		changes[table] = version
+	}
+	return changes, rows.Err()
+}
+
+// TableSnapshot is a table read from one snapshot to be written back to
+// its file
+type TableSnapshot struct {
+	Version int64 // the version of the table's changes the rows include
+
+	rows *Rows
+	runs [][2]int64 // rowids of the rows, as runs of consecutive ones
+}
+
+// Columns returns the names of the table's columns
+func (s *TableSnapshot) Columns() []string {
+	return s.rows.Columns()[1:]
+}
+
+// Rows iterates over the rows in rowid order, the order they were loaded
+// and appended in, each with the record of the file it is unchanged from
+func (s *TableSnapshot) Rows() iter.Seq2[loader.RewriteRow, error] {
+	return func(yield func(loader.RewriteRow, error) bool) {
+		for values, err := range s.rows.All() {
+			if err != nil {
+				yield(loader.RewriteRow{}, err)
+				return
+			}
+			record, _ := values[0].(int64)
+			if !yield(loader.RewriteRow{Record: record, Values: values[1:]}, nil) {
+				return
+			}
+		}
+	}
+}
+
+// Close releases the snapshot
+func (s *TableSnapshot) Close() error {
+	return s.rows.Close()
+}
+
+// ReadTable reads a table to write it back to its file. A row not edited
+// since the file was loaded or written is matched to its record: the rows
+// of a loaded file have the rowids of its records, counted from 1, and the
+// rows a file was written from are kept as runs of rowids by
+// MarkWrittenBack.
+func (m *Manager) ReadTable(ctx context.Context, tableName string) (*TableSnapshot, error) {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	release := func() { tx.Rollback() }
+
+	snap := &TableSnapshot{}
+	err = tx.QueryRowContext(ctx, "SELECT version FROM _csvql_changes WHERE table_name = ?", tableName).Scan(&snap.Version)
+	if err != nil && err != sql.ErrNoRows {
+		release()
+		return nil, fmt.Errorf("failed to read changes: %w", err)
+	}
+
+	// Gaps and islands: consecutive rowids share their distance to the row number
+	runs, err := tx.QueryContext(ctx, fmt.Sprintf(`
+		SELECT MIN(id), MAX(id) FROM (
+			SELECT _rowid_ AS id, _rowid_ - ROW_NUMBER() OVER (ORDER BY _rowid_) AS run FROM %s
+		) GROUP BY run ORDER BY 1
+	`, quoteIdent(tableName)))
+	if err != nil {
+		release()
+		return nil, fmt.Errorf("failed to read rowids of %s: %w", tableName, err)
+	}
+	for runs.Next() {
+		var run [2]int64
+		if err := runs.Scan(&run[0], &run[1]); err != nil {
+			runs.Close()
+			release()
+			return nil, err
+		}
+		snap.runs = append(snap.runs, run)
+	}
+	runs.Close()
+
+	snap.rows, err = m.queryRows(ctx, tx, fmt.Sprintf(`
+		SELECT CASE
+			WHEN c.row_id IS NOT NULL THEN 0
+			WHEN NOT EXISTS (SELECT 1 FROM _csvql_file_rows WHERE table_name = ?1) THEN t._rowid_
+			ELSE (
+				SELECT CASE WHEN t._rowid_ <= f.last_row THEN f.first_record + t._rowid_ - f.first_row ELSE 0 END
+				FROM _csvql_file_rows f WHERE f.table_name = ?1 AND f.first_row <= t._rowid_
+				ORDER BY f.first_row DESC LIMIT 1
+			)
+		END, t.*
+		FROM %s t LEFT JOIN _csvql_changed_rows c ON c.table_name = ?1 AND c.row_id = t._rowid_
+		ORDER BY t._rowid_
+	`, quoteIdent(tableName)), tableName)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	snap.rows.done = release
+	return snap, nil
+}
+
+// MarkWrittenBack records that a table was written back to its file from a
+// snapshot: the file's new modification time becomes the table's, so it is
+// not reloaded, the rows of the snapshot become the records of the file and
+// the changes the snapshot includes are cleared. Later edits stay pending.
+func (m *Manager) MarkWrittenBack(tableName, filePath string, modTime int64, snap *TableSnapshot) error {
+	m.writeMu.Lock()
+	defer m.writeMu.Unlock()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tx, err := m.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE _csvql_metadata SET mod_time = ? WHERE table_name = ?", modTime, tableName); err != nil {
+		return fmt.Errorf("failed to update metadata: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM _csvql_changes WHERE table_name = ? AND version <= ?", tableName, snap.Version); err != nil {
+		return fmt.Errorf("failed to clear changes of %s: %w", tableName, err)
+	}
+	if _, err := tx.Exec("DELETE FROM _csvql_changed_rows WHERE table_name = ? AND version <= ?", tableName, snap.Version); err != nil {
+		return fmt.Errorf("failed to clear changes of %s: %w", tableName, err)
+	}
+	if _, err := tx.Exec("DELETE FROM _csvql_file_rows WHERE table_name = ?", tableName); err != nil {
+		return fmt.Errorf("failed to clear file rows of %s: %w", tableName, err)
+	}
+	record := int64(1)
+	for _, run := range snap.runs {
+		_, err := tx.Exec("INSERT INTO _csvql_file_rows (table_name, first_row, last_row, first_record) VALUES (?, ?, ?, ?)",
+			tableName, run[0], run[1], record)
+		if err != nil {
+			return fmt.Errorf("failed to record file rows of %s: %w", tableName, err)
+		}
+		record += run[1] - run[0] + 1
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	m.metadata[tableName] = modTime
+	m.writtenBack[filePath] = modTime
+	return nil
+}
+
+// WrittenBack reports whether a file is as its table was last written back
+// to it, so the watcher can tell its own writes from external changes
+func (m *Manager) WrittenBack(filePath string, modTime int64) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	written, ok := m.writtenBack[filePath]
+	return ok && written == modTime
+}
+
+// DiscardChanges drops the pending changes of a table without writing them
+func (m *Manager) DiscardChanges(tableName string) error {
+	m.writeMu.Lock()
+	defer m.writeMu.Unlock()
+
+	for _, table := range changeTables[:2] {
+		if _, err := m.db.Exec(fmt.Sprintf("DELETE FROM %s WHERE table_name = ?", table), tableName); err != nil {
+			return fmt.Errorf("failed to clear changes of %s: %w", tableName, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package csvql
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// DriverName is the name csvql registers with database/sql
+const DriverName = "csvql"
+
+func init() {
+	sql.Register(DriverName, &Driver{})
+}
+
+// Driver is the csvql database/sql driver. The data source name is the
+// directory to load, optionally followed by options:
+//
+//	sql.Open("csvql", "/data/exports?watch=1&types=infer")
+//
+// sql.Open scans and loads the directory, and its connections query the
+// managed SQLite database. Closing the sql.DB stops the watcher and closes
+// the database. Options are:
+//
+//	watch=1             keep tables in sync with the files
+//	types=infer|text    infer column types (default) or load all as TEXT
+//	db=path             SQLite database path (default: .csvql.db in the directory)
+//	strict=1            fail a file on its first malformed row
+//	writes=1            allow statements that modify the database
+//	write_back=1        write edited tables back to their files
+//	encoding=name       force the encoding of every file
+//	json_depth=n        levels of nested JSON objects flattened
+//	include=pattern     only load matching files (repeatable)
+//	exclude=pattern     skip matching files and directories (repeatable)
+type Driver struct{}
+
+// Open is not supported, as each call would scan the directory again;
+// database/sql uses OpenConnector
+func (d *Driver) Open(dsn string) (driver.Conn, error) {
+	return nil, errors.New("csvql: open the driver with sql.Open")
+}
+
+// OpenConnector loads the directory of a data source name
+func (d *Driver) OpenConnector(dsn string) (driver.Connector, error) {
+	opts, err := ParseDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+	c, err := New(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &connector{driver: d, c: c}, nil
+}
+
+// ParseDSN parses a csvql data source name into Options
+func ParseDSN(dsn string) (Options, error) {
+	dir, query, _ := strings.Cut(dsn, "?")
+	opts := Options{RootDir: dir}
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return opts, fmt.Errorf("invalid csvql DSN %q: %w", dsn, err)
+	}
+
+	for name, list := range values {
+		value := list[len(list)-1]
+		switch name {
+		case "watch", "strict", "writes", "write_back":
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return opts, fmt.Errorf("invalid csvql DSN option %s=%q: expected a boolean", name, value)
+			}
+			switch name {
+			case "watch":
+				opts.Watch = b
+			case "strict":
+				opts.Strict = b
+			case "writes":
+				opts.AllowWrites = b
+			case "write_back":
+				opts.WriteBack = b
+			}
+		case "types":
+			switch value {
+			case "infer":
+				opts.TextOnly = false
+			case "text":
+				opts.TextOnly = true
+			default:
+				return opts, fmt.Errorf("invalid csvql DSN option types=%q: expected infer or text", value)
+			}
+		case "db":
+			opts.DBPath = value
+		case "encoding":
+			opts.Encodings = map[string]string{"*": value}
+		case "json_depth":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return opts, fmt.Errorf("invalid csvql DSN option json_depth=%q", value)
+			}
+			opts.JSONDepth = n
+		case "include":
+			opts.Include = list
+		case "exclude":
+			opts.Exclude = list
+		default:
+			return opts, fmt.Errorf("unknown csvql DSN option %q", name)
+		}
+	}
+	return opts, nil
+}
+
+// connector hands out connections to the database of one CSVQL instance
+type connector struct {
+	driver *Driver
+	c      *CSVQL
+}
+
+func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c.c.DB.OpenConn()
+}
+
+func (c *connector) Driver() driver.Driver {
+	return c.driver
+}
+
+// Close is called by sql.DB.Close
+func (c *connector) Close() error {
+	return c.c.Close()
+}
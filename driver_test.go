@@ -0,0 +1,118 @@
+package csvql
+
+import (
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	csvqldb "csvql/db"
+)
+
+func TestDriver(t *testing.T) {
+	tmpDir := t.TempDir()
+	os.WriteFile(filepath.Join(tmpDir, "users.csv"), []byte("id,name,joined\n1,Alice,2021-02-03\n2,Bob,2022-04-05\n"), 0644)
+
+	db, err := sql.Open("csvql", tmpDir+"?watch=1")
+	if err != nil {
+		t.Fatalf("sql.Open failed: %v", err)
+	}
+	defer db.Close()
+
+	var id int64
+	var name string
+	if err := db.QueryRow("SELECT id, name FROM users WHERE id = ?", 2).Scan(&id, &name); err != nil {
+		t.Fatalf("QueryRow failed: %v", err)
+	}
+	if id != 2 || name != "Bob" {
+		t.Errorf("Expected 2 Bob, got %d %s", id, name)
+	}
+
+	var typ string
+	if err := db.QueryRow("SELECT type FROM pragma_table_info('users') WHERE name = 'joined'").Scan(&typ); err != nil || typ != "DATE" {
+		t.Errorf("Expected an inferred DATE column, got %q (%v)", typ, err)
+	}
+
+	// The watcher keeps the tables in sync
+	os.WriteFile(filepath.Join(tmpDir, "users.csv"), []byte("id,name,joined\n1,Alice,2021-02-03\n2,Bob,2022-04-05\n3,Carol,2023-06-07\n"), 0644)
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		var n int
+		if err := db.QueryRow("SELECT count(*) FROM users").Scan(&n); err == nil && n == 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Timeout waiting for the watcher to reload users")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	if _, err := db.Exec("DELETE FROM users"); !errors.Is(err, csvqldb.ErrReadOnly) {
+		t.Errorf("Expected a read-only error, got %v", err)
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if err := db.Ping(); err == nil {
+		t.Error("Expected the database to be closed")
+	}
+}
+
+func TestDriver_TextTypes(t *testing.T) {
+	tmpDir := t.TempDir()
+	os.WriteFile(filepath.Join(tmpDir, "codes.csv"), []byte("zip,amount\n00123,1.50\n"), 0644)
+
+	db, err := sql.Open("csvql", tmpDir+"?types=text&writes=1&db="+filepath.Join(tmpDir, "codes.db"))
+	if err != nil {
+		t.Fatalf("sql.Open failed: %v", err)
+	}
+	defer db.Close()
+
+	var zip, amount string
+	if err := db.QueryRow("SELECT zip, amount FROM codes").Scan(&zip, &amount); err != nil {
+		t.Fatalf("QueryRow failed: %v", err)
+	}
+	if zip != "00123" || amount != "1.50" {
+		t.Errorf("Expected the values as written, got %q %q", zip, amount)
+	}
+	if _, err := db.Exec("UPDATE codes SET amount = '2.00'"); err != nil {
+		t.Errorf("Expected writes to be allowed, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "codes.db")); err != nil {
+		t.Errorf("Expected the database at the db option: %v", err)
+	}
+}
+
+func TestParseDSN(t *testing.T) {
+	opts, err := ParseDSN("/data/exports?watch=1&strict=true&writes=0&write_back=1&encoding=cp1252&json_depth=2&include=a/*.csv&include=b/*.csv")
+	if err != nil {
+		t.Fatalf("ParseDSN failed: %v", err)
+	}
+	want := Options{
+		RootDir:   "/data/exports",
+		Watch:     true,
+		Strict:    true,
+		WriteBack: true,
+		Encodings: map[string]string{"*": "cp1252"},
+		JSONDepth: 2,
+		Include:   []string{"a/*.csv", "b/*.csv"},
+	}
+	if !reflect.DeepEqual(opts, want) {
+		t.Errorf("Expected %+v, got %+v", want, opts)
+	}
+
+	for _, dsn := range []string{"dir?watch=maybe", "dir?types=guess", "dir?json_depth=-1", "dir?colour=red"} {
+		if _, err := ParseDSN(dsn); err == nil || !strings.Contains(err.Error(), "csvql DSN") {
+			t.Errorf("%s: expected a DSN error, got %v", dsn, err)
+		}
+	}
+
+	if _, err := sql.Open("csvql", filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("Expected sql.Open to fail for a missing directory")
+	}
+}
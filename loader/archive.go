@@ -0,0 +1,356 @@
+package loader
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// archiveExtensions lists the archive suffixes scanned as virtual directories,
+// longest first so .tar.gz is not mistaken for a compressed .tar file
+var archiveExtensions = []string{".tar.gz", ".tgz", ".tar", ".zip", workbookExtension}
+
+// archiveExtension returns the archive suffix of a file name, lower-cased, or ""
+func archiveExtension(name string) string {
+	lower := strings.ToLower(name)
+	for _, ext := range archiveExtensions {
+		if strings.HasSuffix(lower, ext) && len(lower) > len(ext) {
+			return ext
+		}
+	}
+	return ""
+}
+
+// trimArchiveExtension removes the archive suffix from a file name
+func trimArchiveExtension(name string) string {
+	return name[:len(name)-len(archiveExtension(name))]
+}
+
+// IsArchive reports whether a file is an archive whose members are loaded as tables
+func IsArchive(path string) bool {
+	base := filepath.Base(path)
+	// ~$book.xlsx is the lock file Excel keeps next to an open workbook
+	return archiveExtension(base) != "" && !strings.HasPrefix(base, "~$")
+}
+
+// trimMemberExtensions removes the extensions from an archive member name;
+// worksheet names are kept whole since they are not file names
+func trimMemberExtensions(archive, member string) string {
+	if isWorkbook(archive) {
+		return member
+	}
+	return trimExtensions(member)
+}
+
+// SplitArchivePath splits the path of an archive member, as returned by
+// ScanDirectory (e.g. data/bundle.zip/orders.csv), into the archive path and
+// the slash-separated member name
+func SplitArchivePath(filePath string) (archive, member string, ok bool) {
+	parts := strings.Split(filepath.ToSlash(filePath), "/")
+	for i := 0; i < len(parts)-1; i++ {
+		if archiveExtension(parts[i]) == "" {
+			continue
+		}
+		archive = filepath.FromSlash(strings.Join(parts[:i+1], "/"))
+		if info, err := os.Stat(archive); err == nil && info.IsDir() {
+			continue
+		}
+		return archive, strings.Join(parts[i+1:], "/"), true
+	}
+	return "", "", false
+}
+
+// ListArchive returns the paths of the supported files inside an archive, or
+// the worksheets of a workbook, joined to the archive path as if it were a
+// directory
+func ListArchive(archivePath string) ([]string, error) {
+	if isWorkbook(archivePath) {
+		return ListWorkbook(archivePath)
+	}
+
+	idx, err := acquireArchive(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer idx.Close()
+
+	var members []string
+	for _, name := range idx.names {
+		if IsSupportedFile(name) {
+			members = append(members, filepath.Join(archivePath, filepath.FromSlash(name)))
+		}
+	}
+	return members, nil
+}
+
+// cleanMemberName normalises an archive member name, returning "" for
+// macOS resource fork entries that only look like data files
+func cleanMemberName(name string) string {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	if strings.HasPrefix(name, "__MACOSX/") || strings.HasPrefix(path.Base(name), "._") {
+		return ""
+	}
+	return name
+}
+
+// archiveIndex locates the members of an archive, so loading every member
+// reads the archive once rather than once per member
+type archiveIndex struct {
+	path    string
+	modTime int64
+	size    int64
+	names   []string // regular members, in archive order
+
+	zip      *zip.ReadCloser
+	zipFiles map[string]*zip.File
+
+	// Tar members are sections of the tar; a compressed tar is decompressed
+	// into a temporary file the first time a member is opened
+	tar     *os.File
+	spooled bool
+	members map[string]tarMember
+
+	refs    int  // holds taken by acquireArchive
+	evicted bool // dropped from archiveIndexes, closed with the last hold
+}
+
+// tarMember is the position of a member's data in the uncompressed tar
+type tarMember struct {
+	offset, size int64
+}
+
+// archiveIndexes caches the index of each archive until ReleaseArchives, so
+// listing an archive and loading its members during a scan share one read
+var archiveIndexes = struct {
+	sync.Mutex
+	m map[string]*archiveIndex
+}{m: make(map[string]*archiveIndex)}
+
+// ReleaseArchives drops the cached archive indexes, removing their
+// temporary files once no member is open. Scans call it when done, so a
+// changed archive is read again by the next scan.
+func ReleaseArchives() {
+	archiveIndexes.Lock()
+	defer archiveIndexes.Unlock()
+	for path, idx := range archiveIndexes.m {
+		idx.evict()
+		delete(archiveIndexes.m, path)
+	}
+}
+
+// acquireArchive returns the index of an archive, reusing the cached one
+// while the archive is unchanged; the caller closes it when done
+func acquireArchive(archivePath string) (*archiveIndex, error) {
+	stat, err := os.Stat(archivePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open archive %s: %w", archivePath, err)
+	}
+
+	archiveIndexes.Lock()
+	defer archiveIndexes.Unlock()
+	idx := archiveIndexes.m[archivePath]
+	if idx == nil || idx.modTime != stat.ModTime().UnixNano() || idx.size != stat.Size() {
+		if idx != nil {
+			idx.evict()
+			delete(archiveIndexes.m, archivePath)
+		}
+		if idx, err = indexArchive(archivePath); err != nil {
+			return nil, err
+		}
+		idx.modTime, idx.size = stat.ModTime().UnixNano(), stat.Size()
+		archiveIndexes.m[archivePath] = idx
+	}
+	idx.refs++
+	return idx, nil
+}
+
+// Close releases a hold taken by acquireArchive
+func (idx *archiveIndex) Close() error {
+	archiveIndexes.Lock()
+	defer archiveIndexes.Unlock()
+	idx.refs--
+	if idx.evicted && idx.refs == 0 {
+		idx.close()
+	}
+	return nil
+}
+
+// evict marks an index dropped from the cache, closing it unless held
+func (idx *archiveIndex) evict() {
+	idx.evicted = true
+	if idx.refs == 0 {
+		idx.close()
+	}
+}
+
+func (idx *archiveIndex) close() {
+	if idx.zip != nil {
+		idx.zip.Close()
+	}
+	if idx.tar != nil {
+		idx.tar.Close()
+		if idx.spooled {
+			os.Remove(idx.tar.Name())
+		}
+		idx.tar = nil
+	}
+}
+
+// indexArchive reads the member list of a zip archive, or the position of
+// every member of a plain or gzip-compressed tar archive
+func indexArchive(archivePath string) (*archiveIndex, error) {
+	idx := &archiveIndex{path: archivePath}
+	if archiveExtension(archivePath) == ".zip" {
+		zr, err := zip.OpenReader(archivePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open archive %s: %w", archivePath, err)
+		}
+		idx.zip, idx.zipFiles = zr, make(map[string]*zip.File)
+		for _, f := range zr.File {
+			name := cleanMemberName(f.Name)
+			if name == "" || f.FileInfo().IsDir() {
+				continue
+			}
+			if _, ok := idx.zipFiles[name]; !ok {
+				idx.zipFiles[name] = f
+			}
+			idx.names = append(idx.names, name)
+		}
+		return idx, nil
+	}
+
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open archive %s: %w", archivePath, err)
+	}
+
+	// A plain tar is read in place, seeking past member data; a compressed
+	// one is streamed, counting the uncompressed bytes read so far
+	var tr *tar.Reader
+	var offset func() (int64, error)
+	if archiveExtension(archivePath) == ".tar" {
+		idx.tar = file
+		tr = tar.NewReader(file)
+		offset = func() (int64, error) { return file.Seek(0, io.SeekCurrent) }
+	} else {
+		defer file.Close()
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress archive %s: %w", archivePath, err)
+		}
+		defer gz.Close()
+		counter := &countingReader{r: gz}
+		tr = tar.NewReader(counter)
+		offset = func() (int64, error) { return counter.n, nil }
+	}
+
+	idx.members = make(map[string]tarMember)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			idx.close()
+			return nil, fmt.Errorf("failed to read archive %s: %w", archivePath, err)
+		}
+		name := cleanMemberName(hdr.Name)
+		if hdr.Typeflag != tar.TypeReg || name == "" {
+			continue
+		}
+		pos, err := offset()
+		if err != nil {
+			idx.close()
+			return nil, fmt.Errorf("failed to read archive %s: %w", archivePath, err)
+		}
+		if _, ok := idx.members[name]; !ok {
+			idx.members[name] = tarMember{offset: pos, size: hdr.Size}
+		}
+		idx.names = append(idx.names, name)
+	}
+	return idx, nil
+}
+
+// tarFile returns the uncompressed tar, decompressing a compressed archive
+// into a temporary file on first use
+func (idx *archiveIndex) tarFile() (*os.File, error) {
+	archiveIndexes.Lock()
+	defer archiveIndexes.Unlock()
+	if idx.tar != nil {
+		return idx.tar, nil
+	}
+
+	file, err := os.Open(idx.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open archive %s: %w", idx.path, err)
+	}
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress archive %s: %w", idx.path, err)
+	}
+	defer gz.Close()
+
+	spool, err := os.CreateTemp("", "csvql-*.tar")
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress archive %s: %w", idx.path, err)
+	}
+	if _, err := io.Copy(spool, gz); err != nil {
+		spool.Close()
+		os.Remove(spool.Name())
+		return nil, fmt.Errorf("failed to decompress archive %s: %w", idx.path, err)
+	}
+	idx.tar, idx.spooled = spool, true
+	return spool, nil
+}
+
+// openArchiveMember opens a single member of an archive for streaming and
+// returns its uncompressed size
+func openArchiveMember(archivePath, member string) (io.ReadCloser, int64, error) {
+	idx, err := acquireArchive(archivePath)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if f, ok := idx.zipFiles[member]; ok {
+		rc, err := f.Open()
+		if err != nil {
+			idx.Close()
+			return nil, 0, fmt.Errorf("failed to open %s in %s: %w", member, archivePath, err)
+		}
+		return &multiCloser{Reader: rc, closers: []io.Closer{rc, idx}}, int64(f.UncompressedSize64), nil
+	}
+	if m, ok := idx.members[member]; ok {
+		file, err := idx.tarFile()
+		if err != nil {
+			idx.Close()
+			return nil, 0, err
+		}
+		return &multiCloser{Reader: io.NewSectionReader(file, m.offset, m.size), closers: []io.Closer{idx}}, m.size, nil
+	}
+	idx.Close()
+	return nil, 0, fmt.Errorf("%s not found in %s", member, archivePath)
+}
+
+// multiCloser reads from Reader and closes every closer, in order, on Close
+type multiCloser struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (m *multiCloser) Close() error {
+	var first error
+	for _, c := range m.closers {
+		if err := c.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
@@ -0,0 +1,203 @@
+package loader
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// writeZip creates a zip archive with the given member names and contents
+func writeZip(t *testing.T, path string, members map[string]string) {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range members {
+		f, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte(content))
+	}
+	zw.Close()
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeTar creates a tar archive with the given members, gzip-compressed
+// unless the path ends in .tar
+func writeTar(t *testing.T, path string, members map[string]string) {
+	t.Helper()
+	var buf bytes.Buffer
+	var out io.WriteCloser = nopWriteCloser{&buf}
+	if !strings.HasSuffix(path, ".tar") {
+		out = gzip.NewWriter(&buf)
+	}
+	tw := tar.NewWriter(out)
+	for name, content := range members {
+		tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg})
+		tw.Write([]byte(content))
+	}
+	tw.Close()
+	out.Close()
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+func TestScanDirectory_Archives(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	writeZip(t, filepath.Join(tmpDir, "vendor.zip"), map[string]string{
+		"orders.csv":            "id,total\n1,9.5\n",
+		"nested/customers.tsv":  "id\tname\n1\tAlice\n",
+		"readme.txt":            "not a table",
+		"__MACOSX/._orders.csv": "resource fork",
+	})
+	writeTar(t, filepath.Join(tmpDir, "dump.tar.gz"), map[string]string{
+		"./orders.csv": "id,total\n2,3.0\n",
+	})
+
+	files, err := ScanDirectory(tmpDir)
+	if err != nil {
+		t.Fatalf("ScanDirectory failed: %v", err)
+	}
+
+	names := ResolveTableNames(files, tmpDir)
+	var tables []string
+	for _, name := range names {
+		tables = append(tables, name)
+	}
+	sort.Strings(tables)
+
+	expected := []string{"dump_orders", "vendor_customers", "vendor_orders"}
+	if len(tables) != len(expected) {
+		t.Fatalf("Expected tables %v, got %v", expected, tables)
+	}
+	for i := range expected {
+		if tables[i] != expected[i] {
+			t.Errorf("Expected tables %v, got %v", expected, tables)
+			break
+		}
+	}
+}
+
+func TestParseFile_ArchiveMember(t *testing.T) {
+	tmpDir := t.TempDir()
+	archive := filepath.Join(tmpDir, "vendor.zip")
+	writeZip(t, archive, map[string]string{"data/orders.csv": "id,total\n1,9.5\n2,3.0\n"})
+
+	member := filepath.Join(archive, "data", "orders.csv")
+	parsed, err := ParseFile(member, tmpDir)
+	if err != nil {
+		t.Fatalf("ParseFile failed: %v", err)
+	}
+
+	if parsed.Info.TableName != "vendor_data_orders" {
+		t.Errorf("Expected full table name vendor_data_orders, got %q", parsed.Info.TableName)
+	}
+	if len(parsed.Records) != 2 || parsed.Records[1][1] != "3.0" {
+		t.Errorf("Unexpected records: %v", parsed.Records)
+	}
+
+	stat, _ := os.Stat(archive)
+	if parsed.Info.ModTime != stat.ModTime().UnixNano() {
+		t.Error("Archive members should take the archive's modification time")
+	}
+
+	if _, err := ParseFile(filepath.Join(archive, "missing.csv"), tmpDir); err == nil {
+		t.Error("Expected error for a missing member")
+	}
+}
+
+func TestOpenArchiveMember_Index(t *testing.T) {
+	tmpDir := t.TempDir()
+	long := strings.Repeat("nested/", 20) + "deep.csv" // stored with a PAX header
+	members := map[string]string{
+		"a.csv": "id\n1\n",
+		"b.csv": strings.Repeat("2\n", 1000),
+		long:    "id\n3\n",
+	}
+
+	for _, name := range []string{"dump.tar", "dump.tar.gz"} {
+		t.Run(name, func(t *testing.T) {
+			defer ReleaseArchives()
+			archive := filepath.Join(tmpDir, name)
+			writeTar(t, archive, members)
+
+			var open []io.Closer
+			for member, content := range members {
+				rc, size, err := openArchiveMember(archive, member)
+				if err != nil {
+					t.Fatalf("openArchiveMember %s failed: %v", member, err)
+				}
+				open = append(open, rc)
+				data, _ := io.ReadAll(rc)
+				if string(data) != content || size != int64(len(content)) {
+					t.Errorf("Member %s: got %d bytes %.20q, size %d", member, len(data), data, size)
+				}
+			}
+
+			// Every member came from one index and, for a compressed tar,
+			// one decompressed copy, kept until the last member closes
+			idx := archiveIndexes.m[archive]
+			if idx == nil || idx.refs != len(members) {
+				t.Fatalf("Expected one index held by %d members, got %+v", len(members), idx)
+			}
+			spool := idx.tar.Name()
+			if idx.spooled != strings.HasSuffix(name, ".gz") {
+				t.Errorf("Expected spooled to be %v", !idx.spooled)
+			}
+			ReleaseArchives()
+			for _, rc := range open {
+				if _, err := os.Stat(spool); err != nil {
+					t.Fatalf("Archive closed while members are open: %v", err)
+				}
+				rc.Close()
+			}
+			if _, err := os.Stat(spool); idx.spooled && !os.IsNotExist(err) {
+				t.Errorf("Expected the temporary tar to be removed, got %v", err)
+			}
+
+			// A changed archive is indexed again
+			writeTar(t, archive, map[string]string{"a.csv": "id\n10\n"})
+			rc, _, err := openArchiveMember(archive, "a.csv")
+			if err != nil {
+				t.Fatalf("openArchiveMember failed: %v", err)
+			}
+			data, _ := io.ReadAll(rc)
+			rc.Close()
+			if string(data) != "id\n10\n" {
+				t.Errorf("Expected the rewritten member, got %q", data)
+			}
+			if _, _, err := openArchiveMember(archive, "b.csv"); err == nil {
+				t.Error("Expected error for a member removed from the archive")
+			}
+		})
+	}
+}
+
+func TestSplitArchivePath(t *testing.T) {
+	archive, member, ok := SplitArchivePath(filepath.Join("data", "bundle.tar.gz", "2024", "orders.csv"))
+	if !ok || archive != filepath.Join("data", "bundle.tar.gz") || member != "2024/orders.csv" {
+		t.Errorf("Got %q %q %v", archive, member, ok)
+	}
+
+	if _, _, ok := SplitArchivePath(filepath.Join("data", "orders.csv")); ok {
+		t.Error("Plain file should not be an archive member")
+	}
+	if _, _, ok := SplitArchivePath(filepath.Join("data", "bundle.zip")); ok {
+		t.Error("Archive itself should not be an archive member")
+	}
+}
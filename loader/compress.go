@@ -0,0 +1,68 @@
+package loader
+
+import (
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// compressionExtensions lists the compressed file suffixes that are
+// decompressed on the fly, e.g. orders.csv.gz
+var compressionExtensions = map[string]bool{
+	".gz":  true,
+	".bz2": true,
+	".zst": true,
+	".xz":  true,
+}
+
+// splitExtensions returns the path without its extensions, the data
+// extension (.csv, .tsv, ...) and the compression extension, if any. Both
+// extensions are lower-cased.
+func splitExtensions(path string) (name, dataExt, compressionExt string) {
+	name = path
+	if ext := strings.ToLower(filepath.Ext(name)); compressionExtensions[ext] {
+		compressionExt = ext
+		name = name[:len(name)-len(ext)]
+	}
+	dataExt = strings.ToLower(filepath.Ext(name))
+	name = name[:len(name)-len(dataExt)]
+	return name, dataExt, compressionExt
+}
+
+// trimExtensions removes the data and compression extensions from a path
+func trimExtensions(path string) string {
+	name, _, _ := splitExtensions(path)
+	return name
+}
+
+// newDecompressor wraps r in a streaming decompressor chosen by the
+// compression extension, or returns it unchanged for plain files
+func newDecompressor(r io.Reader, compressionExt string) (io.ReadCloser, error) {
+	switch compressionExt {
+	case ".gz":
+		return gzip.NewReader(r)
+	case ".bz2":
+		return io.NopCloser(bzip2.NewReader(r)), nil
+	case ".zst":
+		d, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	case ".xz":
+		x, err := xz.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(x), nil
+	case "":
+		return io.NopCloser(r), nil
+	}
+	return nil, fmt.Errorf("unsupported compression %s", compressionExt)
+}
@@ -0,0 +1,137 @@
+package loader
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+const compressedCSV = "id,name\n1,Alice\n2,Bob\n"
+
+// compressedBzip2 is compressedCSV compressed with bzip2 -9; the standard
+// library has no bzip2 writer
+const compressedBzip2 = "425a683931415926535957481da8000008dd0000100004300030003e27a00021a81a0d19ea8530004d1d6f0cecb2a48449a8387c5dc914e142415d2076a0"
+
+func compress(t *testing.T, ext string, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	switch ext {
+	case ".gz":
+		w := gzip.NewWriter(&buf)
+		w.Write(data)
+		w.Close()
+	case ".zst":
+		w, err := zstd.NewWriter(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(data)
+		w.Close()
+	case ".xz":
+		w, err := xz.NewWriter(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(data)
+		w.Close()
+	case ".bz2":
+		b, err := hex.DecodeString(compressedBzip2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf.Write(b)
+	}
+	return buf.Bytes()
+}
+
+func TestParseFile_Compressed(t *testing.T) {
+	for _, ext := range []string{".gz", ".bz2", ".zst", ".xz"} {
+		tmpDir := t.TempDir()
+		path := filepath.Join(tmpDir, "orders.csv"+ext)
+		if err := os.WriteFile(path, compress(t, ext, []byte(compressedCSV)), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		if !IsSupportedFile(path) {
+			t.Errorf("%s: IsSupportedFile = false", ext)
+		}
+
+		parsed, err := ParseFile(path, tmpDir)
+		if err != nil {
+			t.Fatalf("%s: ParseFile failed: %v", ext, err)
+		}
+		if parsed.Info.TableName != "orders" {
+			t.Errorf("%s: TableName = %q, want orders", ext, parsed.Info.TableName)
+		}
+		if len(parsed.Records) != 2 || parsed.Records[1][1] != "Bob" {
+			t.Errorf("%s: Records = %q", ext, parsed.Records)
+		}
+	}
+}
+
+func TestOpenFile_CompressedProgress(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "data.tsv.gz")
+	os.WriteFile(path, compress(t, ".gz", []byte("id\tname\n1\tAlice\n")), 0644)
+
+	reader, err := OpenFile(path, tmpDir)
+	if err != nil {
+		t.Fatalf("OpenFile failed: %v", err)
+	}
+	defer reader.Close()
+
+	if reader.Info.Delimiter != '\t' {
+		t.Errorf("Delimiter = %q, want tab", reader.Info.Delimiter)
+	}
+
+	var last Progress
+	reader.SetProgress(func(p Progress) { last = p })
+	for {
+		if _, err := reader.ReadBatch(); err != nil {
+			break
+		}
+	}
+	stat, _ := os.Stat(path)
+	if !last.Done || last.Total != stat.Size() || last.Bytes != stat.Size() {
+		t.Errorf("Progress should be in compressed bytes: %+v (size %d)", last, stat.Size())
+	}
+}
+
+func TestOpenFile_CorruptCompressed(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "broken.csv.gz")
+	os.WriteFile(path, []byte("not gzip at all"), 0644)
+
+	if _, err := OpenFile(path, tmpDir); err == nil {
+		t.Error("Expected error for corrupt gzip file")
+	}
+}
+
+func TestSplitExtensions(t *testing.T) {
+	tests := []struct {
+		path, name, dataExt, compressionExt string
+	}{
+		{"orders.csv", "orders", ".csv", ""},
+		{"dir/Orders.CSV.GZ", "dir/Orders", ".csv", ".gz"},
+		{"orders.tsv.zst", "orders", ".tsv", ".zst"},
+		{"archive.gz", "archive", "", ".gz"},
+		{"notes.txt.xz", "notes", ".txt", ".xz"},
+	}
+
+	for _, tt := range tests {
+		name, dataExt, compressionExt := splitExtensions(tt.path)
+		if name != tt.name || dataExt != tt.dataExt || compressionExt != tt.compressionExt {
+			t.Errorf("splitExtensions(%q) = %q %q %q", tt.path, name, dataExt, compressionExt)
+		}
+	}
+
+	if IsSupportedFile("archive.gz") || IsSupportedFile("notes.txt.xz") {
+		t.Error("Compressed files without a supported inner extension should be ignored")
+	}
+}
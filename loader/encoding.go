@@ -0,0 +1,232 @@
+package loader
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+// Canonical names of the supported character encodings
+const (
+	EncodingUTF8        = "utf-8"
+	EncodingUTF16LE     = "utf-16le"
+	EncodingUTF16BE     = "utf-16be"
+	EncodingWindows1252 = "windows-1252"
+	EncodingLatin1      = "iso-8859-1"
+)
+
+// encodingAliases maps accepted override names to canonical names
+var encodingAliases = map[string]string{
+	"utf-8":        EncodingUTF8,
+	"utf8":         EncodingUTF8,
+	"utf-16le":     EncodingUTF16LE,
+	"utf16le":      EncodingUTF16LE,
+	"utf-16be":     EncodingUTF16BE,
+	"utf16be":      EncodingUTF16BE,
+	"windows-1252": EncodingWindows1252,
+	"cp1252":       EncodingWindows1252,
+	"iso-8859-1":   EncodingLatin1,
+	"latin1":       EncodingLatin1,
+	"latin-1":      EncodingLatin1,
+}
+
+// byteOrderMarks are checked in order against the start of a file
+var byteOrderMarks = []struct {
+	bom      []byte
+	encoding string
+}{
+	{[]byte{0xEF, 0xBB, 0xBF}, EncodingUTF8},
+	{[]byte{0xFF, 0xFE}, EncodingUTF16LE},
+	{[]byte{0xFE, 0xFF}, EncodingUTF16BE},
+}
+
+// NormalizeEncoding returns the canonical name of a supported encoding,
+// accepting common aliases such as "utf8", "cp1252" or "latin1"
+func NormalizeEncoding(name string) (string, error) {
+	canonical, ok := encodingAliases[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		return "", fmt.Errorf("unknown encoding %q", name)
+	}
+	return canonical, nil
+}
+
+// DetectEncoding guesses the encoding of a file from its first bytes and
+// returns it along with the length of the byte order mark, if any. Without
+// a BOM, UTF-16 is recognised by its pattern of zero bytes, valid UTF-8 is
+// taken as UTF-8, and anything else is an 8-bit legacy encoding: Windows-1252
+// if bytes 0x80-0x9F occur (printable there, control codes in Latin-1),
+// ISO-8859-1 otherwise.
+func DetectEncoding(sample []byte) (string, int) {
+	if enc, n := detectBOM(sample); n > 0 {
+		return enc, n
+	}
+
+	if enc := detectUTF16(sample); enc != "" {
+		return enc, 0
+	}
+
+	if utf8.Valid(trimPartialRune(sample)) {
+		return EncodingUTF8, 0
+	}
+
+	for _, b := range sample {
+		if b >= 0x80 && b <= 0x9F {
+			return EncodingWindows1252, 0
+		}
+	}
+	return EncodingLatin1, 0
+}
+
+// detectBOM returns the encoding and length of a leading byte order mark
+func detectBOM(sample []byte) (string, int) {
+	for _, m := range byteOrderMarks {
+		if bytes.HasPrefix(sample, m.bom) {
+			return m.encoding, len(m.bom)
+		}
+	}
+	return "", 0
+}
+
+// detectUTF16 recognises BOM-less UTF-16 text: mostly-ASCII content leaves
+// the high byte of nearly every code unit zero, on the odd positions for
+// little endian and the even ones for big endian
+func detectUTF16(sample []byte) string {
+	sample = sample[:min(len(sample), 4096)&^1]
+	units := len(sample) / 2
+	if units < 2 {
+		return ""
+	}
+
+	evenZeros, oddZeros := 0, 0
+	for i := 0; i < len(sample); i += 2 {
+		if sample[i] == 0 {
+			evenZeros++
+		}
+		if sample[i+1] == 0 {
+			oddZeros++
+		}
+	}
+
+	switch {
+	case oddZeros*10 >= units*3 && evenZeros*20 < units:
+		return EncodingUTF16LE
+	case evenZeros*10 >= units*3 && oddZeros*20 < units:
+		return EncodingUTF16BE
+	}
+	return ""
+}
+
+// trimPartialRune drops an incomplete UTF-8 sequence cut off at the end of a sample
+func trimPartialRune(sample []byte) []byte {
+	for i := 1; i <= utf8.UTFMax && i <= len(sample); i++ {
+		if utf8.RuneStart(sample[len(sample)-i]) {
+			if !utf8.FullRune(sample[len(sample)-i:]) {
+				return sample[:len(sample)-i]
+			}
+			break
+		}
+	}
+	return sample
+}
+
+// bomLength returns the length of a leading BOM belonging to the given encoding
+func bomLength(sample []byte, enc string) int {
+	if bomEnc, n := detectBOM(sample); bomEnc == enc {
+		return n
+	}
+	return 0
+}
+
+// newDecoder wraps r so it yields UTF-8. The BOM must already be skipped.
+func newDecoder(r io.Reader, enc string) io.Reader {
+	var e encoding.Encoding
+	switch enc {
+	case EncodingUTF16LE:
+		e = unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM)
+	case EncodingUTF16BE:
+		e = unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM)
+	case EncodingWindows1252:
+		e = charmap.Windows1252
+	case EncodingLatin1:
+		e = charmap.ISO8859_1
+	default:
+		return r
+	}
+	return transform.NewReader(r, e.NewDecoder())
+}
+
+// utf8Fallback passes through text detected as UTF-8 from its first bytes
+// only. At the first invalid sequence, past the sample DetectEncoding saw,
+// it decodes the rest as Windows-1252, which agrees with ISO-8859-1 on every
+// printable character, so no invalid UTF-8 reaches the table.
+type utf8Fallback struct {
+	r          *bufio.Reader
+	valid      int       // bytes of a rune already checked, not yet read
+	decoder    io.Reader // set once fallen back
+	onFallback func()
+}
+
+func (u *utf8Fallback) Read(p []byte) (int, error) {
+	if u.decoder != nil {
+		return u.decoder.Read(p)
+	}
+	buf, err := u.r.Peek(min(max(len(p), utf8.UTFMax), u.r.Size()))
+	if len(buf) == 0 {
+		return 0, err
+	}
+	more := err == nil || err == bufio.ErrBufferFull
+
+	n := 0
+	for n < len(buf) && n < len(p) {
+		if u.valid > 0 {
+			u.valid--
+			n++
+			continue
+		}
+		if buf[n] < utf8.RuneSelf {
+			n++
+			continue
+		}
+		r, size := utf8.DecodeRune(buf[n:])
+		if r == utf8.RuneError && size <= 1 {
+			if more && !utf8.FullRune(buf[n:]) {
+				break // cut off by the end of buf, checked on the next read
+			}
+			if n == 0 {
+				u.decoder = newDecoder(u.r, EncodingWindows1252)
+				if u.onFallback != nil {
+					u.onFallback()
+				}
+				return u.decoder.Read(p)
+			}
+			break
+		}
+		// The rune may be split across reads
+		u.valid = size - 1
+		n++
+	}
+	copy(p, buf[:n])
+	u.r.Discard(n)
+	return n, nil
+}
+
+// countingReader counts the bytes read through it, so progress can be
+// reported in file bytes when the CSV parser only sees transcoded text
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
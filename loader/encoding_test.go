@@ -0,0 +1,184 @@
+package loader
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// encodeUTF16 encodes s as UTF-16 in the given byte order, optionally with a BOM
+func encodeUTF16(s string, bigEndian, bom bool) []byte {
+	units := utf16.Encode([]rune(s))
+	if bom {
+		units = append([]uint16{0xFEFF}, units...)
+	}
+	out := make([]byte, 0, len(units)*2)
+	for _, u := range units {
+		if bigEndian {
+			out = append(out, byte(u>>8), byte(u))
+		} else {
+			out = append(out, byte(u), byte(u>>8))
+		}
+	}
+	return out
+}
+
+func TestDetectEncoding(t *testing.T) {
+	tests := []struct {
+		name     string
+		sample   []byte
+		encoding string
+		bom      int
+	}{
+		{"ascii", []byte("id,name\n1,Alice\n"), EncodingUTF8, 0},
+		{"utf-8", []byte("città,prezzo\nRoma,€5\n"), EncodingUTF8, 0},
+		{"utf-8 bom", []byte("\xEF\xBB\xBFid,name\n"), EncodingUTF8, 3},
+		{"utf-8 cut mid rune", []byte("a,b\nc,\xE2\x82"), EncodingUTF8, 0},
+		{"utf-16le bom", encodeUTF16("id,name\n", false, true), EncodingUTF16LE, 2},
+		{"utf-16be bom", encodeUTF16("id,name\n", true, true), EncodingUTF16BE, 2},
+		{"utf-16le no bom", encodeUTF16("id,name\n1,Alice\n", false, false), EncodingUTF16LE, 0},
+		{"utf-16be no bom", encodeUTF16("id,name\n1,Alice\n", true, false), EncodingUTF16BE, 0},
+		{"windows-1252", []byte("name\n\x93quoted\x94 caf\xE9\n"), EncodingWindows1252, 0},
+		{"latin-1", []byte("name\ncaf\xE9\n"), EncodingLatin1, 0},
+	}
+
+	for _, tt := range tests {
+		encoding, bom := DetectEncoding(tt.sample)
+		if encoding != tt.encoding || bom != tt.bom {
+			t.Errorf("%s: got %s (bom %d), want %s (bom %d)", tt.name, encoding, bom, tt.encoding, tt.bom)
+		}
+	}
+}
+
+func TestNormalizeEncoding(t *testing.T) {
+	if got, err := NormalizeEncoding(" CP1252 "); err != nil || got != EncodingWindows1252 {
+		t.Errorf("NormalizeEncoding(CP1252) = %q, %v", got, err)
+	}
+	if _, err := NormalizeEncoding("ebcdic"); err == nil {
+		t.Error("Expected error for unsupported encoding")
+	}
+}
+
+func TestParseFile_Encodings(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	tests := []struct {
+		name     string
+		content  []byte
+		encoding string
+	}{
+		{"bom.csv", []byte("\xEF\xBB\xBFcittà,prezzo\nRoma,€5\n"), EncodingUTF8},
+		{"utf16le.csv", encodeUTF16("città,prezzo\nRoma,€5\n", false, true), EncodingUTF16LE},
+		{"utf16be.csv", encodeUTF16("città,prezzo\nRoma,€5\n", true, false), EncodingUTF16BE},
+		{"cp1252.csv", []byte("citt\xE0,prezzo\nRoma,\x805\n"), EncodingWindows1252},
+	}
+
+	for _, tt := range tests {
+		path := filepath.Join(tmpDir, tt.name)
+		if err := os.WriteFile(path, tt.content, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		parsed, err := ParseFile(path, tmpDir)
+		if err != nil {
+			t.Fatalf("%s: ParseFile failed: %v", tt.name, err)
+		}
+		if parsed.Info.Encoding != tt.encoding {
+			t.Errorf("%s: Encoding = %s, want %s", tt.name, parsed.Info.Encoding, tt.encoding)
+		}
+		if got := parsed.Info.Headers; len(got) != 2 || got[0] != "città" || got[1] != "prezzo" {
+			t.Errorf("%s: Headers = %q", tt.name, got)
+		}
+		if len(parsed.Records) != 1 || parsed.Records[0][1] != "€5" {
+			t.Errorf("%s: Records = %q", tt.name, parsed.Records)
+		}
+	}
+}
+
+func TestOpenFileConfig_EncodingOverride(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "latin9.csv")
+
+	// Valid UTF-8 by accident, but actually Latin-1: "Ã©" is "Ã" followed by "©"
+	if err := os.WriteFile(path, []byte("name\n\xC3\xA9\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := OpenFileConfig(path, tmpDir, FileConfig{Encoding: "latin1"})
+	if err != nil {
+		t.Fatalf("OpenFileConfig failed: %v", err)
+	}
+	defer reader.Close()
+
+	batch, err := reader.ReadBatch()
+	if err != nil {
+		t.Fatalf("ReadBatch failed: %v", err)
+	}
+	if reader.Info.Encoding != EncodingLatin1 || batch[0][0] != "Ã©" {
+		t.Errorf("Got %s %q, want iso-8859-1 \"Ã©\"", reader.Info.Encoding, batch[0][0])
+	}
+
+	if _, err := OpenFileConfig(path, tmpDir, FileConfig{Encoding: "klingon"}); err == nil {
+		t.Error("Expected error for unknown encoding")
+	}
+}
+
+func TestParseFile_LegacyByteAfterSample(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "late.csv")
+
+	// UTF-8 throughout the sniffed sample, then a Windows-1252 row
+	content := []byte("id,città\n")
+	for i := 0; len(content) <= SniffSize; i++ {
+		content = fmt.Appendf(content, "%d,città\n", i)
+	}
+	content = append(content, "late,caf\xE9 \x805\n"...)
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := ParseFile(path, tmpDir)
+	if err != nil {
+		t.Fatalf("ParseFile failed: %v", err)
+	}
+	if parsed.Info.Encoding != EncodingWindows1252 {
+		t.Errorf("Encoding = %s, want %s", parsed.Info.Encoding, EncodingWindows1252)
+	}
+	for _, record := range parsed.Records {
+		for _, field := range record {
+			if !utf8.ValidString(field) {
+				t.Fatalf("Invalid UTF-8 loaded: %q", record)
+			}
+		}
+	}
+	if first, last := parsed.Records[0], parsed.Records[len(parsed.Records)-1]; first[1] != "città" || last[1] != "café €5" {
+		t.Errorf("Got first %q and last %q", first, last)
+	}
+}
+
+func TestUTF8Fallback_SplitRunes(t *testing.T) {
+	// One byte at a time, multi-byte runes must not read as invalid
+	text := "città €5 😀"
+	u := &utf8Fallback{r: bufio.NewReaderSize(strings.NewReader(text+"\xE9"), 16)}
+	var out []byte
+	p := make([]byte, 1)
+	for {
+		n, err := u.Read(p)
+		out = append(out, p[:n]...)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if string(out) != text+"é" {
+		t.Errorf("Got %q", out)
+	}
+}
@@ -0,0 +1,206 @@
+package loader
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// fixedWidthExtension marks fixed-width text files; they are only loaded
+// when a layout sidecar describes their columns
+const fixedWidthExtension = ".txt"
+
+// layoutExtension is appended to a fixed-width file name to find its
+// layout: report.txt is described by report.txt.layout
+const layoutExtension = ".layout"
+
+// LayoutColumn is a field of a fixed-width layout. Start is the 1-based
+// character position of the first character; an empty Type is inferred.
+type LayoutColumn struct {
+	Name   string
+	Start  int
+	Length int
+	Type   ColumnType
+}
+
+// LayoutPath returns the layout sidecar of a fixed-width file; a
+// compressed report.txt.gz uses report.txt.layout
+func LayoutPath(filePath string) string {
+	_, dataExt, _ := splitExtensions(filePath)
+	name := trimExtensions(filePath)
+	return name + dataExt + layoutExtension
+}
+
+// IsLayoutFile reports whether a path is a layout sidecar
+func IsLayoutFile(path string) bool {
+	return strings.HasSuffix(strings.ToLower(path), fixedWidthExtension+layoutExtension)
+}
+
+// LayoutDataPath returns the fixed-width file a layout describes
+func LayoutDataPath(layoutPath string) string {
+	return layoutPath[:len(layoutPath)-len(layoutExtension)]
+}
+
+// IsFixedWidthFile reports whether a file is a .txt file with a layout
+// sidecar next to it
+func IsFixedWidthFile(filePath string) bool {
+	if _, _, ok := SplitArchivePath(filePath); ok {
+		return false
+	}
+	_, dataExt, _ := splitExtensions(filePath)
+	if dataExt != fixedWidthExtension {
+		return false
+	}
+	_, err := os.Stat(LayoutPath(filePath))
+	return err == nil
+}
+
+// ReadLayout parses a layout file: one column per line as name, start,
+// length and an optional type, separated by spaces, tabs or commas. Blank
+// lines, # comments and a "name start length type" header are skipped.
+func ReadLayout(path string) ([]LayoutColumn, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open layout %s: %w", path, err)
+	}
+	defer f.Close()
+
+	var columns []LayoutColumn
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.FieldsFunc(line, func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t'
+		})
+		if len(columns) == 0 && len(fields) >= 2 && strings.EqualFold(fields[1], "start") {
+			continue
+		}
+		if len(fields) < 3 || len(fields) > 4 {
+			return nil, fmt.Errorf("layout %s line %d: expected name, start, length and optional type", path, lineNo)
+		}
+
+		col := LayoutColumn{Name: fields[0]}
+		if col.Start, err = strconv.Atoi(fields[1]); err != nil || col.Start < 1 {
+			return nil, fmt.Errorf("layout %s line %d: invalid start %q", path, lineNo, fields[1])
+		}
+		if col.Length, err = strconv.Atoi(fields[2]); err != nil || col.Length < 1 {
+			return nil, fmt.Errorf("layout %s line %d: invalid length %q", path, lineNo, fields[2])
+		}
+		if len(fields) == 4 {
+			if col.Type, err = parseColumnType(fields[3]); err != nil {
+				return nil, fmt.Errorf("layout %s line %d: %w", path, lineNo, err)
+			}
+		}
+		columns = append(columns, col)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read layout %s: %w", path, err)
+	}
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("layout %s defines no columns", path)
+	}
+	return columns, nil
+}
+
+// parseColumnType accepts a column type name in any letter case; "auto"
+// leaves the type to inference
+func parseColumnType(name string) (ColumnType, error) {
+	upper := ColumnType(strings.ToUpper(name))
+	switch upper {
+	case TypeText, TypeInteger, TypeReal, TypeBoolean, TypeDate, TypeDatetime:
+		return upper, nil
+	case "AUTO":
+		return "", nil
+	}
+	return "", fmt.Errorf("unknown column type %q", name)
+}
+
+// openFixedWidth slices each line into the columns of the layout. Values
+// are trimmed of padding; blank and comment lines are skipped.
+func (r *Reader) openFixedWidth(input *bufio.Reader, cfg FileConfig) error {
+	filePath := r.Info.Path
+	layoutPath := LayoutPath(filePath)
+
+	layout, err := ReadLayout(layoutPath)
+	if err != nil {
+		return err
+	}
+	r.Info.ModTime = layoutModTime(filePath, r.Info.ModTime)
+
+	consumed, err := skipLines(input, cfg.SkipLines)
+	if err != nil {
+		return fmt.Errorf("failed to read file %s: %w", filePath, err)
+	}
+	r.next = func() ([]string, error) {
+		for {
+			line, err := input.ReadString('\n')
+			consumed += int64(len(line))
+			if line == "" && err != nil {
+				return nil, err
+			}
+			line = strings.TrimRight(line, "\r\n")
+			if strings.TrimSpace(line) == "" || (cfg.Comment != 0 && strings.HasPrefix(line, string(cfg.Comment))) {
+				continue
+			}
+			return sliceFixedWidth(line, layout), nil
+		}
+	}
+	r.offset = func() int64 { return consumed }
+
+	sample, err := r.sample()
+	if err != nil {
+		return err
+	}
+	if len(sample) == 0 {
+		return fmt.Errorf("%w: %s", ErrEmptyFile, filePath)
+	}
+
+	headers := make([]string, len(layout))
+	for i, col := range layout {
+		headers[i] = col.Name
+	}
+	types := InferColumnTypes(len(headers), sample)
+	for i, col := range layout {
+		if col.Type != "" {
+			types[i] = col.Type
+		}
+	}
+
+	r.Info.Headers = headers
+	r.Info.ColumnTypes = types
+	r.pending = sample
+	return nil
+}
+
+// sliceFixedWidth cuts a line into fields by character position; fields
+// past the end of a short line are empty
+func sliceFixedWidth(line string, layout []LayoutColumn) []string {
+	chars := []rune(line)
+	record := make([]string, len(layout))
+	for i, col := range layout {
+		start := col.Start - 1
+		if start >= len(chars) {
+			continue
+		}
+		end := min(start+col.Length, len(chars))
+		record[i] = strings.TrimSpace(string(chars[start:end]))
+	}
+	return record
+}
+
+// layoutModTime returns the later of a fixed-width file's modification time
+// and its layout's, so editing the layout reloads the file
+func layoutModTime(filePath string, modTime int64) int64 {
+	if !IsFixedWidthFile(filePath) {
+		return modTime
+	}
+	if stat, err := os.Stat(LayoutPath(filePath)); err == nil && stat.ModTime().UnixNano() > modTime {
+		return stat.ModTime().UnixNano()
+	}
+	return modTime
+}
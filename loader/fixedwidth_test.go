@@ -0,0 +1,91 @@
+package loader
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadLayout(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "report.txt.layout")
+	content := "# report extract\nname,start,length,type\nid 1 5 integer\nname\t6\t10\nopened, 16, 8, DATE\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	layout, err := ReadLayout(path)
+	if err != nil {
+		t.Fatalf("ReadLayout failed: %v", err)
+	}
+	want := []LayoutColumn{
+		{Name: "id", Start: 1, Length: 5, Type: TypeInteger},
+		{Name: "name", Start: 6, Length: 10},
+		{Name: "opened", Start: 16, Length: 8, Type: TypeDate},
+	}
+	if !reflect.DeepEqual(layout, want) {
+		t.Errorf("Expected %v, got %v", want, layout)
+	}
+
+	for _, bad := range []string{"id 0 5\n", "id 1\n", "id 1 5 money\n", "# empty\n"} {
+		os.WriteFile(path, []byte(bad), 0644)
+		if _, err := ReadLayout(path); err == nil {
+			t.Errorf("Expected error for layout %q", bad)
+		}
+	}
+}
+
+func TestFixedWidth(t *testing.T) {
+	tmpDir := t.TempDir()
+	data := filepath.Join(tmpDir, "report.txt")
+	os.WriteFile(data, []byte("00001Rossi     2024-01-15  9.50\r\n\r\n00002Müller    2024-02-01\r\n"), 0644)
+
+	if IsFixedWidthFile(data) {
+		t.Error("Expected .txt without a layout not to be fixed-width")
+	}
+	os.WriteFile(LayoutPath(data), []byte("id 1 5 text\nname 6 10\nopened 16 10\namount 26 6\n"), 0644)
+	if !IsFixedWidthFile(data) {
+		t.Fatal("Expected .txt with a layout to be fixed-width")
+	}
+	if got := LayoutPath(data + ".gz"); got != LayoutPath(data) {
+		t.Errorf("Expected compressed file to share layout %s, got %s", LayoutPath(data), got)
+	}
+
+	files, err := ScanDirectory(tmpDir)
+	if err != nil {
+		t.Fatalf("ScanDirectory failed: %v", err)
+	}
+	if len(files) != 1 || files[0] != data {
+		t.Errorf("Expected only %s, got %v", data, files)
+	}
+
+	r, err := OpenFile(data, tmpDir)
+	if err != nil {
+		t.Fatalf("OpenFile failed: %v", err)
+	}
+	defer r.Close()
+
+	if r.Info.TableName != "report" {
+		t.Errorf("Expected table report, got %s", r.Info.TableName)
+	}
+	if !reflect.DeepEqual(r.Info.Headers, []string{"id", "name", "opened", "amount"}) {
+		t.Errorf("Unexpected headers: %v", r.Info.Headers)
+	}
+	if !reflect.DeepEqual(r.Info.ColumnTypes, []ColumnType{TypeText, TypeText, TypeDate, TypeReal}) {
+		t.Errorf("Unexpected column types: %v", r.Info.ColumnTypes)
+	}
+
+	batch, err := r.ReadBatch()
+	if err != nil {
+		t.Fatalf("ReadBatch failed: %v", err)
+	}
+	want := [][]string{{"00001", "Rossi", "2024-01-15", "9.50"}, {"00002", "Müller", "2024-02-01", ""}}
+	if !reflect.DeepEqual(batch, want) {
+		t.Errorf("Expected %v, got %v", want, batch)
+	}
+	if _, err := r.ReadBatch(); err != io.EOF {
+		t.Errorf("Expected EOF, got %v", err)
+	}
+}
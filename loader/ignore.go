@@ -0,0 +1,242 @@
+package loader
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// IgnoreFileName is the gitignore-style file listing, for its directory and
+// those below, the paths csvql does not load or watch
+const IgnoreFileName = ".csvqlignore"
+
+// IsIgnoreFile reports whether a path is a .csvqlignore file
+func IsIgnoreFile(path string) bool {
+	return filepath.Base(path) == IgnoreFileName
+}
+
+// ignoreRule is a pattern of a .csvqlignore file or of the exclude option
+type ignoreRule struct {
+	pattern  string // slash-separated, without a leading or trailing slash
+	negate   bool   // !pattern re-includes what an earlier rule ignored
+	dirOnly  bool   // pattern/ only matches directories
+	anchored bool   // a pattern with a slash is relative to its directory, otherwise it matches names at any depth
+}
+
+// parseIgnoreRule parses one pattern line, reporting false for blank lines
+// and # comments
+func parseIgnoreRule(line string) (ignoreRule, bool) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignoreRule{}, false
+	}
+	var r ignoreRule
+	if strings.HasPrefix(line, "!") {
+		r.negate = true
+		line = line[1:]
+	}
+	line = strings.TrimPrefix(line, `\`) // \# and \! escape a leading character
+	if strings.HasSuffix(line, "/") {
+		r.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if strings.Contains(line, "/") {
+		r.anchored = true
+		line = strings.TrimPrefix(line, "/")
+	}
+	r.pattern = line
+	return r, line != ""
+}
+
+// parseIgnoreRules parses patterns, one per entry
+func parseIgnoreRules(lines []string) []ignoreRule {
+	var rules []ignoreRule
+	for _, line := range lines {
+		if r, ok := parseIgnoreRule(line); ok {
+			rules = append(rules, r)
+		}
+	}
+	return rules
+}
+
+// match reports whether the rule matches a slash-separated path relative to
+// the rule's directory
+func (r ignoreRule) match(rel string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	if r.anchored {
+		return matchGlob(r.pattern, rel)
+	}
+	return matchGlob(r.pattern, path.Base(rel))
+}
+
+// matchGlob matches a slash-separated path against a pattern in which a **
+// segment matches any number of directories
+func matchGlob(pattern, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// Filter decides which paths below a root directory are scanned and watched:
+// those not ignored by the exclude patterns or a .csvqlignore and, when
+// include patterns are given, matching one of them. Include and exclude
+// patterns use .csvqlignore syntax relative to the root. A nil Filter
+// accepts everything.
+type Filter struct {
+	root    string
+	include []ignoreRule
+	exclude []ignoreRule
+
+	mu      sync.Mutex
+	ignores map[string][]ignoreRule // rules of the .csvqlignore of each directory
+}
+
+// NewFilter creates a Filter for a root directory
+func NewFilter(rootDir string, include, exclude []string) *Filter {
+	return &Filter{
+		root:    rootDir,
+		include: parseIgnoreRules(include),
+		exclude: parseIgnoreRules(exclude),
+		ignores: make(map[string][]ignoreRule),
+	}
+}
+
+// Reload forgets the .csvqlignore files read so far, so changes to them
+// take effect
+func (f *Filter) Reload() {
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	f.ignores = make(map[string][]ignoreRule)
+	f.mu.Unlock()
+}
+
+// rulesIn returns the rules of the .csvqlignore in a directory
+func (f *Filter) rulesIn(dir string) []ignoreRule {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if rules, ok := f.ignores[dir]; ok {
+		return rules
+	}
+	var rules []ignoreRule
+	if data, err := os.ReadFile(filepath.Join(dir, IgnoreFileName)); err == nil {
+		rules = parseIgnoreRules(strings.Split(string(data), "\n"))
+	}
+	f.ignores[dir] = rules
+	return rules
+}
+
+// ignored applies the exclude patterns, then the .csvqlignore of each
+// directory from the root down to the path's parent; the last matching
+// rule decides
+func (f *Filter) ignored(rel string, isDir bool) bool {
+	ignored := false
+	for _, r := range f.exclude {
+		if r.match(rel, isDir) {
+			ignored = !r.negate
+		}
+	}
+
+	dir := f.root
+	parts := strings.Split(rel, "/")
+	for i := range parts {
+		for _, r := range f.rulesIn(dir) {
+			if r.match(strings.Join(parts[i:], "/"), isDir) {
+				ignored = !r.negate
+			}
+		}
+		dir = filepath.Join(dir, parts[i])
+	}
+	return ignored
+}
+
+// relative returns a path relative to the root, slash-separated, or false
+// for the root itself and paths outside it
+func (f *Filter) relative(p string) (string, bool) {
+	rel, err := filepath.Rel(f.root, p)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return "", false
+	}
+	return filepath.ToSlash(rel), true
+}
+
+// SkipDir reports whether a directory, or one of its parents, is ignored;
+// skipped directories are neither scanned nor watched
+func (f *Filter) SkipDir(dir string) bool {
+	if f == nil {
+		return false
+	}
+	rel, ok := f.relative(dir)
+	if !ok {
+		return false
+	}
+	parts := strings.Split(rel, "/")
+	for i := range parts {
+		if f.ignored(strings.Join(parts[:i+1], "/"), true) {
+			return true
+		}
+	}
+	return false
+}
+
+// Skip reports whether a file is not loaded: it is in a skipped directory,
+// ignored, or not matched by the include patterns. Archives are containers,
+// so include patterns apply to their members rather than to them.
+func (f *Filter) Skip(file string) bool {
+	if f == nil {
+		return false
+	}
+	if archive, _, ok := SplitArchivePath(file); ok {
+		if f.Skip(archive) {
+			return true
+		}
+	} else {
+		if f.SkipDir(filepath.Dir(file)) {
+			return true
+		}
+		if rel, ok := f.relative(file); ok && f.ignored(rel, false) {
+			return true
+		}
+		if IsArchive(file) {
+			return false
+		}
+	}
+
+	if len(f.include) == 0 {
+		return false
+	}
+	rel, ok := f.relative(file)
+	if !ok {
+		return false
+	}
+	for _, r := range f.include {
+		if r.match(rel, false) {
+			return false
+		}
+	}
+	return true
+}
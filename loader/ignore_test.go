@@ -0,0 +1,80 @@
+package loader
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"*.csv", "a.csv", true},
+		{"data/*.csv", "data/a.csv", true},
+		{"data/*.csv", "data/sub/a.csv", false},
+		{"data/**/*.csv", "data/a.csv", true},
+		{"data/**/*.csv", "data/x/y/a.csv", true},
+		{"**/tmp", "a/b/tmp", true},
+		{"data/**", "data/a/b", true},
+		{"data/**", "other/a", false},
+	}
+	for _, tt := range tests {
+		if got := matchGlob(tt.pattern, tt.name); got != tt.want {
+			t.Errorf("matchGlob(%q, %q) = %v, want %v", tt.pattern, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestScanDirectoryFilter(t *testing.T) {
+	tmpDir := t.TempDir()
+	for _, name := range []string{
+		"a.csv", "scratch.csv", "keep.tmp.csv", "old.tmp.csv",
+		"node_modules/pkg/data.csv", ".git/x.csv",
+		"data/b.csv", "data/raw/c.csv", "data/raw/d.tsv", "data/build/e.csv",
+	} {
+		path := filepath.Join(tmpDir, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		os.WriteFile(path, []byte("id\n1\n"), 0644)
+	}
+	os.WriteFile(filepath.Join(tmpDir, IgnoreFileName), []byte("# vendored\nnode_modules/\n.git/\n/scratch.csv\n*.tmp.csv\n!keep.tmp.csv\n"), 0644)
+	os.WriteFile(filepath.Join(tmpDir, "data", IgnoreFileName), []byte("build/\n"), 0644)
+
+	scan := func(filter *Filter) []string {
+		t.Helper()
+		files, err := ScanDirectoryFilter(tmpDir, filter)
+		if err != nil {
+			t.Fatalf("ScanDirectoryFilter failed: %v", err)
+		}
+		var rel []string
+		for _, f := range files {
+			r, _ := filepath.Rel(tmpDir, f)
+			rel = append(rel, filepath.ToSlash(r))
+		}
+		sort.Strings(rel)
+		return rel
+	}
+
+	want := []string{"a.csv", "data/b.csv", "data/raw/c.csv", "data/raw/d.tsv", "keep.tmp.csv"}
+	if got := scan(NewFilter(tmpDir, nil, nil)); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+
+	filter := NewFilter(tmpDir, []string{"data/**/*.csv"}, []string{"raw/"})
+	if got := scan(filter); !reflect.DeepEqual(got, []string{"data/b.csv"}) {
+		t.Errorf("Expected only data/b.csv, got %v", got)
+	}
+	if !filter.SkipDir(filepath.Join(tmpDir, "node_modules", "pkg")) {
+		t.Error("Expected directories below an ignored one to be skipped")
+	}
+
+	// Edits to .csvqlignore apply after Reload
+	os.WriteFile(filepath.Join(tmpDir, "data", IgnoreFileName), []byte("b.csv\n"), 0644)
+	filter.Reload()
+	if got := scan(filter); !reflect.DeepEqual(got, []string{"data/build/e.csv"}) {
+		t.Errorf("Expected build/ no longer ignored and b.csv ignored, got %v", got)
+	}
+}
@@ -0,0 +1,228 @@
+package loader
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// jsonExtensions lists the file extensions loaded as JSON: a top-level array
+// of objects, or a stream of objects such as one per line (NDJSON)
+var jsonExtensions = map[string]bool{
+	".json":   true,
+	".ndjson": true,
+	".jsonl":  true,
+}
+
+// IsJSONFile reports whether a file is loaded as JSON rather than delimited text
+func IsJSONFile(path string) bool {
+	_, dataExt, _ := splitExtensions(path)
+	return jsonExtensions[dataExt]
+}
+
+// jsonField is a flattened column name and its value as text
+type jsonField struct {
+	key   string
+	value string
+}
+
+// jsonRecords decodes the records of a JSON file one at a time
+type jsonRecords struct {
+	dec     *json.Decoder
+	depth   int
+	inArray bool
+}
+
+// newJSONRecords starts decoding input, stepping into a top-level array
+func newJSONRecords(input *bufio.Reader, depth int) (*jsonRecords, error) {
+	j := &jsonRecords{dec: json.NewDecoder(input), depth: depth}
+
+	// Skip leading white space to see whether the records are wrapped in an array
+	for {
+		b, err := input.Peek(1)
+		if err != nil || !isJSONSpace(b[0]) {
+			break
+		}
+		input.ReadByte()
+	}
+	if b, err := input.Peek(1); err == nil && b[0] == '[' {
+		if _, err := j.dec.Token(); err != nil {
+			return nil, err
+		}
+		j.inArray = true
+	}
+	return j, nil
+}
+
+func isJSONSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\r'
+}
+
+// next returns the flattened fields of the next record, or io.EOF
+func (j *jsonRecords) next() ([]jsonField, error) {
+	if j.inArray && !j.dec.More() {
+		return nil, io.EOF
+	}
+
+	var raw json.RawMessage
+	if err := j.dec.Decode(&raw); err != nil {
+		return nil, err
+	}
+
+	// Records that are not objects become a single value column
+	if len(raw) == 0 || raw[0] != '{' {
+		value, err := jsonText(raw)
+		if err != nil {
+			return nil, err
+		}
+		return []jsonField{{"value", value}}, nil
+	}
+
+	var fields []jsonField
+	if err := j.flatten(raw, "", 1, &fields); err != nil {
+		return nil, err
+	}
+
+	// Keys flattening to the same name, such as a.b and a_b, both keep their
+	// value: later ones get _1, _2, ... like repeated CSV headers
+	seen := make(map[string]bool, len(fields))
+	for i := range fields {
+		base := fields[i].key
+		for n := 1; seen[fields[i].key]; n++ {
+			fields[i].key = fmt.Sprintf("%s_%d", base, n)
+		}
+		seen[fields[i].key] = true
+	}
+	return fields, nil
+}
+
+// flatten appends the members of a JSON object in document order. Nested
+// objects become parent_child columns down to the configured depth, below
+// which they are kept as JSON text like arrays.
+func (j *jsonRecords) flatten(object json.RawMessage, prefix string, level int, fields *[]jsonField) error {
+	dec := json.NewDecoder(bytes.NewReader(object))
+	if _, err := dec.Token(); err != nil {
+		return err
+	}
+
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		key := prefix + tok.(string)
+
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return err
+		}
+
+		if value[0] == '{' && (j.depth <= 0 || level < j.depth) {
+			if err := j.flatten(value, key+"_", level+1, fields); err != nil {
+				return err
+			}
+			continue
+		}
+
+		text, err := jsonText(value)
+		if err != nil {
+			return err
+		}
+		*fields = append(*fields, jsonField{key, text})
+	}
+	return nil
+}
+
+// jsonText converts a JSON value to the text stored in its column: strings
+// unquoted, null empty (so it loads as NULL), numbers and booleans as
+// written, and arrays and objects as compact JSON for use with json_each
+func jsonText(value json.RawMessage) (string, error) {
+	switch value[0] {
+	case '"':
+		var s string
+		err := json.Unmarshal(value, &s)
+		return s, err
+	case 'n':
+		return "", nil
+	case '[', '{':
+		var buf bytes.Buffer
+		err := json.Compact(&buf, value)
+		return buf.String(), err
+	}
+	return string(value), nil
+}
+
+// openJSON reads a JSON file in two passes: the first collects the union of
+// the flattened keys of all records, in order of first appearance, as the
+// header; the second, from a reopened stream, feeds ReadBatch.
+func (r *Reader) openJSON(input *bufio.Reader, cfg FileConfig) error {
+	filePath := r.Info.Path
+
+	records, err := newJSONRecords(input, cfg.FlattenDepth)
+	if err != nil {
+		return fmt.Errorf("failed to parse file %s: %w", filePath, err)
+	}
+
+	var headers []string
+	index := make(map[string]int)
+	count := 0
+	for {
+		fields, err := records.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to parse file %s: %w", filePath, err)
+		}
+		for _, f := range fields {
+			if _, ok := index[f.key]; !ok {
+				index[f.key] = len(headers)
+				headers = append(headers, f.key)
+			}
+		}
+		count++
+	}
+	if count == 0 || len(headers) == 0 {
+		return fmt.Errorf("%w: %s", ErrEmptyFile, filePath)
+	}
+
+	// Second pass
+	second, input, err := openStream(filePath, cfg)
+	if err != nil {
+		return err
+	}
+	r.Close()
+	r.file, r.decompressor, r.counter = second.file, second.decompressor, second.counter
+
+	records, err = newJSONRecords(input, cfg.FlattenDepth)
+	if err != nil {
+		return fmt.Errorf("failed to parse file %s: %w", filePath, err)
+	}
+	r.next = func() ([]string, error) {
+		fields, err := records.next()
+		if err != nil {
+			return nil, err
+		}
+		record := make([]string, len(headers))
+		for _, f := range fields {
+			if i, ok := index[f.key]; ok {
+				record[i] = f.value
+			}
+		}
+		return record, nil
+	}
+	r.offset = records.dec.InputOffset
+
+	sample, err := r.sample()
+	if err != nil {
+		return err
+	}
+
+	r.Info.HasHeader = true
+	r.Info.Headers = headers
+	r.Info.ColumnTypes = InferColumnTypes(len(headers), sample)
+	r.pending = sample
+	return nil
+}
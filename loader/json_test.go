@@ -0,0 +1,123 @@
+package loader
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseFile_JSONArray(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "users.json")
+	content := `[
+		{"id": 1, "name": "Alice", "address": {"city": "Rome", "geo": {"lat": 41.9}}, "tags": ["a", "b"]},
+		{"id": 2, "name": null, "active": true, "address": {"city": "Milan"}}
+	]`
+	os.WriteFile(path, []byte(content), 0644)
+
+	parsed, err := ParseFile(path, tmpDir)
+	if err != nil {
+		t.Fatalf("ParseFile failed: %v", err)
+	}
+
+	expected := []string{"id", "name", "address_city", "address_geo_lat", "tags", "active"}
+	if len(parsed.Info.Headers) != len(expected) {
+		t.Fatalf("Expected headers %v, got %v", expected, parsed.Info.Headers)
+	}
+	for i := range expected {
+		if parsed.Info.Headers[i] != expected[i] {
+			t.Errorf("Expected headers %v, got %v", expected, parsed.Info.Headers)
+			break
+		}
+	}
+
+	if parsed.Info.TableName != "users" {
+		t.Errorf("Expected table users, got %q", parsed.Info.TableName)
+	}
+	if parsed.Info.ColumnTypes[0] != TypeInteger || parsed.Info.ColumnTypes[5] != TypeBoolean {
+		t.Errorf("Unexpected column types: %v", parsed.Info.ColumnTypes)
+	}
+
+	first, second := parsed.Records[0], parsed.Records[1]
+	if first[3] != "41.9" || first[4] != `["a","b"]` || first[5] != "" {
+		t.Errorf("Unexpected first record: %q", first)
+	}
+	if second[1] != "" || second[2] != "Milan" || second[5] != "true" {
+		t.Errorf("Unexpected second record: %q", second)
+	}
+}
+
+func TestParseFile_NDJSON(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "events.ndjson")
+	os.WriteFile(path, []byte("{\"level\":\"info\",\"msg\":\"start\"}\n\n{\"level\":\"error\",\"code\":500}\n"), 0644)
+
+	parsed, err := ParseFile(path, tmpDir)
+	if err != nil {
+		t.Fatalf("ParseFile failed: %v", err)
+	}
+	if len(parsed.Info.Headers) != 3 || parsed.Info.Headers[2] != "code" {
+		t.Errorf("Unexpected headers: %v", parsed.Info.Headers)
+	}
+	if len(parsed.Records) != 2 || parsed.Records[1][2] != "500" {
+		t.Errorf("Unexpected records: %q", parsed.Records)
+	}
+}
+
+func TestOpenFileConfig_FlattenDepth(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "nested.jsonl")
+	os.WriteFile(path, []byte(`{"a": {"b": {"c": 1}}}`), 0644)
+
+	reader, err := OpenFileConfig(path, tmpDir, FileConfig{FlattenDepth: 2})
+	if err != nil {
+		t.Fatalf("OpenFileConfig failed: %v", err)
+	}
+	defer reader.Close()
+
+	batch, _ := reader.ReadBatch()
+	if len(reader.Info.Headers) != 1 || reader.Info.Headers[0] != "a_b" || batch[0][0] != `{"c":1}` {
+		t.Errorf("Expected a_b holding {\"c\":1}, got %v %q", reader.Info.Headers, batch)
+	}
+}
+
+func TestParseFile_JSONKeyCollision(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "clash.ndjson")
+	os.WriteFile(path, []byte("{\"a\":{\"b\":1},\"a_b\":2}\n{\"a_b\":3}\n"), 0644)
+
+	parsed, err := ParseFile(path, tmpDir)
+	if err != nil {
+		t.Fatalf("ParseFile failed: %v", err)
+	}
+	if len(parsed.Info.Headers) != 2 || parsed.Info.Headers[0] != "a_b" || parsed.Info.Headers[1] != "a_b_1" {
+		t.Fatalf("Expected headers [a_b a_b_1], got %v", parsed.Info.Headers)
+	}
+	if first := parsed.Records[0]; first[0] != "1" || first[1] != "2" {
+		t.Errorf("Expected both values kept, got %q", first)
+	}
+	if second := parsed.Records[1]; second[0] != "3" || second[1] != "" {
+		t.Errorf("Unexpected second record: %q", second)
+	}
+}
+
+func TestOpenFile_InvalidJSON(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	for name, content := range map[string]string{
+		"broken.json":  `[{"id": 1},`,
+		"empty.json":   `[]`,
+		"empty.ndjson": "\n",
+	} {
+		path := filepath.Join(tmpDir, name)
+		os.WriteFile(path, []byte(content), 0644)
+		_, err := OpenFile(path, tmpDir)
+		if err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+		if empty := name != "broken.json"; errors.Is(err, ErrEmptyFile) != empty {
+			t.Errorf("%s: expected ErrEmptyFile %v, got %v", name, empty, err)
+		}
+	}
+}
@@ -2,30 +2,60 @@
 package loader
 
 import (
-	"encoding/csv"
 	"fmt"
+	"io"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
 // FileInfo represents metadata about a CSV/TSV file
 type FileInfo struct {
-	Path      string
-	TableName string
-	Delimiter rune
-	Headers   []string
-	ModTime   int64
+	Path        string
+	TableName   string
+	Delimiter   rune
+	Quote       rune
+	HasHeader   bool
+	Encoding    string // encoding of the file; records are always UTF-8
+	Headers     []string
+	ColumnTypes []ColumnType
+	ModTime     int64
 }
 
 // ParsedFile contains all data from a parsed CSV/TSV file
 type ParsedFile struct {
-	Info    FileInfo
-	Records [][]string
+	Info      FileInfo
+	Records   [][]string
+	RowErrors []RowError // malformed rows left out of Records
 }
 
-// ScanDirectory finds all CSV and TSV files in directory and subdirectories
+// supportedExtensions lists the delimited text extensions loaded as tables;
+// see also jsonExtensions and parquetExtension
+var supportedExtensions = map[string]bool{
+	".csv": true,
+	".tsv": true,
+	".psv": true,
+}
+
+// IsSupportedFile reports whether a file has an extension csvql loads,
+// optionally followed by a compression extension such as .gz
+func IsSupportedFile(path string) bool {
+	_, dataExt, _ := splitExtensions(path)
+	return supportedExtensions[dataExt] || jsonExtensions[dataExt] || dataExt == parquetExtension
+}
+
+// ScanDirectory finds all CSV and TSV files, plain or compressed, in directory and subdirectories,
+// leaving out the paths ignored by .csvqlignore files
 func ScanDirectory(rootDir string) ([]string, error) {
+	return ScanDirectoryFilter(rootDir, NewFilter(rootDir, nil, nil))
+}
+
+// ScanDirectoryFilter is ScanDirectory with include and exclude patterns;
+// directories the filter skips are not walked at all
+func ScanDirectoryFilter(rootDir string, filter *Filter) ([]string, error) {
 	var files []string
 
 	err := filepath.Walk(rootDir, func(path string, info os.FileInfo, err error) error {
@@ -33,13 +63,32 @@ func ScanDirectory(rootDir string) ([]string, error) {
 			return err
 		}
 		if info.IsDir() {
+			if filter.SkipDir(path) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if filter.Skip(path) {
 			return nil
 		}
 
-		ext := strings.ToLower(filepath.Ext(path))
-		if ext == ".csv" || ext == ".tsv" {
+		if IsSupportedFile(path) || IsFixedWidthFile(path) {
 			files = append(files, path)
 		}
+
+		// Archives are virtual directories of member files
+		if IsArchive(path) {
+			members, err := ListArchive(path)
+			if err != nil {
+				fmt.Printf("Warning: skipping archive %s: %v\n", path, err)
+				return nil
+			}
+			for _, member := range members {
+				if !filter.Skip(member) {
+					files = append(files, member)
+				}
+			}
+		}
 		return nil
 	})
 
@@ -48,26 +97,71 @@ func ScanDirectory(rootDir string) ([]string, error) {
 
 // sanitizeTableName applies SQLite naming rules to a name
 func sanitizeTableName(name string) string {
-	// Replace path separators and invalid chars with underscores
-	name = strings.ReplaceAll(name, string(filepath.Separator), "_")
-	name = strings.ReplaceAll(name, "-", "_")
-	name = strings.ReplaceAll(name, " ", "_")
-	name = strings.ReplaceAll(name, ".", "_")
+	return sanitizeIdentifier(name, "table")
+}
+
+// sanitizeIdentifier turns an arbitrary string into a stable identifier made of
+// lower-case Unicode letters, digits and underscores. Runs of any other
+// characters (separators, punctuation, symbols, spaces) collapse into a single
+// underscore and are dropped at either end. Names starting with a digit get a
+// leading underscore; names with nothing left become fallback.
+func sanitizeIdentifier(name, fallback string) string {
+	var b strings.Builder
+	pendingSep := false
+	for _, r := range name {
+		if r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if pendingSep && b.Len() > 0 {
+				b.WriteByte('_')
+			}
+			pendingSep = false
+			b.WriteRune(unicode.ToLower(r))
+			continue
+		}
+		pendingSep = true
+	}
+
+	sanitized := b.String()
+	if sanitized == "" {
+		return fallback
+	}
 
 	// Ensure it starts with a letter or underscore
-	if len(name) > 0 && name[0] >= '0' && name[0] <= '9' {
-		name = "_" + name
+	if first, _ := utf8.DecodeRuneInString(sanitized); unicode.IsDigit(first) {
+		sanitized = "_" + sanitized
+	}
+
+	return sanitized
+}
+
+// MatchPath reports whether a file matches a pattern given as a path
+// relative to rootDir or a glob matched against that relative path or the
+// file name alone
+func MatchPath(pattern, filePath, rootDir string) bool {
+	rel, err := filepath.Rel(rootDir, filePath)
+	if err != nil {
+		rel = filePath
 	}
+	rel = filepath.ToSlash(rel)
+	pattern = filepath.ToSlash(pattern)
 
-	return strings.ToLower(name)
+	if pattern == rel {
+		return true
+	}
+	if ok, _ := path.Match(pattern, rel); ok {
+		return true
+	}
+	ok, _ := path.Match(pattern, path.Base(rel))
+	return ok
 }
 
-// GetBaseTableName generates a table name using only the file name (without path)
+// GetBaseTableName generates a table name using only the file name (without path).
+// Archive members are prefixed with the archive name: bundle.zip/orders.csv
+// becomes bundle_orders, and sheet Q1 of book.xlsx becomes book_q1.
 func GetBaseTableName(filePath string) string {
-	base := filepath.Base(filePath)
-	ext := filepath.Ext(base)
-	name := strings.TrimSuffix(base, ext)
-	return sanitizeTableName(name)
+	if archive, member, ok := SplitArchivePath(filePath); ok {
+		return sanitizeTableName(trimArchiveExtension(filepath.Base(archive)) + "_" + trimMemberExtensions(archive, path.Base(member)))
+	}
+	return sanitizeTableName(trimExtensions(filepath.Base(filePath)))
 }
 
 // GetFullTableName generates a table name including the relative path
@@ -78,11 +172,13 @@ func GetFullTableName(filePath, rootDir string) string {
 		relPath = filepath.Base(filePath)
 	}
 
-	// Remove extension
-	ext := filepath.Ext(relPath)
-	name := strings.TrimSuffix(relPath, ext)
+	// Archive members keep the archive path, without its extension, as prefix
+	if archive, member, ok := SplitArchivePath(relPath); ok {
+		return sanitizeTableName(filepath.Join(trimArchiveExtension(archive), trimMemberExtensions(archive, member)))
+	}
 
-	return sanitizeTableName(name)
+	// Remove extensions, including a compression one
+	return sanitizeTableName(trimExtensions(relPath))
 }
 
 // GetTableName generates a valid SQLite table name from file path
@@ -92,22 +188,70 @@ func GetTableName(filePath, rootDir string) string {
 }
 
 // ResolveTableNames takes a list of file paths and returns a map of path -> table name
-// Uses base name only when unique, full path when there are conflicts
+// Uses the name set in a file's sidecar when no other sidecar sets it, else base
+// name only when unique, full path when there are conflicts, including with a
+// sidecar name
 func ResolveTableNames(filePaths []string, rootDir string) map[string]string {
+	return resolveTableNames(filePaths, rootDir, nil)
+}
+
+// resolveTableNames is ResolveTableNames with names fixed beforehand, such
+// as the table names of union rules, keyed like the result. Files conflict
+// with them as with sidecar names.
+func resolveTableNames(filePaths []string, rootDir string, fixed map[string]string) map[string]string {
+	result := make(map[string]string)
+	configured := make(map[string]bool)
+	for key, name := range fixed {
+		result[key] = name
+		configured[name] = true
+	}
+
+	// Names set by sidecars come first; a bad sidecar is reported on load.
+	// Files given the same name, or a fixed one, take their full path name.
+	sidecarNames := make(map[string]string)
+	owners := make(map[string]int)
+	for _, path := range filePaths {
+		if cfg, err := ReadSidecar(path); err == nil && cfg.TableName != "" {
+			sidecarNames[path] = sanitizeTableName(cfg.TableName)
+			owners[sidecarNames[path]]++
+		}
+	}
+	for path, name := range sidecarNames {
+		if owners[name] > 1 || configured[name] {
+			result[path] = GetFullTableName(path, rootDir)
+		} else {
+			result[path] = name
+		}
+	}
+	for name := range owners {
+		configured[name] = true
+	}
+
 	// First pass: count base names
 	baseNameCount := make(map[string][]string)
 	for _, path := range filePaths {
+		if _, ok := result[path]; ok {
+			continue
+		}
 		baseName := GetBaseTableName(path)
 		baseNameCount[baseName] = append(baseNameCount[baseName], path)
 	}
 
 	// Second pass: assign names based on conflicts
-	result := make(map[string]string)
 	for _, path := range filePaths {
+		if _, ok := result[path]; ok {
+			continue
+		}
 		baseName := GetBaseTableName(path)
-		if len(baseNameCount[baseName]) > 1 {
+		if len(baseNameCount[baseName]) > 1 || configured[baseName] {
 			// Conflict: use full path name
 			result[path] = GetFullTableName(path, rootDir)
+			if configured[result[path]] {
+				// A file at the root has no directory to tell it apart; keep its extension
+				if rel, err := filepath.Rel(rootDir, path); err == nil {
+					result[path] = sanitizeTableName(rel)
+				}
+			}
 		} else {
 			// No conflict: use base name only
 			result[path] = baseName
@@ -117,80 +261,68 @@ func ResolveTableNames(filePaths []string, rootDir string) map[string]string {
 	return result
 }
 
-// DetectDelimiter determines if file is CSV or TSV based on extension
+// DetectDelimiter returns the conventional delimiter for the file extension,
+// looking past a compression extension. It is the default for SniffDialect,
+// which may pick another one.
 func DetectDelimiter(filePath string) rune {
-	ext := strings.ToLower(filepath.Ext(filePath))
-	if ext == ".tsv" {
+	_, dataExt, _ := splitExtensions(filePath)
+	switch dataExt {
+	case ".tsv":
 		return '\t'
+	case ".psv":
+		return '|'
 	}
 	return ','
 }
 
-// ParseFile reads and parses a CSV/TSV file
+// ParseFile reads and parses a CSV/TSV file, keeping every record in memory.
+// Use OpenFile to stream large files instead.
+// Parsing options and the table name may be set in the file's sidecars.
 // tableName is optional - if empty, uses GetFullTableName for backwards compatibility
 func ParseFile(filePath, rootDir string, tableName ...string) (*ParsedFile, error) {
-	file, err := os.Open(filePath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open file %s: %w", filePath, err)
-	}
-	defer file.Close()
-
-	stat, err := file.Stat()
-	if err != nil {
-		return nil, fmt.Errorf("failed to stat file %s: %w", filePath, err)
-	}
-
-	delimiter := DetectDelimiter(filePath)
-
-	reader := csv.NewReader(file)
-	reader.Comma = delimiter
-	reader.LazyQuotes = true
-	reader.TrimLeadingSpace = true
-
-	records, err := reader.ReadAll()
+	reader, err := OpenFile(filePath, rootDir, tableName...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse file %s: %w", filePath, err)
-	}
-
-	if len(records) == 0 {
-		return nil, fmt.Errorf("file %s is empty", filePath)
+		return nil, err
 	}
+	defer reader.Close()
 
-	// Use provided table name or fall back to full path name
-	resolvedTableName := GetFullTableName(filePath, rootDir)
-	if len(tableName) > 0 && tableName[0] != "" {
-		resolvedTableName = tableName[0]
+	var records [][]string
+	for {
+		batch, err := reader.ReadBatch()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, batch...)
 	}
 
 	return &ParsedFile{
-		Info: FileInfo{
-			Path:      filePath,
-			TableName: resolvedTableName,
-			Delimiter: delimiter,
-			Headers:   records[0],
-			ModTime:   stat.ModTime().UnixNano(),
-		},
-		Records: records[1:], // Exclude headers
+		Info:      reader.Info,
+		Records:   records,
+		RowErrors: reader.TakeRowErrors(),
 	}, nil
 }
 
 // SanitizeColumnName creates a valid SQLite column name
 func SanitizeColumnName(name string) string {
-	// Replace invalid characters
-	sanitized := strings.ReplaceAll(name, " ", "_")
-	sanitized = strings.ReplaceAll(sanitized, "-", "_")
-	sanitized = strings.ReplaceAll(sanitized, ".", "_")
-	sanitized = strings.ReplaceAll(sanitized, "(", "")
-	sanitized = strings.ReplaceAll(sanitized, ")", "")
-
-	// Ensure it starts with a letter or underscore
-	if len(sanitized) > 0 && sanitized[0] >= '0' && sanitized[0] <= '9' {
-		sanitized = "_" + sanitized
-	}
+	return sanitizeIdentifier(name, "column")
+}
 
-	if sanitized == "" {
-		sanitized = "column"
+// SanitizeColumnNames sanitizes a header row and makes the resulting names
+// unique (case-insensitively, as SQLite compares them) by appending _1, _2, ...
+func SanitizeColumnNames(headers []string) []string {
+	names := make([]string, len(headers))
+	taken := make(map[string]bool, len(headers))
+	for i, header := range headers {
+		baseName := SanitizeColumnName(header)
+		colName := baseName
+		for counter := 1; taken[strings.ToLower(colName)]; counter++ {
+			colName = fmt.Sprintf("%s_%d", baseName, counter)
+		}
+		taken[strings.ToLower(colName)] = true
+		names[i] = colName
 	}
-
-	return strings.ToLower(sanitized)
+	return names
 }
@@ -79,6 +79,7 @@ func TestDetectDelimiter(t *testing.T) {
 		{"file.TSV", '\t'},
 		{"path/to/file.csv", ','},
 		{"path/to/file.tsv", '\t'},
+		{"export.psv", '|'},
 	}
 
 	for _, tt := range tests {
@@ -103,6 +104,15 @@ func TestSanitizeColumnName(t *testing.T) {
 		{"123col", "_123col"},
 		{"", "column"},
 		{"UPPER_CASE", "upper_case"},
+		{"order", "order"},
+		{"a&b", "a_b"},
+		{"price$", "price"},
+		{"  Net -- Total  ", "net_total"},
+		{"_id", "_id"},
+		{"Città", "città"},
+		{"Größe (cm)", "größe_cm"},
+		{"$$$", "column"},
+		{"١٢٣", "_١٢٣"},
 	}
 
 	for _, tt := range tests {
@@ -114,6 +124,18 @@ func TestSanitizeColumnName(t *testing.T) {
 	}
 }
 
+func TestSanitizeColumnNames(t *testing.T) {
+	headers := []string{"name", "Name", "name_1", "a&b", "a b", ""}
+	expected := []string{"name", "name_1", "name_1_1", "a_b", "a_b_1", "column"}
+
+	result := SanitizeColumnNames(headers)
+	for i, want := range expected {
+		if result[i] != want {
+			t.Errorf("SanitizeColumnNames()[%d] = %q, want %q", i, result[i], want)
+		}
+	}
+}
+
 func TestParseFile_CSV(t *testing.T) {
 	tmpDir := t.TempDir()
 	csvPath := filepath.Join(tmpDir, "test.csv")
@@ -238,3 +260,26 @@ func TestParseFile_NonExistent(t *testing.T) {
 		t.Error("Expected error for non-existent file, got nil")
 	}
 }
+
+func TestMatchPath(t *testing.T) {
+	root := filepath.Join("data")
+	file := filepath.Join("data", "legacy", "orders.csv")
+
+	tests := []struct {
+		pattern  string
+		expected bool
+	}{
+		{"legacy/orders.csv", true},
+		{"legacy/*.csv", true},
+		{"*.csv", true},
+		{"orders.csv", true},
+		{"*.tsv", false},
+		{"other/*.csv", false},
+	}
+
+	for _, tt := range tests {
+		if got := MatchPath(tt.pattern, file, root); got != tt.expected {
+			t.Errorf("MatchPath(%q) = %v, want %v", tt.pattern, got, tt.expected)
+		}
+	}
+}
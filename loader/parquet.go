@@ -0,0 +1,151 @@
+package loader
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"csvql/parquet"
+)
+
+// parquetExtension marks Parquet files, which are loaded with the column
+// types of their schema instead of inferred ones
+const parquetExtension = ".parquet"
+
+// IsParquetFile reports whether a file is loaded as Parquet
+func IsParquetFile(path string) bool {
+	_, dataExt, _ := splitExtensions(path)
+	return dataExt == parquetExtension
+}
+
+// parquetColumnTypes maps Parquet column kinds to SQLite column types
+var parquetColumnTypes = map[parquet.Kind]ColumnType{
+	parquet.Boolean:   TypeBoolean,
+	parquet.Int64:     TypeInteger,
+	parquet.Double:    TypeReal,
+	parquet.Decimal:   TypeReal,
+	parquet.Date:      TypeDate,
+	parquet.Timestamp: TypeDatetime,
+}
+
+// openParquetStream opens a Parquet file for reading. Parquet needs random
+// access, so archive members and compressed files are read into memory.
+func openParquetStream(filePath string) (*Reader, *parquet.Reader, error) {
+	var file io.ReadCloser
+	var data io.ReaderAt
+	var size, modTime int64
+
+	_, _, compressionExt := splitExtensions(filePath)
+	if _, _, ok := SplitArchivePath(filePath); ok || compressionExt != "" {
+		source, _, mtime, err := openSource(filePath)
+		if err != nil {
+			return nil, nil, err
+		}
+		decompressed, err := newDecompressor(source, compressionExt)
+		if err != nil {
+			source.Close()
+			return nil, nil, fmt.Errorf("failed to decompress file %s: %w", filePath, err)
+		}
+		content, err := io.ReadAll(decompressed)
+		decompressed.Close()
+		source.Close()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read file %s: %w", filePath, err)
+		}
+		file, data, size, modTime = io.NopCloser(nil), bytes.NewReader(content), int64(len(content)), mtime
+	} else {
+		f, err := os.Open(filePath)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open file %s: %w", filePath, err)
+		}
+		stat, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, nil, fmt.Errorf("failed to stat file %s: %w", filePath, err)
+		}
+		file, data, size, modTime = f, f, stat.Size(), stat.ModTime().UnixNano()
+	}
+
+	pr, err := parquet.NewReader(data, size)
+	if err != nil {
+		file.Close()
+		return nil, nil, fmt.Errorf("failed to parse file %s: %w", filePath, err)
+	}
+
+	r := &Reader{file: file, size: size, offset: pr.Offset}
+	r.Info.Path = filePath
+	r.Info.ModTime = modTime
+	return r, pr, nil
+}
+
+// openParquet takes the header and column types from the Parquet schema.
+// Nested and repeated columns are left out with a warning.
+func (r *Reader) openParquet(pr *parquet.Reader) error {
+	if skipped := pr.Skipped(); len(skipped) > 0 {
+		fmt.Printf("Warning: skipping nested or repeated columns of %s: %s\n", r.Info.Path, strings.Join(skipped, ", "))
+	}
+	columns := pr.Columns()
+	if len(columns) == 0 {
+		return fmt.Errorf("file %s has no columns", r.Info.Path)
+	}
+
+	r.Info.HasHeader = true
+	r.Info.Headers = make([]string, len(columns))
+	r.Info.ColumnTypes = make([]ColumnType, len(columns))
+	for i, c := range columns {
+		r.Info.Headers[i] = c.Name
+		r.Info.ColumnTypes[i] = TypeText
+		if t, ok := parquetColumnTypes[c.Kind]; ok {
+			r.Info.ColumnTypes[i] = t
+		}
+	}
+
+	r.next = func() ([]string, error) {
+		values, err := pr.Next()
+		if err != nil {
+			return nil, err
+		}
+		record := make([]string, len(values))
+		for i, v := range values {
+			record[i] = parquetText(v, columns[i].Kind)
+		}
+		return record, nil
+	}
+	return nil
+}
+
+// parquetText converts a Parquet value to the text ConvertValue stores for
+// its column type. Binary values that are not UTF-8 are hex-encoded.
+func parquetText(v interface{}, kind parquet.Kind) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case bool:
+		return strconv.FormatBool(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case uint64:
+		return strconv.FormatUint(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case string:
+		return v
+	case []byte:
+		if utf8.Valid(v) {
+			return string(v)
+		}
+		return hex.EncodeToString(v)
+	case time.Time:
+		if kind == parquet.Date {
+			return v.Format(dateLayout)
+		}
+		return v.Format(datetimeStoreLayout)
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,75 @@
+package loader
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"csvql/parquet"
+)
+
+// writeParquet creates a Parquet file with the given columns and rows
+func writeParquet(t *testing.T, path string, columns []parquet.Column, rows [][]interface{}) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	w, err := parquet.NewWriter(f, columns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range rows {
+		if err := w.Write(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOpenFile_Parquet(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "events.parquet")
+	writeParquet(t, path, []parquet.Column{
+		{Name: "id", Kind: parquet.Int64},
+		{Name: "code", Kind: parquet.String, Optional: true},
+		{Name: "day", Kind: parquet.Date, Optional: true},
+		{Name: "at", Kind: parquet.Timestamp, Optional: true},
+	}, [][]interface{}{
+		{int64(1), "007", time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC), time.Date(2024, 3, 15, 10, 30, 0, 0, time.UTC)},
+		{int64(2), nil, nil, nil},
+	})
+
+	if !IsSupportedFile(path) {
+		t.Fatal("Expected .parquet to be supported")
+	}
+
+	r, err := OpenFile(path, tmpDir)
+	if err != nil {
+		t.Fatalf("OpenFile failed: %v", err)
+	}
+	defer r.Close()
+
+	if r.Info.TableName != "events" {
+		t.Errorf("Expected table events, got %s", r.Info.TableName)
+	}
+	// Types come from the schema: "007" stays TEXT without inference
+	want := []ColumnType{TypeInteger, TypeText, TypeDate, TypeDatetime}
+	if !reflect.DeepEqual(r.Info.ColumnTypes, want) {
+		t.Errorf("Expected types %v, got %v", want, r.Info.ColumnTypes)
+	}
+
+	batch, err := r.ReadBatch()
+	if err != nil {
+		t.Fatalf("ReadBatch failed: %v", err)
+	}
+	rows := [][]string{{"1", "007", "2024-03-15", "2024-03-15 10:30:00"}, {"2", "", "", ""}}
+	if !reflect.DeepEqual(batch, rows) {
+		t.Errorf("Expected %v, got %v", rows, batch)
+	}
+}
@@ -0,0 +1,90 @@
+package loader
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// RowError is a malformed row of delimited text that was left out of its
+// table instead of failing the whole file
+type RowError struct {
+	Line   int64  // line the row starts on, counting from 1
+	Raw    string // text of the row as it appears in the file
+	Reason string
+}
+
+// TakeRowErrors returns the malformed rows set aside since the last call
+func (r *Reader) TakeRowErrors() []RowError {
+	rowErrors := r.rowErrors
+	r.rowErrors = nil
+	return rowErrors
+}
+
+// BadRows returns the number of malformed rows set aside so far
+func (r *Reader) BadRows() int64 {
+	return r.badRows
+}
+
+// quarantine sets a malformed row aside
+func (r *Reader) quarantine(rowErr RowError) {
+	r.badRows++
+	r.rowErrors = append(r.rowErrors, rowErr)
+}
+
+// rowError describes a parse error of encoding/csv as a RowError, or
+// reports false for errors that are not about a single row
+func rowError(err error, record []string, fieldsPerRecord int, firstLine int64, raw string) (RowError, bool) {
+	var parseErr *csv.ParseError
+	if !errors.As(err, &parseErr) {
+		return RowError{}, false
+	}
+	reason := parseErr.Err.Error()
+	if errors.Is(parseErr.Err, csv.ErrFieldCount) {
+		reason = fmt.Sprintf("expected %d fields, got %d", fieldsPerRecord, len(record))
+	}
+	return RowError{
+		Line:   firstLine + int64(parseErr.StartLine) - 1,
+		Raw:    strings.TrimRight(raw, "\r\n"),
+		Reason: reason,
+	}, true
+}
+
+// captureReader keeps the text read through it from a given offset on, so
+// the raw text of a malformed record can be recovered from the offsets
+// encoding/csv reports
+type captureReader struct {
+	r    io.Reader
+	buf  []byte
+	base int64 // offset of buf[0]
+}
+
+func (c *captureReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.buf = append(c.buf, p[:n]...)
+	return n, err
+}
+
+// text returns the text between two offsets not yet discarded
+func (c *captureReader) text(start, end int64) string {
+	start = max(start, c.base)
+	end = min(end, c.base+int64(len(c.buf)))
+	if start >= end {
+		return ""
+	}
+	return string(c.buf[start-c.base : end-c.base])
+}
+
+// discard forgets the text before an offset. The kept text is moved to the
+// front of buf only once most of buf is discarded, so that discarding after
+// every record does not copy the read-ahead buffer each time.
+func (c *captureReader) discard(offset int64) {
+	n := int(min(offset-c.base, int64(len(c.buf))))
+	if n <= 0 || n < len(c.buf)/2 {
+		return
+	}
+	c.buf = append(c.buf[:0], c.buf[n:]...)
+	c.base += int64(n)
+}
@@ -0,0 +1,533 @@
+package loader
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"csvql/parquet"
+)
+
+// ErrEmptyFile is returned when opening a file without any record, not
+// even a header
+var ErrEmptyFile = errors.New("file is empty")
+
+// DefaultBatchSize is the number of records returned by each ReadBatch call
+const DefaultBatchSize = 10000
+
+// Progress reports how far a streaming read has got through a file
+type Progress struct {
+	Path  string
+	Rows  int64 // data rows read so far
+	Bytes int64 // bytes consumed from the file so far
+	Total int64 // file size in bytes
+	Done  bool  // set on the final report, after the last batch
+}
+
+// ProgressFunc receives progress reports while a file is read
+type ProgressFunc func(Progress)
+
+// Reader streams the records of a CSV/TSV or JSON file in batches, so memory use is
+// bounded by the batch size rather than the file size. The header and a
+// sample of the first records are read by OpenFile to fill in Info.
+type Reader struct {
+	Info      FileInfo
+	BatchSize int
+
+	file         io.ReadCloser
+	next         func() ([]string, error) // returns the next record, io.EOF at the end
+	offset       func() int64             // bytes of UTF-8 input consumed, for progress
+	counter      *countingReader          // file byte count, set when decompressing or transcoding
+	decompressor io.ReadCloser
+	size         int64
+	pending      [][]string // sampled records not yet returned
+	rows         int64
+	done         bool
+	nulls        map[string]bool // values read as NULL
+	rowErrors    []RowError      // malformed rows not yet taken by TakeRowErrors
+	badRows      int64
+	onProgress   ProgressFunc
+}
+
+// FileConfig overrides what OpenFile would otherwise detect for a file.
+// Zero values mean auto-detect.
+type FileConfig struct {
+	Encoding     string   // character encoding, see NormalizeEncoding
+	FlattenDepth int      // JSON object levels flattened into parent_child columns; 0 = all
+	HasHeader    *bool    // whether delimited text has a header row; nil = detect
+	Delimiter    rune     // field delimiter of delimited text; 0 = sniff
+	SkipLines    int      // preamble lines skipped before the header
+	Comment      rune     // lines starting with it are skipped; 0 = none
+	NullValues   []string // values loaded as NULL, besides the empty string
+	TableName    string   // table name, used when none is passed to OpenFile
+	Columns      []string // column names, replacing the header row or generated c1..cN names
+	Strict       bool     // fail on a malformed row instead of setting it aside
+	TextOnly     bool     // load every column as TEXT instead of inferring types
+}
+
+// withDefaults fills the options left unset in cfg from defaults
+func (cfg FileConfig) withDefaults(defaults FileConfig) FileConfig {
+	if cfg.Encoding == "" {
+		cfg.Encoding = defaults.Encoding
+	}
+	if cfg.FlattenDepth == 0 {
+		cfg.FlattenDepth = defaults.FlattenDepth
+	}
+	if cfg.HasHeader == nil {
+		cfg.HasHeader = defaults.HasHeader
+	}
+	if cfg.Delimiter == 0 {
+		cfg.Delimiter = defaults.Delimiter
+	}
+	if cfg.SkipLines == 0 {
+		cfg.SkipLines = defaults.SkipLines
+	}
+	if cfg.Comment == 0 {
+		cfg.Comment = defaults.Comment
+	}
+	if cfg.NullValues == nil {
+		cfg.NullValues = defaults.NullValues
+	}
+	if cfg.TableName == "" {
+		cfg.TableName = defaults.TableName
+	}
+	if cfg.Columns == nil {
+		cfg.Columns = defaults.Columns
+	}
+	cfg.Strict = cfg.Strict || defaults.Strict
+	cfg.TextOnly = cfg.TextOnly || defaults.TextOnly
+	return cfg
+}
+
+// OpenFile opens a data file for streaming, reading the header and a
+// sample of records to infer column types (Parquet files carry their own).
+// tableName is optional - if empty, uses the sidecar's table name or GetFullTableName
+func OpenFile(filePath, rootDir string, tableName ...string) (*Reader, error) {
+	return OpenFileConfig(filePath, rootDir, FileConfig{}, tableName...)
+}
+
+// OpenFileConfig is OpenFile with per-file overrides of the detected format.
+// Options left unset in cfg are taken from the file's sidecars.
+func OpenFileConfig(filePath, rootDir string, cfg FileConfig, tableName ...string) (*Reader, error) {
+	sidecar, err := ReadSidecar(filePath)
+	if err != nil {
+		return nil, err
+	}
+	cfg = cfg.withDefaults(sidecar)
+
+	var r *Reader
+	var open func() error
+	if archive, sheet, ok := SplitArchivePath(filePath); ok && isWorkbook(archive) {
+		r, err = openSheetStream(filePath, archive, sheet)
+		open = r.openSheet
+	} else if IsParquetFile(filePath) {
+		var pr *parquet.Reader
+		r, pr, err = openParquetStream(filePath)
+		open = func() error { return r.openParquet(pr) }
+	} else {
+		var input *bufio.Reader
+		r, input, err = openStream(filePath, cfg)
+		switch {
+		case IsJSONFile(filePath):
+			open = func() error { return r.openJSON(input, cfg) }
+		case IsFixedWidthFile(filePath):
+			open = func() error { return r.openFixedWidth(input, cfg) }
+		default:
+			open = func() error { return r.openCSV(input, cfg) }
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	r.setNullValues(cfg.NullValues)
+	if err := open(); err != nil {
+		r.Close()
+		return nil, err
+	}
+	if err := r.nameColumns(cfg.Columns); err != nil {
+		r.Close()
+		return nil, err
+	}
+	if cfg.TextOnly {
+		for i := range r.Info.ColumnTypes {
+			r.Info.ColumnTypes[i] = TypeText
+		}
+	}
+	r.Info.ModTime = sidecarModTime(filePath, r.Info.ModTime)
+
+	// Use provided table name, then the configured one, or fall back to full path name
+	r.Info.TableName = GetFullTableName(filePath, rootDir)
+	if cfg.TableName != "" {
+		r.Info.TableName = sanitizeTableName(cfg.TableName)
+	}
+	if len(tableName) > 0 && tableName[0] != "" {
+		r.Info.TableName = tableName[0]
+	}
+	r.BatchSize = DefaultBatchSize
+	return r, nil
+}
+
+// openStream opens a file for reading as UTF-8 text, decompressing and
+// transcoding it on the fly, and fills in the Path, Encoding and ModTime of
+// the returned Reader's Info
+func openStream(filePath string, cfg FileConfig) (*Reader, *bufio.Reader, error) {
+	file, size, modTime, err := openSource(filePath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Decompress on the fly; progress then counts compressed bytes
+	counter := &countingReader{r: file}
+	_, _, compressionExt := splitExtensions(filePath)
+	decompressed, err := newDecompressor(counter, compressionExt)
+	if err != nil {
+		file.Close()
+		return nil, nil, fmt.Errorf("failed to decompress file %s: %w", filePath, err)
+	}
+	r := &Reader{file: file, decompressor: decompressed, size: size}
+
+	// Detect the encoding from the raw bytes, skip the BOM and transcode to UTF-8
+	raw := bufio.NewReaderSize(decompressed, SniffSize)
+	rawHead, _ := raw.Peek(SniffSize)
+	encoding, bom := DetectEncoding(rawHead)
+	if cfg.Encoding != "" {
+		if encoding, err = NormalizeEncoding(cfg.Encoding); err != nil {
+			r.Close()
+			return nil, nil, fmt.Errorf("invalid encoding for %s: %w", filePath, err)
+		}
+		bom = bomLength(rawHead, encoding)
+	}
+	raw.Discard(bom)
+
+	if compressionExt != "" || encoding != EncodingUTF8 {
+		r.counter = counter
+	}
+
+	r.Info = FileInfo{
+		Path:     filePath,
+		Encoding: encoding,
+		ModTime:  modTime,
+	}
+	decoded := newDecoder(raw, encoding)
+	if cfg.Encoding == "" && encoding == EncodingUTF8 && bom == 0 {
+		// Only the first SniffSize bytes were seen; a legacy byte further on
+		// switches the rest of the file to Windows-1252
+		decoded = &utf8Fallback{r: raw, onFallback: func() {
+			fmt.Printf("Warning: %s is not UTF-8 past its first bytes, reading the rest as %s\n", filePath, EncodingWindows1252)
+			r.Info.Encoding = EncodingWindows1252
+		}}
+	}
+	return r, bufio.NewReaderSize(decoded, SniffSize), nil
+}
+
+// openCSV sniffs the dialect of delimited text, reads the header and
+// samples the first records to infer column types
+func (r *Reader) openCSV(input *bufio.Reader, cfg FileConfig) error {
+	filePath := r.Info.Path
+	skipped, err := skipLines(input, cfg.SkipLines)
+	if err != nil {
+		return fmt.Errorf("failed to read file %s: %w", filePath, err)
+	}
+
+	// Sniff the dialect from the first bytes without consuming them, unless
+	// the delimiter is configured
+	head, _ := input.Peek(SniffSize)
+	dialect := SniffDialect(withoutComments(head, cfg.Comment), DetectDelimiter(filePath))
+	if cfg.Delimiter != 0 {
+		dialect = Dialect{Delimiter: cfg.Delimiter, Quote: '"'}
+	}
+
+	capture := &captureReader{r: input}
+	var src io.Reader = capture
+	if dialect.Quote == '\'' {
+		src = newQuoteSwapReader(src)
+	}
+
+	reader := csv.NewReader(src)
+	reader.Comma = dialect.Delimiter
+	reader.Comment = cfg.Comment
+	reader.LazyQuotes = true
+	reader.TrimLeadingSpace = true
+
+	// Malformed rows are set aside unless strict, so one bad line does not
+	// fail the whole file
+	firstLine := int64(cfg.SkipLines) + 1
+	r.next = func() ([]string, error) {
+		for {
+			start := reader.InputOffset()
+			record, err := reader.Read()
+			if err != nil && !cfg.Strict {
+				if rowErr, ok := rowError(err, record, reader.FieldsPerRecord, firstLine, capture.text(start, reader.InputOffset())); ok {
+					r.quarantine(rowErr)
+					capture.discard(reader.InputOffset())
+					continue
+				}
+			}
+			capture.discard(reader.InputOffset())
+			if err == nil && dialect.Quote == '\'' {
+				swapQuotes(record)
+			}
+			return record, err
+		}
+	}
+	r.offset = func() int64 { return skipped + reader.InputOffset() }
+
+	first, err := r.next()
+	if err == io.EOF {
+		return fmt.Errorf("%w: %s", ErrEmptyFile, filePath)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to parse file %s: %w", filePath, err)
+	}
+
+	// Sample the first records for type inference; they are replayed by ReadBatch
+	sample, err := r.sample()
+	if err != nil {
+		return err
+	}
+
+	// Without a header row the first record is data and columns get generated names
+	headers := first
+	dialect.HasHeader = DetectHeader(first, sample)
+	if cfg.HasHeader != nil {
+		dialect.HasHeader = *cfg.HasHeader
+	}
+	if !dialect.HasHeader {
+		sample = append([][]string{r.nullify(first)}, sample...)
+		headers = GenerateColumnNames(maxFields(sample))
+	}
+
+	r.Info.Delimiter = dialect.Delimiter
+	r.Info.Quote = dialect.Quote
+	r.Info.HasHeader = dialect.HasHeader
+	r.Info.Headers = headers
+	r.Info.ColumnTypes = InferColumnTypes(len(headers), sample)
+	r.pending = sample
+	return nil
+}
+
+// sample reads up to TypeSampleSize records for type inference
+func (r *Reader) sample() ([][]string, error) {
+	var sample [][]string
+	for len(sample) < TypeSampleSize {
+		record, err := r.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse file %s: %w", r.Info.Path, err)
+		}
+		sample = append(sample, r.nullify(record))
+	}
+	return sample, nil
+}
+
+// nameColumns replaces the first column names with configured ones; the
+// remaining columns keep theirs
+func (r *Reader) nameColumns(names []string) error {
+	if len(names) > len(r.Info.Headers) {
+		return fmt.Errorf("file %s has %d columns but %d names are configured", r.Info.Path, len(r.Info.Headers), len(names))
+	}
+	copy(r.Info.Headers, names)
+	return nil
+}
+
+// setNullValues sets the values read as empty, and so loaded as NULL
+func (r *Reader) setNullValues(values []string) {
+	r.nulls = nil
+	for _, v := range values {
+		if r.nulls == nil {
+			r.nulls = make(map[string]bool)
+		}
+		r.nulls[v] = true
+	}
+}
+
+// nullify empties the fields of a record holding a configured NULL value
+func (r *Reader) nullify(record []string) []string {
+	if r.nulls == nil {
+		return record
+	}
+	for i, v := range record {
+		if r.nulls[v] {
+			record[i] = ""
+		}
+	}
+	return record
+}
+
+// skipLines discards the first n lines of input and returns their length in bytes
+func skipLines(input *bufio.Reader, n int) (int64, error) {
+	var skipped int64
+	for i := 0; i < n; i++ {
+		line, err := input.ReadString('\n')
+		skipped += int64(len(line))
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return skipped, err
+		}
+	}
+	return skipped, nil
+}
+
+// withoutComments drops the lines starting with comment from a sample, so
+// they do not take part in sniffing
+func withoutComments(sample []byte, comment rune) []byte {
+	if comment == 0 {
+		return sample
+	}
+	prefix := []byte(string(comment))
+	var kept []byte
+	for _, line := range bytes.SplitAfter(sample, []byte("\n")) {
+		if !bytes.HasPrefix(line, prefix) {
+			kept = append(kept, line...)
+		}
+	}
+	return kept
+}
+
+// openSource opens a file, or a member when the path points inside an
+// archive, and returns its size and modification time. Archive members take
+// the archive's modification time, so they all reload when it changes.
+func openSource(filePath string) (io.ReadCloser, int64, int64, error) {
+	if archive, member, ok := SplitArchivePath(filePath); ok {
+		stat, err := os.Stat(archive)
+		if err != nil {
+			return nil, 0, 0, fmt.Errorf("failed to stat file %s: %w", archive, err)
+		}
+		rc, size, err := openArchiveMember(archive, member)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		return rc, size, stat.ModTime().UnixNano(), nil
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("failed to open file %s: %w", filePath, err)
+	}
+
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, 0, fmt.Errorf("failed to stat file %s: %w", filePath, err)
+	}
+	return file, stat.Size(), stat.ModTime().UnixNano(), nil
+}
+
+// FileModTime returns the modification time recorded for a file; archive
+// members report the archive's. Fixed-width files and files with sidecars
+// report the latest of the file, its layout and its sidecars.
+func FileModTime(filePath string) (int64, error) {
+	if archive, _, ok := SplitArchivePath(filePath); ok {
+		filePath = archive
+	}
+	stat, err := os.Stat(filePath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to stat file %s: %w", filePath, err)
+	}
+	return sidecarModTime(filePath, layoutModTime(filePath, stat.ModTime().UnixNano())), nil
+}
+
+// GenerateColumnNames returns c1..cN for files without a header row
+func GenerateColumnNames(n int) []string {
+	names := make([]string, n)
+	for i := range names {
+		names[i] = fmt.Sprintf("c%d", i+1)
+	}
+	return names
+}
+
+// maxFields returns the largest field count among records
+func maxFields(records [][]string) int {
+	n := 0
+	for _, record := range records {
+		n = max(n, len(record))
+	}
+	return n
+}
+
+// SetProgress registers a callback invoked after every batch
+func (r *Reader) SetProgress(fn ProgressFunc) {
+	r.onProgress = fn
+}
+
+// ReadBatch returns up to BatchSize records. It returns io.EOF once all
+// records have been read.
+func (r *Reader) ReadBatch() ([][]string, error) {
+	if r.done {
+		return nil, io.EOF
+	}
+
+	batchSize := r.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+
+	batch := make([][]string, 0, min(batchSize, DefaultBatchSize))
+	for len(batch) < batchSize {
+		if len(r.pending) > 0 {
+			n := min(batchSize-len(batch), len(r.pending))
+			batch = append(batch, r.pending[:n]...)
+			r.pending = r.pending[n:]
+			continue
+		}
+
+		record, err := r.next()
+		if err == io.EOF {
+			r.done = true
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse file %s: %w", r.Info.Path, err)
+		}
+		batch = append(batch, r.nullify(record))
+	}
+	if len(r.pending) == 0 {
+		r.pending = nil
+	}
+
+	r.rows += int64(len(batch))
+	r.reportProgress()
+
+	if len(batch) == 0 {
+		return nil, io.EOF
+	}
+	return batch, nil
+}
+
+// reportProgress sends the current position to the progress callback
+func (r *Reader) reportProgress() {
+	if r.onProgress == nil {
+		return
+	}
+	bytes := r.offset()
+	if r.counter != nil {
+		bytes = r.counter.n
+	}
+	if r.done {
+		bytes = r.size
+	}
+	r.onProgress(Progress{
+		Path:  r.Info.Path,
+		Rows:  r.rows,
+		Bytes: bytes,
+		Total: r.size,
+		Done:  r.done,
+	})
+}
+
+// Close closes the decompressor and the underlying file
+func (r *Reader) Close() error {
+	if r.decompressor != nil {
+		r.decompressor.Close()
+	}
+	return r.file.Close()
+}
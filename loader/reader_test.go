@@ -0,0 +1,128 @@
+package loader
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOpenFile_Batches(t *testing.T) {
+	tmpDir := t.TempDir()
+	csvPath := filepath.Join(tmpDir, "rows.csv")
+
+	var sb strings.Builder
+	sb.WriteString("id,value\n")
+	for i := 0; i < 2500; i++ {
+		fmt.Fprintf(&sb, "%d,v%d\n", i, i)
+	}
+	if err := os.WriteFile(csvPath, []byte(sb.String()), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	reader, err := OpenFile(csvPath, tmpDir)
+	if err != nil {
+		t.Fatalf("OpenFile failed: %v", err)
+	}
+	defer reader.Close()
+	reader.BatchSize = 1000
+
+	if reader.Info.ColumnTypes[0] != TypeInteger {
+		t.Errorf("Expected INTEGER id from sample, got %s", reader.Info.ColumnTypes[0])
+	}
+
+	var progress []Progress
+	reader.SetProgress(func(p Progress) {
+		progress = append(progress, p)
+	})
+
+	var sizes []int
+	var first, last string
+	for {
+		batch, err := reader.ReadBatch()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("ReadBatch failed: %v", err)
+		}
+		if first == "" {
+			first = batch[0][0]
+		}
+		last = batch[len(batch)-1][0]
+		sizes = append(sizes, len(batch))
+	}
+
+	if fmt.Sprint(sizes) != "[1000 1000 500]" {
+		t.Errorf("Unexpected batch sizes: %v", sizes)
+	}
+	if first != "0" || last != "2499" {
+		t.Errorf("Expected records 0..2499 in order, got %s..%s", first, last)
+	}
+
+	final := progress[len(progress)-1]
+	if !final.Done || final.Rows != 2500 || final.Bytes != final.Total {
+		t.Errorf("Unexpected final progress: %+v", final)
+	}
+}
+
+func TestOpenFile_Empty(t *testing.T) {
+	tmpDir := t.TempDir()
+	csvPath := filepath.Join(tmpDir, "empty.csv")
+	os.WriteFile(csvPath, []byte(""), 0644)
+
+	if _, err := OpenFile(csvPath, tmpDir); err == nil {
+		t.Error("Expected error for empty file, got nil")
+	}
+}
+
+func TestOpenFile_BadRows(t *testing.T) {
+	tmpDir := t.TempDir()
+	csvPath := filepath.Join(tmpDir, "ragged.csv")
+
+	var sb strings.Builder
+	sb.WriteString("id,name,amount\n")
+	sb.WriteString("1,ann,9.5\n")
+	sb.WriteString("2,bob,3.5,extra\n") // line 3
+	for i := 3; i < 3000; i++ {
+		fmt.Fprintf(&sb, "%d,n%d,%d.5\n", i, i, i)
+	}
+	sb.WriteString("3000,\"multi\nline\"\n") // lines 3001-3002
+	sb.WriteString("3001,zoe,1.5\n")
+	if err := os.WriteFile(csvPath, []byte(sb.String()), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	parsed, err := ParseFile(csvPath, tmpDir)
+	if err != nil {
+		t.Fatalf("ParseFile failed: %v", err)
+	}
+	if len(parsed.Records) != 2999 {
+		t.Errorf("Expected 2999 good rows, got %d", len(parsed.Records))
+	}
+	if last := parsed.Records[len(parsed.Records)-1]; last[0] != "3001" {
+		t.Errorf("Expected the row after a bad one to load, got %v", last)
+	}
+
+	want := []RowError{
+		{Line: 3, Raw: "2,bob,3.5,extra", Reason: "expected 3 fields, got 4"},
+		{Line: 3001, Raw: "3000,\"multi\nline\"", Reason: "expected 3 fields, got 2"},
+	}
+	if fmt.Sprint(parsed.RowErrors) != fmt.Sprint(want) {
+		t.Errorf("Expected row errors %q, got %q", want, parsed.RowErrors)
+	}
+
+	// Strict mode fails on the first malformed row
+	reader, err := OpenFileConfig(csvPath, tmpDir, FileConfig{Strict: true})
+	if err == nil {
+		defer reader.Close()
+		for err == nil {
+			_, err = reader.ReadBatch()
+		}
+	}
+	if err == nil || err == io.EOF {
+		t.Errorf("Expected a strict parse error, got %v", err)
+	}
+}
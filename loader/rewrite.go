@@ -0,0 +1,370 @@
+package loader
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// ErrNotRewritable is returned by RewriteFile for files it cannot write
+// without losing content or changing their format
+var ErrNotRewritable = errors.New("file cannot be rewritten")
+
+// utf8BOM is written back to files that started with it
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// textStyle is how a delimited text file writes its records
+type textStyle struct {
+	delimiter rune
+	quote     rune
+	quoteAll  bool // every field is quoted, not only the ones that need it
+	crlf      bool
+	bom       bool
+	null      string // written for NULL values
+}
+
+// RewriteRow is a row of a table written back to its file
+type RewriteRow struct {
+	Record int64         // the record of the file the row is unchanged from, counted from 1 after the header, or 0 if it was added or edited
+	Values []interface{} // int64, float64, bool, string or nil, as returned by db.Rows
+}
+
+// RewriteFile replaces a CSV, TSV or PSV file with the rows of its table.
+// Rows unchanged from a record of the file keep the record as written, so
+// values such as 1.50 or 1e3 are not normalised; added and edited rows are
+// written in the file's delimiter, quote character, quoting style and line
+// endings. The BOM and the header row are kept too, unless the columns
+// changed. NULL is written as the first configured NULL value.
+// The rows are written to a temporary file that is renamed over the file,
+// and the file's new modification time is returned, as FileModTime reports
+// it. Compressed files, archive members, other formats, files that are not
+// UTF-8 and files with preamble or comment lines return an error wrapping
+// ErrNotRewritable.
+func RewriteFile(filePath string, cfg FileConfig, columns []string, rows iter.Seq2[RewriteRow, error]) (int64, error) {
+	if err := checkRewritable(filePath, cfg); err != nil {
+		return 0, err
+	}
+
+	// Read the dialect, header and encoding the file was loaded with
+	r, err := OpenFileConfig(filePath, filepath.Dir(filePath), cfg)
+	if err != nil {
+		return 0, err
+	}
+	info := r.Info
+	r.Close()
+	if info.Encoding != EncodingUTF8 {
+		return 0, fmt.Errorf("%w: %s is %s, only UTF-8 files are written back", ErrNotRewritable, filePath, info.Encoding)
+	}
+
+	style, header, err := sniffStyle(filePath, info)
+	if err != nil {
+		return 0, err
+	}
+	sidecar, _ := ReadSidecar(filePath)
+	if nulls := cfg.withDefaults(sidecar).NullValues; len(nulls) > 0 {
+		style.null = nulls[0]
+	}
+
+	// Keep the header as written unless the table's columns changed
+	keepHeader := slices.Equal(SanitizeColumnNames(info.Headers), columns) && len(header) == len(columns)
+	records, err := openRawRecords(filePath, style)
+	if err != nil {
+		return 0, err
+	}
+	defer records.Close()
+
+	stat, err := os.Stat(filePath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to stat file %s: %w", filePath, err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(filePath), "."+filepath.Base(filePath)+".csvql-*.tmp")
+	if err != nil {
+		return 0, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	w := bufio.NewWriter(tmp)
+	if style.bom {
+		w.Write(utf8BOM)
+	}
+	// A record kept as written may end the file without a line break
+	unterminated := false
+	writeRaw := func(text string) {
+		if unterminated {
+			w.WriteString(style.lineBreak())
+		}
+		w.WriteString(text)
+		unterminated = !strings.HasSuffix(text, "\n")
+	}
+	writeFields := func(fields []string) {
+		if unterminated {
+			w.WriteString(style.lineBreak())
+			unterminated = false
+		}
+		style.writeRecord(w, fields)
+	}
+
+	first := int64(1)
+	if info.HasHeader {
+		text, err := records.read(0)
+		if err != nil {
+			return 0, err
+		}
+		if keepHeader && text != "" {
+			writeRaw(text)
+		} else {
+			writeFields(columns)
+		}
+		first = 0
+	}
+	fields := make([]string, len(columns))
+	for row, err := range rows {
+		if err != nil {
+			return 0, fmt.Errorf("failed to read rows for %s: %w", filePath, err)
+		}
+		if row.Record > 0 {
+			text, err := records.read(row.Record - first)
+			if err != nil {
+				return 0, err
+			}
+			if text != "" {
+				writeRaw(text)
+				continue
+			}
+		}
+		for i := range fields {
+			fields[i] = style.null
+			if i < len(row.Values) && row.Values[i] != nil {
+				fields[i] = formatField(row.Values[i])
+			}
+		}
+		writeFields(fields)
+	}
+
+	if err := w.Flush(); err != nil {
+		return 0, fmt.Errorf("failed to write %s: %w", tmp.Name(), err)
+	}
+	if err := tmp.Sync(); err != nil {
+		return 0, fmt.Errorf("failed to write %s: %w", tmp.Name(), err)
+	}
+	if err := tmp.Chmod(stat.Mode().Perm()); err != nil {
+		return 0, fmt.Errorf("failed to write %s: %w", tmp.Name(), err)
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, fmt.Errorf("failed to write %s: %w", tmp.Name(), err)
+	}
+	if err := os.Rename(tmp.Name(), filePath); err != nil {
+		return 0, fmt.Errorf("failed to replace %s: %w", filePath, err)
+	}
+	return FileModTime(filePath)
+}
+
+// checkRewritable rejects the files RewriteFile cannot reproduce
+func checkRewritable(filePath string, cfg FileConfig) error {
+	if _, _, ok := SplitArchivePath(filePath); ok {
+		return fmt.Errorf("%w: %s is inside an archive", ErrNotRewritable, filePath)
+	}
+	_, dataExt, compressionExt := splitExtensions(filePath)
+	if compressionExt != "" {
+		return fmt.Errorf("%w: %s is compressed", ErrNotRewritable, filePath)
+	}
+	if !supportedExtensions[dataExt] || IsFixedWidthFile(filePath) {
+		return fmt.Errorf("%w: only CSV, TSV and PSV files are written back, not %s", ErrNotRewritable, filePath)
+	}
+
+	sidecar, err := ReadSidecar(filePath)
+	if err != nil {
+		return err
+	}
+	cfg = cfg.withDefaults(sidecar)
+	if cfg.SkipLines > 0 || cfg.Comment != 0 {
+		return fmt.Errorf("%w: the preamble or comment lines of %s would be lost", ErrNotRewritable, filePath)
+	}
+	return nil
+}
+
+// sniffStyle reads the quoting style, line endings and BOM of a file from
+// its first lines, and its header row as written
+func sniffStyle(filePath string, info FileInfo) (textStyle, []string, error) {
+	style := textStyle{delimiter: info.Delimiter, quote: info.Quote}
+
+	f, err := os.Open(filePath)
+	if err != nil {
+		return style, nil, fmt.Errorf("failed to open file %s: %w", filePath, err)
+	}
+	defer f.Close()
+	head := make([]byte, SniffSize)
+	n, _ := f.Read(head)
+	head = head[:n]
+
+	if enc, n := detectBOM(head); enc == EncodingUTF8 {
+		style.bom = true
+		head = head[n:]
+	}
+	lines := bytes.SplitAfterN(head, []byte("\n"), 3)
+	style.crlf = bytes.HasSuffix(lines[0], []byte("\r\n"))
+
+	// Quote every field if the first lines do
+	style.quoteAll = len(head) > 0
+	for _, line := range lines[:min(len(lines), 2)] {
+		line = bytes.TrimRight(line, "\r\n")
+		if len(line) > 0 && !fullyQuoted(string(line), style.delimiter, style.quote, len(info.Headers)) {
+			style.quoteAll = false
+		}
+	}
+
+	if !info.HasHeader {
+		return style, nil, nil
+	}
+	var src io.Reader = bytes.NewReader(lines[0])
+	if style.quote == '\'' {
+		src = newQuoteSwapReader(src)
+	}
+	cr := csv.NewReader(src)
+	cr.Comma = style.delimiter
+	cr.LazyQuotes = true
+	cr.TrimLeadingSpace = true
+	header, err := cr.Read()
+	if err != nil {
+		return style, nil, nil
+	}
+	if style.quote == '\'' {
+		swapQuotes(header)
+	}
+	return style, header, nil
+}
+
+// fullyQuoted reports whether every one of the n fields of a line is quoted
+func fullyQuoted(line string, delimiter, quote rune, n int) bool {
+	if quote == 0 || len(line) < 2 {
+		return false
+	}
+	q := string(quote)
+	return strings.HasPrefix(line, q) && strings.HasSuffix(line, q) &&
+		strings.Count(line, q+string(delimiter)+q) == n-1
+}
+
+// writeRecord writes the fields of a record, quoting the ones that would
+// not read back as written
+func (s textStyle) writeRecord(w *bufio.Writer, fields []string) {
+	quote := s.quote
+	if quote == 0 {
+		quote = '"'
+	}
+	for i, field := range fields {
+		if i > 0 {
+			w.WriteRune(s.delimiter)
+		}
+		if !s.quoteAll && !s.needsQuotes(field, quote) {
+			w.WriteString(field)
+			continue
+		}
+		q := string(quote)
+		w.WriteString(q + strings.ReplaceAll(field, q, q+q) + q)
+	}
+	w.WriteString(s.lineBreak())
+}
+
+// lineBreak returns the line ending of the file
+func (s textStyle) lineBreak() string {
+	if s.crlf {
+		return "\r\n"
+	}
+	return "\n"
+}
+
+// rawRecords reads the records of a delimited file as written, each with
+// the blank lines before it and the line break after it
+type rawRecords struct {
+	file    *os.File
+	capture *captureReader
+	reader  *csv.Reader
+	next    int64 // index of the next record, the header counted as 0
+}
+
+func openRawRecords(filePath string, style textStyle) (*rawRecords, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file %s: %w", filePath, err)
+	}
+	input := bufio.NewReader(f)
+	if style.bom {
+		input.Discard(len(utf8BOM))
+	}
+	capture := &captureReader{r: input}
+	var src io.Reader = capture
+	if style.quote == '\'' {
+		src = newQuoteSwapReader(src)
+	}
+	reader := csv.NewReader(src)
+	reader.Comma = style.delimiter
+	reader.LazyQuotes = true
+	reader.FieldsPerRecord = -1
+	return &rawRecords{file: f, capture: capture, reader: reader}, nil
+}
+
+// read returns the text of record i, counted from 0, or "" past the end of
+// the file or if a later record was already read
+func (r *rawRecords) read(i int64) (string, error) {
+	for r.next <= i {
+		start := r.reader.InputOffset()
+		_, err := r.reader.Read()
+		if err == io.EOF {
+			return "", nil
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to read file %s: %w", r.file.Name(), err)
+		}
+		end := r.reader.InputOffset()
+		text := r.capture.text(start, end)
+		r.capture.discard(end)
+		if !utf8.ValidString(text) {
+			return "", fmt.Errorf("%w: %s is not UTF-8 throughout", ErrNotRewritable, r.file.Name())
+		}
+		r.next++
+		if r.next > i {
+			return text, nil
+		}
+	}
+	return "", nil
+}
+
+func (r *rawRecords) Close() error {
+	return r.file.Close()
+}
+
+// needsQuotes reports whether a field must be quoted: it holds the
+// delimiter, a quote or a line break, or leading spaces, which the reader
+// trims
+func (s textStyle) needsQuotes(field string, quote rune) bool {
+	return strings.ContainsRune(field, s.delimiter) || strings.ContainsRune(field, quote) ||
+		strings.ContainsAny(field, "\r\n") || strings.HasPrefix(field, " ") || strings.HasPrefix(field, "\t")
+}
+
+// formatField writes a table value as text that loads back to it
+func formatField(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case []byte:
+		return string(v)
+	}
+	return fmt.Sprint(v)
+}
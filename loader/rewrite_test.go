@@ -0,0 +1,204 @@
+package loader
+
+import (
+	"errors"
+	"fmt"
+	"iter"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// rowsOf iterates over added or edited rows
+func rowsOf(rows ...[]interface{}) iter.Seq2[RewriteRow, error] {
+	return func(yield func(RewriteRow, error) bool) {
+		for _, row := range rows {
+			if !yield(RewriteRow{Values: row}, nil) {
+				return
+			}
+		}
+	}
+}
+
+func TestRewriteFile(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	tests := []struct {
+		name    string
+		content string
+		rows    iter.Seq2[RewriteRow, error]
+		want    string
+	}{
+		{
+			name:    "quoted.csv",
+			content: "\xEF\xBB\xBF\"Id\";\"Full Name\";\"Note\"\r\n\"1\";\"Ann\";\"a;b\"\r\n",
+			rows:    rowsOf([]interface{}{int64(1), "Ann", `say "hi"`}, []interface{}{int64(2), "Bob", nil}),
+			want:    "\xEF\xBB\xBF\"Id\";\"Full Name\";\"Note\"\r\n\"1\";\"Ann\";\"say \"\"hi\"\"\"\r\n\"2\";\"Bob\";\"\"\r\n",
+		},
+		{
+			name:    "plain.csv",
+			content: "id,name,price,active\n1,ann,2.5,true\n",
+			rows:    rowsOf([]interface{}{int64(1), "ann, jr", 2.25, true}, []interface{}{int64(2), " bob\nsmith", 3.0, false}),
+			want:    "id,name,price,active\n1,\"ann, jr\",2.25,true\n2,\" bob\nsmith\",3,false\n",
+		},
+		{
+			name:    "single.tsv",
+			content: "id\tname\n1\t'ann'\n",
+			rows:    rowsOf([]interface{}{int64(1), "it's"}),
+			want:    "id\tname\n1\t'it''s'\n",
+		},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(tmpDir, tt.name)
+		os.WriteFile(path, []byte(tt.content), 0640)
+		r, err := OpenFile(path, tmpDir)
+		if err != nil {
+			t.Fatalf("%s: OpenFile failed: %v", tt.name, err)
+		}
+		columns := SanitizeColumnNames(r.Info.Headers)
+		r.Close()
+
+		modTime, err := RewriteFile(path, FileConfig{}, columns, tt.rows)
+		if err != nil {
+			t.Fatalf("%s: RewriteFile failed: %v", tt.name, err)
+		}
+		data, _ := os.ReadFile(path)
+		if string(data) != tt.want {
+			t.Errorf("%s: expected %q, got %q", tt.name, tt.want, data)
+		}
+		if stat, _ := os.Stat(path); stat.Mode().Perm() != 0640 {
+			t.Errorf("%s: expected mode 0640, got %v", tt.name, stat.Mode().Perm())
+		}
+		if current, _ := FileModTime(path); current != modTime {
+			t.Errorf("%s: expected mod time %d, got %d", tt.name, current, modTime)
+		}
+	}
+
+	// The file reads back as the rows, with the first NULL value for NULL
+	path := filepath.Join(tmpDir, "nulls.csv")
+	os.WriteFile(path, []byte("id,name\n1,N/A\n"), 0644)
+	os.WriteFile(SidecarPath(path), []byte("null_values: [N/A]\n"), 0644)
+	if _, err := RewriteFile(path, FileConfig{}, []string{"id", "renamed"}, rowsOf([]interface{}{int64(1), nil}, []interface{}{int64(2), "x"})); err != nil {
+		t.Fatalf("RewriteFile failed: %v", err)
+	}
+	parsed, err := ParseFile(path, tmpDir)
+	if err != nil {
+		t.Fatalf("ParseFile failed: %v", err)
+	}
+	if !reflect.DeepEqual(parsed.Info.Headers, []string{"id", "renamed"}) || !reflect.DeepEqual(parsed.Records, [][]string{{"1", ""}, {"2", "x"}}) {
+		t.Errorf("Unexpected file after rewrite: %v %v", parsed.Info.Headers, parsed.Records)
+	}
+
+	entries, _ := os.ReadDir(tmpDir)
+	for _, entry := range entries {
+		if filepath.Ext(entry.Name()) == ".tmp" {
+			t.Errorf("Expected no temporary file left, found %s", entry.Name())
+		}
+	}
+}
+
+func TestRewriteFile_KeepsUneditedRecords(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "prices.csv")
+	content := "id,price,at,qty\r\n" +
+		"1,1.50,2024-01-05T10:00:00,1e3\r\n" +
+		"2, 2.00 ,\"2024-01-06\",7\r\n" +
+		"\r\n" +
+		"3,3.10,2024-01-07T08:30:00,2e1\r\n" +
+		"4,4.00,2024-01-08T00:00:00,40"
+	os.WriteFile(path, []byte(content), 0644)
+
+	// Record 2 is edited, record 3 deleted and a row added after record 4
+	rows := func(yield func(RewriteRow, error) bool) {
+		for _, row := range []RewriteRow{
+			{Record: 1, Values: []interface{}{int64(1), 1.5, "2024-01-05 10:00:00", 1000.0}},
+			{Values: []interface{}{int64(2), 2.5, "2024-01-06 00:00:00", int64(7)}},
+			{Record: 4, Values: []interface{}{int64(4), 4.0, "2024-01-08 00:00:00", int64(40)}},
+			{Values: []interface{}{int64(5), 5.25, nil, int64(1)}},
+		} {
+			if !yield(row, nil) {
+				return
+			}
+		}
+	}
+	if _, err := RewriteFile(path, FileConfig{}, []string{"id", "price", "at", "qty"}, rows); err != nil {
+		t.Fatalf("RewriteFile failed: %v", err)
+	}
+	want := "id,price,at,qty\r\n" +
+		"1,1.50,2024-01-05T10:00:00,1e3\r\n" +
+		"2,2.5,2024-01-06 00:00:00,7\r\n" +
+		"4,4.00,2024-01-08T00:00:00,40\r\n" +
+		"5,5.25,,1\r\n"
+	if data, _ := os.ReadFile(path); string(data) != want {
+		t.Errorf("Expected %q, got %q", want, data)
+	}
+
+	// Rewriting without edits leaves the file byte-identical
+	var unchanged []RewriteRow
+	for i := int64(1); i <= 4; i++ {
+		unchanged = append(unchanged, RewriteRow{Record: i})
+	}
+	if _, err := RewriteFile(path, FileConfig{}, []string{"id", "price", "at", "qty"}, func(yield func(RewriteRow, error) bool) {
+		for _, row := range unchanged {
+			if !yield(row, nil) {
+				return
+			}
+		}
+	}); err != nil {
+		t.Fatalf("RewriteFile failed: %v", err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != want {
+		t.Errorf("Expected the file unchanged, got %q", data)
+	}
+}
+
+func TestRewriteFile_NotRewritable(t *testing.T) {
+	tmpDir := t.TempDir()
+	gz := filepath.Join(tmpDir, "data.csv.gz")
+	os.WriteFile(gz, []byte("not read"), 0644)
+	preamble := filepath.Join(tmpDir, "report.csv")
+	os.WriteFile(preamble, []byte("Report of 2024\nid,name\n1,ann\n"), 0644)
+	latin1 := filepath.Join(tmpDir, "latin1.csv")
+	os.WriteFile(latin1, []byte("id,city\n1,Citt\xe0\n"), 0644)
+
+	tests := map[string]FileConfig{
+		gz:       {},
+		preamble: {SkipLines: 1},
+		latin1:   {},
+	}
+	for path, cfg := range tests {
+		_, err := RewriteFile(path, cfg, []string{"id", "name"}, rowsOf())
+		if !errors.Is(err, ErrNotRewritable) {
+			t.Errorf("%s: expected ErrNotRewritable, got %v", filepath.Base(path), err)
+		}
+	}
+	if data, _ := os.ReadFile(preamble); string(data) != "Report of 2024\nid,name\n1,ann\n" {
+		t.Errorf("Expected the file unchanged, got %q", data)
+	}
+
+	// A legacy byte past the sniffed sample is not copied into a UTF-8 file
+	late := filepath.Join(tmpDir, "late.csv")
+	content := []byte("id,name\n")
+	var kept []RewriteRow
+	for i := int64(1); len(content) <= SniffSize; i++ {
+		content = fmt.Appendf(content, "%d,ann\n", i)
+		kept = append(kept, RewriteRow{Record: i})
+	}
+	content = append(content, "0,Citt\xe0\n"...)
+	kept = append(kept, RewriteRow{Record: int64(len(kept) + 1)})
+	os.WriteFile(late, content, 0644)
+	_, err := RewriteFile(late, FileConfig{}, []string{"id", "name"}, func(yield func(RewriteRow, error) bool) {
+		for _, row := range kept {
+			if !yield(row, nil) {
+				return
+			}
+		}
+	})
+	if !errors.Is(err, ErrNotRewritable) {
+		t.Errorf("late.csv: expected ErrNotRewritable, got %v", err)
+	}
+	if data, _ := os.ReadFile(late); string(data) != string(content) {
+		t.Error("Expected late.csv unchanged")
+	}
+}
@@ -0,0 +1,230 @@
+package loader
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+// sidecarSuffix is appended to a file name to find its sidecar:
+// orders.csv is configured by orders.csv.csvql.yaml
+const sidecarSuffix = ".csvql.yaml"
+
+// DirConfigName is the directory-level sidecar, a mapping of glob patterns
+// to the options of the files they match in that directory
+const DirConfigName = ".csvql.yaml"
+
+// sidecarOptions are the parsing options of a sidecar file or section
+type sidecarOptions struct {
+	Header    *bool    `yaml:"header"`      // false: the first row is data
+	Delimiter string   `yaml:"delimiter"`   // one character, or "tab"
+	Skip      int      `yaml:"skip"`        // preamble lines before the header
+	Comment   string   `yaml:"comment"`     // lines starting with it are skipped
+	Null      []string `yaml:"null_values"` // values loaded as NULL
+	Table     string   `yaml:"table"`
+	Encoding  string   `yaml:"encoding"`
+	Columns   []string `yaml:"columns"` // names of the first columns
+}
+
+// merge overrides the options set in over
+func (o *sidecarOptions) merge(over sidecarOptions) {
+	if over.Header != nil {
+		o.Header = over.Header
+	}
+	if over.Delimiter != "" {
+		o.Delimiter = over.Delimiter
+	}
+	if over.Skip != 0 {
+		o.Skip = over.Skip
+	}
+	if over.Comment != "" {
+		o.Comment = over.Comment
+	}
+	if over.Null != nil {
+		o.Null = over.Null
+	}
+	if over.Table != "" {
+		o.Table = over.Table
+	}
+	if over.Encoding != "" {
+		o.Encoding = over.Encoding
+	}
+	if over.Columns != nil {
+		o.Columns = over.Columns
+	}
+}
+
+// SidecarPath returns the per-file sidecar of a data file
+func SidecarPath(filePath string) string {
+	return filePath + sidecarSuffix
+}
+
+// IsSidecarFile reports whether a path is a per-file or directory sidecar
+func IsSidecarFile(path string) bool {
+	return strings.HasSuffix(path, sidecarSuffix)
+}
+
+// SidecarDataPath returns the file a per-file sidecar configures, or "" for
+// a directory sidecar, which configures the files of its directory
+func SidecarDataPath(sidecarPath string) string {
+	if filepath.Base(sidecarPath) == DirConfigName {
+		return ""
+	}
+	return strings.TrimSuffix(sidecarPath, sidecarSuffix)
+}
+
+// sidecarDir returns the directory whose sidecar applies to a file; archive
+// members use the archive's
+func sidecarDir(filePath string) string {
+	if archive, _, ok := SplitArchivePath(filePath); ok {
+		return filepath.Dir(archive)
+	}
+	return filepath.Dir(filePath)
+}
+
+// readSidecarOptions merges the sections of the directory sidecar matching
+// a file, the more specific (longer) patterns last, then its own sidecar.
+// It also returns the latest modification time among the sidecars used.
+func readSidecarOptions(filePath string) (sidecarOptions, int64, error) {
+	var opts sidecarOptions
+	var modTime int64
+
+	dir := sidecarDir(filePath)
+	dirConfig := filepath.Join(dir, DirConfigName)
+	if data, stat, err := readIfExists(dirConfig); err != nil {
+		return opts, 0, err
+	} else if data != nil {
+		var sections map[string]sidecarOptions
+		if err := decodeYAML(data, &sections); err != nil {
+			return opts, 0, fmt.Errorf("failed to parse %s: %w", dirConfig, err)
+		}
+		var patterns []string
+		for pattern := range sections {
+			if MatchPath(pattern, filePath, dir) {
+				patterns = append(patterns, pattern)
+			}
+		}
+		sort.Slice(patterns, func(i, j int) bool {
+			if len(patterns[i]) != len(patterns[j]) {
+				return len(patterns[i]) < len(patterns[j])
+			}
+			return patterns[i] < patterns[j]
+		})
+		for _, pattern := range patterns {
+			opts.merge(sections[pattern])
+		}
+		if len(patterns) > 0 {
+			modTime = stat.ModTime().UnixNano()
+		}
+	}
+
+	if _, _, ok := SplitArchivePath(filePath); ok {
+		return opts, modTime, nil
+	}
+	sidecar := SidecarPath(filePath)
+	if data, stat, err := readIfExists(sidecar); err != nil {
+		return opts, 0, err
+	} else if data != nil {
+		var own sidecarOptions
+		if err := decodeYAML(data, &own); err != nil {
+			return opts, 0, fmt.Errorf("failed to parse %s: %w", sidecar, err)
+		}
+		opts.merge(own)
+		modTime = max(modTime, stat.ModTime().UnixNano())
+	}
+	return opts, modTime, nil
+}
+
+// decodeYAML decodes a sidecar, rejecting unknown options so that typos
+// are reported instead of ignored. An empty file decodes to zero options.
+func decodeYAML(data []byte, v interface{}) error {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(v); err != nil && err != io.EOF {
+		return err
+	}
+	return nil
+}
+
+// readIfExists reads a file, returning nil data if it does not exist
+func readIfExists(path string) ([]byte, os.FileInfo, error) {
+	stat, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to stat %s: %w", path, err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return data, stat, nil
+}
+
+// ReadSidecar returns the options set by the sidecars of a file: its own
+// orders.csv.csvql.yaml over the matching sections of the .csvql.yaml in
+// its directory. Files without sidecars get a zero FileConfig.
+func ReadSidecar(filePath string) (FileConfig, error) {
+	opts, _, err := readSidecarOptions(filePath)
+	if err != nil {
+		return FileConfig{}, err
+	}
+
+	cfg := FileConfig{
+		Encoding:   opts.Encoding,
+		HasHeader:  opts.Header,
+		SkipLines:  opts.Skip,
+		NullValues: opts.Null,
+		TableName:  opts.Table,
+		Columns:    opts.Columns,
+	}
+	if opts.Skip < 0 {
+		return cfg, fmt.Errorf("sidecar of %s: skip must not be negative", filePath)
+	}
+	if opts.Delimiter != "" {
+		if cfg.Delimiter, err = sidecarRune(opts.Delimiter); err != nil {
+			return cfg, fmt.Errorf("sidecar of %s: invalid delimiter: %w", filePath, err)
+		}
+	}
+	if opts.Comment != "" {
+		if cfg.Comment, err = sidecarRune(opts.Comment); err != nil {
+			return cfg, fmt.Errorf("sidecar of %s: invalid comment: %w", filePath, err)
+		}
+	}
+	if opts.Encoding != "" {
+		if _, err := NormalizeEncoding(opts.Encoding); err != nil {
+			return cfg, fmt.Errorf("sidecar of %s: %w", filePath, err)
+		}
+	}
+	return cfg, nil
+}
+
+// sidecarRune parses a single-character option; "tab" and "\t" mean a tab
+func sidecarRune(s string) (rune, error) {
+	if s == "tab" || s == `\t` {
+		return '\t', nil
+	}
+	r, size := utf8.DecodeRuneInString(s)
+	if size != len(s) || r == '\n' || r == '\r' || r == '"' {
+		return 0, fmt.Errorf("%q is not a single character", s)
+	}
+	return r, nil
+}
+
+// sidecarModTime returns the later of modTime and the modification time of
+// the sidecars applying to a file, so editing them reloads the file
+func sidecarModTime(filePath string, modTime int64) int64 {
+	_, sidecarTime, err := readSidecarOptions(filePath)
+	if err != nil {
+		return modTime
+	}
+	return max(modTime, sidecarTime)
+}
@@ -0,0 +1,142 @@
+package loader
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSidecar(t *testing.T) {
+	tmpDir := t.TempDir()
+	orders := filepath.Join(tmpDir, "orders.csv")
+	content := "Export generated 2024-01-31\nSystem: ERP\n\n# orders;of;January\n1;ann;N/A\n2;bob;9.5\n"
+	os.WriteFile(orders, []byte(content), 0644)
+	os.WriteFile(SidecarPath(orders), []byte("header: false\ndelimiter: \";\"\nskip: 3\ncomment: \"#\"\nnull_values: [N/A]\ntable: customers\n"), 0644)
+
+	parsed, err := ParseFile(orders, tmpDir)
+	if err != nil {
+		t.Fatalf("ParseFile failed: %v", err)
+	}
+	if parsed.Info.TableName != "customers" {
+		t.Errorf("Expected table customers, got %s", parsed.Info.TableName)
+	}
+	if parsed.Info.HasHeader || parsed.Info.Delimiter != ';' {
+		t.Errorf("Expected headerless ';' file, got header=%v delimiter=%q", parsed.Info.HasHeader, parsed.Info.Delimiter)
+	}
+	if !reflect.DeepEqual(parsed.Info.Headers, []string{"c1", "c2", "c3"}) {
+		t.Errorf("Unexpected headers: %v", parsed.Info.Headers)
+	}
+	if parsed.Info.ColumnTypes[2] != TypeReal {
+		t.Errorf("Expected N/A ignored by inference, got %s", parsed.Info.ColumnTypes[2])
+	}
+	want := [][]string{{"1", "ann", ""}, {"2", "bob", "9.5"}}
+	if !reflect.DeepEqual(parsed.Records, want) {
+		t.Errorf("Expected %v, got %v", want, parsed.Records)
+	}
+
+	names := ResolveTableNames([]string{orders, filepath.Join(tmpDir, "sub", "customers.csv")}, tmpDir)
+	if names[orders] != "customers" || names[filepath.Join(tmpDir, "sub", "customers.csv")] != "sub_customers" {
+		t.Errorf("Expected the sidecar name to win a conflict, got %v", names)
+	}
+
+	// Two sidecars naming the same table both fall back to full path names
+	os.MkdirAll(filepath.Join(tmpDir, "eu"), 0755)
+	eu := filepath.Join(tmpDir, "eu", "clients.csv")
+	os.WriteFile(eu, []byte("id\n1\n"), 0644)
+	os.WriteFile(SidecarPath(eu), []byte("table: customers\n"), 0644)
+	names = ResolveTableNames([]string{orders, eu, filepath.Join(tmpDir, "customers.csv")}, tmpDir)
+	wantNames := map[string]string{orders: "orders", eu: "eu_clients", filepath.Join(tmpDir, "customers.csv"): "customers_csv"}
+	if !reflect.DeepEqual(names, wantNames) {
+		t.Errorf("Expected %v for a duplicate sidecar name, got %v", wantNames, names)
+	}
+
+	// Unknown options are errors, not silently ignored
+	os.WriteFile(SidecarPath(orders), []byte("delimeter: \";\"\n"), 0644)
+	if _, err := ParseFile(orders, tmpDir); err == nil {
+		t.Error("Expected error for unknown sidecar option")
+	}
+}
+
+func TestDirectorySidecar(t *testing.T) {
+	tmpDir := t.TempDir()
+	a := filepath.Join(tmpDir, "a.csv")
+	b := filepath.Join(tmpDir, "b_raw.csv")
+	os.WriteFile(a, []byte("id,name\n1,ann\n"), 0644)
+	os.WriteFile(b, []byte("1|ann|-\n2|bob|x\n"), 0644)
+	os.WriteFile(filepath.Join(tmpDir, DirConfigName), []byte(`
+"*.csv":
+  null_values: ["-"]
+"*_raw.csv":
+  header: false
+  delimiter: "|"
+`), 0644)
+
+	cfg, err := ReadSidecar(b)
+	if err != nil {
+		t.Fatalf("ReadSidecar failed: %v", err)
+	}
+	if cfg.Delimiter != '|' || cfg.HasHeader == nil || *cfg.HasHeader || !reflect.DeepEqual(cfg.NullValues, []string{"-"}) {
+		t.Errorf("Expected both sections merged, got %+v", cfg)
+	}
+
+	// A file's own sidecar overrides the directory's
+	os.WriteFile(SidecarPath(b), []byte("null_values: [x]\n"), 0644)
+	parsed, err := ParseFile(b, tmpDir)
+	if err != nil {
+		t.Fatalf("ParseFile failed: %v", err)
+	}
+	want := [][]string{{"1", "ann", "-"}, {"2", "bob", ""}}
+	if !reflect.DeepEqual(parsed.Records, want) {
+		t.Errorf("Expected %v, got %v", want, parsed.Records)
+	}
+
+	parsed, err = ParseFile(a, tmpDir)
+	if err != nil {
+		t.Fatalf("ParseFile failed: %v", err)
+	}
+	if !parsed.Info.HasHeader || parsed.Info.Delimiter != ',' {
+		t.Errorf("Expected a.csv detected as usual, got %+v", parsed.Info)
+	}
+}
+
+func TestColumnNames(t *testing.T) {
+	tmpDir := t.TempDir()
+	dump := filepath.Join(tmpDir, "dump.csv")
+	os.WriteFile(dump, []byte("1,ann,9.5\n2,bob,3.5\n"), 0644)
+
+	r, err := OpenFile(dump, tmpDir)
+	if err != nil {
+		t.Fatalf("OpenFile failed: %v", err)
+	}
+	r.Close()
+	if r.Info.HasHeader || !reflect.DeepEqual(r.Info.Headers, []string{"c1", "c2", "c3"}) {
+		t.Errorf("Expected generated names for a headerless file, got %v", r.Info.Headers)
+	}
+
+	os.WriteFile(SidecarPath(dump), []byte("columns: [id, name]\n"), 0644)
+	r, err = OpenFile(dump, tmpDir)
+	if err != nil {
+		t.Fatalf("OpenFile failed: %v", err)
+	}
+	r.Close()
+	if !reflect.DeepEqual(r.Info.Headers, []string{"id", "name", "c3"}) {
+		t.Errorf("Expected sidecar names, got %v", r.Info.Headers)
+	}
+
+	// Options passed in override the sidecar, including forcing a header
+	hasHeader := true
+	r, err = OpenFileConfig(dump, tmpDir, FileConfig{HasHeader: &hasHeader, Columns: []string{"a", "b", "c"}})
+	if err != nil {
+		t.Fatalf("OpenFileConfig failed: %v", err)
+	}
+	batch, _ := r.ReadBatch()
+	r.Close()
+	if !reflect.DeepEqual(r.Info.Headers, []string{"a", "b", "c"}) || len(batch) != 1 {
+		t.Errorf("Expected header row replaced by a, b, c, got %v with %d rows", r.Info.Headers, len(batch))
+	}
+
+	if _, err := OpenFileConfig(dump, tmpDir, FileConfig{Columns: []string{"a", "b", "c", "d"}}); err == nil {
+		t.Error("Expected error for more names than columns")
+	}
+}
@@ -0,0 +1,251 @@
+package loader
+
+import (
+	"bytes"
+	"encoding/csv"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// SniffSize is the number of bytes sampled from the start of a file to detect its dialect
+const SniffSize = 64 * 1024
+
+// candidateDelimiters are tried in order of preference when sniffing
+var candidateDelimiters = []rune{',', ';', '\t', '|', ':'}
+
+// Dialect describes how a delimited text file is formatted
+type Dialect struct {
+	Delimiter rune
+	Quote     rune
+	HasHeader bool
+}
+
+// SniffDialect detects the delimiter and quote character from a sample of the
+// file. Every candidate is parsed and the one splitting the most lines into
+// the same number of fields (more than one), the first line included, wins;
+// ties go to defaultDelimiter,
+// then to the order of candidateDelimiters. HasHeader is not set here, see
+// DetectHeader.
+func SniffDialect(sample []byte, defaultDelimiter rune) Dialect {
+	// Drop a trailing partial line when the sample was cut short
+	if len(sample) >= SniffSize {
+		if i := bytes.LastIndexByte(sample, '\n'); i > 0 {
+			sample = sample[:i+1]
+		}
+	}
+
+	delimiters := []rune{defaultDelimiter}
+	for _, d := range candidateDelimiters {
+		if d != defaultDelimiter {
+			delimiters = append(delimiters, d)
+		}
+	}
+
+	best := Dialect{Delimiter: defaultDelimiter, Quote: '"'}
+	bestScore := 0.0
+	for _, quote := range []rune{'"', '\''} {
+		for _, delim := range delimiters {
+			score := consistency(sample, delim, quote)
+			if score > bestScore {
+				best = Dialect{Delimiter: delim, Quote: quote}
+				bestScore = score
+			}
+		}
+	}
+
+	// Equal scores keep the double quote unless fields are clearly single-quoted
+	if best.Quote == '"' && consistency(sample, best.Delimiter, '\'') == bestScore &&
+		countQuotedFields(sample, best.Delimiter, '\'') > countQuotedFields(sample, best.Delimiter, '"') {
+		best.Quote = '\''
+	}
+
+	return best
+}
+
+// consistency parses the sample and returns the fraction of records having
+// the most common field count, or 0 if that count is not above one or the
+// first record, usually the header, splits differently
+func consistency(sample []byte, delimiter, quote rune) float64 {
+	records := parseSample(sample, delimiter, quote)
+	if len(records) == 0 {
+		return 0
+	}
+
+	counts := make(map[int]int)
+	for _, record := range records {
+		counts[len(record)]++
+	}
+
+	modal, modalCount := 0, 0
+	for fields, n := range counts {
+		if n > modalCount || (n == modalCount && fields > modal) {
+			modal, modalCount = fields, n
+		}
+	}
+	if modal <= 1 || len(records[0]) != modal {
+		return 0
+	}
+	return float64(modalCount) / float64(len(records))
+}
+
+// countQuotedFields counts raw fields wrapped in the given quote character
+func countQuotedFields(sample []byte, delimiter, quote rune) int {
+	q := string(quote)
+	n := 0
+	for _, line := range strings.Split(string(sample), "\n") {
+		for _, field := range strings.Split(strings.TrimRight(line, "\r"), string(delimiter)) {
+			field = strings.TrimSpace(field)
+			if len(field) >= 2 && strings.HasPrefix(field, q) && strings.HasSuffix(field, q) {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// parseSample reads as many records as possible from the sample
+func parseSample(sample []byte, delimiter, quote rune) [][]string {
+	var src io.Reader = bytes.NewReader(sample)
+	if quote == '\'' {
+		src = newQuoteSwapReader(src)
+	}
+
+	reader := csv.NewReader(src)
+	reader.Comma = delimiter
+	reader.LazyQuotes = true
+	reader.FieldsPerRecord = -1
+
+	var records [][]string
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			continue
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+// DetectHeader decides whether first is a header row by comparing it with
+// the following records: a column whose data is numeric, boolean or a date
+// but whose first value is not of that type points to a header, while first
+// values matching their column's type, and as long as its values, point to
+// data. A matching value of another length, such as the year 2024 heading a
+// column of amounts, could be either, and keeps the header. When no column
+// is conclusive (all text), textHeaderVotes looks at uniqueness and
+// lengths, and if that is inconclusive too the first row is assumed to be a
+// header.
+func DetectHeader(first []string, records [][]string) bool {
+	if len(records) == 0 {
+		return true
+	}
+
+	types := InferColumnTypes(len(first), records)
+	headerVotes, dataVotes := 0, 0
+	for i, value := range first {
+		if types[i] == TypeText || value == "" {
+			continue
+		}
+		if widenType(types[i], detectValueType(value)) != types[i] {
+			headerVotes++
+			continue
+		}
+		if !lengthInColumn(value, records, i) {
+			return true
+		}
+		dataVotes++
+	}
+	if headerVotes == 0 && dataVotes == 0 {
+		headerVotes, dataVotes = textHeaderVotes(first, records)
+	}
+
+	return headerVotes >= dataVotes
+}
+
+// lengthInColumn reports whether a value is no shorter and no longer than
+// the values of column i
+func lengthInColumn(value string, records [][]string, i int) bool {
+	n := utf8.RuneCountInString(value)
+	shortest, longest := -1, -1
+	for _, record := range records {
+		if i >= len(record) || record[i] == "" {
+			continue
+		}
+		length := utf8.RuneCountInString(record[i])
+		if shortest < 0 || length < shortest {
+			shortest = length
+		}
+		longest = max(longest, length)
+	}
+	return shortest < 0 || (n >= shortest && n <= longest)
+}
+
+// textHeaderVotes weighs a first row of text: values that reappear in their
+// column point to data, while a value whose length differs from a column of
+// equal-length values (codes, ids) points to a header. Repeated values say
+// nothing, since exports often repeat column names.
+func textHeaderVotes(first []string, records [][]string) (headerVotes, dataVotes int) {
+	for i, value := range first {
+		if value == "" {
+			continue
+		}
+		length, sameLength, reappears := -1, true, false
+		for _, record := range records {
+			if i >= len(record) {
+				continue
+			}
+			reappears = reappears || record[i] == value
+			n := utf8.RuneCountInString(record[i])
+			if length >= 0 && n != length {
+				sameLength = false
+			}
+			length = n
+		}
+		switch {
+		case reappears:
+			dataVotes++
+		case sameLength && len(records) > 1 && length > 0 && utf8.RuneCountInString(value) != length:
+			headerVotes++
+		}
+	}
+	return headerVotes, dataVotes
+}
+
+// quoteSwapReader exchanges single and double quotes so encoding/csv, which
+// only understands double quotes, can parse single-quoted files. Parsed
+// fields are swapped back with swapQuotes.
+type quoteSwapReader struct {
+	r io.Reader
+}
+
+func newQuoteSwapReader(r io.Reader) io.Reader {
+	return &quoteSwapReader{r: r}
+}
+
+func (q *quoteSwapReader) Read(p []byte) (int, error) {
+	n, err := q.r.Read(p)
+	for i := 0; i < n; i++ {
+		switch p[i] {
+		case '"':
+			p[i] = '\''
+		case '\'':
+			p[i] = '"'
+		}
+	}
+	return n, err
+}
+
+// swapQuotes undoes the quote exchange of quoteSwapReader on parsed fields
+func swapQuotes(record []string) {
+	for i, field := range record {
+		if strings.ContainsAny(field, `"'`) {
+			record[i] = quoteSwapper.Replace(field)
+		}
+	}
+}
+
+var quoteSwapper = strings.NewReplacer(`"`, `'`, `'`, `"`)
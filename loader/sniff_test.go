@@ -0,0 +1,111 @@
+package loader
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSniffDialect(t *testing.T) {
+	tests := []struct {
+		name      string
+		sample    string
+		def       rune
+		delimiter rune
+		quote     rune
+	}{
+		{"comma", "id,name\n1,Alice\n2,Bob\n", ',', ',', '"'},
+		{"semicolon with decimal commas", "name;price\nA;1,50\nB;2,00\n", ',', ';', '"'},
+		{"tab in csv", "id\tname\n1\tAlice\n2\tBob\n", ',', '\t', '"'},
+		{"pipe", "id|name|city\n1|Alice|Rome\n2|Bob|Milan\n", ',', '|', '"'},
+		{"colon", "user:uid:shell\nroot:0:/bin/sh\nbin:1:/sbin/nologin\n", ',', ':', '"'},
+		{"times do not win over commas", "id,time\n1,10:30:00\n2,11:45:10\n", ',', ',', '"'},
+		{"single column keeps default", "value\na\nb\n", '\t', '\t', '"'},
+		{"single column of times", "time\n10:30\n11:45\n12:00\n", ',', ',', '"'},
+		{"double quoted", "a,b\n\"x, y\",1\n\"z\",2\n", ',', ',', '"'},
+		{"single quoted", "a,b\n'x, y',1\n'z',2\n", ',', ',', '\''},
+		{"single quoted, consistent either way", "'a','b'\n'c','d'\n", ',', ',', '\''},
+	}
+
+	for _, tt := range tests {
+		d := SniffDialect([]byte(tt.sample), tt.def)
+		if d.Delimiter != tt.delimiter || d.Quote != tt.quote {
+			t.Errorf("%s: got delimiter %q quote %q, want %q %q",
+				tt.name, d.Delimiter, d.Quote, tt.delimiter, tt.quote)
+		}
+	}
+}
+
+func TestDetectHeader(t *testing.T) {
+	tests := []struct {
+		name     string
+		first    []string
+		records  [][]string
+		expected bool
+	}{
+		{"named numeric column", []string{"id", "name"}, [][]string{{"1", "a"}, {"2", "b"}}, true},
+		{"all numeric", []string{"1", "2"}, [][]string{{"3", "4"}, {"5", "6"}}, false},
+		{"data with dates", []string{"7", "2024-01-01"}, [][]string{{"8", "2024-02-01"}}, false},
+		{"all text", []string{"col"}, [][]string{{"val"}}, true},
+		{"header only", []string{"a", "b"}, nil, true},
+		{"repeated names", []string{"name", "name"}, [][]string{{"bob", "eve"}, {"ann", "al"}}, true},
+		{"text reappears", []string{"IT", "ann"}, [][]string{{"HR", "bob"}, {"IT", "eve"}}, false},
+		{"fixed-length codes", []string{"country"}, [][]string{{"IT"}, {"FR"}}, true},
+		{"pivot header of years", []string{"region", "2023", "2024"}, [][]string{{"north", "150", "175"}, {"south", "90", "120"}}, true},
+		{"year column", []string{"2020", "10"}, [][]string{{"2021", "12"}, {"2022", "9"}}, false},
+	}
+
+	for _, tt := range tests {
+		if got := DetectHeader(tt.first, tt.records); got != tt.expected {
+			t.Errorf("%s: DetectHeader = %v, want %v", tt.name, got, tt.expected)
+		}
+	}
+}
+
+func TestParseFile_SniffedDialect(t *testing.T) {
+	tmpDir := t.TempDir()
+	csvPath := filepath.Join(tmpDir, "euro.csv")
+	os.WriteFile(csvPath, []byte("name;note\n'Rossi; Mario';'it''s'\n'Bianchi';'say \"hi\"'\n"), 0644)
+
+	parsed, err := ParseFile(csvPath, tmpDir)
+	if err != nil {
+		t.Fatalf("ParseFile failed: %v", err)
+	}
+
+	if parsed.Info.Delimiter != ';' || parsed.Info.Quote != '\'' {
+		t.Errorf("Expected ; and ' dialect, got %q %q", parsed.Info.Delimiter, parsed.Info.Quote)
+	}
+	if len(parsed.Records) != 2 {
+		t.Fatalf("Expected 2 records, got %d", len(parsed.Records))
+	}
+	if parsed.Records[0][0] != "Rossi; Mario" || parsed.Records[0][1] != "it's" {
+		t.Errorf("Unexpected first record: %q", parsed.Records[0])
+	}
+	if parsed.Records[1][1] != `say "hi"` {
+		t.Errorf("Expected double quotes preserved, got %q", parsed.Records[1][1])
+	}
+}
+
+func TestParseFile_NoHeader(t *testing.T) {
+	tmpDir := t.TempDir()
+	csvPath := filepath.Join(tmpDir, "nums.psv")
+	os.WriteFile(csvPath, []byte("1|2.5|x\n2|3.5|y\n"), 0644)
+
+	parsed, err := ParseFile(csvPath, tmpDir)
+	if err != nil {
+		t.Fatalf("ParseFile failed: %v", err)
+	}
+
+	if parsed.Info.HasHeader {
+		t.Error("Expected no header to be detected")
+	}
+	if len(parsed.Records) != 2 {
+		t.Errorf("Expected first row kept as data, got %d records", len(parsed.Records))
+	}
+	expected := []string{"c1", "c2", "c3"}
+	for i, h := range parsed.Info.Headers {
+		if h != expected[i] {
+			t.Errorf("Expected header %q, got %q", expected[i], h)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package loader
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ColumnType is the SQLite column type inferred for a CSV/TSV column
+type ColumnType string
+
+// Supported column types
+const (
+	TypeText     ColumnType = "TEXT"
+	TypeInteger  ColumnType = "INTEGER"
+	TypeReal     ColumnType = "REAL"
+	TypeBoolean  ColumnType = "BOOLEAN"
+	TypeDate     ColumnType = "DATE"
+	TypeDatetime ColumnType = "DATETIME"
+)
+
+// TypeSampleSize is the maximum number of non-empty values inspected per column
+const TypeSampleSize = 1000
+
+// dateLayout is the ISO-8601 calendar date format
+const dateLayout = "2006-01-02"
+
+// datetimeLayouts are the ISO-8601 date-time formats recognised during inference
+var datetimeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04",
+}
+
+// Storage formats for date-time values, matching what SQLite date functions accept
+const (
+	datetimeStoreLayout     = "2006-01-02 15:04:05.999999999"
+	datetimeStoreLayoutZone = "2006-01-02 15:04:05.999999999-07:00"
+)
+
+// InferColumnTypes samples the records and picks the narrowest type for each column.
+// Columns with no non-empty values in the sample default to TEXT.
+func InferColumnTypes(numColumns int, records [][]string) []ColumnType {
+	types := make([]ColumnType, numColumns)
+	for col := 0; col < numColumns; col++ {
+		var candidate ColumnType
+		sampled := 0
+		for _, record := range records {
+			if sampled >= TypeSampleSize {
+				break
+			}
+			if col >= len(record) || record[col] == "" {
+				continue
+			}
+			sampled++
+			candidate = widenType(candidate, detectValueType(record[col]))
+			if candidate == TypeText {
+				break
+			}
+		}
+		if candidate == "" {
+			candidate = TypeText
+		}
+		types[col] = candidate
+	}
+	return types
+}
+
+// detectValueType returns the narrowest type able to represent a single value
+func detectValueType(value string) ColumnType {
+	if !hasLeadingZero(value) {
+		if isInteger(value) {
+			return TypeInteger
+		}
+		if isReal(value) {
+			return TypeReal
+		}
+	}
+	if _, ok := parseBool(value); ok {
+		return TypeBoolean
+	}
+	if _, err := time.Parse(dateLayout, value); err == nil {
+		return TypeDate
+	}
+	if _, ok := parseDatetime(value); ok {
+		return TypeDatetime
+	}
+	return TypeText
+}
+
+// widenType merges the type seen so far with the type of a new value
+func widenType(current, next ColumnType) ColumnType {
+	switch {
+	case current == "" || current == next:
+		return next
+	case (current == TypeInteger && next == TypeReal) || (current == TypeReal && next == TypeInteger):
+		return TypeReal
+	case (current == TypeDate && next == TypeDatetime) || (current == TypeDatetime && next == TypeDate):
+		return TypeDatetime
+	default:
+		return TypeText
+	}
+}
+
+// hasLeadingZero reports whether a numeric-looking value starts with a
+// redundant zero (zip codes, identifiers), which must be kept as text
+func hasLeadingZero(value string) bool {
+	digits := strings.TrimLeft(value, "+-")
+	return len(digits) > 1 && digits[0] == '0' && digits[1] != '.'
+}
+
+// isInteger reports whether value is a plain integer
+func isInteger(value string) bool {
+	_, err := strconv.ParseInt(value, 10, 64)
+	return err == nil
+}
+
+// isReal reports whether value is a finite decimal number
+func isReal(value string) bool {
+	lower := strings.ToLower(value)
+	if strings.Contains(lower, "inf") || strings.Contains(lower, "nan") || strings.HasPrefix(lower, "0x") {
+		return false
+	}
+	_, err := strconv.ParseFloat(value, 64)
+	return err == nil
+}
+
+// parseBool accepts true/false in any letter case
+func parseBool(value string) (bool, bool) {
+	switch strings.ToLower(value) {
+	case "true":
+		return true, true
+	case "false":
+		return false, true
+	}
+	return false, false
+}
+
+// parseDatetime tries each supported ISO-8601 date-time layout
+func parseDatetime(value string) (time.Time, bool) {
+	for _, layout := range datetimeLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// ConvertValue converts a raw field into the Go value stored for the column type.
+// Empty fields become nil (NULL); values that do not fit the type are kept as text.
+func ConvertValue(value string, colType ColumnType) interface{} {
+	if value == "" {
+		return nil
+	}
+
+	switch colType {
+	case TypeInteger:
+		if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return n
+		}
+	case TypeReal:
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			return f
+		}
+	case TypeBoolean:
+		if b, ok := parseBool(value); ok {
+			return b
+		}
+	case TypeDatetime:
+		if _, err := time.Parse(dateLayout, value); err == nil {
+			return value + " 00:00:00"
+		}
+		if t, ok := parseDatetime(value); ok {
+			if hasZone(value) {
+				return t.Format(datetimeStoreLayoutZone)
+			}
+			return t.Format(datetimeStoreLayout)
+		}
+	}
+	return value
+}
+
+// hasZone reports whether a date-time string carries an explicit UTC offset
+func hasZone(value string) bool {
+	if strings.HasSuffix(value, "Z") {
+		return true
+	}
+	timePart := value[len(dateLayout):]
+	return strings.ContainsAny(timePart, "+-")
+}
@@ -0,0 +1,52 @@
+package loader
+
+import "testing"
+
+func TestInferColumnTypes(t *testing.T) {
+	records := [][]string{
+		{"1", "1.5", "true", "2024-01-15", "2024-01-15T10:30:00Z", "007", "abc", ""},
+		{"2", "2", "False", "2024-02-01", "2024-01-15", "008", "1", ""},
+		{"", "-3e2", "", "", "2024-01-16 08:00:00", "009", "", ""},
+	}
+
+	expected := []ColumnType{
+		TypeInteger, TypeReal, TypeBoolean, TypeDate, TypeDatetime, TypeText, TypeText, TypeText,
+	}
+
+	result := InferColumnTypes(len(expected), records)
+	for i, want := range expected {
+		if result[i] != want {
+			t.Errorf("column %d: got %s, want %s", i, result[i], want)
+		}
+	}
+}
+
+func TestConvertValue(t *testing.T) {
+	tests := []struct {
+		value    string
+		colType  ColumnType
+		expected interface{}
+	}{
+		{"", TypeText, nil},
+		{"", TypeInteger, nil},
+		{"42", TypeInteger, int64(42)},
+		{"1.25", TypeReal, 1.25},
+		{"10", TypeReal, float64(10)},
+		{"TRUE", TypeBoolean, true},
+		{"false", TypeBoolean, false},
+		{"2024-01-15", TypeDate, "2024-01-15"},
+		{"2024-01-15", TypeDatetime, "2024-01-15 00:00:00"},
+		{"2024-01-15T10:30:00", TypeDatetime, "2024-01-15 10:30:00"},
+		{"2024-01-15T10:30:00+02:00", TypeDatetime, "2024-01-15 10:30:00+02:00"},
+		{"hello", TypeText, "hello"},
+		{"oops", TypeInteger, "oops"},
+	}
+
+	for _, tt := range tests {
+		result := ConvertValue(tt.value, tt.colType)
+		if result != tt.expected {
+			t.Errorf("ConvertValue(%q, %s) = %#v, want %#v",
+				tt.value, tt.colType, result, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package loader
+
+import (
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// SourceFileColumn holds the path, relative to the root directory, of the
+// file each row of a union table came from
+const SourceFileColumn = "_source_file"
+
+// UnionRule unions every file matching Pattern (see MatchPath) into Table
+type UnionRule struct {
+	Table   string
+	Pattern string
+}
+
+// FileSet is a group of files loaded into a single table: either the files
+// matching a UnionRule or a Hive-style partitioned directory such as
+// events/year=2024/month=03/part-0.csv
+type FileSet struct {
+	Key   string   // path recorded for the table: the dataset directory or the rule pattern
+	Table string   // table name from a rule; empty for partitioned directories
+	Dir   string   // directory below which key=value segments are partitions
+	Files []string // sorted member files
+}
+
+// GroupFiles splits scanned files into files loaded as their own table and
+// file sets. Files matching a rule join that rule's set; otherwise a file
+// below a key=value directory joins the set of the directory above the
+// first such segment.
+func GroupFiles(files []string, rootDir string, rules []UnionRule) ([]string, []*FileSet) {
+	var singles []string
+	var sets []*FileSet
+	byKey := make(map[string]*FileSet)
+
+	add := func(key, table, dir, file string) {
+		set, ok := byKey[key]
+		if !ok {
+			set = &FileSet{Key: key, Table: table, Dir: dir}
+			byKey[key] = set
+			sets = append(sets, set)
+		}
+		set.Files = append(set.Files, file)
+	}
+
+	for _, file := range files {
+		if rule, ok := matchRule(rules, file, rootDir); ok {
+			add(filepath.Join(rootDir, filepath.FromSlash(rule.Pattern)), rule.Table, rootDir, file)
+			continue
+		}
+		if dir, ok := partitionedDir(file, rootDir); ok {
+			add(dir, "", dir, file)
+			continue
+		}
+		singles = append(singles, file)
+	}
+
+	for _, set := range sets {
+		sort.Strings(set.Files)
+	}
+	return singles, sets
+}
+
+// matchRule returns the first rule whose pattern matches the file
+func matchRule(rules []UnionRule, file, rootDir string) (UnionRule, bool) {
+	for _, rule := range rules {
+		if MatchPath(rule.Pattern, file, rootDir) {
+			return rule, true
+		}
+	}
+	return UnionRule{}, false
+}
+
+// partitionedDir returns the directory above the first key=value directory
+// segment of a file's path, relative to rootDir
+func partitionedDir(file, rootDir string) (string, bool) {
+	rel, err := filepath.Rel(rootDir, filepath.Dir(file))
+	if err != nil || rel == "." {
+		return "", false
+	}
+
+	segments := strings.Split(filepath.ToSlash(rel), "/")
+	for i, segment := range segments {
+		if _, _, ok := parsePartition(segment); ok {
+			return filepath.Join(rootDir, filepath.FromSlash(strings.Join(segments[:i], "/"))), true
+		}
+	}
+	return "", false
+}
+
+// parsePartition splits a key=value directory name
+func parsePartition(segment string) (key, value string, ok bool) {
+	key, value, ok = strings.Cut(segment, "=")
+	return key, value, ok && key != ""
+}
+
+// Partitions returns the key=value pairs in the directories between the set's
+// directory and the file, outermost first
+func (s *FileSet) Partitions(file string) (keys, values []string) {
+	rel, err := filepath.Rel(s.Dir, filepath.Dir(file))
+	if err != nil || rel == "." {
+		return nil, nil
+	}
+	for _, segment := range strings.Split(filepath.ToSlash(rel), "/") {
+		if key, value, ok := parsePartition(segment); ok {
+			keys = append(keys, key)
+			values = append(values, value)
+		}
+	}
+	return keys, values
+}
+
+// SourceFile returns the value of SourceFileColumn for a file
+func SourceFile(file, rootDir string) string {
+	rel, err := filepath.Rel(rootDir, file)
+	if err != nil {
+		return filepath.ToSlash(file)
+	}
+	return path.Clean(filepath.ToSlash(rel))
+}
+
+// ResolveSetNames extends ResolveTableNames to file sets: rule sets take the
+// rule's table name, which files then conflict with as with a sidecar name,
+// and partitioned directories are named like a file at the directory's path.
+// The returned map is keyed by file path or set Key.
+func ResolveSetNames(singles []string, sets []*FileSet, rootDir string) map[string]string {
+	paths := append([]string(nil), singles...)
+	ruleNames := make(map[string]string)
+	for _, set := range sets {
+		if set.Table != "" {
+			ruleNames[set.Key] = sanitizeTableName(set.Table)
+		} else {
+			paths = append(paths, set.Key)
+		}
+	}
+	return resolveTableNames(paths, rootDir, ruleNames)
+}
@@ -0,0 +1,79 @@
+package loader
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestGroupFiles(t *testing.T) {
+	root := filepath.Join("data")
+	files := []string{
+		filepath.Join(root, "users.csv"),
+		filepath.Join(root, "sales", "2024-02.csv"),
+		filepath.Join(root, "sales", "2024-01.csv"),
+		filepath.Join(root, "events", "year=2024", "month=03", "part-0.csv"),
+		filepath.Join(root, "events", "year=2023", "month=12", "part-0.csv"),
+	}
+	rules := []UnionRule{{Table: "Sales", Pattern: "sales/*.csv"}}
+
+	singles, sets := GroupFiles(files, root, rules)
+
+	if len(singles) != 1 || singles[0] != files[0] {
+		t.Errorf("Expected only users.csv as a single file, got %v", singles)
+	}
+	if len(sets) != 2 {
+		t.Fatalf("Expected 2 file sets, got %d", len(sets))
+	}
+
+	sales, events := sets[0], sets[1]
+	if sales.Table != "Sales" || len(sales.Files) != 2 || sales.Files[0] != files[2] {
+		t.Errorf("Unexpected rule set: %+v", sales)
+	}
+	if events.Key != filepath.Join(root, "events") || len(events.Files) != 2 {
+		t.Errorf("Unexpected partitioned set: %+v", events)
+	}
+
+	keys, values := events.Partitions(files[3])
+	if len(keys) != 2 || keys[0] != "year" || values[0] != "2024" || keys[1] != "month" || values[1] != "03" {
+		t.Errorf("Partitions = %v %v", keys, values)
+	}
+	if keys, _ := sales.Partitions(files[1]); len(keys) != 0 {
+		t.Errorf("Rule set without key=value directories should have no partitions, got %v", keys)
+	}
+
+	names := ResolveSetNames(singles, sets, root)
+	if names[files[0]] != "users" || names[sales.Key] != "sales" || names[events.Key] != "events" {
+		t.Errorf("Unexpected names: %v", names)
+	}
+
+	if got := SourceFile(files[3], root); got != "events/year=2024/month=03/part-0.csv" {
+		t.Errorf("SourceFile = %q", got)
+	}
+}
+
+func TestResolveSetNames_RuleConflicts(t *testing.T) {
+	root := filepath.Join("data")
+	files := []string{
+		filepath.Join(root, "sales.csv"),
+		filepath.Join(root, "archive", "sales.csv"),
+		filepath.Join(root, "s", "2024-01.csv"),
+		filepath.Join(root, "s", "2024-02.csv"),
+	}
+	singles, sets := GroupFiles(files, root, []UnionRule{{Table: "sales", Pattern: "s/*.csv"}})
+
+	// Files named like a rule's table give way to it, as to a sidecar name
+	names := ResolveSetNames(singles, sets, root)
+	want := map[string]string{
+		files[0]:    "sales_csv",
+		files[1]:    "archive_sales",
+		sets[0].Key: "sales",
+	}
+	if len(names) != len(want) {
+		t.Errorf("Expected %v, got %v", want, names)
+	}
+	for key, name := range want {
+		if names[key] != name {
+			t.Errorf("Expected %s for %s, got %q", name, key, names[key])
+		}
+	}
+}
@@ -0,0 +1,537 @@
+package loader
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// workbookExtension is the Excel workbook suffix; workbooks are scanned like
+// archives, with one member per worksheet
+const workbookExtension = ".xlsx"
+
+// isWorkbook reports whether an archive path is an Excel workbook
+func isWorkbook(archivePath string) bool {
+	return archiveExtension(archivePath) == workbookExtension
+}
+
+// workbook is an open .xlsx file with the parts needed to read cell values
+type workbook struct {
+	zip           *zip.ReadCloser
+	sheets        []workbookSheet
+	sharedStrings []string
+	dateStyles    []bool // by cell style index: whether the number format is a date or time
+	date1904      bool
+}
+
+// workbookSheet is a worksheet name and the zip entry holding its cells
+type workbookSheet struct {
+	name string
+	file *zip.File
+}
+
+// openWorkbook opens a workbook and reads its sheet list, shared strings and styles
+func openWorkbook(filePath string) (*workbook, error) {
+	zr, err := zip.OpenReader(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open workbook %s: %w", filePath, err)
+	}
+	wb := &workbook{zip: zr}
+
+	if err := wb.readSheets(); err != nil {
+		zr.Close()
+		return nil, fmt.Errorf("failed to read workbook %s: %w", filePath, err)
+	}
+	if err := wb.readSharedStrings(); err != nil {
+		zr.Close()
+		return nil, fmt.Errorf("failed to read shared strings of %s: %w", filePath, err)
+	}
+	if err := wb.readStyles(); err != nil {
+		zr.Close()
+		return nil, fmt.Errorf("failed to read styles of %s: %w", filePath, err)
+	}
+	return wb, nil
+}
+
+// Close closes the underlying zip file
+func (wb *workbook) Close() error {
+	return wb.zip.Close()
+}
+
+// part returns a zip entry by name, or nil
+func (wb *workbook) part(name string) *zip.File {
+	for _, f := range wb.zip.File {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// decodePart unmarshals an XML part into v; missing parts leave v unchanged
+func (wb *workbook) decodePart(name string, v interface{}) error {
+	f := wb.part(name)
+	if f == nil {
+		return nil
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return xml.NewDecoder(rc).Decode(v)
+}
+
+// readSheets resolves the worksheets listed in xl/workbook.xml to their parts
+func (wb *workbook) readSheets() error {
+	var doc struct {
+		Properties struct {
+			Date1904 string `xml:"date1904,attr"`
+		} `xml:"workbookPr"`
+		Sheets []struct {
+			Name  string     `xml:"name,attr"`
+			Attrs []xml.Attr `xml:",any,attr"`
+		} `xml:"sheets>sheet"`
+	}
+	if err := wb.decodePart("xl/workbook.xml", &doc); err != nil {
+		return err
+	}
+	wb.date1904 = doc.Properties.Date1904 == "1" || doc.Properties.Date1904 == "true"
+
+	var rels struct {
+		Relationships []struct {
+			ID     string `xml:"Id,attr"`
+			Target string `xml:"Target,attr"`
+		} `xml:"Relationship"`
+	}
+	if err := wb.decodePart("xl/_rels/workbook.xml.rels", &rels); err != nil {
+		return err
+	}
+	targets := make(map[string]string)
+	for _, rel := range rels.Relationships {
+		target := rel.Target
+		if strings.HasPrefix(target, "/") {
+			target = strings.TrimPrefix(target, "/")
+		} else {
+			target = path.Join("xl", target)
+		}
+		targets[rel.ID] = target
+	}
+
+	for _, sheet := range doc.Sheets {
+		// r:id, whatever prefix the relationships namespace was given
+		var id string
+		for _, attr := range sheet.Attrs {
+			if attr.Name.Local == "id" && attr.Name.Space != "" {
+				id = attr.Value
+			}
+		}
+		if f := wb.part(targets[id]); f != nil {
+			wb.sheets = append(wb.sheets, workbookSheet{name: sheet.Name, file: f})
+		}
+	}
+	return nil
+}
+
+// readSharedStrings loads xl/sharedStrings.xml, joining rich text runs and
+// skipping phonetic hints
+func (wb *workbook) readSharedStrings() error {
+	f := wb.part("xl/sharedStrings.xml")
+	if f == nil {
+		return nil
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	dec := xml.NewDecoder(rc)
+	var current strings.Builder
+	inText, inPhonetic := false, false
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "si":
+				current.Reset()
+			case "t":
+				inText = true
+			case "rPh":
+				inPhonetic = true
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "si":
+				wb.sharedStrings = append(wb.sharedStrings, current.String())
+			case "t":
+				inText = false
+			case "rPh":
+				inPhonetic = false
+			}
+		case xml.CharData:
+			if inText && !inPhonetic {
+				current.Write(t)
+			}
+		}
+	}
+}
+
+// readStyles marks the cell styles whose number format shows a date or time
+func (wb *workbook) readStyles() error {
+	var doc struct {
+		NumFmts []struct {
+			ID   int    `xml:"numFmtId,attr"`
+			Code string `xml:"formatCode,attr"`
+		} `xml:"numFmts>numFmt"`
+		CellXfs []struct {
+			NumFmtID int `xml:"numFmtId,attr"`
+		} `xml:"cellXfs>xf"`
+	}
+	if err := wb.decodePart("xl/styles.xml", &doc); err != nil {
+		return err
+	}
+
+	custom := make(map[int]string)
+	for _, nf := range doc.NumFmts {
+		custom[nf.ID] = nf.Code
+	}
+
+	wb.dateStyles = make([]bool, len(doc.CellXfs))
+	for i, xf := range doc.CellXfs {
+		if code, ok := custom[xf.NumFmtID]; ok {
+			wb.dateStyles[i] = isDateFormat(code)
+		} else {
+			wb.dateStyles[i] = isBuiltinDateFormat(xf.NumFmtID)
+		}
+	}
+	return nil
+}
+
+// isBuiltinDateFormat reports whether a built-in number format id is a date or time
+func isBuiltinDateFormat(id int) bool {
+	return (id >= 14 && id <= 22) || (id >= 27 && id <= 36) || (id >= 45 && id <= 47) || (id >= 50 && id <= 58)
+}
+
+// isDateFormat reports whether a custom number format code shows a date or
+// time, ignoring quoted literals, escaped characters and [colour] sections
+func isDateFormat(code string) bool {
+	inQuote, inBracket := false, false
+	for i := 0; i < len(code); i++ {
+		c := code[i]
+		switch {
+		case inQuote:
+			inQuote = c != '"'
+		case inBracket:
+			inBracket = c != ']'
+		case c == '"':
+			inQuote = true
+		case c == '[':
+			inBracket = true
+		case c == '\\' || c == '_' || c == '*':
+			i++
+		case strings.IndexByte("ymdhsYMDHS", c) >= 0:
+			return true
+		}
+	}
+	return false
+}
+
+// listSheets returns the names of the worksheets that hold at least one value
+func (wb *workbook) listSheets() ([]string, error) {
+	var names []string
+	for _, sheet := range wb.sheets {
+		rows, err := wb.openSheet(sheet.name)
+		if err != nil {
+			return nil, err
+		}
+		_, err = rows.next()
+		rows.Close()
+		if err == io.EOF {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read sheet %s: %w", sheet.name, err)
+		}
+		names = append(names, sheet.name)
+	}
+	return names, nil
+}
+
+// ListWorkbook returns one path per non-empty worksheet of a workbook,
+// joined to the workbook path as if it were a directory
+func ListWorkbook(filePath string) ([]string, error) {
+	wb, err := openWorkbook(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer wb.Close()
+
+	names, err := wb.listSheets()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read workbook %s: %w", filePath, err)
+	}
+
+	paths := make([]string, len(names))
+	for i, name := range names {
+		paths[i] = filepath.Join(filePath, name)
+	}
+	return paths, nil
+}
+
+// sheetRows streams the rows of a worksheet as text values
+type sheetRows struct {
+	wb   *workbook
+	rc   io.ReadCloser
+	dec  *xml.Decoder
+	size int64
+	row  int // number of the last row returned
+}
+
+// openSheet starts streaming a worksheet by name
+func (wb *workbook) openSheet(name string) (*sheetRows, error) {
+	for _, sheet := range wb.sheets {
+		if sheet.name != name {
+			continue
+		}
+		rc, err := sheet.file.Open()
+		if err != nil {
+			return nil, err
+		}
+		return &sheetRows{wb: wb, rc: rc, dec: xml.NewDecoder(rc), size: int64(sheet.file.UncompressedSize64)}, nil
+	}
+	return nil, fmt.Errorf("sheet %s not found", name)
+}
+
+// Close closes the worksheet stream
+func (s *sheetRows) Close() error {
+	return s.rc.Close()
+}
+
+// next returns the values of the next row that has any, with trailing empty
+// cells dropped, or io.EOF after the last row
+func (s *sheetRows) next() ([]string, error) {
+	for {
+		tok, err := s.dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok || start.Name.Local != "row" {
+			continue
+		}
+
+		s.row++
+		if r := attr(start, "r"); r != "" {
+			if n, err := strconv.Atoi(r); err == nil {
+				s.row = n
+			}
+		}
+
+		values, err := s.readRow()
+		if err != nil {
+			return nil, err
+		}
+		for len(values) > 0 && values[len(values)-1] == "" {
+			values = values[:len(values)-1]
+		}
+		if len(values) > 0 {
+			return values, nil
+		}
+	}
+}
+
+// readRow reads the cells of a <row> element, placing each by its reference
+func (s *sheetRows) readRow() ([]string, error) {
+	var values []string
+	col := -1
+	for {
+		tok, err := s.dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.EndElement:
+			if t.Name.Local == "row" {
+				return values, nil
+			}
+		case xml.StartElement:
+			if t.Name.Local != "c" {
+				continue
+			}
+			col++
+			if ref := attr(t, "r"); ref != "" {
+				col = columnIndex(ref)
+			}
+			value, err := s.readCell(t)
+			if err != nil {
+				return nil, err
+			}
+			for len(values) <= col {
+				values = append(values, "")
+			}
+			values[col] = value
+		}
+	}
+}
+
+// cellXML is the content of a <c> element
+type cellXML struct {
+	Type   string `xml:"t,attr"`
+	Style  int    `xml:"s,attr"`
+	Value  string `xml:"v"`
+	Inline struct {
+		Text []string `xml:"t"`
+		Runs []string `xml:"r>t"`
+	} `xml:"is"`
+}
+
+// readCell converts a cell to the text its column type is inferred from:
+// shared and inline strings, TRUE/FALSE as true/false, numbers as written,
+// numbers in a date format as ISO-8601 and errors as empty (NULL)
+func (s *sheetRows) readCell(start xml.StartElement) (string, error) {
+	var c cellXML
+	if err := s.dec.DecodeElement(&c, &start); err != nil {
+		return "", err
+	}
+
+	switch c.Type {
+	case "s":
+		i, err := strconv.Atoi(c.Value)
+		if err != nil || i < 0 || i >= len(s.wb.sharedStrings) {
+			return "", nil
+		}
+		return s.wb.sharedStrings[i], nil
+	case "inlineStr":
+		return strings.Join(c.Inline.Text, "") + strings.Join(c.Inline.Runs, ""), nil
+	case "b":
+		if c.Value == "1" {
+			return "true", nil
+		}
+		return "false", nil
+	case "e":
+		return "", nil
+	case "str", "d":
+		return c.Value, nil
+	}
+
+	if c.Value != "" && c.Style >= 0 && c.Style < len(s.wb.dateStyles) && s.wb.dateStyles[c.Style] {
+		if serial, err := strconv.ParseFloat(c.Value, 64); err == nil {
+			return formatSerialDate(serial, s.wb.date1904), nil
+		}
+	}
+	return c.Value, nil
+}
+
+// formatSerialDate converts an Excel date serial number to ISO-8601: a date,
+// a date and time, or a time of day for values below one day
+func formatSerialDate(serial float64, date1904 bool) string {
+	epoch := time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+	if date1904 {
+		epoch = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+
+	days := math.Floor(serial)
+	seconds := math.Round((serial - days) * 86400)
+	t := epoch.AddDate(0, 0, int(days)).Add(time.Duration(seconds) * time.Second)
+
+	switch {
+	case serial < 1 && !date1904:
+		return t.Format("15:04:05")
+	case seconds == 0:
+		return t.Format("2006-01-02")
+	}
+	return t.Format("2006-01-02 15:04:05")
+}
+
+// columnIndex converts the letters of a cell reference such as "BC12" to a
+// zero-based column index
+func columnIndex(ref string) int {
+	n := 0
+	for _, c := range ref {
+		if c < 'A' || c > 'Z' {
+			break
+		}
+		n = n*26 + int(c-'A'+1)
+	}
+	return n - 1
+}
+
+// attr returns the value of an attribute by local name
+func attr(start xml.StartElement, name string) string {
+	for _, a := range start.Attr {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// openSheetStream opens a worksheet for streaming. Like archive members,
+// sheets take the workbook's modification time.
+func openSheetStream(filePath, workbookPath, sheetName string) (*Reader, error) {
+	modTime, err := FileModTime(workbookPath)
+	if err != nil {
+		return nil, err
+	}
+	wb, err := openWorkbook(workbookPath)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := wb.openSheet(sheetName)
+	if err != nil {
+		wb.Close()
+		return nil, fmt.Errorf("failed to open %s: %w", filePath, err)
+	}
+
+	r := &Reader{
+		file:   &multiCloser{closers: []io.Closer{rows, wb}},
+		next:   rows.next,
+		offset: rows.dec.InputOffset,
+		size:   rows.size,
+	}
+	r.Info.Path = filePath
+	r.Info.Encoding = EncodingUTF8
+	r.Info.ModTime = modTime
+	return r, nil
+}
+
+// openSheet reads the first non-empty row of a worksheet as the header and a
+// sample of rows to infer column types
+func (r *Reader) openSheet() error {
+	filePath := r.Info.Path
+
+	headers, err := r.next()
+	if err == io.EOF {
+		return fmt.Errorf("%w: %s", ErrEmptyFile, filePath)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to parse file %s: %w", filePath, err)
+	}
+
+	sample, err := r.sample()
+	if err != nil {
+		return err
+	}
+
+	r.Info.HasHeader = true
+	r.Info.Headers = headers
+	r.Info.ColumnTypes = InferColumnTypes(len(headers), sample)
+	r.pending = sample
+	return nil
+}
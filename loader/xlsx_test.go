@@ -0,0 +1,119 @@
+package loader
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeXLSX creates a workbook with one worksheet per sheetData body, in
+// order, plus shared strings and a date style (s="1") and a time style (s="2")
+func writeXLSX(t *testing.T, path string, names []string, sheets []string, shared []string) {
+	t.Helper()
+	var list, rels strings.Builder
+	parts := make(map[string]string)
+	for i, name := range names {
+		fmt.Fprintf(&list, `<sheet name="%s" sheetId="%d" r:id="rId%d"/>`, name, i+1, i+1)
+		fmt.Fprintf(&rels, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet%d.xml"/>`, i+1, i+1)
+		parts[fmt.Sprintf("xl/worksheets/sheet%d.xml", i+1)] = `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>` + sheets[i] + `</sheetData></worksheet>`
+	}
+	parts["xl/workbook.xml"] = `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets>` + list.String() + `</sheets></workbook>`
+	parts["xl/_rels/workbook.xml.rels"] = `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` + rels.String() + `</Relationships>`
+	parts["xl/styles.xml"] = `<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><numFmts><numFmt numFmtId="164" formatCode="hh:mm;@"/></numFmts><cellXfs><xf numFmtId="0"/><xf numFmtId="14"/><xf numFmtId="164"/></cellXfs></styleSheet>`
+
+	var sst strings.Builder
+	for _, s := range shared {
+		fmt.Fprintf(&sst, `<si><t>%s</t></si>`, s)
+	}
+	parts["xl/sharedStrings.xml"] = `<sst xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` + sst.String() + `</sst>`
+	writeZip(t, path, parts)
+}
+
+func TestWorkbook(t *testing.T) {
+	tmpDir := t.TempDir()
+	book := filepath.Join(tmpDir, "book.xlsx")
+
+	orders := `<row r="2"><c r="A2" s="3"/></row>` + // formatting only: skipped
+		`<row r="3"><c r="A3" t="s"><v>0</v></c><c r="B3" t="s"><v>1</v></c><c r="C3" t="s"><v>2</v></c><c r="D3" t="inlineStr"><is><t>paid</t></is></c></row>` +
+		`<row r="4"><c r="A4"><v>1</v></c><c r="B4" s="1"><v>45306</v></c><c r="C4"><v>9.5</v></c><c r="D4" t="b"><v>1</v></c></row>` +
+		`<row r="5"><c r="A5"><v>2</v></c><c r="C5" t="e"><v>#DIV/0!</v></c><c r="D5" t="b"><v>0</v></c></row>`
+	writeXLSX(t, book, []string{"Q1.2024", "Empty"}, []string{orders, `<row r="1"><c r="A1" s="2"/></row>`}, []string{"id", "date", "amount"})
+
+	files, err := ListArchive(book)
+	if err != nil {
+		t.Fatalf("ListArchive failed: %v", err)
+	}
+	if len(files) != 1 || files[0] != filepath.Join(book, "Q1.2024") {
+		t.Fatalf("Expected only the non-empty sheet, got %v", files)
+	}
+	if name := GetFullTableName(files[0], tmpDir); name != "book_q1_2024" {
+		t.Errorf("Expected table book_q1_2024, got %s", name)
+	}
+
+	r, err := OpenFile(files[0], tmpDir)
+	if err != nil {
+		t.Fatalf("OpenFile failed: %v", err)
+	}
+	defer r.Close()
+
+	if !reflect.DeepEqual(r.Info.Headers, []string{"id", "date", "amount", "paid"}) {
+		t.Errorf("Unexpected headers: %v", r.Info.Headers)
+	}
+	if !reflect.DeepEqual(r.Info.ColumnTypes, []ColumnType{TypeInteger, TypeDate, TypeReal, TypeBoolean}) {
+		t.Errorf("Unexpected column types: %v", r.Info.ColumnTypes)
+	}
+
+	batch, err := r.ReadBatch()
+	if err != nil {
+		t.Fatalf("ReadBatch failed: %v", err)
+	}
+	want := [][]string{{"1", "2024-01-15", "9.5", "true"}, {"2", "", "", "false"}}
+	if !reflect.DeepEqual(batch, want) {
+		t.Errorf("Expected %v, got %v", want, batch)
+	}
+	if _, err := r.ReadBatch(); err != io.EOF {
+		t.Errorf("Expected EOF, got %v", err)
+	}
+}
+
+func TestFormatSerialDate(t *testing.T) {
+	tests := []struct {
+		serial   float64
+		date1904 bool
+		want     string
+	}{
+		{45306, false, "2024-01-15"},
+		{45306.75, false, "2024-01-15 18:00:00"},
+		{0.5, false, "12:00:00"},
+		{61, false, "1900-03-01"},
+		{0, true, "1904-01-01"},
+	}
+	for _, tt := range tests {
+		if got := formatSerialDate(tt.serial, tt.date1904); got != tt.want {
+			t.Errorf("formatSerialDate(%v, %v) = %s, want %s", tt.serial, tt.date1904, got, tt.want)
+		}
+	}
+}
+
+func TestIsDateFormat(t *testing.T) {
+	tests := map[string]bool{
+		"yyyy-mm-dd":          true,
+		"hh:mm;@":             true,
+		"#,##0.00":            false,
+		`0.0" days"`:          false,
+		"[Red]#,##0":          false,
+		`#,##0.00\ "h"`:       false,
+		"General":             false,
+		"[$-409]d-mmm-yy;@":   true,
+		`_("$"* #,##0_)`:      false,
+		"dd/mm/yyyy hh:mm:ss": true,
+	}
+	for code, want := range tests {
+		if got := isDateFormat(code); got != want {
+			t.Errorf("isDateFormat(%q) = %v, want %v", code, got, want)
+		}
+	}
+}
@@ -0,0 +1,441 @@
+// Package output renders query results in text, tabular and machine-readable formats
+package output
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/mattn/go-runewidth"
+)
+
+// Writer renders a result set: the header once, then each row, then Flush
+type Writer interface {
+	WriteHeader(columns []string) error
+	WriteRow(values []interface{}) error
+	Flush() error
+}
+
+// Options control how results are rendered
+type Options struct {
+	// Header includes column names in the output. For json and ndjson,
+	// disabling it emits rows as arrays instead of objects.
+	Header bool
+}
+
+// nullText is how NULL is displayed by the human-readable formats
+const nullText = "NULL"
+
+// formats maps format names to their constructors
+var formats = map[string]func(w io.Writer, opts Options) Writer{
+	"table":    newTableWriter,
+	"list":     newListWriter,
+	"line":     newLineWriter,
+	"box":      newBoxWriter,
+	"csv":      func(w io.Writer, opts Options) Writer { return newCSVWriter(w, opts, ',') },
+	"tsv":      func(w io.Writer, opts Options) Writer { return newCSVWriter(w, opts, '\t') },
+	"json":     func(w io.Writer, opts Options) Writer { return newJSONWriter(w, opts, false) },
+	"ndjson":   func(w io.Writer, opts Options) Writer { return newJSONWriter(w, opts, true) },
+	"markdown": newMarkdownWriter,
+	"html":     newHTMLWriter,
+	"parquet":  newParquetWriter,
+}
+
+// Formats returns the supported format names
+func Formats() []string {
+	return []string{"table", "list", "line", "box", "csv", "tsv", "json", "ndjson", "markdown", "html", "parquet"}
+}
+
+// New creates a Writer for the named format
+func New(format string, w io.Writer, opts Options) (Writer, error) {
+	constructor, ok := formats[format]
+	if !ok {
+		return nil, fmt.Errorf("unknown format %q (%s)", format, strings.Join(Formats(), ", "))
+	}
+	return constructor(w, opts), nil
+}
+
+// Write renders a complete, already materialised result set
+func Write(w Writer, columns []string, rows [][]interface{}) error {
+	if err := w.WriteHeader(columns); err != nil {
+		return err
+	}
+	for _, row := range rows {
+		if err := w.WriteRow(row); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+// text renders a value for the human-readable formats
+func text(v interface{}, null string) string {
+	if v == nil {
+		return null
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+// textRow renders a row of values as strings
+func textRow(values []interface{}, null string) []string {
+	row := make([]string, len(values))
+	for i, v := range values {
+		row[i] = text(v, null)
+	}
+	return row
+}
+
+// tableWriter is the aligned grid with a dashed separator and row count
+type tableWriter struct {
+	out     io.Writer
+	tw      *tabwriter.Writer
+	opts    Options
+	columns []string
+	count   int
+}
+
+func newTableWriter(w io.Writer, opts Options) Writer {
+	return &tableWriter{out: w, tw: tabwriter.NewWriter(w, 0, 0, 2, ' ', 0), opts: opts}
+}
+
+func (t *tableWriter) WriteHeader(columns []string) error {
+	t.columns = columns
+	return nil
+}
+
+func (t *tableWriter) WriteRow(values []interface{}) error {
+	if t.count == 0 && t.opts.Header {
+		fmt.Fprintln(t.tw, strings.Join(t.columns, "\t"))
+		sep := make([]string, len(t.columns))
+		for i := range sep {
+			sep[i] = strings.Repeat("-", len(t.columns[i]))
+		}
+		fmt.Fprintln(t.tw, strings.Join(sep, "\t"))
+	}
+	t.count++
+	_, err := fmt.Fprintln(t.tw, strings.Join(textRow(values, nullText), "\t"))
+	return err
+}
+
+func (t *tableWriter) Flush() error {
+	if t.count == 0 {
+		_, err := fmt.Fprintln(t.out, "(no results)")
+		return err
+	}
+	if err := t.tw.Flush(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(t.out, "\n(%d rows)\n", t.count)
+	return err
+}
+
+// listWriter prints pipe-separated values like the sqlite3 shell
+type listWriter struct {
+	out  io.Writer
+	opts Options
+}
+
+func newListWriter(w io.Writer, opts Options) Writer {
+	return &listWriter{out: w, opts: opts}
+}
+
+func (l *listWriter) WriteHeader(columns []string) error {
+	if !l.opts.Header {
+		return nil
+	}
+	_, err := fmt.Fprintln(l.out, strings.Join(columns, "|"))
+	return err
+}
+
+func (l *listWriter) WriteRow(values []interface{}) error {
+	_, err := fmt.Fprintln(l.out, strings.Join(textRow(values, nullText), "|"))
+	return err
+}
+
+func (l *listWriter) Flush() error { return nil }
+
+// lineWriter prints one "column = value" line per field, records separated by a blank line
+type lineWriter struct {
+	out     io.Writer
+	columns []string
+	width   int
+	count   int
+}
+
+func newLineWriter(w io.Writer, opts Options) Writer {
+	return &lineWriter{out: w}
+}
+
+func (l *lineWriter) WriteHeader(columns []string) error {
+	l.columns = columns
+	for _, col := range columns {
+		l.width = max(l.width, runewidth.StringWidth(col))
+	}
+	return nil
+}
+
+func (l *lineWriter) WriteRow(values []interface{}) error {
+	if l.count > 0 {
+		fmt.Fprintln(l.out)
+	}
+	l.count++
+	for i, v := range values {
+		pad := strings.Repeat(" ", l.width-runewidth.StringWidth(l.columns[i]))
+		if _, err := fmt.Fprintf(l.out, "%s%s = %s\n", pad, l.columns[i], text(v, nullText)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *lineWriter) Flush() error { return nil }
+
+// boxWriter draws the result inside Unicode box-drawing borders. Column widths
+// depend on every value, so rows are buffered until Flush.
+type boxWriter struct {
+	out     io.Writer
+	opts    Options
+	columns []string
+	rows    [][]string
+}
+
+func newBoxWriter(w io.Writer, opts Options) Writer {
+	return &boxWriter{out: w, opts: opts}
+}
+
+func (b *boxWriter) WriteHeader(columns []string) error {
+	b.columns = columns
+	return nil
+}
+
+func (b *boxWriter) WriteRow(values []interface{}) error {
+	b.rows = append(b.rows, textRow(values, nullText))
+	return nil
+}
+
+func (b *boxWriter) Flush() error {
+	widths := make([]int, len(b.columns))
+	if b.opts.Header {
+		for i, col := range b.columns {
+			widths[i] = runewidth.StringWidth(col)
+		}
+	}
+	for _, row := range b.rows {
+		for i, val := range row {
+			widths[i] = max(widths[i], runewidth.StringWidth(val))
+		}
+	}
+
+	border := func(left, mid, right string) string {
+		parts := make([]string, len(widths))
+		for i, w := range widths {
+			parts[i] = strings.Repeat("─", w+2)
+		}
+		return left + strings.Join(parts, mid) + right + "\n"
+	}
+	line := func(cells []string) string {
+		parts := make([]string, len(cells))
+		for i, cell := range cells {
+			parts[i] = " " + runewidth.FillRight(cell, widths[i]) + " "
+		}
+		return "│" + strings.Join(parts, "│") + "│\n"
+	}
+
+	var sb strings.Builder
+	sb.WriteString(border("┌", "┬", "┐"))
+	if b.opts.Header {
+		sb.WriteString(line(b.columns))
+		sb.WriteString(border("├", "┼", "┤"))
+	}
+	for _, row := range b.rows {
+		sb.WriteString(line(row))
+	}
+	sb.WriteString(border("└", "┴", "┘"))
+
+	_, err := io.WriteString(b.out, sb.String())
+	return err
+}
+
+// csvWriter emits RFC 4180 CSV (or TSV), with NULL as an empty field
+type csvWriter struct {
+	cw   *csv.Writer
+	opts Options
+}
+
+func newCSVWriter(w io.Writer, opts Options, comma rune) Writer {
+	cw := csv.NewWriter(w)
+	cw.Comma = comma
+	return &csvWriter{cw: cw, opts: opts}
+}
+
+func (c *csvWriter) WriteHeader(columns []string) error {
+	if !c.opts.Header {
+		return nil
+	}
+	return c.cw.Write(columns)
+}
+
+func (c *csvWriter) WriteRow(values []interface{}) error {
+	return c.cw.Write(textRow(values, ""))
+}
+
+func (c *csvWriter) Flush() error {
+	c.cw.Flush()
+	return c.cw.Error()
+}
+
+// jsonWriter emits a JSON array, or one JSON value per line for NDJSON.
+// Values keep their types: numbers stay numbers, booleans stay booleans and
+// NULL becomes null. Object keys follow the column order.
+type jsonWriter struct {
+	out     io.Writer
+	opts    Options
+	lines   bool
+	columns []string
+	count   int
+}
+
+func newJSONWriter(w io.Writer, opts Options, lines bool) Writer {
+	return &jsonWriter{out: w, opts: opts, lines: lines}
+}
+
+func (j *jsonWriter) WriteHeader(columns []string) error {
+	j.columns = columns
+	return nil
+}
+
+func (j *jsonWriter) WriteRow(values []interface{}) error {
+	var sb strings.Builder
+	switch {
+	case j.lines:
+	case j.count == 0:
+		sb.WriteString("[\n")
+	default:
+		sb.WriteString(",\n")
+	}
+	j.count++
+
+	openTok, closeTok := "{", "}"
+	if !j.opts.Header {
+		openTok, closeTok = "[", "]"
+	}
+	sb.WriteString(openTok)
+	for i, v := range values {
+		if i > 0 {
+			sb.WriteString(",")
+		}
+		if j.opts.Header {
+			key, _ := json.Marshal(j.columns[i])
+			sb.Write(key)
+			sb.WriteString(":")
+		}
+		val, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Errorf("failed to encode column %s: %w", j.columns[i], err)
+		}
+		sb.Write(val)
+	}
+	sb.WriteString(closeTok)
+	if j.lines {
+		sb.WriteString("\n")
+	}
+
+	_, err := io.WriteString(j.out, sb.String())
+	return err
+}
+
+func (j *jsonWriter) Flush() error {
+	if j.lines {
+		return nil
+	}
+	end := "\n]\n"
+	if j.count == 0 {
+		end = "[]\n"
+	}
+	_, err := io.WriteString(j.out, end)
+	return err
+}
+
+// markdownWriter emits a GitHub-flavoured Markdown table
+type markdownWriter struct {
+	out     io.Writer
+	opts    Options
+	columns []string
+}
+
+func newMarkdownWriter(w io.Writer, opts Options) Writer {
+	return &markdownWriter{out: w, opts: opts}
+}
+
+// markdownEscaper keeps cell content from breaking the table layout
+var markdownEscaper = strings.NewReplacer("|", `\|`, "\r\n", "<br>", "\n", "<br>")
+
+func (m *markdownWriter) WriteHeader(columns []string) error {
+	m.columns = columns
+	header := make([]string, len(columns))
+	sep := make([]string, len(columns))
+	for i, col := range columns {
+		if m.opts.Header {
+			header[i] = markdownEscaper.Replace(col)
+		}
+		sep[i] = "---"
+	}
+	_, err := fmt.Fprintf(m.out, "| %s |\n|%s|\n", strings.Join(header, " | "), strings.Join(sep, "|"))
+	return err
+}
+
+func (m *markdownWriter) WriteRow(values []interface{}) error {
+	cells := textRow(values, nullText)
+	for i, cell := range cells {
+		cells[i] = markdownEscaper.Replace(cell)
+	}
+	_, err := fmt.Fprintf(m.out, "| %s |\n", strings.Join(cells, " | "))
+	return err
+}
+
+func (m *markdownWriter) Flush() error { return nil }
+
+// htmlWriter emits an HTML <table> with escaped content
+type htmlWriter struct {
+	out  io.Writer
+	opts Options
+}
+
+func newHTMLWriter(w io.Writer, opts Options) Writer {
+	return &htmlWriter{out: w, opts: opts}
+}
+
+func (h *htmlWriter) WriteHeader(columns []string) error {
+	var sb strings.Builder
+	sb.WriteString("<table>\n")
+	if h.opts.Header {
+		sb.WriteString("<thead>\n<tr>")
+		for _, col := range columns {
+			sb.WriteString("<th>" + html.EscapeString(col) + "</th>")
+		}
+		sb.WriteString("</tr>\n</thead>\n")
+	}
+	sb.WriteString("<tbody>\n")
+	_, err := io.WriteString(h.out, sb.String())
+	return err
+}
+
+func (h *htmlWriter) WriteRow(values []interface{}) error {
+	var sb strings.Builder
+	sb.WriteString("<tr>")
+	for _, cell := range textRow(values, nullText) {
+		sb.WriteString("<td>" + html.EscapeString(cell) + "</td>")
+	}
+	sb.WriteString("</tr>\n")
+	_, err := io.WriteString(h.out, sb.String())
+	return err
+}
+
+func (h *htmlWriter) Flush() error {
+	_, err := io.WriteString(h.out, "</tbody>\n</table>\n")
+	return err
+}
@@ -0,0 +1,179 @@
+package output
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"csvql/parquet"
+)
+
+var (
+	testColumns = []string{"id", "name", "score", "active"}
+	testRows    = [][]interface{}{
+		{int64(1), "Alice, \"A\"", 9.5, true},
+		{int64(2), "Bob|B", nil, false},
+	}
+)
+
+func render(t *testing.T, format string, opts Options) string {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := New(format, &buf, opts)
+	if err != nil {
+		t.Fatalf("New(%q) failed: %v", format, err)
+	}
+	if err := Write(w, testColumns, testRows); err != nil {
+		t.Fatalf("Write(%q) failed: %v", format, err)
+	}
+	return buf.String()
+}
+
+func TestNew_UnknownFormat(t *testing.T) {
+	if _, err := New("xml", &bytes.Buffer{}, Options{}); err == nil {
+		t.Error("Expected error for unknown format")
+	}
+}
+
+func TestFormats_AllConstructible(t *testing.T) {
+	for _, format := range Formats() {
+		if _, err := New(format, &bytes.Buffer{}, Options{Header: true}); err != nil {
+			t.Errorf("New(%q) failed: %v", format, err)
+		}
+	}
+}
+
+func TestCSV(t *testing.T) {
+	expected := "id,name,score,active\n1,\"Alice, \"\"A\"\"\",9.5,true\n2,Bob|B,,false\n"
+	if got := render(t, "csv", Options{Header: true}); got != expected {
+		t.Errorf("csv output:\n%s\nwant:\n%s", got, expected)
+	}
+
+	noHeader := render(t, "csv", Options{Header: false})
+	if strings.HasPrefix(noHeader, "id,") {
+		t.Errorf("Expected no header line, got:\n%s", noHeader)
+	}
+}
+
+func TestTSV(t *testing.T) {
+	expected := "id\tname\tscore\tactive\n1\t\"Alice, \"\"A\"\"\"\t9.5\ttrue\n2\tBob|B\t\tfalse\n"
+	if got := render(t, "tsv", Options{Header: true}); got != expected {
+		t.Errorf("tsv output:\n%s\nwant:\n%s", got, expected)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	expected := "[\n" +
+		`{"id":1,"name":"Alice, \"A\"","score":9.5,"active":true},` + "\n" +
+		`{"id":2,"name":"Bob|B","score":null,"active":false}` + "\n]\n"
+	if got := render(t, "json", Options{Header: true}); got != expected {
+		t.Errorf("json output:\n%s\nwant:\n%s", got, expected)
+	}
+}
+
+func TestJSON_Empty(t *testing.T) {
+	var buf bytes.Buffer
+	w, _ := New("json", &buf, Options{Header: true})
+	Write(w, testColumns, nil)
+	if buf.String() != "[]\n" {
+		t.Errorf("Expected empty array, got %q", buf.String())
+	}
+}
+
+func TestNDJSON(t *testing.T) {
+	expected := `{"id":1,"name":"Alice, \"A\"","score":9.5,"active":true}` + "\n" +
+		`{"id":2,"name":"Bob|B","score":null,"active":false}` + "\n"
+	if got := render(t, "ndjson", Options{Header: true}); got != expected {
+		t.Errorf("ndjson output:\n%s\nwant:\n%s", got, expected)
+	}
+
+	arrays := `[1,"Alice, \"A\"",9.5,true]` + "\n" + `[2,"Bob|B",null,false]` + "\n"
+	if got := render(t, "ndjson", Options{Header: false}); got != arrays {
+		t.Errorf("ndjson without header:\n%s\nwant:\n%s", got, arrays)
+	}
+}
+
+func TestMarkdown(t *testing.T) {
+	got := render(t, "markdown", Options{Header: true})
+	expected := "| id | name | score | active |\n|---|---|---|---|\n" +
+		"| 1 | Alice, \"A\" | 9.5 | true |\n| 2 | Bob\\|B | NULL | false |\n"
+	if got != expected {
+		t.Errorf("markdown output:\n%s\nwant:\n%s", got, expected)
+	}
+}
+
+func TestHTML(t *testing.T) {
+	got := render(t, "html", Options{Header: true})
+	if !strings.Contains(got, "<th>name</th>") {
+		t.Errorf("Expected header cells, got:\n%s", got)
+	}
+	if !strings.Contains(got, "<td>Alice, &#34;A&#34;</td>") {
+		t.Errorf("Expected escaped cell, got:\n%s", got)
+	}
+}
+
+func TestBox(t *testing.T) {
+	got := render(t, "box", Options{Header: true})
+	lines := strings.Split(strings.TrimSuffix(got, "\n"), "\n")
+	if len(lines) != 6 {
+		t.Fatalf("Expected 6 lines, got %d:\n%s", len(lines), got)
+	}
+	if !strings.HasPrefix(lines[0], "┌") || !strings.HasPrefix(lines[5], "└") {
+		t.Errorf("Missing borders:\n%s", got)
+	}
+	if !strings.Contains(lines[4], "NULL") {
+		t.Errorf("Expected NULL in second row, got %q", lines[4])
+	}
+}
+
+func TestTable(t *testing.T) {
+	got := render(t, "table", Options{Header: true})
+	if !strings.HasPrefix(got, "id") || !strings.HasSuffix(got, "(2 rows)\n") {
+		t.Errorf("Unexpected table output:\n%s", got)
+	}
+
+	var buf bytes.Buffer
+	w, _ := New("table", &buf, Options{Header: true})
+	Write(w, testColumns, nil)
+	if buf.String() != "(no results)\n" {
+		t.Errorf("Expected (no results), got %q", buf.String())
+	}
+}
+
+func TestLine(t *testing.T) {
+	got := render(t, "line", Options{Header: true})
+	if !strings.HasPrefix(got, "    id = 1\n  name = Alice") {
+		t.Errorf("Unexpected line output:\n%s", got)
+	}
+}
+
+func TestParquet(t *testing.T) {
+	var buf bytes.Buffer
+	w, _ := New("parquet", &buf, Options{})
+	w.(TypedWriter).SetColumnTypes([]string{"INTEGER", "TEXT", "", "DATE"})
+	w.WriteHeader([]string{"id", "name", "score", "day"})
+	w.WriteRow([]interface{}{int64(1), "Alice", int64(9), "2024-03-15"})
+	w.WriteRow([]interface{}{int64(2), nil, 9.5, nil})
+	if err := w.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	r, err := parquet.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("NewReader failed: %v", err)
+	}
+	kinds := []parquet.Kind{parquet.Int64, parquet.String, parquet.Double, parquet.Date}
+	for i, c := range r.Columns() {
+		if c.Kind != kinds[i] {
+			t.Errorf("Column %s: expected %s, got %s", c.Name, kinds[i], c.Kind)
+		}
+	}
+	row, err := r.Next()
+	if err != nil {
+		t.Fatalf("Next failed: %v", err)
+	}
+	if row[0] != int64(1) || row[1] != "Alice" || row[2] != 9.0 || !row[3].(time.Time).Equal(time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected row: %v", row)
+	}
+}
@@ -0,0 +1,202 @@
+package output
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"csvql/parquet"
+)
+
+// TypedWriter is implemented by writers that store values by type, such as
+// parquet. SetColumnTypes receives the declared SQLite type of each result
+// column ("" for expressions) before the header is written.
+type TypedWriter interface {
+	SetColumnTypes(types []string)
+}
+
+// IsBinary reports whether a format writes binary data, which is only
+// useful written to a file
+func IsBinary(format string) bool {
+	return format == "parquet"
+}
+
+// parquetSampleSize is the number of rows buffered to choose the Parquet
+// type of columns without a declared type
+const parquetSampleSize = 1000
+
+// declaredKinds maps declared SQLite column types to Parquet kinds
+var declaredKinds = map[string]parquet.Kind{
+	"INTEGER":  parquet.Int64,
+	"REAL":     parquet.Double,
+	"BOOLEAN":  parquet.Boolean,
+	"DATE":     parquet.Date,
+	"DATETIME": parquet.Timestamp,
+	"TEXT":     parquet.String,
+}
+
+// parquetWriter writes a Parquet file. The first rows are buffered to
+// choose column types: the declared type when every sampled value fits it,
+// otherwise the narrowest of int64, double, boolean and string that does.
+type parquetWriter struct {
+	out     io.Writer
+	columns []string
+	types   []string
+	kinds   []parquet.Kind
+	sample  [][]interface{}
+	pw      *parquet.Writer
+}
+
+func newParquetWriter(w io.Writer, opts Options) Writer {
+	return &parquetWriter{out: w}
+}
+
+func (p *parquetWriter) SetColumnTypes(types []string) {
+	p.types = types
+}
+
+func (p *parquetWriter) WriteHeader(columns []string) error {
+	p.columns = columns
+	return nil
+}
+
+func (p *parquetWriter) WriteRow(values []interface{}) error {
+	if p.pw == nil {
+		p.sample = append(p.sample, values)
+		if len(p.sample) < parquetSampleSize {
+			return nil
+		}
+		return p.start()
+	}
+	return p.write(values)
+}
+
+// start chooses the column types from the sample and writes it out
+func (p *parquetWriter) start() error {
+	columns := make([]parquet.Column, len(p.columns))
+	p.kinds = make([]parquet.Kind, len(p.columns))
+	for i, name := range p.columns {
+		var declared string
+		if i < len(p.types) {
+			declared = strings.ToUpper(p.types[i])
+		}
+		p.kinds[i] = p.chooseKind(i, declared)
+		columns[i] = parquet.Column{Name: name, Kind: p.kinds[i], Optional: true}
+	}
+
+	pw, err := parquet.NewWriter(p.out, columns)
+	if err != nil {
+		return err
+	}
+	p.pw = pw
+
+	for _, values := range p.sample {
+		if err := p.write(values); err != nil {
+			return err
+		}
+	}
+	p.sample = nil
+	return nil
+}
+
+// chooseKind picks the kind of column i: the declared one if every sampled
+// value converts to it, otherwise the narrowest that fits the sample
+func (p *parquetWriter) chooseKind(i int, declared string) parquet.Kind {
+	fits := func(kind parquet.Kind) bool {
+		for _, values := range p.sample {
+			if _, ok := parquetValue(values[i], kind); !ok {
+				return false
+			}
+		}
+		return true
+	}
+
+	if kind, ok := declaredKinds[declared]; ok && fits(kind) {
+		return kind
+	}
+	for _, kind := range []parquet.Kind{parquet.Int64, parquet.Double, parquet.Boolean} {
+		if fits(kind) && p.hasValues(i) {
+			return kind
+		}
+	}
+	return parquet.String
+}
+
+// hasValues reports whether column i has any non-NULL value in the sample
+func (p *parquetWriter) hasValues(i int) bool {
+	for _, values := range p.sample {
+		if values[i] != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// write converts a row to the chosen kinds and writes it
+func (p *parquetWriter) write(values []interface{}) error {
+	row := make([]interface{}, len(values))
+	for i, v := range values {
+		converted, ok := parquetValue(v, p.kinds[i])
+		if !ok {
+			return fmt.Errorf("column %s: value %v does not fit the %s type chosen from the first rows; use CAST in the query", p.columns[i], v, p.kinds[i])
+		}
+		row[i] = converted
+	}
+	return p.pw.Write(row)
+}
+
+func (p *parquetWriter) Flush() error {
+	if p.pw == nil {
+		if err := p.start(); err != nil {
+			return err
+		}
+	}
+	return p.pw.Close()
+}
+
+// parquetValue converts a result value to the Go type of a Parquet kind.
+// Dates and date-times arrive as the text the db package renders them as.
+func parquetValue(v interface{}, kind parquet.Kind) (interface{}, bool) {
+	if v == nil {
+		return nil, true
+	}
+	switch kind {
+	case parquet.Int64:
+		n, ok := v.(int64)
+		return n, ok
+	case parquet.Double:
+		switch n := v.(type) {
+		case int64:
+			return float64(n), true
+		case float64:
+			return n, true
+		}
+	case parquet.Boolean:
+		switch b := v.(type) {
+		case bool:
+			return b, true
+		case int64:
+			return b != 0, b == 0 || b == 1
+		}
+	case parquet.Date:
+		if s, ok := v.(string); ok {
+			t, err := time.Parse("2006-01-02", s)
+			return t, err == nil
+		}
+	case parquet.Timestamp:
+		if s, ok := v.(string); ok {
+			for _, layout := range []string{"2006-01-02 15:04:05.999999999", "2006-01-02 15:04:05.999999999-07:00", "2006-01-02"} {
+				if t, err := time.Parse(layout, s); err == nil {
+					return t.UTC(), true
+				}
+			}
+		}
+	case parquet.String:
+		if b, ok := v.([]byte); ok {
+			return string(b), true
+		}
+		return text(v, ""), true
+	}
+	return nil, false
+}
@@ -0,0 +1,366 @@
+package parquet
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Encodings
+const (
+	encodingPlain              = 0
+	encodingPlainDictionary    = 2
+	encodingRLE                = 3
+	encodingBitPacked          = 4
+	encodingDeltaBinaryPacked  = 5
+	encodingDeltaLengthByteArr = 6
+	encodingDeltaByteArray     = 7
+	encodingRLEDictionary      = 8
+	encodingByteStreamSplit    = 9
+)
+
+// errTruncated is returned when a page ends before all its values are read
+var errTruncated = errors.New("truncated page")
+
+// readBits reads width bits starting at bit pos, least significant first
+func readBits(buf []byte, pos uint64, width uint) (uint64, error) {
+	if (pos+uint64(width)+7)/8 > uint64(len(buf)) {
+		return 0, errTruncated
+	}
+	var v uint64
+	for read := uint(0); read < width; {
+		off := uint(pos % 8)
+		take := min(8-off, width-read)
+		bits := (uint64(buf[pos/8]) >> off) & (1<<take - 1)
+		v |= bits << read
+		read += take
+		pos += uint64(take)
+	}
+	return v, nil
+}
+
+// decodeHybrid decodes count values of the RLE/bit-packed hybrid encoding
+// used for definition levels, dictionary indices and booleans
+func decodeHybrid(buf []byte, bitWidth, count int) ([]uint64, error) {
+	values := make([]uint64, 0, count)
+	byteWidth := (bitWidth + 7) / 8
+	pos := 0
+	for len(values) < count {
+		header, n := binary.Uvarint(buf[pos:])
+		if n <= 0 {
+			return nil, errTruncated
+		}
+		pos += n
+
+		if header&1 == 0 {
+			// RLE run: one value repeated
+			run := int(header >> 1)
+			if pos+byteWidth > len(buf) {
+				return nil, errTruncated
+			}
+			var v uint64
+			for i := 0; i < byteWidth; i++ {
+				v |= uint64(buf[pos+i]) << (8 * i)
+			}
+			pos += byteWidth
+			for i := 0; i < run && len(values) < count; i++ {
+				values = append(values, v)
+			}
+			continue
+		}
+
+		// Bit-packed groups of eight values
+		groups := int(header >> 1)
+		size := groups * bitWidth
+		if pos+size > len(buf) {
+			// The last group may be cut short when it is padding only
+			size = len(buf) - pos
+		}
+		packed := buf[pos : pos+size]
+		for i := 0; i < groups*8 && len(values) < count; i++ {
+			v, err := readBits(packed, uint64(i*bitWidth), uint(bitWidth))
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+		}
+		pos += size
+	}
+	return values, nil
+}
+
+// encodeRLE encodes values as RLE runs of the hybrid encoding
+func encodeRLE(values []uint64, bitWidth int) []byte {
+	byteWidth := (bitWidth + 7) / 8
+	var buf []byte
+	for i := 0; i < len(values); {
+		j := i + 1
+		for j < len(values) && values[j] == values[i] {
+			j++
+		}
+		buf = binary.AppendUvarint(buf, uint64(j-i)<<1)
+		for b := 0; b < byteWidth; b++ {
+			buf = append(buf, byte(values[i]>>(8*b)))
+		}
+		i = j
+	}
+	return buf
+}
+
+// bitWidth returns the number of bits needed to store max
+func bitWidth(max uint64) int {
+	n := 0
+	for max > 0 {
+		n++
+		max >>= 1
+	}
+	return n
+}
+
+// decodeDeltaBinaryPacked decodes DELTA_BINARY_PACKED integers and returns
+// them with the number of bytes used
+func decodeDeltaBinaryPacked(buf []byte) ([]int64, int, error) {
+	d := &thriftDecoder{buf: buf}
+	blockSize, err := d.readVarint()
+	if err != nil {
+		return nil, 0, err
+	}
+	miniblocks, err := d.readVarint()
+	if err != nil {
+		return nil, 0, err
+	}
+	total, err := d.readVarint()
+	if err != nil {
+		return nil, 0, err
+	}
+	first, err := d.readZigzag()
+	if err != nil {
+		return nil, 0, err
+	}
+	if miniblocks == 0 || blockSize%miniblocks != 0 || total > uint64(len(buf))*64 {
+		return nil, 0, fmt.Errorf("invalid delta header")
+	}
+	perMiniblock := int(blockSize / miniblocks)
+
+	values := make([]int64, 0, total)
+	if total > 0 {
+		values = append(values, first)
+	}
+	last := first
+	for uint64(len(values)) < total {
+		minDelta, err := d.readZigzag()
+		if err != nil {
+			return nil, 0, err
+		}
+		if d.pos+int(miniblocks) > len(buf) {
+			return nil, 0, errTruncated
+		}
+		widths := buf[d.pos : d.pos+int(miniblocks)]
+		d.pos += int(miniblocks)
+
+		for _, width := range widths {
+			if uint64(len(values)) >= total {
+				break
+			}
+			size := perMiniblock * int(width) / 8
+			if d.pos+size > len(buf) {
+				return nil, 0, errTruncated
+			}
+			packed := buf[d.pos : d.pos+size]
+			for i := 0; i < perMiniblock && uint64(len(values)) < total; i++ {
+				delta, err := readBits(packed, uint64(i)*uint64(width), uint(width))
+				if err != nil {
+					return nil, 0, err
+				}
+				last += minDelta + int64(delta)
+				values = append(values, last)
+			}
+			d.pos += size
+		}
+	}
+	return values, d.pos, nil
+}
+
+// decodeDeltaLengthByteArray decodes DELTA_LENGTH_BYTE_ARRAY values
+func decodeDeltaLengthByteArray(buf []byte) ([][]byte, int, error) {
+	lengths, pos, err := decodeDeltaBinaryPacked(buf)
+	if err != nil {
+		return nil, 0, err
+	}
+	values := make([][]byte, len(lengths))
+	for i, n := range lengths {
+		if n < 0 || pos+int(n) > len(buf) {
+			return nil, 0, errTruncated
+		}
+		values[i] = buf[pos : pos+int(n)]
+		pos += int(n)
+	}
+	return values, pos, nil
+}
+
+// decodeDeltaByteArray decodes DELTA_BYTE_ARRAY values: the length of the
+// prefix shared with the previous value, then the remaining suffix
+func decodeDeltaByteArray(buf []byte) ([][]byte, error) {
+	prefixes, pos, err := decodeDeltaBinaryPacked(buf)
+	if err != nil {
+		return nil, err
+	}
+	suffixes, _, err := decodeDeltaLengthByteArray(buf[pos:])
+	if err != nil {
+		return nil, err
+	}
+	if len(suffixes) != len(prefixes) {
+		return nil, errTruncated
+	}
+
+	values := make([][]byte, len(prefixes))
+	var prev []byte
+	for i, n := range prefixes {
+		if n < 0 || int(n) > len(prev) {
+			return nil, fmt.Errorf("invalid delta prefix length")
+		}
+		value := make([]byte, 0, int(n)+len(suffixes[i]))
+		value = append(append(value, prev[:n]...), suffixes[i]...)
+		values[i] = value
+		prev = value
+	}
+	return values, nil
+}
+
+// decodeValues decodes count non-null values of a physical type in the
+// given encoding. Integers are returned as int64, FLOAT as float32, DOUBLE
+// as float64 and INT96 and byte arrays as []byte.
+func decodeValues(buf []byte, encoding, physical, typeLength, count int) ([]interface{}, error) {
+	values := make([]interface{}, 0, count)
+	switch encoding {
+	case encodingPlain:
+		return decodePlain(buf, physical, typeLength, count)
+
+	case encodingRLE:
+		if physical != typeBoolean || len(buf) < 4 {
+			break
+		}
+		n := int(binary.LittleEndian.Uint32(buf))
+		if 4+n > len(buf) {
+			return nil, errTruncated
+		}
+		bits, err := decodeHybrid(buf[4:4+n], 1, count)
+		if err != nil {
+			return nil, err
+		}
+		for _, b := range bits {
+			values = append(values, b == 1)
+		}
+		return values, nil
+
+	case encodingDeltaBinaryPacked:
+		if physical != typeInt32 && physical != typeInt64 {
+			break
+		}
+		ints, _, err := decodeDeltaBinaryPacked(buf)
+		if err != nil {
+			return nil, err
+		}
+		for _, n := range ints {
+			if physical == typeInt32 {
+				n = int64(int32(n))
+			}
+			values = append(values, n)
+		}
+		return values, nil
+
+	case encodingDeltaLengthByteArr, encodingDeltaByteArray:
+		if physical != typeByteArray && physical != typeFixedLenByteArray {
+			break
+		}
+		var arrays [][]byte
+		var err error
+		if encoding == encodingDeltaByteArray {
+			arrays, err = decodeDeltaByteArray(buf)
+		} else {
+			arrays, _, err = decodeDeltaLengthByteArray(buf)
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, b := range arrays {
+			values = append(values, b)
+		}
+		return values, nil
+
+	case encodingByteStreamSplit:
+		width := map[int]int{typeInt32: 4, typeFloat: 4, typeInt64: 8, typeDouble: 8, typeFixedLenByteArray: typeLength}[physical]
+		if width == 0 {
+			break
+		}
+		if len(buf) < width*count {
+			return nil, errTruncated
+		}
+		joined := make([]byte, width*count)
+		for i := 0; i < count; i++ {
+			for b := 0; b < width; b++ {
+				joined[i*width+b] = buf[b*count+i]
+			}
+		}
+		return decodePlain(joined, physical, typeLength, count)
+	}
+	return nil, fmt.Errorf("unsupported encoding %d", encoding)
+}
+
+// decodePlain decodes count PLAIN-encoded values
+func decodePlain(buf []byte, physical, typeLength, count int) ([]interface{}, error) {
+	values := make([]interface{}, 0, count)
+	fixed := map[int]int{typeInt32: 4, typeInt64: 8, typeInt96: 12, typeFloat: 4, typeDouble: 8, typeFixedLenByteArray: typeLength}
+
+	switch physical {
+	case typeBoolean:
+		if (count+7)/8 > len(buf) {
+			return nil, errTruncated
+		}
+		for i := 0; i < count; i++ {
+			values = append(values, buf[i/8]>>(i%8)&1 == 1)
+		}
+		return values, nil
+
+	case typeByteArray:
+		pos := 0
+		for i := 0; i < count; i++ {
+			if pos+4 > len(buf) {
+				return nil, errTruncated
+			}
+			n := int(binary.LittleEndian.Uint32(buf[pos:]))
+			pos += 4
+			if n < 0 || pos+n > len(buf) {
+				return nil, errTruncated
+			}
+			values = append(values, buf[pos:pos+n])
+			pos += n
+		}
+		return values, nil
+	}
+
+	width, ok := fixed[physical]
+	if !ok || width <= 0 {
+		return nil, fmt.Errorf("unsupported physical type %d", physical)
+	}
+	if width*count > len(buf) {
+		return nil, errTruncated
+	}
+	for i := 0; i < count; i++ {
+		b := buf[i*width : (i+1)*width]
+		switch physical {
+		case typeInt32:
+			values = append(values, int64(int32(binary.LittleEndian.Uint32(b))))
+		case typeInt64:
+			values = append(values, int64(binary.LittleEndian.Uint64(b)))
+		case typeFloat:
+			values = append(values, math.Float32frombits(binary.LittleEndian.Uint32(b)))
+		case typeDouble:
+			values = append(values, math.Float64frombits(binary.LittleEndian.Uint64(b)))
+		default:
+			values = append(values, b)
+		}
+	}
+	return values, nil
+}
@@ -0,0 +1,251 @@
+package parquet
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWriteRead(t *testing.T) {
+	columns := []Column{
+		{Name: "id", Kind: Int64},
+		{Name: "name", Kind: String, Optional: true},
+		{Name: "price", Kind: Double, Optional: true},
+		{Name: "active", Kind: Boolean, Optional: true},
+		{Name: "day", Kind: Date, Optional: true},
+		{Name: "at", Kind: Timestamp, Optional: true},
+	}
+	day := time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC)
+	at := time.Date(2024, 3, 15, 10, 30, 0, 123456000, time.UTC)
+	rows := [][]interface{}{
+		{int64(1), "ann", 9.5, true, day, at},
+		{int64(2), nil, int64(3), false, nil, nil},
+		{int64(3), "", nil, nil, day, at},
+	}
+
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, columns)
+	if err != nil {
+		t.Fatalf("NewWriter failed: %v", err)
+	}
+	w.RowGroupSize = 2
+	for _, row := range rows {
+		if err := w.Write(row); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	r, err := NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("NewReader failed: %v", err)
+	}
+	if !reflect.DeepEqual(r.Columns(), columns) {
+		t.Errorf("Expected columns %v, got %v", columns, r.Columns())
+	}
+	if r.NumRows() != 3 {
+		t.Errorf("Expected 3 rows, got %d", r.NumRows())
+	}
+
+	rows[1][2] = 3.0 // written as a double
+	for i, want := range rows {
+		got, err := r.Next()
+		if err != nil {
+			t.Fatalf("Next failed at row %d: %v", i, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Row %d: expected %v, got %v", i, want, got)
+		}
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Errorf("Expected EOF, got %v", err)
+	}
+}
+
+// TestReadFixtures decodes files laid out like those of other writers (see
+// testdata/gen.go): dictionary pages, data pages v2, snappy, INT96 and a
+// struct column, which is skipped
+func TestReadFixtures(t *testing.T) {
+	at := time.Date(2024, 3, 15, 10, 30, 0, 123456000, time.UTC)
+	end := time.Date(2024, 12, 31, 23, 59, 59, 0, time.UTC)
+	moon := time.Date(1969, 7, 20, 20, 17, 40, 0, time.UTC)
+	half := time.Date(2024, 3, 15, 10, 30, 0, 500000000, time.UTC)
+	last := time.Date(1999, 12, 31, 23, 59, 59, 999999999, time.UTC)
+
+	tests := []struct {
+		file    string
+		columns []Column
+		skipped []string
+		rows    [][]interface{}
+	}{
+		{
+			"dictionary.parquet",
+			[]Column{
+				{Name: "id", Kind: Int64},
+				{Name: "city", Kind: String, Optional: true},
+				{Name: "at", Kind: Timestamp, Optional: true},
+				{Name: "price", Kind: Double, Optional: true},
+			},
+			nil,
+			[][]interface{}{
+				{int64(1), "Rome", at, 9.5},
+				{int64(2), nil, nil, 9.5},
+				{int64(3), "Milan", at, nil},
+				{int64(4), "Rome", end, 0.1},
+				{int64(5), "Turin", nil, 9.5},
+				{int64(6), "Rome", moon, 2.25},
+				{int64(7), nil, at, nil},
+			},
+		},
+		{
+			"v2.parquet",
+			[]Column{
+				{Name: "id", Kind: Int64},
+				{Name: "name", Kind: String, Optional: true},
+				{Name: "score", Kind: Double, Optional: true},
+				{Name: "at", Kind: Timestamp, Optional: true},
+				{Name: "note", Kind: String, Optional: true},
+			},
+			nil,
+			[][]interface{}{
+				{int64(1), "ann", 1.5, half, "x"},
+				{int64(2), nil, nil, nil, nil},
+				{int64(3), "bob", -2.0, last, ""},
+				{int64(4), "ann", nil, half, nil},
+				{int64(5), "ann", 0.25, nil, "héllo"},
+			},
+		},
+		{
+			"nested.parquet",
+			[]Column{
+				{Name: "id", Kind: Int64},
+				{Name: "name", Kind: String, Optional: true},
+			},
+			[]string{"address"},
+			[][]interface{}{
+				{int64(1), "ann"},
+				{int64(2), "bob"},
+				{int64(3), nil},
+				{int64(4), "cy"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata", tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			r, err := NewReader(bytes.NewReader(data), int64(len(data)))
+			if err != nil {
+				t.Fatalf("NewReader failed: %v", err)
+			}
+			if !reflect.DeepEqual(r.Columns(), tt.columns) {
+				t.Errorf("Expected columns %v, got %v", tt.columns, r.Columns())
+			}
+			if !reflect.DeepEqual(r.Skipped(), tt.skipped) {
+				t.Errorf("Expected skipped %v, got %v", tt.skipped, r.Skipped())
+			}
+			if r.NumRows() != int64(len(tt.rows)) {
+				t.Errorf("Expected %d rows, got %d", len(tt.rows), r.NumRows())
+			}
+			for i, want := range tt.rows {
+				got, err := r.Next()
+				if err != nil {
+					t.Fatalf("Next failed at row %d: %v", i, err)
+				}
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("Row %d: expected %v, got %v", i, want, got)
+				}
+			}
+			if _, err := r.Next(); err != io.EOF {
+				t.Errorf("Expected EOF, got %v", err)
+			}
+		})
+	}
+}
+
+func TestParseSchema_Repeated(t *testing.T) {
+	elements := []interface{}{
+		thriftStruct{4: []byte("schema"), 5: int64(3)},
+		thriftStruct{1: int64(typeInt64), 3: int64(repetitionRequired), 4: []byte("id")},
+		thriftStruct{1: int64(typeByteArray), 3: int64(repetitionRepeated), 4: []byte("tags")},
+		thriftStruct{3: int64(repetitionOptional), 4: []byte("point"), 5: int64(2)},
+		thriftStruct{1: int64(typeDouble), 3: int64(repetitionRequired), 4: []byte("x")},
+		thriftStruct{1: int64(typeDouble), 3: int64(repetitionRequired), 4: []byte("y")},
+	}
+	sch, err := parseSchema(elements)
+	if err != nil {
+		t.Fatalf("parseSchema failed: %v", err)
+	}
+	if len(sch.leaves) != 1 || sch.leaves[0].Name != "id" || sch.leaves[0].chunk != 0 {
+		t.Errorf("Expected only id, got %v", sch.leaves)
+	}
+	if !reflect.DeepEqual(sch.skipped, []string{"tags", "point"}) || sch.chunks != 4 {
+		t.Errorf("Expected tags and point skipped with 4 chunks, got %v %d", sch.skipped, sch.chunks)
+	}
+
+	if _, err := parseSchema(elements[:4]); err == nil {
+		t.Error("Expected an error for a truncated schema")
+	}
+}
+
+func TestWriter_TypeMismatch(t *testing.T) {
+	w, _ := NewWriter(io.Discard, []Column{{Name: "n", Kind: Int64}})
+	if err := w.Write([]interface{}{"x"}); err == nil {
+		t.Error("Expected an error writing a string to an int64 column")
+	}
+	if err := w.Write([]interface{}{nil}); err == nil {
+		t.Error("Expected an error writing NULL to a required column")
+	}
+}
+
+func TestDecodeHybrid(t *testing.T) {
+	// Bit-packed 0..7 at width 3, then a run of five 4s
+	buf := []byte{0x03, 0x88, 0xc6, 0xfa, 0x0a, 0x04}
+	got, err := decodeHybrid(buf, 3, 13)
+	if err != nil {
+		t.Fatalf("decodeHybrid failed: %v", err)
+	}
+	want := []uint64{0, 1, 2, 3, 4, 5, 6, 7, 4, 4, 4, 4, 4}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
+
+func TestDecodeDeltaBinaryPacked(t *testing.T) {
+	// Example from the format specification: 1, 2, 3, 4, 5 with block size
+	// 128 and 4 miniblocks; every delta is the minimum, so bit width 0
+	buf := []byte{0x80, 0x01, 0x04, 0x05, 0x02, 0x02, 0x00, 0x00, 0x00, 0x00}
+	got, n, err := decodeDeltaBinaryPacked(buf)
+	if err != nil {
+		t.Fatalf("decodeDeltaBinaryPacked failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, []int64{1, 2, 3, 4, 5}) || n != len(buf) {
+		t.Errorf("Unexpected values %v (%d bytes)", got, n)
+	}
+}
+
+func TestFormatDecimal(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		scale int
+		want  string
+	}{
+		{int64(12345), 2, "123.45"},
+		{int64(-5), 3, "-0.005"},
+		{int64(42), 0, "42"},
+		{[]byte{0xff, 0x85}, 1, "-12.3"},
+	}
+	for _, tt := range tests {
+		if got := formatDecimal(tt.value, tt.scale); got != tt.want {
+			t.Errorf("formatDecimal(%v, %d) = %s, want %s", tt.value, tt.scale, got, tt.want)
+		}
+	}
+}
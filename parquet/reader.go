@@ -0,0 +1,434 @@
+// Package parquet reads and writes flat Parquet files: top-level primitive
+// columns, optional or required. Nested and repeated fields are skipped
+// when reading.
+package parquet
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// magic starts and ends every Parquet file
+const magic = "PAR1"
+
+// Compression codecs
+const (
+	codecUncompressed = 0
+	codecSnappy       = 1
+	codecGzip         = 2
+	codecZstd         = 6
+)
+
+// Page types
+const (
+	pageData       = 0
+	pageDictionary = 2
+	pageDataV2     = 3
+)
+
+// Reader streams the rows of a Parquet file, one row group at a time
+type Reader struct {
+	r         io.ReaderAt
+	leaves    []*leaf
+	columns   []Column
+	chunks    int      // column chunks per row group
+	skipped   []string // nested and repeated fields left out
+	numRows   int64
+	rowGroups []thriftStruct
+
+	group  int             // next row group to read
+	values [][]interface{} // values of the current row group, by column
+	row    int             // next row within the current group
+	rows   int             // rows in the current group
+	offset int64           // end of the column data read so far
+}
+
+// NewReader reads the footer of a Parquet file of the given size
+func NewReader(r io.ReaderAt, size int64) (*Reader, error) {
+	if size < int64(2*len(magic)+4) {
+		return nil, fmt.Errorf("not a parquet file")
+	}
+	tail := make([]byte, 8)
+	if _, err := r.ReadAt(tail, size-8); err != nil {
+		return nil, err
+	}
+	if string(tail[4:]) != magic {
+		return nil, fmt.Errorf("not a parquet file")
+	}
+
+	footerSize := int64(binary.LittleEndian.Uint32(tail))
+	if footerSize > size-int64(2*len(magic)+4) {
+		return nil, fmt.Errorf("invalid footer size %d", footerSize)
+	}
+	footer := make([]byte, footerSize)
+	if _, err := r.ReadAt(footer, size-8-footerSize); err != nil {
+		return nil, err
+	}
+	meta, _, err := decodeStruct(footer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode footer: %w", err)
+	}
+
+	sch, err := parseSchema(meta.list(2))
+	if err != nil {
+		return nil, err
+	}
+
+	pr := &Reader{r: r, leaves: sch.leaves, chunks: sch.chunks, skipped: sch.skipped, numRows: meta.int(3)}
+	for _, l := range sch.leaves {
+		pr.columns = append(pr.columns, l.Column)
+	}
+	for _, rg := range meta.list(4) {
+		if s, ok := rg.(thriftStruct); ok {
+			pr.rowGroups = append(pr.rowGroups, s)
+		}
+	}
+	return pr, nil
+}
+
+// Columns returns the columns of the file
+func (r *Reader) Columns() []Column {
+	return r.columns
+}
+
+// Skipped returns the top-level fields left out of the columns because they
+// are nested or repeated
+func (r *Reader) Skipped() []string {
+	return r.skipped
+}
+
+// NumRows returns the number of rows in the file
+func (r *Reader) NumRows() int64 {
+	return r.numRows
+}
+
+// Offset returns how far into the file the rows read so far extend
+func (r *Reader) Offset() int64 {
+	return r.offset
+}
+
+// Next returns the values of the next row, nil for NULL, or io.EOF
+func (r *Reader) Next() ([]interface{}, error) {
+	for r.row >= r.rows {
+		if r.group >= len(r.rowGroups) {
+			return nil, io.EOF
+		}
+		if err := r.readRowGroup(r.rowGroups[r.group]); err != nil {
+			return nil, fmt.Errorf("row group %d: %w", r.group, err)
+		}
+		r.group++
+	}
+
+	row := make([]interface{}, len(r.values))
+	for i, column := range r.values {
+		row[i] = column[r.row]
+	}
+	r.row++
+	return row, nil
+}
+
+// readRowGroup decodes every column chunk of a row group
+func (r *Reader) readRowGroup(rg thriftStruct) error {
+	chunks := rg.list(1)
+	if len(chunks) != r.chunks {
+		return fmt.Errorf("expected %d column chunks, found %d", r.chunks, len(chunks))
+	}
+
+	r.rows = int(rg.int(3))
+	r.row = 0
+	r.values = make([][]interface{}, len(r.leaves))
+	for i, l := range r.leaves {
+		chunk, _ := chunks[l.chunk].(thriftStruct)
+		values, err := r.readChunk(l, chunk.sub(3))
+		if err != nil {
+			return fmt.Errorf("column %s: %w", l.Name, err)
+		}
+		if len(values) != r.rows {
+			return fmt.Errorf("column %s: expected %d values, found %d", l.Name, r.rows, len(values))
+		}
+		r.values[i] = values
+	}
+	return nil
+}
+
+// readChunk reads the pages of a column chunk
+func (r *Reader) readChunk(l *leaf, meta thriftStruct) ([]interface{}, error) {
+	start := meta.int(9)
+	if dict := meta.int(11); meta.has(11) && dict > 0 && dict < start {
+		start = dict
+	}
+	size := meta.int(7)
+	if start < 0 || size < 0 || size > 1<<31 {
+		return nil, fmt.Errorf("invalid column chunk")
+	}
+	buf := make([]byte, size)
+	if _, err := r.r.ReadAt(buf, start); err != nil {
+		return nil, err
+	}
+	r.offset = max(r.offset, start+size)
+
+	codec := int(meta.int(4))
+	total := int(meta.int(5))
+	values := make([]interface{}, 0, total)
+	var dictionary []interface{}
+
+	for pos := 0; len(values) < total && pos < len(buf); {
+		header, n, err := decodeStruct(buf[pos:])
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode page header: %w", err)
+		}
+		pos += n
+		compressed := int(header.int(3))
+		if compressed < 0 || pos+compressed > len(buf) {
+			return nil, errTruncated
+		}
+		page := buf[pos : pos+compressed]
+		pos += compressed
+
+		switch header.int(1) {
+		case pageDictionary:
+			data, err := decompress(codec, page, int(header.int(2)))
+			if err != nil {
+				return nil, err
+			}
+			dh := header.sub(7)
+			raw, err := decodePlain(data, l.physical, l.typeLength, int(dh.int(1)))
+			if err != nil {
+				return nil, err
+			}
+			dictionary = make([]interface{}, len(raw))
+			for i, v := range raw {
+				dictionary[i] = l.convert(v)
+			}
+
+		case pageData:
+			dh := header.sub(5)
+			data, err := decompress(codec, page, int(header.int(2)))
+			if err != nil {
+				return nil, err
+			}
+			count := int(dh.int(1))
+			var defs []uint64
+			if l.Optional {
+				if len(data) < 4 {
+					return nil, errTruncated
+				}
+				n := int(binary.LittleEndian.Uint32(data))
+				if 4+n > len(data) {
+					return nil, errTruncated
+				}
+				if defs, err = decodeHybrid(data[4:4+n], 1, count); err != nil {
+					return nil, err
+				}
+				data = data[4+n:]
+			}
+			if values, err = l.appendPage(values, data, int(dh.int(2)), count, defs, dictionary); err != nil {
+				return nil, err
+			}
+
+		case pageDataV2:
+			dh := header.sub(8)
+			count := int(dh.int(1))
+			defLen, repLen := int(dh.int(5)), int(dh.int(6))
+			if defLen < 0 || repLen < 0 || repLen+defLen > len(page) {
+				return nil, errTruncated
+			}
+			var defs []uint64
+			if l.Optional {
+				if defs, err = decodeHybrid(page[repLen:repLen+defLen], 1, count); err != nil {
+					return nil, err
+				}
+			}
+			data := page[repLen+defLen:]
+			if !dh.has(7) || dh.bool(7) {
+				if data, err = decompress(codec, data, int(header.int(2))-repLen-defLen); err != nil {
+					return nil, err
+				}
+			}
+			if values, err = l.appendPage(values, data, int(dh.int(4)), count, defs, dictionary); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return values, nil
+}
+
+// appendPage decodes the values of a data page, placing NULLs where the
+// definition level is 0
+func (l *leaf) appendPage(values []interface{}, data []byte, encoding, count int, defs []uint64, dictionary []interface{}) ([]interface{}, error) {
+	present := count
+	if defs != nil {
+		present = 0
+		for _, d := range defs {
+			if d == 1 {
+				present++
+			}
+		}
+	}
+
+	var decoded []interface{}
+	if encoding == encodingPlainDictionary || encoding == encodingRLEDictionary {
+		if len(data) == 0 {
+			if present > 0 {
+				return nil, errTruncated
+			}
+		} else {
+			indices, err := decodeHybrid(data[1:], int(data[0]), present)
+			if err != nil {
+				return nil, err
+			}
+			decoded = make([]interface{}, len(indices))
+			for i, idx := range indices {
+				if idx >= uint64(len(dictionary)) {
+					return nil, fmt.Errorf("dictionary index %d out of range", idx)
+				}
+				decoded[i] = dictionary[idx]
+			}
+		}
+	} else {
+		raw, err := decodeValues(data, encoding, l.physical, l.typeLength, present)
+		if err != nil {
+			return nil, err
+		}
+		decoded = make([]interface{}, len(raw))
+		for i, v := range raw {
+			decoded[i] = l.convert(v)
+		}
+	}
+
+	next := 0
+	for i := 0; i < count; i++ {
+		if defs != nil && defs[i] == 0 {
+			values = append(values, nil)
+			continue
+		}
+		values = append(values, decoded[next])
+		next++
+	}
+	return values, nil
+}
+
+// decompress inflates a page compressed with the column's codec
+func decompress(codec int, data []byte, size int) ([]byte, error) {
+	switch codec {
+	case codecUncompressed:
+		return data, nil
+	case codecSnappy:
+		return snappy.Decode(nil, data)
+	case codecGzip:
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		return io.ReadAll(gz)
+	case codecZstd:
+		dec, err := zstd.NewReader(nil)
+		if err != nil {
+			return nil, err
+		}
+		defer dec.Close()
+		return dec.DecodeAll(data, make([]byte, 0, max(size, 0)))
+	}
+	return nil, fmt.Errorf("unsupported compression codec %d", codec)
+}
+
+// julianUnixEpoch is the Julian day number of 1970-01-01, used by INT96 timestamps
+const julianUnixEpoch = 2440588
+
+// convert turns a decoded physical value into the Go value of the column kind
+func (l *leaf) convert(v interface{}) interface{} {
+	switch l.Kind {
+	case Int64:
+		n := v.(int64)
+		switch {
+		case l.unsigned && l.physical == typeInt32:
+			return int64(uint32(n))
+		case l.unsigned && n < 0:
+			return uint64(n)
+		}
+		return n
+
+	case Double:
+		if f, ok := v.(float32); ok {
+			// Shortest decimal form, so 0.1 stays 0.1 rather than 0.10000000149011612
+			d, _ := strconv.ParseFloat(strconv.FormatFloat(float64(f), 'g', -1, 32), 64)
+			return d
+		}
+		return v
+
+	case Date:
+		return time.Unix(v.(int64)*86400, 0).UTC()
+
+	case Timestamp:
+		if b, ok := v.([]byte); ok {
+			nanos := int64(binary.LittleEndian.Uint64(b))
+			day := int64(binary.LittleEndian.Uint32(b[8:]))
+			return time.Unix((day-julianUnixEpoch)*86400, nanos).UTC()
+		}
+		switch n := v.(int64); l.unit {
+		case time.Millisecond:
+			return time.UnixMilli(n).UTC()
+		case time.Microsecond:
+			return time.UnixMicro(n).UTC()
+		default:
+			return time.Unix(0, n).UTC()
+		}
+
+	case Time:
+		d := time.Duration(v.(int64)) * l.unit
+		return time.Unix(0, 0).UTC().Add(d).Format("15:04:05.999999999")
+
+	case Decimal:
+		return formatDecimal(v, l.scale)
+
+	case String:
+		b := v.([]byte)
+		if l.uuid && len(b) == 16 {
+			return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+		}
+		return string(b)
+
+	case Bytes:
+		return bytes.Clone(v.([]byte))
+	}
+	return v
+}
+
+// formatDecimal renders an unscaled decimal stored as an integer or a
+// big-endian two's complement byte array
+func formatDecimal(v interface{}, scale int) string {
+	unscaled := new(big.Int)
+	switch n := v.(type) {
+	case int64:
+		unscaled.SetInt64(n)
+	case []byte:
+		unscaled.SetBytes(n)
+		if len(n) > 0 && n[0]&0x80 != 0 {
+			unscaled.Sub(unscaled, new(big.Int).Lsh(big.NewInt(1), uint(8*len(n))))
+		}
+	}
+
+	digits := unscaled.String()
+	if scale <= 0 {
+		return digits
+	}
+	sign := ""
+	if strings.HasPrefix(digits, "-") {
+		sign, digits = "-", digits[1:]
+	}
+	if len(digits) <= scale {
+		digits = strings.Repeat("0", scale-len(digits)+1) + digits
+	}
+	return sign + digits[:len(digits)-scale] + "." + digits[len(digits)-scale:]
+}
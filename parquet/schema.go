@@ -0,0 +1,233 @@
+package parquet
+
+import (
+	"fmt"
+	"time"
+)
+
+// Physical types
+const (
+	typeBoolean = iota
+	typeInt32
+	typeInt64
+	typeInt96
+	typeFloat
+	typeDouble
+	typeByteArray
+	typeFixedLenByteArray
+)
+
+// Converted types, the annotations that predate logical types
+const (
+	convertedUTF8            = 0
+	convertedEnum            = 4
+	convertedDecimal         = 5
+	convertedDate            = 6
+	convertedTimeMillis      = 7
+	convertedTimeMicros      = 8
+	convertedTimestampMillis = 9
+	convertedTimestampMicros = 10
+	convertedUint32          = 13
+	convertedUint64          = 14
+	convertedJSON            = 19
+)
+
+// Repetition types
+const (
+	repetitionRequired = 0
+	repetitionOptional = 1
+	repetitionRepeated = 2
+)
+
+// Kind is how the values of a column are presented to callers
+type Kind int
+
+// Column kinds and the Go type of their values
+const (
+	Bytes     Kind = iota // []byte
+	String                // string
+	Boolean               // bool
+	Int64                 // int64, or uint64 for unsigned 64-bit columns
+	Double                // float64
+	Decimal               // string with the exact decimal digits
+	Date                  // time.Time at midnight UTC
+	Timestamp             // time.Time in UTC
+	Time                  // string, the time of day as 15:04:05.999999999
+)
+
+var kindNames = [...]string{"bytes", "string", "boolean", "int64", "double", "decimal", "date", "timestamp", "time"}
+
+func (k Kind) String() string {
+	if int(k) < len(kindNames) {
+		return kindNames[k]
+	}
+	return fmt.Sprintf("kind(%d)", int(k))
+}
+
+// Column is a top-level field of a Parquet file
+type Column struct {
+	Name     string
+	Kind     Kind
+	Optional bool
+}
+
+// leaf is the schema of a column as stored in the file
+type leaf struct {
+	Column
+	chunk      int // index of the column's chunk in each row group
+	physical   int
+	typeLength int
+	scale      int
+	unit       time.Duration // timestamp and time units
+	unsigned   bool
+	uuid       bool
+}
+
+// schema is the parsed schema of a file
+type schema struct {
+	leaves  []*leaf
+	chunks  int      // column chunks per row group, one per primitive field
+	skipped []string // nested and repeated top-level fields
+}
+
+// parseSchema turns the flattened schema list of the footer into columns.
+// Only primitive fields directly below the root, optional or required, are
+// read; nested and repeated fields are skipped along with their chunks.
+func parseSchema(elements []interface{}) (*schema, error) {
+	if len(elements) == 0 {
+		return nil, fmt.Errorf("missing schema")
+	}
+
+	sch := &schema{}
+	root, _ := elements[0].(thriftStruct)
+	pos := 1
+	for range root.int(5) {
+		if pos >= len(elements) {
+			return nil, fmt.Errorf("truncated schema")
+		}
+		el, _ := elements[pos].(thriftStruct)
+		name := el.string(4)
+		next, chunks, err := skipField(elements, pos)
+		if err != nil {
+			return nil, err
+		}
+		pos = next
+		if !el.has(1) || el.int(3) == repetitionRepeated {
+			sch.skipped = append(sch.skipped, name)
+			sch.chunks += chunks
+			continue
+		}
+
+		l := &leaf{
+			Column:     Column{Name: name, Optional: el.int(3) == repetitionOptional},
+			chunk:      sch.chunks,
+			physical:   int(el.int(1)),
+			typeLength: int(el.int(2)),
+			scale:      int(el.int(7)),
+		}
+		if err := l.resolveKind(el); err != nil {
+			return nil, err
+		}
+		sch.leaves = append(sch.leaves, l)
+		sch.chunks++
+	}
+	if pos != len(elements) {
+		return nil, fmt.Errorf("invalid schema: %d elements outside the root", len(elements)-pos)
+	}
+	return sch, nil
+}
+
+// skipField returns the position after the field at pos and its children,
+// and the number of primitive fields, each with its own column chunk, in it
+func skipField(elements []interface{}, pos int) (int, int, error) {
+	el, _ := elements[pos].(thriftStruct)
+	pos++
+	if el.has(1) {
+		return pos, 1, nil
+	}
+	chunks := 0
+	for range el.int(5) {
+		if pos >= len(elements) {
+			return 0, 0, fmt.Errorf("truncated schema")
+		}
+		next, n, err := skipField(elements, pos)
+		if err != nil {
+			return 0, 0, err
+		}
+		pos, chunks = next, chunks+n
+	}
+	return pos, chunks, nil
+}
+
+// resolveKind reads the logical type of a schema element, falling back to
+// its converted type and then to the physical type
+func (l *leaf) resolveKind(el thriftStruct) error {
+	l.Kind = Bytes
+	switch l.physical {
+	case typeBoolean:
+		l.Kind = Boolean
+	case typeInt32, typeInt64:
+		l.Kind = Int64
+	case typeInt96:
+		l.Kind, l.unit = Timestamp, time.Nanosecond
+	case typeFloat, typeDouble:
+		l.Kind = Double
+	case typeByteArray, typeFixedLenByteArray:
+	default:
+		return fmt.Errorf("column %s: unknown physical type %d", l.Name, l.physical)
+	}
+
+	if logical := el.sub(10); logical != nil {
+		switch {
+		case logical.has(1), logical.has(4), logical.has(12):
+			l.Kind = String
+		case logical.has(5):
+			l.Kind, l.scale = Decimal, int(logical.sub(5).int(1))
+		case logical.has(6):
+			l.Kind = Date
+		case logical.has(7):
+			l.Kind, l.unit = Time, timeUnit(logical.sub(7).sub(2))
+		case logical.has(8):
+			l.Kind, l.unit = Timestamp, timeUnit(logical.sub(8).sub(2))
+		case logical.has(10):
+			l.unsigned = !logical.sub(10).bool(2)
+		case logical.has(14):
+			l.Kind, l.uuid = String, true
+		}
+		return nil
+	}
+
+	if !el.has(6) {
+		return nil
+	}
+	switch el.int(6) {
+	case convertedUTF8, convertedEnum, convertedJSON:
+		l.Kind = String
+	case convertedDecimal:
+		l.Kind = Decimal
+	case convertedDate:
+		l.Kind = Date
+	case convertedTimeMillis:
+		l.Kind, l.unit = Time, time.Millisecond
+	case convertedTimeMicros:
+		l.Kind, l.unit = Time, time.Microsecond
+	case convertedTimestampMillis:
+		l.Kind, l.unit = Timestamp, time.Millisecond
+	case convertedTimestampMicros:
+		l.Kind, l.unit = Timestamp, time.Microsecond
+	case convertedUint32, convertedUint64:
+		l.unsigned = true
+	}
+	return nil
+}
+
+// timeUnit reads a TimeUnit union
+func timeUnit(unit thriftStruct) time.Duration {
+	switch {
+	case unit.has(1):
+		return time.Millisecond
+	case unit.has(3):
+		return time.Nanosecond
+	}
+	return time.Microsecond
+}
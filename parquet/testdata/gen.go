@@ -0,0 +1,565 @@
+//go:build ignore
+
+// gen writes the Parquet fixtures read by the parquet tests. The files are
+// encoded by hand from the format specification, without the parquet
+// package, so the reader is checked against a writer it shares no code with.
+// Their layouts follow the writers csvql most often meets:
+//
+//   - dictionary.parquet: pyarrow defaults, a dictionary page per column
+//     chunk and v1 data pages, snappy, two row groups, nullable columns and
+//     a microsecond timestamp
+//   - v2.parquet: data pages v2 with uncompressed levels, snappy values (one
+//     column left uncompressed), dictionary and plain columns and a
+//     Spark-style INT96 timestamp
+//   - nested.parquet: pyarrow defaults with a struct column, an optional
+//     group of two leaves, between flat ones
+//
+// Run it from this directory with go run gen.go
+package main
+
+import (
+	"encoding/binary"
+	"log"
+	"math"
+	"math/bits"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+)
+
+// Physical types, converted types, codecs, page types and encodings, as
+// numbered by parquet.thrift
+const (
+	typeInt32     = 1
+	typeInt64     = 2
+	typeInt96     = 3
+	typeDouble    = 5
+	typeByteArray = 6
+
+	convertedUTF8            = 0
+	convertedTimestampMicros = 10
+
+	codecSnappy = 1
+
+	pageData       = 0
+	pageDictionary = 2
+	pageDataV2     = 3
+
+	encodingPlain           = 0
+	encodingPlainDictionary = 2
+	encodingRLE             = 3
+	encodingRLEDictionary   = 8
+)
+
+// Thrift compact protocol types
+const (
+	tTrue   = 1
+	tFalse  = 2
+	tI32    = 5
+	tI64    = 6
+	tBinary = 8
+	tList   = 9
+	tStruct = 12
+)
+
+// thrift encodes structs in the compact protocol
+type thrift struct {
+	buf   []byte
+	last  int16
+	outer []int16
+}
+
+func (t *thrift) field(id int16, typ byte) {
+	if d := id - t.last; d > 0 && d <= 15 {
+		t.buf = append(t.buf, byte(d)<<4|typ)
+	} else {
+		t.buf = append(t.buf, typ)
+		t.buf = binary.AppendVarint(t.buf, int64(id))
+	}
+	t.last = id
+}
+
+func (t *thrift) i32(id int16, v int64) {
+	t.field(id, tI32)
+	t.buf = binary.AppendVarint(t.buf, v)
+}
+
+func (t *thrift) i64(id int16, v int64) {
+	t.field(id, tI64)
+	t.buf = binary.AppendVarint(t.buf, v)
+}
+
+func (t *thrift) str(id int16, s string) {
+	t.field(id, tBinary)
+	t.buf = binary.AppendUvarint(t.buf, uint64(len(s)))
+	t.buf = append(t.buf, s...)
+}
+
+func (t *thrift) flag(id int16, v bool) {
+	if v {
+		t.field(id, tTrue)
+	} else {
+		t.field(id, tFalse)
+	}
+}
+
+// list starts a list field; its elements are appended with elemI32,
+// elemString or a begin/end pair per struct
+func (t *thrift) list(id int16, elem byte, n int) {
+	t.field(id, tList)
+	if n < 15 {
+		t.buf = append(t.buf, byte(n)<<4|elem)
+	} else {
+		t.buf = append(t.buf, 0xf0|elem)
+		t.buf = binary.AppendUvarint(t.buf, uint64(n))
+	}
+}
+
+func (t *thrift) elemI32(v int64) {
+	t.buf = binary.AppendVarint(t.buf, v)
+}
+
+func (t *thrift) elemString(s string) {
+	t.buf = binary.AppendUvarint(t.buf, uint64(len(s)))
+	t.buf = append(t.buf, s...)
+}
+
+// begin opens a struct, as field id or as a list element when id is 0
+func (t *thrift) begin(id int16) {
+	if id != 0 {
+		t.field(id, tStruct)
+	}
+	t.outer = append(t.outer, t.last)
+	t.last = 0
+}
+
+func (t *thrift) end() {
+	t.buf = append(t.buf, 0)
+	t.last = t.outer[len(t.outer)-1]
+	t.outer = t.outer[:len(t.outer)-1]
+}
+
+// column describes a leaf of the schema and the values written to it
+type column struct {
+	name       string
+	group      string // optional group holding the column, "" at the top level
+	physical   int64
+	optional   bool
+	converted  int64 // -1 for none
+	logical    func(t *thrift)
+	dictionary bool
+	compressed bool // v2 only: whether the values section is compressed
+	values     []interface{}
+}
+
+// file holds the layout choices of a fixture
+type file struct {
+	v2           bool
+	rowGroupSize int
+	pageSize     int // rows per data page
+	dictEncoding int64
+	dataEncoding int64
+	columns      []*column
+	createdBy    string
+}
+
+func main() {
+	at := time.Date(2024, 3, 15, 10, 30, 0, 123456000, time.UTC)
+	end := time.Date(2024, 12, 31, 23, 59, 59, 0, time.UTC)
+	moon := time.Date(1969, 7, 20, 20, 17, 40, 0, time.UTC)
+
+	write("dictionary.parquet", &file{
+		rowGroupSize: 4,
+		pageSize:     3,
+		dictEncoding: encodingPlainDictionary,
+		dataEncoding: encodingPlainDictionary,
+		createdBy:    "csvql testdata generator (pyarrow layout)",
+		columns: []*column{
+			{name: "id", physical: typeInt64, converted: -1, dictionary: true,
+				values: []interface{}{int64(1), int64(2), int64(3), int64(4), int64(5), int64(6), int64(7)}},
+			{name: "city", physical: typeByteArray, optional: true, converted: convertedUTF8, logical: stringType, dictionary: true,
+				values: []interface{}{"Rome", nil, "Milan", "Rome", "Turin", "Rome", nil}},
+			{name: "at", physical: typeInt64, optional: true, converted: convertedTimestampMicros, logical: timestampMicros, dictionary: true,
+				values: []interface{}{at, nil, at, end, nil, moon, at}},
+			{name: "price", physical: typeDouble, optional: true, converted: -1, dictionary: true,
+				values: []interface{}{9.5, 9.5, nil, 0.1, 9.5, 2.25, nil}},
+		},
+	})
+
+	half := time.Date(2024, 3, 15, 10, 30, 0, 500000000, time.UTC)
+	last := time.Date(1999, 12, 31, 23, 59, 59, 999999999, time.UTC)
+
+	write("v2.parquet", &file{
+		v2:           true,
+		rowGroupSize: 5,
+		pageSize:     2,
+		dictEncoding: encodingPlain,
+		dataEncoding: encodingRLEDictionary,
+		createdBy:    "csvql testdata generator (Spark layout)",
+		columns: []*column{
+			{name: "id", physical: typeInt32, converted: -1, compressed: true,
+				values: []interface{}{int64(1), int64(2), int64(3), int64(4), int64(5)}},
+			{name: "name", physical: typeByteArray, optional: true, converted: convertedUTF8, logical: stringType, dictionary: true, compressed: true,
+				values: []interface{}{"ann", nil, "bob", "ann", "ann"}},
+			{name: "score", physical: typeDouble, optional: true, converted: -1, compressed: true,
+				values: []interface{}{1.5, nil, -2.0, nil, 0.25}},
+			{name: "at", physical: typeInt96, optional: true, converted: -1, compressed: true,
+				values: []interface{}{half, nil, last, half, nil}},
+			{name: "note", physical: typeByteArray, optional: true, converted: convertedUTF8, logical: stringType,
+				values: []interface{}{"x", nil, "", nil, "héllo"}},
+		},
+	})
+
+	// A NULL in a leaf of address stands for a NULL address
+	write("nested.parquet", &file{
+		rowGroupSize: 3,
+		pageSize:     3,
+		dictEncoding: encodingPlainDictionary,
+		dataEncoding: encodingRLEDictionary,
+		createdBy:    "csvql testdata generator (pyarrow layout)",
+		columns: []*column{
+			{name: "id", physical: typeInt64, converted: -1, dictionary: true,
+				values: []interface{}{int64(1), int64(2), int64(3), int64(4)}},
+			{name: "city", group: "address", physical: typeByteArray, optional: true, converted: convertedUTF8, logical: stringType, dictionary: true,
+				values: []interface{}{"Rome", nil, "Milan", "Rome"}},
+			{name: "zip", group: "address", physical: typeInt32, optional: true, converted: -1, dictionary: true,
+				values: []interface{}{int64(100), nil, int64(20100), int64(118)}},
+			{name: "name", physical: typeByteArray, optional: true, converted: convertedUTF8, logical: stringType, dictionary: true,
+				values: []interface{}{"ann", "bob", nil, "cy"}},
+		},
+	})
+}
+
+func stringType(t *thrift) {
+	t.begin(1)
+	t.end()
+}
+
+func timestampMicros(t *thrift) {
+	t.begin(8)
+	t.flag(1, true)
+	t.begin(2)
+	t.begin(2)
+	t.end()
+	t.end()
+	t.end()
+}
+
+// write encodes f and saves it as name
+func write(name string, f *file) {
+	out := []byte("PAR1")
+	rows := len(f.columns[0].values)
+
+	var meta thrift
+	meta.begin(0)
+	if f.v2 {
+		meta.i32(1, 2)
+	} else {
+		meta.i32(1, 1)
+	}
+
+	var fields int
+	elements := len(f.columns) + 1
+	for i, c := range f.columns {
+		if c.group == "" || i == 0 || f.columns[i-1].group != c.group {
+			fields++
+			if c.group != "" {
+				elements++
+			}
+		}
+	}
+	meta.list(2, tStruct, elements)
+	meta.begin(0)
+	meta.str(4, "schema")
+	meta.i32(5, int64(fields))
+	meta.end()
+	for i, c := range f.columns {
+		if c.group != "" && (i == 0 || f.columns[i-1].group != c.group) {
+			children := 0
+			for _, other := range f.columns[i:] {
+				if other.group != c.group {
+					break
+				}
+				children++
+			}
+			meta.begin(0)
+			meta.i32(3, 1)
+			meta.str(4, c.group)
+			meta.i32(5, int64(children))
+			meta.end()
+		}
+		meta.begin(0)
+		meta.i32(1, c.physical)
+		if c.optional {
+			meta.i32(3, 1)
+		} else {
+			meta.i32(3, 0)
+		}
+		meta.str(4, c.name)
+		if c.converted >= 0 {
+			meta.i32(6, c.converted)
+		}
+		if c.logical != nil {
+			meta.begin(10)
+			c.logical(&meta)
+			meta.end()
+		}
+		meta.end()
+	}
+	meta.i64(3, int64(rows))
+
+	groups := (rows + f.rowGroupSize - 1) / f.rowGroupSize
+	meta.list(4, tStruct, groups)
+	for start := 0; start < rows; start += f.rowGroupSize {
+		stop := min(start+f.rowGroupSize, rows)
+		meta.begin(0)
+		meta.list(1, tStruct, len(f.columns))
+		var total int64
+		for _, c := range f.columns {
+			offset := int64(len(out))
+			chunk, dictLen, uncompressed := f.chunk(c, c.values[start:stop])
+			out = append(out, chunk...)
+			total += uncompressed
+
+			meta.begin(0)
+			meta.i64(2, offset)
+			meta.begin(3)
+			meta.i32(1, c.physical)
+			if c.dictionary {
+				meta.list(2, tI32, 3)
+				meta.elemI32(f.dictEncoding)
+				meta.elemI32(encodingRLE)
+				meta.elemI32(f.dataEncoding)
+			} else {
+				meta.list(2, tI32, 2)
+				meta.elemI32(encodingPlain)
+				meta.elemI32(encodingRLE)
+			}
+			path := []string{c.name}
+			if c.group != "" {
+				path = slices.Insert(path, 0, c.group)
+			}
+			meta.list(3, tBinary, len(path))
+			for _, name := range path {
+				meta.elemString(name)
+			}
+			meta.i32(4, codecSnappy)
+			meta.i64(5, int64(stop-start))
+			meta.i64(6, uncompressed)
+			meta.i64(7, int64(len(chunk)))
+			meta.i64(9, offset+int64(dictLen))
+			if c.dictionary {
+				meta.i64(11, offset)
+			}
+			meta.end()
+			meta.end()
+		}
+		meta.i64(2, total)
+		meta.i64(3, int64(stop-start))
+		meta.end()
+	}
+	meta.str(6, f.createdBy)
+	meta.end()
+
+	out = append(out, meta.buf...)
+	out = binary.LittleEndian.AppendUint32(out, uint32(len(meta.buf)))
+	out = append(out, "PAR1"...)
+	if err := os.WriteFile(name, out, 0o644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// chunk encodes the pages of a column chunk, returning its bytes, the size
+// of its dictionary page and its uncompressed size
+func (f *file) chunk(c *column, values []interface{}) ([]byte, int, int64) {
+	var out []byte
+	var uncompressed int64
+	var dictLen int
+
+	var index map[string]int
+	if c.dictionary {
+		index = make(map[string]int)
+		var dict []byte
+		for _, v := range values {
+			if v == nil {
+				continue
+			}
+			key := string(plain(nil, c, v))
+			if _, ok := index[key]; !ok {
+				index[key] = len(index)
+				dict = append(dict, key...)
+			}
+		}
+		body := snappy.Encode(nil, dict)
+		var h thrift
+		h.begin(0)
+		h.i32(1, pageDictionary)
+		h.i32(2, int64(len(dict)))
+		h.i32(3, int64(len(body)))
+		h.begin(7)
+		h.i32(1, int64(len(index)))
+		h.i32(2, f.dictEncoding)
+		h.flag(3, false)
+		h.end()
+		h.end()
+		out = append(append(out, h.buf...), body...)
+		uncompressed += int64(len(h.buf) + len(dict))
+		dictLen = len(out)
+	}
+
+	for start := 0; start < len(values); start += f.pageSize {
+		page := values[start:min(start+f.pageSize, len(values))]
+
+		// A value is defined at the deepest level; NULL is 0, a NULL
+		// group for the leaves of one
+		maxDef := 0
+		if c.optional {
+			maxDef++
+		}
+		if c.group != "" {
+			maxDef++
+		}
+		var levels []byte
+		nulls := 0
+		if maxDef > 0 {
+			defs := make([]uint64, len(page))
+			for i, v := range page {
+				if v != nil {
+					defs[i] = uint64(maxDef)
+				} else {
+					nulls++
+				}
+			}
+			levels = encodeRuns(defs, bits.Len(uint(maxDef)))
+		}
+
+		var data []byte
+		encoding := int64(encodingPlain)
+		if c.dictionary {
+			encoding = f.dataEncoding
+			var ids []uint64
+			for _, v := range page {
+				if v != nil {
+					ids = append(ids, uint64(index[string(plain(nil, c, v))]))
+				}
+			}
+			width := max(bits.Len(uint(len(index)-1)), 1)
+			data = append([]byte{byte(width)}, encodeBitPacked(ids, width)...)
+		} else {
+			for _, v := range page {
+				if v != nil {
+					data = plain(data, c, v)
+				}
+			}
+		}
+
+		var h thrift
+		h.begin(0)
+		if f.v2 {
+			body := data
+			if c.compressed {
+				body = snappy.Encode(nil, data)
+			}
+			h.i32(1, pageDataV2)
+			h.i32(2, int64(len(levels)+len(data)))
+			h.i32(3, int64(len(levels)+len(body)))
+			h.begin(8)
+			h.i32(1, int64(len(page)))
+			h.i32(2, int64(nulls))
+			h.i32(3, int64(len(page)))
+			h.i32(4, encoding)
+			h.i32(5, int64(len(levels)))
+			h.i32(6, 0)
+			h.flag(7, c.compressed)
+			h.end()
+			h.end()
+			out = append(append(append(out, h.buf...), levels...), body...)
+			uncompressed += int64(len(h.buf) + len(levels) + len(data))
+			continue
+		}
+
+		var raw []byte
+		if maxDef > 0 {
+			raw = binary.LittleEndian.AppendUint32(raw, uint32(len(levels)))
+			raw = append(raw, levels...)
+		}
+		raw = append(raw, data...)
+		body := snappy.Encode(nil, raw)
+		h.i32(1, pageData)
+		h.i32(2, int64(len(raw)))
+		h.i32(3, int64(len(body)))
+		h.begin(5)
+		h.i32(1, int64(len(page)))
+		h.i32(2, encoding)
+		h.i32(3, encodingRLE)
+		h.i32(4, encodingRLE)
+		h.end()
+		h.end()
+		out = append(append(out, h.buf...), body...)
+		uncompressed += int64(len(h.buf) + len(raw))
+	}
+	return out, dictLen, uncompressed
+}
+
+// plain appends v in PLAIN encoding for the physical type of c
+func plain(buf []byte, c *column, v interface{}) []byte {
+	switch v := v.(type) {
+	case int64:
+		if c.physical == typeInt32 {
+			return binary.LittleEndian.AppendUint32(buf, uint32(v))
+		}
+		return binary.LittleEndian.AppendUint64(buf, uint64(v))
+	case float64:
+		return binary.LittleEndian.AppendUint64(buf, math.Float64bits(v))
+	case string:
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(len(v)))
+		return append(buf, v...)
+	case time.Time:
+		if c.physical == typeInt96 {
+			midnight := v.Truncate(24 * time.Hour)
+			buf = binary.LittleEndian.AppendUint64(buf, uint64(v.Sub(midnight)))
+			return binary.LittleEndian.AppendUint32(buf, uint32(midnight.Unix()/86400+2440588))
+		}
+		return binary.LittleEndian.AppendUint64(buf, uint64(v.UnixMicro()))
+	}
+	log.Fatalf("unsupported value %T", v)
+	return nil
+}
+
+// encodeRuns encodes values as RLE runs of the hybrid encoding, as writers
+// do for definition levels
+func encodeRuns(values []uint64, width int) []byte {
+	var out []byte
+	for i := 0; i < len(values); {
+		j := i
+		for j < len(values) && values[j] == values[i] {
+			j++
+		}
+		out = binary.AppendUvarint(out, uint64(j-i)<<1)
+		for b := 0; b < (width+7)/8; b++ {
+			out = append(out, byte(values[i]>>(8*b)))
+		}
+		i = j
+	}
+	return out
+}
+
+// encodeBitPacked encodes values as one bit-packed run of the hybrid
+// encoding, padded to a multiple of eight values, as writers do for
+// dictionary indices
+func encodeBitPacked(values []uint64, width int) []byte {
+	groups := (len(values) + 7) / 8
+	out := binary.AppendUvarint(nil, uint64(groups)<<1|1)
+	packed := make([]byte, groups*width)
+	for i, v := range values {
+		for b := 0; b < width; b++ {
+			if v>>b&1 == 1 {
+				pos := i*width + b
+				packed[pos/8] |= 1 << (pos % 8)
+			}
+		}
+	}
+	return append(out, packed...)
+}
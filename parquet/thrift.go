@@ -0,0 +1,299 @@
+package parquet
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Thrift compact protocol type ids, as used by the Parquet metadata
+const (
+	compactStop   = 0
+	compactTrue   = 1
+	compactFalse  = 2
+	compactByte   = 3
+	compactI16    = 4
+	compactI32    = 5
+	compactI64    = 6
+	compactDouble = 7
+	compactBinary = 8
+	compactList   = 9
+	compactSet    = 10
+	compactMap    = 11
+	compactStruct = 12
+)
+
+// errThriftTruncated is returned when a structure runs past its buffer
+var errThriftTruncated = errors.New("truncated thrift data")
+
+// thriftStruct is a decoded struct: field id to value. Values are int64 for
+// every integer type, bool, float64, []byte, []interface{} or thriftStruct.
+type thriftStruct map[int16]interface{}
+
+func (s thriftStruct) int(id int16) int64 {
+	n, _ := s[id].(int64)
+	return n
+}
+
+func (s thriftStruct) has(id int16) bool {
+	_, ok := s[id]
+	return ok
+}
+
+func (s thriftStruct) bool(id int16) bool {
+	b, _ := s[id].(bool)
+	return b
+}
+
+func (s thriftStruct) string(id int16) string {
+	b, _ := s[id].([]byte)
+	return string(b)
+}
+
+func (s thriftStruct) sub(id int16) thriftStruct {
+	sub, _ := s[id].(thriftStruct)
+	return sub
+}
+
+func (s thriftStruct) list(id int16) []interface{} {
+	list, _ := s[id].([]interface{})
+	return list
+}
+
+// thriftDecoder reads compact protocol values from a buffer
+type thriftDecoder struct {
+	buf []byte
+	pos int
+}
+
+// decodeStruct decodes one struct from the start of buf and returns it with
+// the number of bytes it used
+func decodeStruct(buf []byte) (thriftStruct, int, error) {
+	d := &thriftDecoder{buf: buf}
+	s, err := d.readStruct(0)
+	return s, d.pos, err
+}
+
+func (d *thriftDecoder) readByte() (byte, error) {
+	if d.pos >= len(d.buf) {
+		return 0, errThriftTruncated
+	}
+	b := d.buf[d.pos]
+	d.pos++
+	return b, nil
+}
+
+func (d *thriftDecoder) readVarint() (uint64, error) {
+	v, n := binary.Uvarint(d.buf[d.pos:])
+	if n <= 0 {
+		return 0, errThriftTruncated
+	}
+	d.pos += n
+	return v, nil
+}
+
+func (d *thriftDecoder) readZigzag() (int64, error) {
+	v, err := d.readVarint()
+	return int64(v>>1) ^ -int64(v&1), err
+}
+
+// readStruct reads fields until the stop byte. depth guards against
+// malicious nesting.
+func (d *thriftDecoder) readStruct(depth int) (thriftStruct, error) {
+	if depth > 64 {
+		return nil, errors.New("thrift data nested too deeply")
+	}
+	s := make(thriftStruct)
+	var last int16
+	for {
+		header, err := d.readByte()
+		if err != nil {
+			return nil, err
+		}
+		if header == compactStop {
+			return s, nil
+		}
+
+		id := last + int16(header>>4)
+		if header>>4 == 0 {
+			n, err := d.readZigzag()
+			if err != nil {
+				return nil, err
+			}
+			id = int16(n)
+		}
+		last = id
+
+		typ := header & 0x0f
+		if typ == compactTrue || typ == compactFalse {
+			s[id] = typ == compactTrue
+			continue
+		}
+		if s[id], err = d.readValue(typ, depth); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// readValue reads a value of the given type
+func (d *thriftDecoder) readValue(typ byte, depth int) (interface{}, error) {
+	switch typ {
+	case compactTrue, compactFalse:
+		b, err := d.readByte()
+		return b == compactTrue, err
+	case compactByte:
+		b, err := d.readByte()
+		return int64(int8(b)), err
+	case compactI16, compactI32, compactI64:
+		return d.readZigzag()
+	case compactDouble:
+		if d.pos+8 > len(d.buf) {
+			return nil, errThriftTruncated
+		}
+		v := math.Float64frombits(binary.LittleEndian.Uint64(d.buf[d.pos:]))
+		d.pos += 8
+		return v, nil
+	case compactBinary:
+		n, err := d.readVarint()
+		if err != nil {
+			return nil, err
+		}
+		if n > uint64(len(d.buf)-d.pos) {
+			return nil, errThriftTruncated
+		}
+		b := d.buf[d.pos : d.pos+int(n)]
+		d.pos += int(n)
+		return b, nil
+	case compactList, compactSet:
+		header, err := d.readByte()
+		if err != nil {
+			return nil, err
+		}
+		size := uint64(header >> 4)
+		if size == 15 {
+			if size, err = d.readVarint(); err != nil {
+				return nil, err
+			}
+		}
+		if size > uint64(len(d.buf)-d.pos) {
+			return nil, errThriftTruncated
+		}
+		list := make([]interface{}, 0, size)
+		for i := uint64(0); i < size; i++ {
+			v, err := d.readValue(header&0x0f, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+		return list, nil
+	case compactMap:
+		size, err := d.readVarint()
+		if err != nil || size == 0 {
+			return nil, err
+		}
+		types, err := d.readByte()
+		if err != nil {
+			return nil, err
+		}
+		for i := uint64(0); i < size; i++ {
+			if _, err := d.readValue(types>>4, depth+1); err != nil {
+				return nil, err
+			}
+			if _, err := d.readValue(types&0x0f, depth+1); err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	case compactStruct:
+		return d.readStruct(depth + 1)
+	}
+	return nil, fmt.Errorf("unknown thrift type %d", typ)
+}
+
+// thriftEncoder writes compact protocol values
+type thriftEncoder struct {
+	buf  []byte
+	last []int16 // last field id of each open struct
+}
+
+func (e *thriftEncoder) varint(v uint64) {
+	e.buf = binary.AppendUvarint(e.buf, v)
+}
+
+func (e *thriftEncoder) zigzag(v int64) {
+	e.varint(uint64(v<<1) ^ uint64(v>>63))
+}
+
+// field writes a field header
+func (e *thriftEncoder) field(id int16, typ byte) {
+	last := &e.last[len(e.last)-1]
+	if delta := id - *last; delta > 0 && delta <= 15 {
+		e.buf = append(e.buf, byte(delta)<<4|typ)
+	} else {
+		e.buf = append(e.buf, typ)
+		e.zigzag(int64(id))
+	}
+	*last = id
+}
+
+func (e *thriftEncoder) beginStruct() {
+	e.last = append(e.last, 0)
+}
+
+func (e *thriftEncoder) endStruct() {
+	e.buf = append(e.buf, compactStop)
+	e.last = e.last[:len(e.last)-1]
+}
+
+func (e *thriftEncoder) i32(id int16, v int32) {
+	e.field(id, compactI32)
+	e.zigzag(int64(v))
+}
+
+func (e *thriftEncoder) i64(id int16, v int64) {
+	e.field(id, compactI64)
+	e.zigzag(v)
+}
+
+func (e *thriftEncoder) bool(id int16, v bool) {
+	if v {
+		e.field(id, compactTrue)
+	} else {
+		e.field(id, compactFalse)
+	}
+}
+
+func (e *thriftEncoder) binary(id int16, b []byte) {
+	e.field(id, compactBinary)
+	e.varint(uint64(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+func (e *thriftEncoder) string(id int16, s string) {
+	e.binary(id, []byte(s))
+}
+
+// structField opens a nested struct field; close it with endStruct
+func (e *thriftEncoder) structField(id int16) {
+	e.field(id, compactStruct)
+	e.beginStruct()
+}
+
+// emptyStruct writes a struct field without fields, as used by unions
+func (e *thriftEncoder) emptyStruct(id int16) {
+	e.structField(id)
+	e.endStruct()
+}
+
+// listField writes a list field header; the elements follow
+func (e *thriftEncoder) listField(id int16, elem byte, size int) {
+	e.field(id, compactList)
+	if size < 15 {
+		e.buf = append(e.buf, byte(size)<<4|elem)
+	} else {
+		e.buf = append(e.buf, 0xf0|elem)
+		e.varint(uint64(size))
+	}
+}
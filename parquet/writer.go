@@ -0,0 +1,347 @@
+package parquet
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+)
+
+// DefaultRowGroupSize is the number of rows buffered per row group
+const DefaultRowGroupSize = 100000
+
+// createdBy is recorded in the footer of written files
+const createdBy = "csvql"
+
+// Writer writes rows to a Parquet file: PLAIN-encoded, Snappy-compressed
+// pages, one page per column and row group
+type Writer struct {
+	RowGroupSize int
+
+	w         io.Writer
+	pos       int64
+	columns   []Column
+	pending   [][]interface{} // buffered values of the current row group, by column
+	rows      int
+	numRows   int64
+	rowGroups []rowGroupMeta
+	err       error
+}
+
+// rowGroupMeta records where the column chunks of a written row group are
+type rowGroupMeta struct {
+	numRows int
+	size    int64
+	chunks  []chunkMeta
+}
+
+type chunkMeta struct {
+	offset           int64
+	values           int
+	compressedSize   int64
+	uncompressedSize int64
+}
+
+// NewWriter starts a Parquet file with the given columns. Decimal and Time
+// columns cannot be written; store them as String.
+func NewWriter(w io.Writer, columns []Column) (*Writer, error) {
+	for _, c := range columns {
+		if c.Kind == Decimal || c.Kind == Time || c.Kind > Time {
+			return nil, fmt.Errorf("column %s: cannot write %s columns", c.Name, c.Kind)
+		}
+	}
+
+	pw := &Writer{
+		RowGroupSize: DefaultRowGroupSize,
+		w:            w,
+		columns:      columns,
+		pending:      make([][]interface{}, len(columns)),
+	}
+	if err := pw.write([]byte(magic)); err != nil {
+		return nil, err
+	}
+	return pw, nil
+}
+
+// write appends to the file, keeping track of the offset
+func (w *Writer) write(b []byte) error {
+	if w.err != nil {
+		return w.err
+	}
+	n, err := w.w.Write(b)
+	w.pos += int64(n)
+	w.err = err
+	return err
+}
+
+// Write buffers a row. Values must be nil or of the Go type of their
+// column's kind; Double columns also accept int64.
+func (w *Writer) Write(row []interface{}) error {
+	if len(row) != len(w.columns) {
+		return fmt.Errorf("expected %d values, got %d", len(w.columns), len(row))
+	}
+	values := make([]interface{}, len(row))
+	for i, v := range row {
+		c := w.columns[i]
+		values[i] = v
+		if v == nil {
+			if !c.Optional {
+				return fmt.Errorf("column %s: NULL in a required column", c.Name)
+			}
+			continue
+		}
+		ok := false
+		switch c.Kind {
+		case Bytes:
+			_, ok = v.([]byte)
+		case String:
+			_, ok = v.(string)
+		case Boolean:
+			_, ok = v.(bool)
+		case Int64:
+			_, ok = v.(int64)
+		case Double:
+			if n, isInt := v.(int64); isInt {
+				values[i], ok = float64(n), true
+			} else {
+				_, ok = v.(float64)
+			}
+		case Date, Timestamp:
+			_, ok = v.(time.Time)
+		}
+		if !ok {
+			return fmt.Errorf("column %s: cannot write %T as %s", c.Name, v, c.Kind)
+		}
+	}
+
+	for i, v := range values {
+		w.pending[i] = append(w.pending[i], v)
+	}
+	w.rows++
+	if w.rows >= w.RowGroupSize {
+		return w.flushRowGroup()
+	}
+	return nil
+}
+
+// flushRowGroup writes the buffered rows as a row group
+func (w *Writer) flushRowGroup() error {
+	if w.rows == 0 {
+		return nil
+	}
+	rg := rowGroupMeta{numRows: w.rows}
+	for i, c := range w.columns {
+		chunk, err := w.writeChunk(c, w.pending[i])
+		if err != nil {
+			return fmt.Errorf("column %s: %w", c.Name, err)
+		}
+		rg.size += chunk.uncompressedSize
+		rg.chunks = append(rg.chunks, chunk)
+		w.pending[i] = w.pending[i][:0]
+	}
+	w.rowGroups = append(w.rowGroups, rg)
+	w.numRows += int64(w.rows)
+	w.rows = 0
+	return nil
+}
+
+// writeChunk writes a column chunk as a single data page
+func (w *Writer) writeChunk(c Column, values []interface{}) (chunkMeta, error) {
+	var body []byte
+	var defs []uint64
+	for _, v := range values {
+		if v == nil {
+			defs = append(defs, 0)
+		} else {
+			defs = append(defs, 1)
+		}
+	}
+	if c.Optional {
+		levels := encodeRLE(defs, 1)
+		body = binary.LittleEndian.AppendUint32(body, uint32(len(levels)))
+		body = append(body, levels...)
+	}
+	body = appendPlain(body, c.Kind, values)
+
+	compressed := snappy.Encode(nil, body)
+
+	var header thriftEncoder
+	header.beginStruct()
+	header.i32(1, pageData)
+	header.i32(2, int32(len(body)))
+	header.i32(3, int32(len(compressed)))
+	header.structField(5)
+	header.i32(1, int32(len(values)))
+	header.i32(2, encodingPlain)
+	header.i32(3, encodingRLE)
+	header.i32(4, encodingRLE)
+	header.endStruct()
+	header.endStruct()
+
+	chunk := chunkMeta{
+		offset:           w.pos,
+		values:           len(values),
+		compressedSize:   int64(len(header.buf) + len(compressed)),
+		uncompressedSize: int64(len(header.buf) + len(body)),
+	}
+	if err := w.write(header.buf); err != nil {
+		return chunk, err
+	}
+	return chunk, w.write(compressed)
+}
+
+// appendPlain appends the non-null values in PLAIN encoding
+func appendPlain(buf []byte, kind Kind, values []interface{}) []byte {
+	if kind == Boolean {
+		var bits []byte
+		n := 0
+		for _, v := range values {
+			if v == nil {
+				continue
+			}
+			if n%8 == 0 {
+				bits = append(bits, 0)
+			}
+			if v.(bool) {
+				bits[n/8] |= 1 << (n % 8)
+			}
+			n++
+		}
+		return append(buf, bits...)
+	}
+
+	for _, v := range values {
+		switch v := v.(type) {
+		case nil:
+		case int64:
+			buf = binary.LittleEndian.AppendUint64(buf, uint64(v))
+		case float64:
+			buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(v))
+		case string:
+			buf = binary.LittleEndian.AppendUint32(buf, uint32(len(v)))
+			buf = append(buf, v...)
+		case []byte:
+			buf = binary.LittleEndian.AppendUint32(buf, uint32(len(v)))
+			buf = append(buf, v...)
+		case time.Time:
+			if kind == Date {
+				days := math.Floor(float64(v.Unix()) / 86400)
+				buf = binary.LittleEndian.AppendUint32(buf, uint32(int32(days)))
+			} else {
+				buf = binary.LittleEndian.AppendUint64(buf, uint64(v.UnixMicro()))
+			}
+		}
+	}
+	return buf
+}
+
+// Close writes the remaining rows and the footer. It does not close the
+// underlying writer.
+func (w *Writer) Close() error {
+	if err := w.flushRowGroup(); err != nil {
+		return err
+	}
+
+	var e thriftEncoder
+	e.beginStruct()
+	e.i32(1, 1)
+	e.listField(2, compactStruct, len(w.columns)+1)
+	e.beginStruct()
+	e.string(4, "schema")
+	e.i32(5, int32(len(w.columns)))
+	e.endStruct()
+	for _, c := range w.columns {
+		writeSchemaElement(&e, c)
+	}
+	e.i64(3, w.numRows)
+
+	e.listField(4, compactStruct, len(w.rowGroups))
+	for _, rg := range w.rowGroups {
+		e.beginStruct()
+		e.listField(1, compactStruct, len(rg.chunks))
+		for i, chunk := range rg.chunks {
+			c := w.columns[i]
+			e.beginStruct()
+			e.i64(2, chunk.offset)
+			e.structField(3)
+			e.i32(1, int32(physicalType(c.Kind)))
+			e.listField(2, compactI32, 2)
+			e.zigzag(encodingPlain)
+			e.zigzag(encodingRLE)
+			e.listField(3, compactBinary, 1)
+			e.varint(uint64(len(c.Name)))
+			e.buf = append(e.buf, c.Name...)
+			e.i32(4, codecSnappy)
+			e.i64(5, int64(chunk.values))
+			e.i64(6, chunk.uncompressedSize)
+			e.i64(7, chunk.compressedSize)
+			e.i64(9, chunk.offset)
+			e.endStruct()
+			e.endStruct()
+		}
+		e.i64(2, rg.size)
+		e.i64(3, int64(rg.numRows))
+		e.endStruct()
+	}
+	e.string(6, createdBy)
+	e.endStruct()
+
+	footer := binary.LittleEndian.AppendUint32(e.buf, uint32(len(e.buf)))
+	footer = append(footer, magic...)
+	return w.write(footer)
+}
+
+// physicalType returns the stored type of a kind
+func physicalType(kind Kind) int {
+	switch kind {
+	case Boolean:
+		return typeBoolean
+	case Int64, Timestamp:
+		return typeInt64
+	case Double:
+		return typeDouble
+	case Date:
+		return typeInt32
+	}
+	return typeByteArray
+}
+
+// writeSchemaElement writes the schema of a column with both its converted
+// and logical type, so older readers understand it too
+func writeSchemaElement(e *thriftEncoder, c Column) {
+	e.beginStruct()
+	e.i32(1, int32(physicalType(c.Kind)))
+	if c.Optional {
+		e.i32(3, repetitionOptional)
+	} else {
+		e.i32(3, repetitionRequired)
+	}
+	e.string(4, c.Name)
+
+	switch c.Kind {
+	case String:
+		e.i32(6, convertedUTF8)
+		e.structField(10)
+		e.emptyStruct(1)
+		e.endStruct()
+	case Date:
+		e.i32(6, convertedDate)
+		e.structField(10)
+		e.emptyStruct(6)
+		e.endStruct()
+	case Timestamp:
+		e.i32(6, convertedTimestampMicros)
+		e.structField(10)
+		e.structField(8)
+		e.bool(1, true)
+		e.structField(2)
+		e.emptyStruct(2)
+		e.endStruct()
+		e.endStruct()
+		e.endStruct()
+	}
+	e.endStruct()
+}
@@ -0,0 +1,79 @@
+package pgwire
+
+import (
+	"fmt"
+	"strings"
+)
+
+// serverVersion is reported to clients, which pick features by it
+const serverVersion = "14.0"
+
+// pgDataType maps a declared SQLite column type to the information_schema
+// data_type of its Postgres type
+const pgDataType = `CASE upper(p.type)
+	WHEN 'INTEGER' THEN 'bigint'
+	WHEN 'REAL' THEN 'double precision'
+	WHEN 'BOOLEAN' THEN 'boolean'
+	WHEN 'DATE' THEN 'date'
+	WHEN 'DATETIME' THEN 'timestamp without time zone'
+	ELSE 'text' END`
+
+// catalogViews emulate the parts of pg_catalog and information_schema that
+// clients query to list tables and columns. They are TEMP views of the
+// session's connection; rewrite maps pg_catalog.x and information_schema.x
+// to them.
+func catalogViews(database string) []string {
+	db := quoteLiteral(database)
+	return []string{
+		`CREATE TEMP VIEW pg_namespace AS
+			SELECT 11 AS oid, 'pg_catalog' AS nspname
+			UNION ALL SELECT 2200, 'public'
+			UNION ALL SELECT 13000, 'information_schema'`,
+
+		fmt.Sprintf(`CREATE TEMP VIEW pg_database AS SELECT 1 AS oid, %s AS datname, 'UTF8' AS encoding`, db),
+
+		`CREATE TEMP VIEW pg_class AS
+			SELECT 16384 + m.rowid AS oid, m.table_name AS relname, 2200 AS relnamespace, 'r' AS relkind
+			FROM main._csvql_metadata m`,
+
+		`CREATE TEMP VIEW pg_tables AS
+			SELECT 'public' AS schemaname, table_name AS tablename, 'csvql' AS tableowner,
+				NULL AS tablespace, 'f' AS hasindexes, 'f' AS hasrules, 'f' AS hastriggers, 'f' AS rowsecurity
+			FROM main._csvql_metadata`,
+
+		`CREATE TEMP VIEW pg_views AS
+			SELECT NULL AS schemaname, NULL AS viewname, NULL AS viewowner, NULL AS definition WHERE 0`,
+
+		fmt.Sprintf(`CREATE TEMP VIEW information_schema_schemata AS
+			SELECT %[1]s AS catalog_name, 'public' AS schema_name, 'csvql' AS schema_owner
+			UNION ALL SELECT %[1]s, 'pg_catalog', 'csvql'
+			UNION ALL SELECT %[1]s, 'information_schema', 'csvql'`, db),
+
+		fmt.Sprintf(`CREATE TEMP VIEW information_schema_tables AS
+			SELECT %s AS table_catalog, 'public' AS table_schema, table_name, 'BASE TABLE' AS table_type
+			FROM main._csvql_metadata`, db),
+
+		fmt.Sprintf(`CREATE TEMP VIEW information_schema_columns AS
+			SELECT %s AS table_catalog, 'public' AS table_schema, m.table_name, p.name AS column_name,
+				p.cid + 1 AS ordinal_position, NULL AS column_default, 'YES' AS is_nullable,
+				%s AS data_type
+			FROM main._csvql_metadata m, pragma_table_info(m.table_name) p`, db, pgDataType),
+	}
+}
+
+// catalogFuncs are the Postgres functions of a session
+func catalogFuncs(user, database string) map[string]interface{} {
+	return map[string]interface{}{
+		"version":          func() string { return "PostgreSQL " + serverVersion + " (csvql)" },
+		"current_database": func() string { return database },
+		"current_catalog":  func() string { return database },
+		"current_schema":   func() string { return "public" },
+		"current_user":     func() string { return user },
+		"session_user":     func() string { return user },
+	}
+}
+
+// quoteLiteral quotes a string for SQL
+func quoteLiteral(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
@@ -0,0 +1,345 @@
+package pgwire
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Protocol codes of the startup packet
+const (
+	protocolVersion = 196608 // 3.0
+	sslRequest      = 80877103
+	gssEncRequest   = 80877104
+	cancelRequest   = 80877102
+)
+
+// maxMessageSize bounds a client message, so a bad length cannot exhaust memory
+const maxMessageSize = 64 << 20
+
+// Type OIDs of the columns and parameters, from pg_type
+const (
+	oidBool        = 16
+	oidBytea       = 17
+	oidInt8        = 20
+	oidInt2        = 21
+	oidInt4        = 23
+	oidText        = 25
+	oidFloat4      = 700
+	oidFloat8      = 701
+	oidVarchar     = 1043
+	oidDate        = 1082
+	oidTimestamp   = 1114
+	oidTimestamptz = 1184
+)
+
+// typeOIDs maps the declared SQLite column types to Postgres types; other
+// and computed columns are text
+var typeOIDs = map[string]uint32{
+	"INTEGER":  oidInt8,
+	"REAL":     oidFloat8,
+	"BOOLEAN":  oidBool,
+	"DATE":     oidDate,
+	"DATETIME": oidTimestamp,
+}
+
+// typeSizes is the pg_type.typlen of the column types, -1 for varlena
+var typeSizes = map[uint32]int16{
+	oidBool:      1,
+	oidInt8:      8,
+	oidFloat8:    8,
+	oidDate:      4,
+	oidTimestamp: 8,
+}
+
+// typeOID returns the Postgres type of a declared SQLite column type
+func typeOID(dbType string) uint32 {
+	if oid, ok := typeOIDs[strings.ToUpper(dbType)]; ok {
+		return oid
+	}
+	return oidText
+}
+
+// pgEpoch is the zero of binary dates and timestamps
+var pgEpoch = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// message is a buffer for one backend message
+type message struct {
+	buf []byte
+}
+
+func newMessage(typ byte) *message {
+	return &message{buf: []byte{typ, 0, 0, 0, 0}}
+}
+
+func (m *message) byte(b byte) *message {
+	m.buf = append(m.buf, b)
+	return m
+}
+
+func (m *message) int16(v int16) *message {
+	m.buf = binary.BigEndian.AppendUint16(m.buf, uint16(v))
+	return m
+}
+
+func (m *message) int32(v int32) *message {
+	m.buf = binary.BigEndian.AppendUint32(m.buf, uint32(v))
+	return m
+}
+
+func (m *message) string(s string) *message {
+	m.buf = append(m.buf, s...)
+	m.buf = append(m.buf, 0)
+	return m
+}
+
+func (m *message) bytes(b []byte) *message {
+	m.buf = append(m.buf, b...)
+	return m
+}
+
+// finish fills in the length and returns the encoded message
+func (m *message) finish() []byte {
+	binary.BigEndian.PutUint32(m.buf[1:5], uint32(len(m.buf)-1))
+	return m.buf
+}
+
+// readStartup reads the length-prefixed packet that opens a connection
+func readStartup(r *bufio.Reader) ([]byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	n := int(binary.BigEndian.Uint32(header[:]))
+	if n < 8 || n > 10000 {
+		return nil, fmt.Errorf("invalid startup packet length %d", n)
+	}
+	body := make([]byte, n-4)
+	_, err := io.ReadFull(r, body)
+	return body, err
+}
+
+// readMessage reads a typed frontend message
+func readMessage(r *bufio.Reader) (byte, []byte, error) {
+	typ, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, nil, err
+	}
+	n := int(binary.BigEndian.Uint32(header[:]))
+	if n < 4 || n > maxMessageSize {
+		return 0, nil, fmt.Errorf("invalid message length %d", n)
+	}
+	body := make([]byte, n-4)
+	_, err = io.ReadFull(r, body)
+	return typ, body, err
+}
+
+// errMalformed is returned when a message is shorter than its fields
+var errMalformed = errors.New("malformed message")
+
+// reader decodes the fields of a frontend message
+type reader struct {
+	buf []byte
+	err error
+}
+
+func (r *reader) byte() byte {
+	if len(r.buf) < 1 {
+		r.err = errMalformed
+		return 0
+	}
+	b := r.buf[0]
+	r.buf = r.buf[1:]
+	return b
+}
+
+func (r *reader) int16() int16 {
+	if len(r.buf) < 2 {
+		r.err = errMalformed
+		return 0
+	}
+	v := int16(binary.BigEndian.Uint16(r.buf))
+	r.buf = r.buf[2:]
+	return v
+}
+
+func (r *reader) int32() int32 {
+	if len(r.buf) < 4 {
+		r.err = errMalformed
+		return 0
+	}
+	v := int32(binary.BigEndian.Uint32(r.buf))
+	r.buf = r.buf[4:]
+	return v
+}
+
+func (r *reader) string() string {
+	i := strings.IndexByte(string(r.buf), 0)
+	if i < 0 {
+		r.err = errMalformed
+		return ""
+	}
+	s := string(r.buf[:i])
+	r.buf = r.buf[i+1:]
+	return s
+}
+
+func (r *reader) bytes(n int) []byte {
+	if n < 0 || len(r.buf) < n {
+		r.err = errMalformed
+		return nil
+	}
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b
+}
+
+// encodeText renders a value in the text format; nil is NULL
+func encodeText(v interface{}) []byte {
+	switch val := v.(type) {
+	case nil:
+		return nil
+	case bool:
+		if val {
+			return []byte("t")
+		}
+		return []byte("f")
+	case int64:
+		return strconv.AppendInt(nil, val, 10)
+	case float64:
+		switch {
+		case math.IsInf(val, 1):
+			return []byte("Infinity")
+		case math.IsInf(val, -1):
+			return []byte("-Infinity")
+		case math.IsNaN(val):
+			return []byte("NaN")
+		}
+		return strconv.AppendFloat(nil, val, 'g', -1, 64)
+	case string:
+		return []byte(val)
+	}
+	return []byte(fmt.Sprint(v))
+}
+
+// encodeBinary renders a value in the binary format of a column type
+func encodeBinary(v interface{}, oid uint32) ([]byte, error) {
+	if v == nil {
+		return nil, nil
+	}
+	text := string(encodeText(v))
+	switch oid {
+	case oidBool:
+		b, ok := v.(bool)
+		if !ok {
+			return nil, fmt.Errorf("%q is not a boolean", text)
+		}
+		if b {
+			return []byte{1}, nil
+		}
+		return []byte{0}, nil
+	case oidInt8:
+		i, err := strconv.ParseInt(text, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an integer", text)
+		}
+		return binary.BigEndian.AppendUint64(nil, uint64(i)), nil
+	case oidFloat8:
+		f, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a number", text)
+		}
+		return binary.BigEndian.AppendUint64(nil, math.Float64bits(f)), nil
+	case oidDate:
+		t, err := time.Parse("2006-01-02", text)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a date", text)
+		}
+		days := int32((t.Unix() - pgEpoch.Unix()) / 86400)
+		return binary.BigEndian.AppendUint32(nil, uint32(days)), nil
+	case oidTimestamp:
+		t, err := parseTimestamp(text)
+		if err != nil {
+			return nil, err
+		}
+		micros := t.UnixMicro() - pgEpoch.UnixMicro()
+		return binary.BigEndian.AppendUint64(nil, uint64(micros)), nil
+	}
+	return []byte(text), nil
+}
+
+// parseTimestamp parses the ISO-8601 timestamps of DATETIME columns
+func parseTimestamp(s string) (time.Time, error) {
+	for _, layout := range []string{"2006-01-02 15:04:05.999999999", "2006-01-02 15:04:05.999999999-07:00", time.RFC3339Nano} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%q is not a timestamp", s)
+}
+
+// decodeParam converts a bound parameter to a value for SQLite. Text
+// parameters are passed as strings, which SQLite converts when compared
+// with a typed column; binary ones are decoded by their declared type.
+func decodeParam(data []byte, binaryFormat bool, oid uint32) (interface{}, error) {
+	if data == nil {
+		return nil, nil
+	}
+	if !binaryFormat {
+		return string(data), nil
+	}
+	switch oid {
+	case oidBool:
+		if len(data) == 1 {
+			return data[0] != 0, nil
+		}
+	case oidInt2:
+		if len(data) == 2 {
+			return int64(int16(binary.BigEndian.Uint16(data))), nil
+		}
+	case oidInt4:
+		if len(data) == 4 {
+			return int64(int32(binary.BigEndian.Uint32(data))), nil
+		}
+	case oidInt8:
+		if len(data) == 8 {
+			return int64(binary.BigEndian.Uint64(data)), nil
+		}
+	case oidFloat4:
+		if len(data) == 4 {
+			return float64(math.Float32frombits(binary.BigEndian.Uint32(data))), nil
+		}
+	case oidFloat8:
+		if len(data) == 8 {
+			return math.Float64frombits(binary.BigEndian.Uint64(data)), nil
+		}
+	case oidDate:
+		if len(data) == 4 {
+			days := int32(binary.BigEndian.Uint32(data))
+			return pgEpoch.AddDate(0, 0, int(days)).Format("2006-01-02"), nil
+		}
+	case oidTimestamp, oidTimestamptz:
+		if len(data) == 8 {
+			micros := int64(binary.BigEndian.Uint64(data))
+			t := time.UnixMicro(pgEpoch.UnixMicro() + micros).UTC()
+			return t.Format("2006-01-02 15:04:05.999999"), nil
+		}
+	case oidText, oidVarchar, 0:
+		return string(data), nil
+	case oidBytea:
+		return data, nil
+	default:
+		return nil, fmt.Errorf("binary parameters of type %d are not supported", oid)
+	}
+	return nil, fmt.Errorf("invalid binary parameter of type %d", oid)
+}
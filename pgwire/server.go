@@ -0,0 +1,278 @@
+// Package pgwire serves the tables of a CSVQL instance over the PostgreSQL
+// v3 wire protocol, so psql, BI tools and Postgres drivers can query them
+package pgwire
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"csvql"
+)
+
+// Options configure a Server
+type Options struct {
+	// Password, if set, is required from clients as a cleartext password;
+	// any user name is accepted
+	Password string
+	// Timeout bounds the time a statement runs; zero means no limit
+	Timeout time.Duration
+}
+
+// Server accepts PostgreSQL connections. Queries run on the managed SQLite
+// database, read-only unless the CSVQL instance allows writes; each
+// connection gets emulated pg_catalog and information_schema views listing
+// the loaded tables.
+type Server struct {
+	c    *csvql.CSVQL
+	opts Options
+
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	sessions map[int32]*session // by process ID, for cancel requests
+	nextPID  int32
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+// New creates a Server for a CSVQL instance
+func New(c *csvql.CSVQL, opts Options) *Server {
+	return &Server{
+		c:        c,
+		opts:     opts,
+		conns:    make(map[net.Conn]struct{}),
+		sessions: make(map[int32]*session),
+	}
+}
+
+// ListenAndServe listens on a TCP address and serves connections until Close
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve serves connections accepted by a listener until Close
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		l.Close()
+		return net.ErrClosed
+	}
+	s.listener = l
+	s.mu.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+		s.mu.Lock()
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handle(conn)
+			s.mu.Lock()
+			delete(s.conns, conn)
+			s.mu.Unlock()
+		}()
+	}
+}
+
+// Close stops accepting connections and closes the open ones
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	return err
+}
+
+// handle runs a connection from the startup packet to its end
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+
+	sess := &session{
+		server:   s,
+		conn:     conn,
+		r:        bufio.NewReader(conn),
+		w:        bufio.NewWriter(conn),
+		stmts:    make(map[string]*statement),
+		portals:  make(map[string]*portal),
+		txStatus: 'I',
+	}
+	params, ok := sess.startup()
+	if !ok {
+		return
+	}
+	if !sess.authenticate() {
+		return
+	}
+
+	ctx := context.Background()
+	dbConn, err := s.c.DB.Conn(ctx)
+	if err != nil {
+		sess.fatal("08006", err.Error())
+		return
+	}
+	defer dbConn.Close()
+	sess.db = dbConn
+
+	sess.user = params["user"]
+	sess.database = params["database"]
+	if sess.database == "" {
+		sess.database = "csvql"
+	}
+	if err := dbConn.Setup(ctx, catalogViews(sess.database), catalogFuncs(sess.user, sess.database)); err != nil {
+		sess.fatal("XX000", err.Error())
+		return
+	}
+
+	sess.pid, sess.secret = s.register(sess)
+	defer s.unregister(sess.pid)
+
+	sess.params = map[string]string{
+		"server_version":              serverVersion,
+		"server_encoding":             "UTF8",
+		"client_encoding":             "UTF8",
+		"DateStyle":                   "ISO, MDY",
+		"TimeZone":                    "UTC",
+		"integer_datetimes":           "on",
+		"standard_conforming_strings": "on",
+		"is_superuser":                "off",
+		"application_name":            params["application_name"],
+	}
+	sess.send(newMessage('R').int32(0))
+	for name, value := range sess.params {
+		sess.send(newMessage('S').string(name).string(value))
+	}
+	sess.send(newMessage('K').int32(sess.pid).int32(sess.secret))
+	sess.readyForQuery()
+
+	if err := sess.serve(); err != nil {
+		log.Printf("PostgreSQL connection from %s: %v", conn.RemoteAddr(), err)
+	}
+}
+
+// register assigns a process ID and secret key to a session, which clients
+// quote to cancel its queries
+func (s *Server) register(sess *session) (int32, int32) {
+	var key [4]byte
+	rand.Read(key[:])
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextPID++
+	s.sessions[s.nextPID] = sess
+	return s.nextPID, int32(binary.BigEndian.Uint32(key[:]))
+}
+
+func (s *Server) unregister(pid int32) {
+	s.mu.Lock()
+	delete(s.sessions, pid)
+	s.mu.Unlock()
+}
+
+// cancel interrupts the running statement of a session
+func (s *Server) cancel(pid, secret int32) {
+	s.mu.Lock()
+	sess := s.sessions[pid]
+	s.mu.Unlock()
+	if sess != nil && sess.secret == secret {
+		sess.cancelRunning()
+	}
+}
+
+// startup answers SSL and GSSAPI requests, which are declined, and cancel
+// requests, then reads the startup parameters
+func (sess *session) startup() (map[string]string, bool) {
+	for {
+		sess.conn.SetReadDeadline(time.Now().Add(30 * time.Second))
+		body, err := readStartup(sess.r)
+		sess.conn.SetReadDeadline(time.Time{})
+		if err != nil {
+			return nil, false
+		}
+		r := &reader{buf: body}
+		code := r.int32()
+		switch code {
+		case sslRequest, gssEncRequest:
+			sess.conn.Write([]byte{'N'})
+			continue
+		case cancelRequest:
+			pid, secret := r.int32(), r.int32()
+			if r.err == nil {
+				sess.server.cancel(pid, secret)
+			}
+			return nil, false
+		}
+		if code>>16 != 3 {
+			sess.fatal("0A000", fmt.Sprintf("unsupported frontend protocol %d.%d", code>>16, code&0xffff))
+			return nil, false
+		}
+
+		params := make(map[string]string)
+		for r.err == nil && len(r.buf) > 1 {
+			name := r.string()
+			params[name] = r.string()
+		}
+		if r.err != nil {
+			sess.fatal("08P01", "invalid startup packet")
+			return nil, false
+		}
+		return params, true
+	}
+}
+
+// authenticate asks for the password when one is configured
+func (sess *session) authenticate() bool {
+	password := sess.server.opts.Password
+	if password == "" {
+		return true
+	}
+	sess.send(newMessage('R').int32(3))
+	sess.flush()
+
+	typ, body, err := readMessage(sess.r)
+	if err != nil {
+		return false
+	}
+	r := &reader{buf: body}
+	given := r.string()
+	if typ != 'p' || r.err != nil || subtle.ConstantTimeCompare([]byte(given), []byte(password)) != 1 {
+		sess.fatal("28P01", "password authentication failed")
+		return false
+	}
+	return true
+}
+
+// errClosed ends a session on a Terminate message
+var errClosed = errors.New("connection closed by client")
@@ -0,0 +1,524 @@
+package pgwire
+
+import (
+	"bufio"
+	"encoding/binary"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"csvql"
+)
+
+func startServer(t *testing.T, opts Options) string {
+	t.Helper()
+	_, addr := startServerWith(t, csvql.Options{}, opts)
+	return addr
+}
+
+// startServerWith serves a directory holding employees.csv, loaded with the
+// given options
+func startServerWith(t *testing.T, csvqlOpts csvql.Options, opts Options) (*csvql.CSVQL, string) {
+	t.Helper()
+	tmpDir := t.TempDir()
+	os.WriteFile(filepath.Join(tmpDir, "employees.csv"), []byte("id,name,salary,hired\n1,Alice,5000.5,2020-01-15\n2,Bob,4000,2021-03-01\n3,Carol,6000,2019-07-30\n"), 0644)
+
+	csvqlOpts.RootDir = tmpDir
+	c, err := csvql.New(csvqlOpts)
+	if err != nil {
+		t.Fatalf("csvql.New failed: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	s := New(c, opts)
+	go s.Serve(l)
+	t.Cleanup(func() { s.Close() })
+	return c, l.Addr().String()
+}
+
+// client is a minimal frontend speaking the protocol by hand
+type client struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+	pid  int32
+	key  int32
+}
+
+// result is what the server sent for a query, up to ReadyForQuery
+type result struct {
+	columns []string
+	oids    []uint32
+	rows    [][]string
+	tags    []string
+	code    string // SQLSTATE of an error
+	status  byte
+}
+
+func connect(t *testing.T, addr, password string) *client {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	c := &client{t: t, conn: conn, r: bufio.NewReader(conn)}
+
+	// SSL is declined
+	conn.Write(binary.BigEndian.AppendUint32([]byte{0, 0, 0, 8}, sslRequest))
+	if b, _ := c.r.ReadByte(); b != 'N' {
+		t.Fatalf("Expected SSL to be declined, got %q", b)
+	}
+
+	body := binary.BigEndian.AppendUint32(nil, protocolVersion)
+	for _, s := range []string{"user", "tester", "database", "exports", ""} {
+		body = append(append(body, s...), 0)
+	}
+	conn.Write(append(binary.BigEndian.AppendUint32(nil, uint32(len(body)+4)), body...))
+
+	for {
+		typ, body := c.receive()
+		switch typ {
+		case 'R':
+			if binary.BigEndian.Uint32(body) == 3 {
+				c.send(newMessage('p').string(password))
+			}
+		case 'K':
+			c.pid = int32(binary.BigEndian.Uint32(body))
+			c.key = int32(binary.BigEndian.Uint32(body[4:]))
+		case 'E':
+			t.Fatalf("Startup failed: %s", fields(body)['M'])
+		case 'Z':
+			return c
+		}
+	}
+}
+
+func (c *client) send(msg *message) {
+	c.conn.Write(msg.finish())
+}
+
+func (c *client) receive() (byte, []byte) {
+	c.t.Helper()
+	typ, body, err := readMessage(c.r)
+	if err != nil {
+		c.t.Fatalf("Reading message failed: %v", err)
+	}
+	return typ, body
+}
+
+// collect reads the responses up to ReadyForQuery
+func (c *client) collect(oids ...uint32) result {
+	var res result
+	for {
+		typ, body := c.receive()
+		r := &reader{buf: body}
+		switch typ {
+		case 'T':
+			res.columns, res.oids = nil, nil
+			for n := r.int16(); n > 0; n-- {
+				res.columns = append(res.columns, r.string())
+				r.int32()
+				r.int16()
+				res.oids = append(res.oids, uint32(r.int32()))
+				r.int16()
+				r.int32()
+				r.int16()
+			}
+		case 'D':
+			var row []string
+			for i, n := 0, int(r.int16()); i < n; i++ {
+				size := r.int32()
+				if size < 0 {
+					row = append(row, "NULL")
+					continue
+				}
+				row = append(row, decodeTestValue(r.bytes(int(size)), oids, i))
+			}
+			res.rows = append(res.rows, row)
+		case 'C':
+			res.tags = append(res.tags, r.string())
+		case 's':
+			res.tags = append(res.tags, "suspended")
+		case 'E':
+			res.code = fields(body)['C']
+		case 'Z':
+			res.status = body[0]
+			return res
+		}
+	}
+}
+
+// decodeTestValue renders a binary value of the column types given, or text
+func decodeTestValue(data []byte, oids []uint32, i int) string {
+	if i >= len(oids) {
+		return string(data)
+	}
+	switch oids[i] {
+	case oidInt8:
+		return strings.TrimSpace(string(encodeText(int64(binary.BigEndian.Uint64(data)))))
+	case oidFloat8:
+		return string(encodeText(math.Float64frombits(binary.BigEndian.Uint64(data))))
+	case oidDate:
+		days := int32(binary.BigEndian.Uint32(data))
+		return pgEpoch.AddDate(0, 0, int(days)).Format("2006-01-02")
+	}
+	return string(data)
+}
+
+func fields(body []byte) map[byte]string {
+	m := make(map[byte]string)
+	r := &reader{buf: body}
+	for r.err == nil && len(r.buf) > 0 {
+		code := r.byte()
+		if code == 0 {
+			break
+		}
+		m[code] = r.string()
+	}
+	return m
+}
+
+func (c *client) query(sql string) result {
+	c.send(newMessage('Q').string(sql))
+	return c.collect()
+}
+
+func TestSimpleQuery(t *testing.T) {
+	c := connect(t, startServer(t, Options{}), "")
+
+	res := c.query("SELECT name, salary FROM employees WHERE id < 3 ORDER BY id; SELECT count(*)::int AS n FROM public.employees")
+	if !reflect.DeepEqual(res.rows, [][]string{{"Alice", "5000.5"}, {"Bob", "4000"}, {"3"}}) {
+		t.Errorf("Unexpected rows: %v", res.rows)
+	}
+	if !reflect.DeepEqual(res.tags, []string{"SELECT 2", "SELECT 1"}) {
+		t.Errorf("Unexpected tags: %v", res.tags)
+	}
+
+	res = c.query("SELECT id, salary, hired FROM employees LIMIT 1")
+	if !reflect.DeepEqual(res.oids, []uint32{oidInt8, oidFloat8, oidDate}) {
+		t.Errorf("Unexpected column types: %v", res.oids)
+	}
+
+	res = c.query("DROP TABLE employees")
+	if res.code != "25006" || res.status != 'I' {
+		t.Errorf("Expected a read-only error, got %q", res.code)
+	}
+	if res = c.query("SELECT * FROM nope"); res.code != "42P01" {
+		t.Errorf("Expected undefined table, got %q", res.code)
+	}
+	if res = c.query("SELECT count(*) FROM employees"); !reflect.DeepEqual(res.rows, [][]string{{"3"}}) {
+		t.Errorf("Expected the table untouched, got %v", res.rows)
+	}
+
+	if res = c.query(""); res.code != "" || len(res.tags) != 0 {
+		t.Errorf("Expected an empty query response, got %+v", res)
+	}
+}
+
+func TestSessionCommands(t *testing.T) {
+	c := connect(t, startServer(t, Options{}), "")
+
+	res := c.query("SET application_name = 'bi'; SHOW application_name; SHOW server_version")
+	if !reflect.DeepEqual(res.rows, [][]string{{"bi"}, {serverVersion}}) {
+		t.Errorf("Unexpected SHOW rows: %v", res.rows)
+	}
+	if !reflect.DeepEqual(res.tags, []string{"SET", "SHOW", "SHOW"}) {
+		t.Errorf("Unexpected tags: %v", res.tags)
+	}
+
+	if res = c.query("BEGIN"); res.status != 'T' {
+		t.Errorf("Expected a transaction block, got status %q", res.status)
+	}
+	if res = c.query("COMMIT"); res.status != 'I' {
+		t.Errorf("Expected idle after COMMIT, got status %q", res.status)
+	}
+	if res = c.query("SHOW nope"); res.code != "42704" {
+		t.Errorf("Expected an unknown parameter error, got %q", res.code)
+	}
+}
+
+func TestTransaction_AllowWrites(t *testing.T) {
+	_, addr := startServerWith(t, csvql.Options{AllowWrites: true}, Options{})
+	c := connect(t, addr, "")
+
+	if res := c.query("BEGIN; DELETE FROM employees; ROLLBACK"); res.code != "" || res.status != 'I' {
+		t.Fatalf("Expected the transaction to roll back, got %q status %q", res.code, res.status)
+	}
+	if res := c.query("SELECT count(*) FROM employees"); !reflect.DeepEqual(res.rows, [][]string{{"3"}}) {
+		t.Errorf("Expected the delete rolled back, got %v", res.rows)
+	}
+
+	res := c.query("BEGIN; DELETE FROM employees WHERE id = 1; SAVEPOINT sp; DELETE FROM employees; ROLLBACK TO SAVEPOINT sp; COMMIT")
+	if res.code != "" || res.status != 'I' {
+		t.Fatalf("Expected the transaction to commit, got %q status %q", res.code, res.status)
+	}
+	if res := c.query("SELECT count(*) FROM employees"); !reflect.DeepEqual(res.rows, [][]string{{"2"}}) {
+		t.Errorf("Expected only the delete before the savepoint kept, got %v", res.rows)
+	}
+
+	// The block is the connection's transaction, not only its status
+	c.query("BEGIN")
+	if res := c.query("BEGIN"); res.code != "" || res.status != 'T' {
+		t.Errorf("Expected BEGIN inside a block to do nothing, got %q status %q", res.code, res.status)
+	}
+	c.query("DELETE FROM employees")
+	if res := c.query("ROLLBACK; SELECT count(*) FROM employees; COMMIT"); !reflect.DeepEqual(res.rows, [][]string{{"2"}}) || res.code != "" {
+		t.Errorf("Expected the delete rolled back, got %v %q", res.rows, res.code)
+	}
+	if res := c.query("SHOW transaction_read_only"); !reflect.DeepEqual(res.rows, [][]string{{"off"}}) {
+		t.Errorf("Expected a writable session, got %v", res.rows)
+	}
+}
+
+func TestTransaction_Failed(t *testing.T) {
+	_, addr := startServerWith(t, csvql.Options{AllowWrites: true}, Options{})
+	c := connect(t, addr, "")
+
+	if res := c.query("BEGIN; DELETE FROM employees WHERE id = 1; SELECT * FROM nope"); res.code != "42P01" || res.status != 'E' {
+		t.Fatalf("Expected the block to fail, got %q status %q", res.code, res.status)
+	}
+	if res := c.query("SELECT 1"); res.code != "25P02" || res.status != 'E' {
+		t.Errorf("Expected statements refused in a failed block, got %q status %q", res.code, res.status)
+	}
+	if res := c.query("ROLLBACK"); res.code != "" || res.status != 'I' {
+		t.Fatalf("Expected ROLLBACK to end the block, got %q status %q", res.code, res.status)
+	}
+	if res := c.query("SELECT count(*) FROM employees"); !reflect.DeepEqual(res.rows, [][]string{{"3"}}) {
+		t.Errorf("Expected the delete rolled back, got %v", res.rows)
+	}
+
+	// COMMIT of a failed block rolls it back
+	c.query("BEGIN; DELETE FROM employees WHERE id = 1; SELECT * FROM nope")
+	if res := c.query("COMMIT"); res.status != 'I' || !reflect.DeepEqual(res.tags, []string{"ROLLBACK"}) {
+		t.Errorf("Expected COMMIT to roll back, got %v status %q", res.tags, res.status)
+	}
+	if res := c.query("SELECT count(*) FROM employees"); !reflect.DeepEqual(res.rows, [][]string{{"3"}}) {
+		t.Errorf("Expected the delete rolled back, got %v", res.rows)
+	}
+
+	// Rolling back to a savepoint recovers the block
+	c.query("BEGIN; DELETE FROM employees WHERE id = 1; SAVEPOINT sp; SELECT * FROM nope")
+	if res := c.query("ROLLBACK TO SAVEPOINT sp"); res.code != "" || res.status != 'T' {
+		t.Errorf("Expected the block recovered, got %q status %q", res.code, res.status)
+	}
+	if res := c.query("COMMIT; SELECT count(*) FROM employees"); res.status != 'I' || !reflect.DeepEqual(res.rows, [][]string{{"2"}}) {
+		t.Errorf("Expected the delete committed, got %v status %q", res.rows, res.status)
+	}
+
+	// An extended query error fails the block too
+	c.query("BEGIN")
+	c.send(newMessage('P').string("").string("SELECT * FROM nope").int16(0))
+	c.send(newMessage('B').string("").string("").int16(0).int16(0).int16(0))
+	c.send(newMessage('S'))
+	if res := c.collect(); res.code != "42P01" || res.status != 'E' {
+		t.Errorf("Expected the block to fail, got %q status %q", res.code, res.status)
+	}
+	if res := c.query("ROLLBACK"); res.status != 'I' {
+		t.Errorf("Expected ROLLBACK to end the block, got status %q", res.status)
+	}
+}
+
+func TestCommandTags_Writes(t *testing.T) {
+	_, addr := startServerWith(t, csvql.Options{AllowWrites: true}, Options{})
+	c := connect(t, addr, "")
+
+	res := c.query("BEGIN; UPDATE employees SET salary = 0 WHERE id < 3; INSERT INTO employees (id) VALUES (4); DELETE FROM employees; ROLLBACK")
+	want := []string{"BEGIN", "UPDATE 2", "INSERT 0 1", "DELETE 4", "ROLLBACK"}
+	if res.code != "" || !reflect.DeepEqual(res.tags, want) {
+		t.Errorf("Expected tags %v, got %v (error %q)", want, res.tags, res.code)
+	}
+	if res := c.query("SELECT name FROM employees WHERE id = 1"); !reflect.DeepEqual(res.tags, []string{"SELECT 1"}) {
+		t.Errorf("Expected a SELECT tag, got %v", res.tags)
+	}
+}
+
+func TestCatalog(t *testing.T) {
+	c := connect(t, startServer(t, Options{}), "")
+
+	tests := []struct {
+		sql  string
+		want [][]string
+	}{
+		{"SELECT tablename FROM pg_catalog.pg_tables WHERE schemaname = 'public'", [][]string{{"employees"}}},
+		{"SELECT table_name, table_type FROM information_schema.tables", [][]string{{"employees", "BASE TABLE"}}},
+		{"SELECT column_name, data_type FROM information_schema.columns WHERE table_name = 'employees' ORDER BY ordinal_position",
+			[][]string{{"id", "bigint"}, {"name", "text"}, {"salary", "double precision"}, {"hired", "date"}}},
+		{"SELECT current_database(), current_schema, current_user", [][]string{{"exports", "public", "tester"}}},
+		{"SELECT nspname FROM pg_namespace WHERE oid = 2200", [][]string{{"public"}}},
+	}
+	for _, tt := range tests {
+		res := c.query(tt.sql)
+		if res.code != "" || !reflect.DeepEqual(res.rows, tt.want) {
+			t.Errorf("%s: expected %v, got %v (error %q)", tt.sql, tt.want, res.rows, res.code)
+		}
+	}
+
+	if res := c.query("SELECT version()"); len(res.rows) != 1 || !strings.HasPrefix(res.rows[0][0], "PostgreSQL") {
+		t.Errorf("Unexpected version: %v", res.rows)
+	}
+}
+
+func TestExtendedQuery(t *testing.T) {
+	c := connect(t, startServer(t, Options{}), "")
+
+	// Parse with an int8 parameter, Describe, then Bind it in binary with
+	// binary results
+	c.send(newMessage('P').string("s1").string("SELECT id, salary, hired, name FROM employees WHERE id >= $1 ORDER BY id").int16(1).int32(oidInt8))
+	c.send(newMessage('D').byte('S').string("s1"))
+	c.send(newMessage('B').string("").string("s1").
+		int16(1).int16(1).
+		int16(1).int32(8).bytes(binary.BigEndian.AppendUint64(nil, 2)).
+		int16(1).int16(1))
+	c.send(newMessage('E').string("").int32(1))
+	c.send(newMessage('E').string("").int32(0))
+	c.send(newMessage('S'))
+
+	var types []uint32
+	var rows [][]string
+	var tags []string
+	for done := false; !done; {
+		typ, body := c.receive()
+		r := &reader{buf: body}
+		switch typ {
+		case 't':
+			if n := r.int16(); n != 1 || r.int32() != oidInt8 {
+				t.Errorf("Unexpected parameter description")
+			}
+		case 'T':
+			for n := r.int16(); n > 0; n-- {
+				r.string()
+				r.int32()
+				r.int16()
+				types = append(types, uint32(r.int32()))
+				r.int16()
+				r.int32()
+				r.int16()
+			}
+		case 'D':
+			var row []string
+			for i, n := 0, int(r.int16()); i < n; i++ {
+				row = append(row, decodeTestValue(r.bytes(int(r.int32())), types, i))
+			}
+			rows = append(rows, row)
+		case 's':
+			tags = append(tags, "suspended")
+		case 'C':
+			tags = append(tags, r.string())
+		case 'E':
+			t.Fatalf("Unexpected error: %v", fields(body))
+		case 'Z':
+			done = true
+		}
+	}
+
+	want := [][]string{{"2", "4000", "2021-03-01", "Bob"}, {"3", "6000", "2019-07-30", "Carol"}}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("Expected %v, got %v", want, rows)
+	}
+	if !reflect.DeepEqual(tags, []string{"suspended", "SELECT 2"}) {
+		t.Errorf("Unexpected completion: %v", tags)
+	}
+
+	// An error skips the messages up to Sync
+	c.send(newMessage('P').string("").string("DELETE FROM employees").int16(0))
+	c.send(newMessage('B').string("").string("").int16(0).int16(0).int16(0))
+	c.send(newMessage('E').string("").int32(0))
+	c.send(newMessage('S'))
+	if res := c.collect(); res.code != "25006" || len(res.tags) != 0 {
+		t.Errorf("Expected one read-only error, got %+v", res)
+	}
+}
+
+func TestSuspendedPortal(t *testing.T) {
+	db, addr := startServerWith(t, csvql.Options{}, Options{})
+	c := connect(t, addr, "")
+
+	// In a transaction block the portal outlives Sync, as with JDBC fetches
+	c.query("BEGIN")
+	c.send(newMessage('P').string("").string("SELECT name FROM employees ORDER BY id").int16(0))
+	c.send(newMessage('B').string("p1").string("").int16(0).int16(0).int16(0))
+	c.send(newMessage('E').string("p1").int32(1))
+	c.send(newMessage('S'))
+	res := c.collect()
+	if !reflect.DeepEqual(res.rows, [][]string{{"Alice"}}) || !reflect.DeepEqual(res.tags, []string{"suspended"}) {
+		t.Fatalf("Expected one row and a suspension, got %+v", res)
+	}
+
+	// The idle portal holds no snapshot: a reload commits and the WAL can
+	// be checkpointed completely
+	os.WriteFile(filepath.Join(db.RootDir, "employees.csv"), []byte("id,name\n9,Zed\n"), 0644)
+	if err := db.Scan(); err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+	var busy, logged, checkpointed int
+	if err := db.DB.DB().QueryRow("PRAGMA wal_checkpoint(TRUNCATE)").Scan(&busy, &logged, &checkpointed); err != nil || busy != 0 {
+		t.Errorf("Expected a complete checkpoint, got busy=%d (%v)", busy, err)
+	}
+
+	c.send(newMessage('E').string("p1").int32(0))
+	c.send(newMessage('S'))
+	res = c.collect()
+	if !reflect.DeepEqual(res.rows, [][]string{{"Bob"}, {"Carol"}}) || !reflect.DeepEqual(res.tags, []string{"SELECT 3"}) {
+		t.Errorf("Expected the rest of the result, got %+v", res)
+	}
+}
+
+func TestPassword(t *testing.T) {
+	addr := startServer(t, Options{Password: "secret"})
+
+	c := connect(t, addr, "secret")
+	if res := c.query("SELECT 1"); !reflect.DeepEqual(res.rows, [][]string{{"1"}}) {
+		t.Errorf("Expected a working session, got %+v", res)
+	}
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	body := binary.BigEndian.AppendUint32(nil, protocolVersion)
+	body = append(append(body, "user\x00x\x00"...), 0)
+	conn.Write(append(binary.BigEndian.AppendUint32(nil, uint32(len(body)+4)), body...))
+	conn.Write(newMessage('p').string("wrong").finish())
+
+	r := bufio.NewReader(conn)
+	readMessage(r) // AuthenticationCleartextPassword
+	typ, msg, err := readMessage(r)
+	if err != nil || typ != 'E' || fields(msg)['C'] != "28P01" {
+		t.Errorf("Expected an authentication error, got %q %v", typ, err)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	addr := startServer(t, Options{})
+	c := connect(t, addr, "")
+
+	c.send(newMessage('Q').string("WITH RECURSIVE n(x) AS (SELECT 1 UNION ALL SELECT x + 1 FROM n) SELECT count(*) FROM n"))
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	body := binary.BigEndian.AppendUint32(nil, cancelRequest)
+	body = binary.BigEndian.AppendUint32(body, uint32(c.pid))
+	body = binary.BigEndian.AppendUint32(body, uint32(c.key))
+	conn.Write(append(binary.BigEndian.AppendUint32(nil, uint32(len(body)+4)), body...))
+	conn.Close()
+
+	if res := c.collect(); res.code != "57014" {
+		t.Errorf("Expected the query to be canceled, got %+v", res)
+	}
+}
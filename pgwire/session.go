@@ -0,0 +1,786 @@
+package pgwire
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+
+	"csvql/db"
+)
+
+// session is the state of one client connection
+type session struct {
+	server *Server
+	conn   net.Conn
+	r      *bufio.Reader
+	w      *bufio.Writer
+	db     *db.Conn
+
+	pid, secret    int32
+	user, database string
+	params         map[string]string // reported by SHOW and changed by SET
+
+	stmts    map[string]*statement
+	portals  map[string]*portal
+	txStatus byte // I idle, T in a transaction block, E in a failed one
+	failed   bool // an extended query message failed; skip to Sync
+
+	mu      sync.Mutex
+	running context.CancelFunc // cancels the statement being executed
+}
+
+// statement is a parsed statement, prepared by Parse or a simple query
+type statement struct {
+	sql       string // rewritten for SQLite
+	cmd       command
+	args      []string // words after the command keyword
+	numParams int
+	paramOIDs []uint32
+}
+
+// paramOID returns the type of parameter i: the one given by Parse, or
+// text, which SQLite converts as needed
+func (stmt *statement) paramOID(i int) uint32 {
+	if i < len(stmt.paramOIDs) && stmt.paramOIDs[i] != 0 {
+		return stmt.paramOIDs[i]
+	}
+	return oidText
+}
+
+// field is a result column
+type field struct {
+	name   string
+	oid    uint32
+	format int16 // 0 text, 1 binary
+}
+
+// portal is a statement bound to its parameters, ready to run
+type portal struct {
+	stmt     *statement
+	args     []interface{}
+	fields   []field
+	rows     *db.Rows        // open result of a query
+	buffered [][]interface{} // rows left when the portal was suspended
+	ctx      context.Context
+	cancel   context.CancelFunc // releases ctx
+	count    int                // rows sent so far
+	done     bool
+}
+
+// next returns the next row of a query portal, from its open result or,
+// once suspended, from the rows buffered then
+func (p *portal) next() ([]interface{}, bool, error) {
+	if p.rows == nil {
+		if len(p.buffered) == 0 {
+			return nil, false, nil
+		}
+		values := p.buffered[0]
+		p.buffered = p.buffered[1:]
+		return values, true, nil
+	}
+	if p.rows.Next() {
+		return p.rows.Values(), true, nil
+	}
+	err := p.rows.Err()
+	p.rows.Close()
+	p.rows = nil
+	return nil, false, err
+}
+
+// suspend reads the rest of an open result into memory and closes it, so
+// no statement, and no snapshot of the database, stays open while the
+// client decides whether to fetch more
+func (p *portal) suspend() error {
+	if p.rows == nil {
+		return nil
+	}
+	defer func() {
+		p.rows.Close()
+		p.rows = nil
+	}()
+	for p.rows.Next() {
+		p.buffered = append(p.buffered, p.rows.Values())
+	}
+	return p.rows.Err()
+}
+
+// pgError is an error reported to the client with its SQLSTATE code
+type pgError struct {
+	code    string
+	message string
+}
+
+func (e *pgError) Error() string { return e.message }
+
+// serve reads and answers messages until the client disconnects
+func (sess *session) serve() error {
+	defer sess.closePortals(true)
+	for {
+		typ, body, err := readMessage(sess.r)
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		if err := sess.dispatch(typ, body); err != nil {
+			if err == errClosed {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// dispatch answers one message. Errors of extended query messages are sent
+// to the client, which then sends Sync; errors returned end the session.
+func (sess *session) dispatch(typ byte, body []byte) error {
+	r := &reader{buf: body}
+	switch typ {
+	case 'Q':
+		query := r.string()
+		if r.err != nil {
+			return r.err
+		}
+		sess.simpleQuery(query)
+		sess.readyForQuery()
+		return nil
+	case 'X':
+		return errClosed
+	case 'S':
+		sess.failed = false
+		if sess.txStatus == 'I' {
+			sess.closePortals(false)
+		}
+		sess.readyForQuery()
+		return nil
+	case 'H':
+		return sess.flush()
+	}
+
+	if sess.failed {
+		return nil
+	}
+	var err error
+	switch typ {
+	case 'P':
+		err = sess.parse(r)
+	case 'B':
+		err = sess.bind(r)
+	case 'D':
+		err = sess.describe(r)
+	case 'E':
+		err = sess.execute(r)
+	case 'C':
+		err = sess.closeMessage(r)
+	default:
+		err = &pgError{"08P01", fmt.Sprintf("unsupported message type %q", typ)}
+	}
+	if r.err != nil && err == nil {
+		err = &pgError{"08P01", "malformed message"}
+	}
+	if err != nil {
+		sess.sendError(err)
+		sess.failed = true
+	}
+	return nil
+}
+
+// simpleQuery runs the statements of a Query message in order, stopping at
+// the first error
+func (sess *session) simpleQuery(query string) {
+	stmts := splitStatements(query)
+	if len(stmts) == 0 {
+		sess.send(newMessage('I'))
+		return
+	}
+	for _, text := range stmts {
+		stmt := prepare(text)
+		if stmt.numParams > 0 {
+			sess.sendError(&pgError{"42P02", fmt.Sprintf("there is no parameter $%d", stmt.numParams)})
+			return
+		}
+		p, err := sess.open(stmt, nil, nil)
+		if err == nil {
+			if len(p.fields) > 0 {
+				sess.sendRowDescription(p.fields)
+			}
+			err = sess.run(p, 0)
+			sess.closePortal(p)
+		}
+		if err != nil {
+			sess.sendError(err)
+			return
+		}
+	}
+}
+
+// prepare parses a statement
+func prepare(text string) *statement {
+	cmd, args := classify(text)
+	stmt := &statement{cmd: cmd, args: args}
+	if cmd == cmdQuery {
+		stmt.sql, stmt.numParams = rewrite(text)
+	}
+	return stmt
+}
+
+// parse handles Parse: name, query and parameter types
+func (sess *session) parse(r *reader) error {
+	name := r.string()
+	query := r.string()
+	n := int(r.int16())
+	oids := make([]uint32, 0, max(n, 0))
+	for i := 0; i < n && r.err == nil; i++ {
+		oids = append(oids, uint32(r.int32()))
+	}
+	if r.err != nil {
+		return nil
+	}
+
+	stmts := splitStatements(query)
+	if len(stmts) > 1 {
+		return &pgError{"42601", "cannot insert multiple commands into a prepared statement"}
+	}
+	text := ""
+	if len(stmts) == 1 {
+		text = stmts[0]
+	}
+	stmt := prepare(text)
+	stmt.paramOIDs = oids
+	stmt.numParams = max(stmt.numParams, len(oids))
+	sess.stmts[name] = stmt
+	sess.send(newMessage('1'))
+	return nil
+}
+
+// bind handles Bind: it decodes the parameters and opens the portal
+func (sess *session) bind(r *reader) error {
+	portalName := r.string()
+	stmtName := r.string()
+	formats := make([]int16, max(int(r.int16()), 0))
+	for i := range formats {
+		formats[i] = r.int16()
+	}
+	values := make([][]byte, max(int(r.int16()), 0))
+	for i := range values {
+		if n := r.int32(); n >= 0 {
+			values[i] = r.bytes(int(n))
+			if values[i] == nil {
+				values[i] = []byte{}
+			}
+		}
+	}
+	resultFormats := make([]int16, max(int(r.int16()), 0))
+	for i := range resultFormats {
+		resultFormats[i] = r.int16()
+	}
+	if r.err != nil {
+		return nil
+	}
+
+	stmt, ok := sess.stmts[stmtName]
+	if !ok {
+		return &pgError{"26000", fmt.Sprintf("prepared statement %q does not exist", stmtName)}
+	}
+	if len(values) != stmt.numParams {
+		return &pgError{"08P01", fmt.Sprintf("bind message supplies %d parameters, but prepared statement requires %d", len(values), stmt.numParams)}
+	}
+
+	args := make([]interface{}, len(values))
+	for i, v := range values {
+		arg, err := decodeParam(v, formatOf(formats, i) == 1, stmt.paramOID(i))
+		if err != nil {
+			return &pgError{"22P03", fmt.Sprintf("parameter $%d: %v", i+1, err)}
+		}
+		args[i] = arg
+	}
+
+	if old, ok := sess.portals[portalName]; ok {
+		sess.closePortal(old)
+	}
+	p, err := sess.open(stmt, args, resultFormats)
+	if err != nil {
+		return err
+	}
+	sess.portals[portalName] = p
+	sess.send(newMessage('2'))
+	return nil
+}
+
+// describe handles Describe of a statement ('S') or a portal ('P')
+func (sess *session) describe(r *reader) error {
+	kind := r.byte()
+	name := r.string()
+	if r.err != nil {
+		return nil
+	}
+
+	switch kind {
+	case 'S':
+		stmt, ok := sess.stmts[name]
+		if !ok {
+			return &pgError{"26000", fmt.Sprintf("prepared statement %q does not exist", name)}
+		}
+		// Open the query without parameters to learn its columns; nothing
+		// runs until rows are read
+		p, err := sess.open(stmt, make([]interface{}, stmt.numParams), nil)
+		if err != nil {
+			return err
+		}
+		sess.closePortal(p)
+
+		msg := newMessage('t').int16(int16(stmt.numParams))
+		for i := 0; i < stmt.numParams; i++ {
+			msg.int32(int32(stmt.paramOID(i)))
+		}
+		sess.send(msg)
+		sess.sendFields(p.fields)
+	case 'P':
+		p, ok := sess.portals[name]
+		if !ok {
+			return &pgError{"34000", fmt.Sprintf("portal %q does not exist", name)}
+		}
+		sess.sendFields(p.fields)
+	default:
+		return &pgError{"08P01", fmt.Sprintf("invalid describe kind %q", kind)}
+	}
+	return nil
+}
+
+// execute handles Execute: it sends up to maxRows rows of a portal
+func (sess *session) execute(r *reader) error {
+	name := r.string()
+	maxRows := int(r.int32())
+	if r.err != nil {
+		return nil
+	}
+	p, ok := sess.portals[name]
+	if !ok {
+		return &pgError{"34000", fmt.Sprintf("portal %q does not exist", name)}
+	}
+	if err := sess.checkAborted(p.stmt); err != nil {
+		return err
+	}
+	return sess.run(p, maxRows)
+}
+
+// closeMessage handles Close of a statement ('S') or a portal ('P')
+func (sess *session) closeMessage(r *reader) error {
+	kind := r.byte()
+	name := r.string()
+	if r.err != nil {
+		return nil
+	}
+	switch kind {
+	case 'S':
+		delete(sess.stmts, name)
+	case 'P':
+		if p, ok := sess.portals[name]; ok {
+			sess.closePortal(p)
+			delete(sess.portals, name)
+		}
+	}
+	sess.send(newMessage('3'))
+	return nil
+}
+
+// open binds a statement to its parameters. Queries start on the database,
+// which reports their columns; session commands run when executed.
+func (sess *session) open(stmt *statement, args []interface{}, resultFormats []int16) (*portal, error) {
+	if err := sess.checkAborted(stmt); err != nil {
+		return nil, err
+	}
+	p := &portal{stmt: stmt, args: args}
+	switch stmt.cmd {
+	case cmdShow:
+		name := strings.ToLower(strings.Join(stmt.args, " "))
+		p.fields = []field{{name: name, oid: oidText}}
+	case cmdQuery:
+		if strings.TrimSpace(stmt.sql) == "" {
+			return p, nil
+		}
+		ctx, cancel := context.Background(), context.CancelFunc(func() {})
+		if timeout := sess.server.opts.Timeout; timeout > 0 {
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+		}
+		ctx, cancelRun := context.WithCancel(ctx)
+		p.ctx, p.cancel = ctx, func() { cancelRun(); cancel() }
+		sess.setRunning(cancelRun)
+		defer sess.setRunning(nil)
+
+		rows, err := sess.db.QueryContext(ctx, stmt.sql, args...)
+		if err != nil {
+			err = queryError(ctx, err)
+			p.cancel()
+			return nil, err
+		}
+		p.rows = rows
+		types := rows.ColumnTypes()
+		for i, name := range rows.Columns() {
+			p.fields = append(p.fields, field{name: name, oid: typeOID(types[i])})
+		}
+	}
+	for i := range p.fields {
+		p.fields[i].format = formatOf(resultFormats, i)
+	}
+	return p, nil
+}
+
+// run executes a portal, sending up to maxRows rows (all if zero), then
+// CommandComplete, or PortalSuspended if rows may remain
+func (sess *session) run(p *portal, maxRows int) error {
+	if p.done {
+		sess.send(newMessage('C').string(sess.tag(p)))
+		return nil
+	}
+	if p.stmt.cmd != cmdQuery {
+		return sess.runCommand(p)
+	}
+	if strings.TrimSpace(p.stmt.sql) == "" {
+		p.done = true
+		sess.send(newMessage('I'))
+		return nil
+	}
+
+	sess.setRunning(p.cancel)
+	defer sess.setRunning(nil)
+	for sent := 0; maxRows <= 0 || sent < maxRows; sent++ {
+		values, ok, err := p.next()
+		if err != nil {
+			p.done = true
+			return queryError(p.ctx, err)
+		}
+		if !ok {
+			p.done = true
+			if sess.txStatus == 'E' && rollsBackToSavepoint(p.stmt) {
+				sess.txStatus = 'T'
+			}
+			sess.send(newMessage('C').string(sess.tag(p)))
+			return nil
+		}
+		if err := sess.sendRow(p.fields, values); err != nil {
+			return err
+		}
+		p.count++
+	}
+	if err := p.suspend(); err != nil {
+		p.done = true
+		return queryError(p.ctx, err)
+	}
+	sess.send(newMessage('s'))
+	return nil
+}
+
+// runCommand runs a session command
+func (sess *session) runCommand(p *portal) error {
+	p.done = true
+	switch p.stmt.cmd {
+	case cmdSet:
+		if name, value := parseSet(p.stmt.args); name != "" {
+			for key := range sess.params {
+				if strings.EqualFold(key, name) {
+					name = key
+				}
+			}
+			sess.params[name] = value
+		}
+	case cmdShow:
+		value, ok := sess.param(p.fields[0].name)
+		if !ok {
+			return &pgError{"42704", fmt.Sprintf("unrecognized configuration parameter %q", p.fields[0].name)}
+		}
+		if err := sess.sendRow(p.fields, []interface{}{value}); err != nil {
+			return err
+		}
+		p.count = 1
+	case cmdBegin, cmdCommit, cmdRollback:
+		cmd, err := sess.transaction(p.stmt.cmd)
+		if err != nil {
+			return err
+		}
+		if cmd != p.stmt.cmd {
+			sess.send(newMessage('C').string(commandTags[cmd]))
+			return nil
+		}
+	case cmdDiscard:
+		sess.closePortals(true)
+		sess.stmts = make(map[string]*statement)
+	case cmdDeallocate:
+		if len(p.stmt.args) > 0 {
+			name := p.stmt.args[len(p.stmt.args)-1]
+			if strings.EqualFold(name, "all") {
+				sess.stmts = make(map[string]*statement)
+			} else {
+				delete(sess.stmts, strings.Trim(name, `"`))
+			}
+		}
+	}
+	sess.send(newMessage('C').string(sess.tag(p)))
+	return nil
+}
+
+// transaction starts or ends a transaction block. With writes allowed it is
+// a transaction of the session's connection, so ROLLBACK undoes its edits;
+// a read-only session has nothing to undo and only reports the block.
+// As in PostgreSQL, BEGIN inside a block and COMMIT outside one do nothing,
+// and COMMIT of a failed block rolls it back. It returns the command run.
+func (sess *session) transaction(cmd command) (command, error) {
+	inBlock := sess.txStatus != 'I'
+	if cmd == cmdCommit && sess.txStatus == 'E' {
+		cmd = cmdRollback
+	}
+	if !sess.db.ReadOnly() && inBlock == (cmd != cmdBegin) {
+		if err := sess.db.ExecContext(context.Background(), commandTags[cmd]); err != nil {
+			return cmd, queryError(context.Background(), err)
+		}
+	}
+	switch {
+	case cmd != cmdBegin:
+		sess.txStatus = 'I'
+	case !inBlock:
+		sess.txStatus = 'T'
+	}
+	return cmd, nil
+}
+
+// checkAborted refuses statements in a failed transaction block until it
+// ends, or is rolled back to a savepoint
+func (sess *session) checkAborted(stmt *statement) error {
+	if sess.txStatus != 'E' || stmt.cmd == cmdCommit || stmt.cmd == cmdRollback || rollsBackToSavepoint(stmt) {
+		return nil
+	}
+	return &pgError{"25P02", "current transaction is aborted, commands ignored until end of transaction block"}
+}
+
+// rollsBackToSavepoint reports whether a statement is ROLLBACK TO a savepoint
+func rollsBackToSavepoint(stmt *statement) bool {
+	words := strings.Fields(stmt.sql)
+	return stmt.cmd == cmdQuery && len(words) > 0 && strings.EqualFold(words[0], "rollback")
+}
+
+// param looks up a parameter for SHOW, ignoring case
+func (sess *session) param(name string) (string, bool) {
+	switch name {
+	case "search_path":
+		return "public", true
+	case "transaction_isolation", "transaction isolation level":
+		return "serializable", true
+	case "transaction_read_only", "default_transaction_read_only":
+		if !sess.db.ReadOnly() {
+			return "off", true
+		}
+		return "on", true
+	case "max_identifier_length":
+		return "63", true
+	}
+	for key, value := range sess.params {
+		if strings.EqualFold(key, name) {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// tag returns the CommandComplete tag of a portal. INSERT, UPDATE and
+// DELETE report the rows they changed, other queries the rows sent.
+func (sess *session) tag(p *portal) string {
+	if p.stmt.cmd != cmdQuery {
+		return commandTags[p.stmt.cmd]
+	}
+	var keyword string
+	if words := strings.Fields(p.stmt.sql); len(words) > 0 {
+		keyword = strings.ToUpper(words[0])
+	}
+	switch keyword {
+	case "INSERT", "UPDATE", "DELETE":
+		n, err := sess.changes()
+		if err != nil {
+			n = int64(p.count)
+		}
+		if keyword == "INSERT" {
+			return fmt.Sprintf("INSERT 0 %d", n)
+		}
+		return fmt.Sprintf("%s %d", keyword, n)
+	}
+	return fmt.Sprintf("SELECT %d", p.count)
+}
+
+// changes returns the rows changed by the last INSERT, UPDATE or DELETE on
+// the session's connection
+func (sess *session) changes() (int64, error) {
+	rows, err := sess.db.QueryContext(context.Background(), "SELECT changes()")
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return 0, rows.Err()
+	}
+	n, _ := rows.Values()[0].(int64)
+	return n, nil
+}
+
+// closePortal releases the result of a portal
+func (sess *session) closePortal(p *portal) {
+	if p.rows != nil {
+		p.rows.Close()
+	}
+	if p.cancel != nil {
+		p.cancel()
+	}
+}
+
+// closePortals closes the unnamed portal, or every portal, as at the end of
+// a transaction
+func (sess *session) closePortals(all bool) {
+	for name, p := range sess.portals {
+		if all || name == "" {
+			sess.closePortal(p)
+			delete(sess.portals, name)
+		}
+	}
+}
+
+func (sess *session) setRunning(cancel context.CancelFunc) {
+	sess.mu.Lock()
+	sess.running = cancel
+	sess.mu.Unlock()
+}
+
+// cancelRunning interrupts the statement being executed, if any
+func (sess *session) cancelRunning() {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	if sess.running != nil {
+		sess.running()
+	}
+}
+
+// formatOf returns the format code of column or parameter i: none means
+// text, one applies to all
+func formatOf(formats []int16, i int) int16 {
+	switch {
+	case len(formats) == 0:
+		return 0
+	case len(formats) == 1:
+		return formats[0]
+	case i < len(formats):
+		return formats[i]
+	}
+	return 0
+}
+
+// queryError maps a database error to a SQLSTATE
+func queryError(ctx context.Context, err error) error {
+	msg := err.Error()
+	switch {
+	case errors.Is(err, db.ErrReadOnly):
+		return &pgError{"25006", fmt.Sprintf("cannot execute in a read-only transaction (%v)", err)}
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return &pgError{"57014", "canceling statement due to statement timeout"}
+	case errors.Is(ctx.Err(), context.Canceled) || strings.Contains(msg, "interrupted"):
+		return &pgError{"57014", "canceling statement due to user request"}
+	case strings.Contains(msg, "no such table"):
+		return &pgError{"42P01", msg}
+	case strings.Contains(msg, "no such column"):
+		return &pgError{"42703", msg}
+	case strings.Contains(msg, "no such function"):
+		return &pgError{"42883", msg}
+	case strings.Contains(msg, "syntax error"), strings.Contains(msg, "incomplete input"):
+		return &pgError{"42601", msg}
+	}
+	return &pgError{"XX000", msg}
+}
+
+// sendFields sends RowDescription, or NoData for statements without columns
+func (sess *session) sendFields(fields []field) {
+	if len(fields) == 0 {
+		sess.send(newMessage('n'))
+		return
+	}
+	sess.sendRowDescription(fields)
+}
+
+func (sess *session) sendRowDescription(fields []field) {
+	msg := newMessage('T').int16(int16(len(fields)))
+	for _, f := range fields {
+		size, ok := typeSizes[f.oid]
+		if !ok {
+			size = -1
+		}
+		msg.string(f.name).int32(0).int16(0).int32(int32(f.oid)).int16(size).int32(-1).int16(f.format)
+	}
+	sess.send(msg)
+}
+
+// sendRow sends a DataRow in the formats of the fields
+func (sess *session) sendRow(fields []field, values []interface{}) error {
+	msg := newMessage('D').int16(int16(len(values)))
+	for i, v := range values {
+		var data []byte
+		if fields[i].format == 1 {
+			var err error
+			if data, err = encodeBinary(v, fields[i].oid); err != nil {
+				return &pgError{"22P03", fmt.Sprintf("column %s: %v", fields[i].name, err)}
+			}
+		} else {
+			data = encodeText(v)
+		}
+		if v == nil {
+			msg.int32(-1)
+			continue
+		}
+		msg.int32(int32(len(data))).bytes(data)
+	}
+	sess.send(msg)
+	return nil
+}
+
+// sendError sends an ErrorResponse. An error inside a transaction block
+// fails the block.
+func (sess *session) sendError(err error) {
+	if sess.txStatus == 'T' {
+		sess.txStatus = 'E'
+	}
+	var pgErr *pgError
+	if !errors.As(err, &pgErr) {
+		pgErr = &pgError{"XX000", err.Error()}
+	}
+	sess.send(newMessage('E').
+		byte('S').string("ERROR").
+		byte('V').string("ERROR").
+		byte('C').string(pgErr.code).
+		byte('M').string(pgErr.message).
+		byte(0))
+}
+
+// fatal sends a FATAL ErrorResponse before the connection is closed
+func (sess *session) fatal(code, message string) {
+	sess.send(newMessage('E').
+		byte('S').string("FATAL").
+		byte('V').string("FATAL").
+		byte('C').string(code).
+		byte('M').string(message).
+		byte(0))
+	sess.flush()
+}
+
+func (sess *session) readyForQuery() {
+	sess.send(newMessage('Z').byte(sess.txStatus))
+	sess.flush()
+}
+
+// send buffers a message; write errors surface when the connection is read
+func (sess *session) send(msg *message) {
+	sess.w.Write(msg.finish())
+}
+
+func (sess *session) flush() error {
+	return sess.w.Flush()
+}
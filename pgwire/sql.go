@@ -0,0 +1,285 @@
+package pgwire
+
+import (
+	"strconv"
+	"strings"
+)
+
+// scanner walks SQL text, telling code apart from string literals, quoted
+// identifiers and comments
+type scanner struct {
+	sql string
+	pos int
+}
+
+// skipLiteral reports the end of the literal, quoted identifier or comment
+// starting at pos, or -1 if none starts there
+func (s *scanner) skipLiteral() int {
+	rest := s.sql[s.pos:]
+	switch {
+	case strings.HasPrefix(rest, "--"):
+		if i := strings.IndexByte(rest, '\n'); i >= 0 {
+			return s.pos + i + 1
+		}
+		return len(s.sql)
+	case strings.HasPrefix(rest, "/*"):
+		if i := strings.Index(rest[2:], "*/"); i >= 0 {
+			return s.pos + 2 + i + 2
+		}
+		return len(s.sql)
+	case rest[0] == '\'' || rest[0] == '"':
+		quote := rest[0]
+		for i := 1; i < len(rest); i++ {
+			if rest[i] == quote {
+				if i+1 < len(rest) && rest[i+1] == quote {
+					i++
+					continue
+				}
+				return s.pos + i + 1
+			}
+		}
+		return len(s.sql)
+	}
+	return -1
+}
+
+// splitStatements splits a simple query into its statements, dropping empty ones
+func splitStatements(sql string) []string {
+	var stmts []string
+	s := &scanner{sql: sql}
+	start := 0
+	for s.pos < len(sql) {
+		if end := s.skipLiteral(); end >= 0 {
+			s.pos = end
+			continue
+		}
+		if sql[s.pos] == ';' {
+			if stmt := strings.TrimSpace(sql[start:s.pos]); stmt != "" {
+				stmts = append(stmts, stmt)
+			}
+			start = s.pos + 1
+		}
+		s.pos++
+	}
+	if stmt := strings.TrimSpace(sql[start:]); stmt != "" && !isComment(stmt) {
+		stmts = append(stmts, stmt)
+	}
+	return stmts
+}
+
+// isComment reports whether a statement is only comments
+func isComment(stmt string) bool {
+	s := &scanner{sql: stmt}
+	for s.pos < len(stmt) {
+		if strings.HasPrefix(stmt[s.pos:], "--") || strings.HasPrefix(stmt[s.pos:], "/*") {
+			s.pos = s.skipLiteral()
+			continue
+		}
+		if !isSpace(stmt[s.pos]) {
+			return false
+		}
+		s.pos++
+	}
+	return true
+}
+
+// keywordFuncs are Postgres functions called without parentheses
+var keywordFuncs = map[string]bool{
+	"current_user":    true,
+	"session_user":    true,
+	"current_catalog": true,
+	"current_schema":  true,
+}
+
+// rewrite translates the Postgres dialect of a statement into SQLite: $1
+// parameters become ?1, ::type casts are dropped, the pg_catalog and public
+// schemas are the default one and information_schema.x is the view
+// information_schema_x. It also returns the number of parameters.
+func rewrite(sql string) (string, int) {
+	var sb strings.Builder
+	params := 0
+	s := &scanner{sql: sql}
+	for s.pos < len(sql) {
+		if end := s.skipLiteral(); end >= 0 {
+			sb.WriteString(sql[s.pos:end])
+			s.pos = end
+			continue
+		}
+
+		c := sql[s.pos]
+		switch {
+		case c == '$' && s.pos+1 < len(sql) && isDigit(sql[s.pos+1]):
+			end := s.pos + 1
+			for end < len(sql) && isDigit(sql[end]) {
+				end++
+			}
+			n, _ := strconv.Atoi(sql[s.pos+1 : end])
+			params = max(params, n)
+			sb.WriteString("?" + sql[s.pos+1:end])
+			s.pos = end
+
+		case c == ':' && strings.HasPrefix(sql[s.pos:], "::"):
+			s.pos = skipCast(sql, s.pos+2)
+
+		case isIdentStart(c) && (s.pos == 0 || !isIdentPart(sql[s.pos-1])):
+			end := identEnd(sql, s.pos)
+			word := strings.ToLower(sql[s.pos:end])
+			dot := end < len(sql) && sql[end] == '.'
+			switch {
+			case dot && (word == "pg_catalog" || word == "public"):
+				s.pos = end + 1
+			case dot && word == "information_schema" && end+1 < len(sql) && isIdentStart(sql[end+1]):
+				nameEnd := identEnd(sql, end+1)
+				sb.WriteString("information_schema_" + strings.ToLower(sql[end+1:nameEnd]))
+				s.pos = nameEnd
+			case keywordFuncs[word] && !followedByParen(sql, end):
+				sb.WriteString(word + "()")
+				s.pos = end
+			default:
+				sb.WriteString(sql[s.pos:end])
+				s.pos = end
+			}
+
+		default:
+			sb.WriteByte(c)
+			s.pos++
+		}
+	}
+	return sb.String(), params
+}
+
+// skipCast returns the end of the type name of a ::type cast starting at pos,
+// such as int, pg_catalog.regclass, varchar(10) or text[]
+func skipCast(sql string, pos int) int {
+	for pos < len(sql) && isSpace(sql[pos]) {
+		pos++
+	}
+	if pos < len(sql) && sql[pos] == '"' {
+		s := &scanner{sql: sql, pos: pos}
+		pos = s.skipLiteral()
+	} else {
+		pos = identEnd(sql, pos)
+	}
+	for pos < len(sql) && sql[pos] == '.' {
+		pos = identEnd(sql, pos+1)
+	}
+	if pos < len(sql) && sql[pos] == '(' {
+		if i := strings.IndexByte(sql[pos:], ')'); i >= 0 {
+			pos += i + 1
+		}
+	}
+	for strings.HasPrefix(sql[pos:], "[]") {
+		pos += 2
+	}
+	return pos
+}
+
+// followedByParen reports whether the next non-space character is (
+func followedByParen(sql string, pos int) bool {
+	for pos < len(sql) && isSpace(sql[pos]) {
+		pos++
+	}
+	return pos < len(sql) && sql[pos] == '('
+}
+
+func identEnd(sql string, pos int) int {
+	for pos < len(sql) && isIdentPart(sql[pos]) {
+		pos++
+	}
+	return pos
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80
+}
+
+func isIdentPart(c byte) bool {
+	return isIdentStart(c) || isDigit(c) || c == '$'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+// command is the kind of a statement: a query run by SQLite, or a session
+// command answered by the server
+type command int
+
+const (
+	cmdQuery command = iota
+	cmdSet
+	cmdShow
+	cmdBegin
+	cmdCommit
+	cmdRollback
+	cmdDiscard
+	cmdDeallocate
+)
+
+// commandTags are the CommandComplete tags of the session commands
+var commandTags = map[command]string{
+	cmdSet:        "SET",
+	cmdShow:       "SHOW",
+	cmdBegin:      "BEGIN",
+	cmdCommit:     "COMMIT",
+	cmdRollback:   "ROLLBACK",
+	cmdDiscard:    "DISCARD ALL",
+	cmdDeallocate: "DEALLOCATE",
+}
+
+// classify returns the command of a statement and its words after the keyword
+func classify(stmt string) (command, []string) {
+	words := strings.Fields(strings.TrimRight(stmt, "; \t\r\n"))
+	if len(words) == 0 {
+		return cmdQuery, nil
+	}
+	rest := words[1:]
+	switch strings.ToLower(words[0]) {
+	case "set":
+		return cmdSet, rest
+	case "show":
+		return cmdShow, rest
+	case "begin", "start":
+		return cmdBegin, rest
+	case "commit", "end":
+		return cmdCommit, rest
+	case "rollback", "abort":
+		for _, word := range rest {
+			if strings.EqualFold(word, "to") {
+				// ROLLBACK TO a savepoint, which SQLite runs
+				return cmdQuery, nil
+			}
+		}
+		return cmdRollback, rest
+	case "discard":
+		return cmdDiscard, rest
+	case "deallocate":
+		return cmdDeallocate, rest
+	}
+	return cmdQuery, nil
+}
+
+// parseSet returns the parameter and value of SET [SESSION|LOCAL] name
+// {TO|=} value; SET with other forms, such as SET TIME ZONE, is accepted
+// with an empty name
+func parseSet(words []string) (string, string) {
+	if len(words) > 0 && (strings.EqualFold(words[0], "session") || strings.EqualFold(words[0], "local")) {
+		words = words[1:]
+	}
+	joined := strings.Join(words, " ")
+	name, value, ok := strings.Cut(joined, "=")
+	if !ok {
+		fields := strings.Fields(joined)
+		if len(fields) < 3 || !strings.EqualFold(fields[1], "to") {
+			return "", ""
+		}
+		name, value = fields[0], strings.Join(fields[2:], " ")
+	}
+	value = strings.TrimSpace(value)
+	value = strings.Trim(value, `'"`)
+	return strings.ToLower(strings.TrimSpace(name)), value
+}
@@ -0,0 +1,57 @@
+package pgwire
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRewrite(t *testing.T) {
+	tests := []struct {
+		sql    string
+		want   string
+		params int
+	}{
+		{"SELECT * FROM t WHERE a = $1 AND b = $12", "SELECT * FROM t WHERE a = ?1 AND b = ?12", 12},
+		{"SELECT '$1', \"$2\" -- $3\n", "SELECT '$1', \"$2\" -- $3\n", 0},
+		{"SELECT id::text, 'x'::varchar(10), $1::int[] FROM t", "SELECT id, 'x', ?1 FROM t", 1},
+		{"SELECT c.oid::pg_catalog.regclass FROM pg_catalog.pg_class c", "SELECT c.oid FROM pg_class c", 0},
+		{"SELECT * FROM public.orders JOIN information_schema.COLUMNS", "SELECT * FROM orders JOIN information_schema_columns", 0},
+		{"SELECT current_user, current_schema(), my_current_user FROM t", "SELECT current_user(), current_schema(), my_current_user FROM t", 0},
+		{"SELECT 'it''s public.x'", "SELECT 'it''s public.x'", 0},
+	}
+	for _, tt := range tests {
+		got, params := rewrite(tt.sql)
+		if got != tt.want || params != tt.params {
+			t.Errorf("rewrite(%q) = %q, %d; want %q, %d", tt.sql, got, params, tt.want, tt.params)
+		}
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	got := splitStatements("SELECT ';'; ;SELECT 2 /* ; */; -- done")
+	want := []string{"SELECT ';'", "SELECT 2 /* ; */"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
+
+func TestParseSet(t *testing.T) {
+	tests := []struct {
+		sql   string
+		name  string
+		value string
+	}{
+		{"SET extra_float_digits = 3", "extra_float_digits", "3"},
+		{"SET SESSION DateStyle TO 'ISO'", "datestyle", "ISO"},
+		{"SET TIME ZONE 'UTC'", "", ""},
+	}
+	for _, tt := range tests {
+		cmd, words := classify(tt.sql)
+		if cmd != cmdSet {
+			t.Fatalf("Expected %q to be a SET", tt.sql)
+		}
+		if name, value := parseSet(words); name != tt.name || value != tt.value {
+			t.Errorf("parseSet(%q) = %q, %q; want %q, %q", tt.sql, name, value, tt.name, tt.value)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package csvql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"csvql/db"
+)
+
+// QueryStructs runs a query and scans every row into a T, matching columns to
+// struct fields by name. A `csvql:"column"` tag overrides the match and
+// `csvql:"-"` skips a field; untagged fields match case-insensitively with
+// underscores ignored, so HireDate receives hire_date. Columns without a
+// matching field are ignored, and NULL leaves the field at its zero value
+// (or sets pointer fields to nil).
+func QueryStructs[T any](ctx context.Context, c *CSVQL, query string, args ...interface{}) ([]T, error) {
+	rows, err := c.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return ScanStructs[T](rows)
+}
+
+// ScanStructs consumes rows and scans each one into a T (see QueryStructs)
+func ScanStructs[T any](rows *db.Rows) ([]T, error) {
+	structType := reflect.TypeFor[T]()
+	if structType.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("csvql: ScanStructs requires a struct type, got %s", structType)
+	}
+
+	fields := mapColumns(structType, rows.Columns())
+
+	var results []T
+	for rows.Next() {
+		var item T
+		target := reflect.ValueOf(&item).Elem()
+		for i, v := range rows.Values() {
+			if fields[i] == nil {
+				continue
+			}
+			if err := assignValue(target.FieldByIndex(fields[i]), v); err != nil {
+				return nil, fmt.Errorf("csvql: column %s: %w", rows.Columns()[i], err)
+			}
+		}
+		results = append(results, item)
+	}
+	return results, rows.Err()
+}
+
+// mapColumns returns, for each column, the index path of the matching field or nil
+func mapColumns(structType reflect.Type, columns []string) [][]int {
+	byName := make(map[string][]int)
+	for _, field := range reflect.VisibleFields(structType) {
+		if !field.IsExported() || field.Anonymous {
+			continue
+		}
+		name := field.Name
+		if tag, ok := field.Tag.Lookup("csvql"); ok {
+			if tag == "-" {
+				continue
+			}
+			name = tag
+		}
+		byName[normalizeFieldName(name)] = field.Index
+	}
+
+	fields := make([][]int, len(columns))
+	for i, col := range columns {
+		fields[i] = byName[normalizeFieldName(col)]
+	}
+	return fields
+}
+
+// normalizeFieldName folds case and drops underscores so Go and SQL names compare equal
+func normalizeFieldName(name string) string {
+	return strings.ToLower(strings.ReplaceAll(name, "_", ""))
+}
+
+// assignValue stores a typed query value into a struct field, converting
+// between compatible kinds
+func assignValue(field reflect.Value, v interface{}) error {
+	if scanner, ok := field.Addr().Interface().(sql.Scanner); ok {
+		return scanner.Scan(v)
+	}
+
+	if v == nil {
+		field.SetZero()
+		return nil
+	}
+
+	if field.Kind() == reflect.Pointer {
+		elem := reflect.New(field.Type().Elem())
+		if err := assignValue(elem.Elem(), v); err != nil {
+			return err
+		}
+		field.Set(elem)
+		return nil
+	}
+
+	if field.Type() == reflect.TypeFor[time.Time]() {
+		s, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("cannot convert %T to time.Time", v)
+		}
+		t, err := parseTime(s)
+		if err != nil {
+			return err
+		}
+		field.Set(reflect.ValueOf(t))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(fmt.Sprintf("%v", v))
+		return nil
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, ok := int64(0), false
+		switch val := v.(type) {
+		case int64:
+			n, ok = val, true
+		case float64:
+			n, ok = int64(val), val == float64(int64(val))
+		case string:
+			parsed, err := strconv.ParseInt(val, 10, 64)
+			n, ok = parsed, err == nil
+		}
+		if ok {
+			if field.OverflowInt(n) {
+				return fmt.Errorf("value %d overflows %s", n, field.Type())
+			}
+			field.SetInt(n)
+			return nil
+		}
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if val, ok := v.(int64); ok && val >= 0 {
+			if field.OverflowUint(uint64(val)) {
+				return fmt.Errorf("value %d overflows %s", val, field.Type())
+			}
+			field.SetUint(uint64(val))
+			return nil
+		}
+
+	case reflect.Float32, reflect.Float64:
+		f, ok := 0.0, false
+		switch val := v.(type) {
+		case float64:
+			f, ok = val, true
+		case int64:
+			f, ok = float64(val), true
+		case string:
+			parsed, err := strconv.ParseFloat(val, 64)
+			f, ok = parsed, err == nil
+		}
+		if ok {
+			if field.OverflowFloat(f) {
+				return fmt.Errorf("value %g overflows %s", f, field.Type())
+			}
+			field.SetFloat(f)
+			return nil
+		}
+
+	case reflect.Bool:
+		switch val := v.(type) {
+		case bool:
+			field.SetBool(val)
+			return nil
+		case int64:
+			field.SetBool(val != 0)
+			return nil
+		}
+
+	case reflect.Interface:
+		if reflect.TypeOf(v).AssignableTo(field.Type()) {
+			field.Set(reflect.ValueOf(v))
+			return nil
+		}
+	}
+
+	return fmt.Errorf("cannot convert %T to %s", v, field.Type())
+}
+
+// parseTime parses the ISO-8601 strings returned for DATE and DATETIME columns
+func parseTime(s string) (time.Time, error) {
+	for _, layout := range []string{
+		"2006-01-02 15:04:05.999999999-07:00",
+		"2006-01-02 15:04:05.999999999",
+		time.RFC3339Nano,
+		"2006-01-02",
+	} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("cannot parse %q as a date", s)
+}
@@ -0,0 +1,411 @@
+// Package server exposes the tables of a CSVQL instance through a JSON REST API
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"csvql"
+	"csvql/db"
+	"csvql/output"
+)
+
+// DefaultTimeout bounds a query when Options.Timeout is not set
+const DefaultTimeout = 30 * time.Second
+
+const (
+	defaultLimit = 100
+	maxLimit     = 10000
+	maxBodySize  = 1 << 20
+)
+
+// mediaTypes maps the Accept media types to the output format of a result
+var mediaTypes = map[string]string{
+	"application/json":          "json",
+	"text/csv":                  "csv",
+	"text/tab-separated-values": "tsv",
+	"application/x-ndjson":      "ndjson",
+	"application/ndjson":        "ndjson",
+	"application/jsonl":         "ndjson",
+}
+
+// contentTypes is the Content-Type of each output format
+var contentTypes = map[string]string{
+	"json":   "application/json",
+	"csv":    "text/csv; charset=utf-8",
+	"tsv":    "text/tab-separated-values; charset=utf-8",
+	"ndjson": "application/x-ndjson",
+}
+
+// Options configure a Server
+type Options struct {
+	// Timeout bounds the time a query runs, including streaming its result;
+	// zero means DefaultTimeout
+	Timeout time.Duration
+}
+
+// Server answers HTTP requests with the results of queries, which are
+// read-only unless the CSVQL instance allows writes:
+//
+//	POST /query                   {"sql": "...", "params": [...] or {...}}
+//	GET  /tables                  the loaded tables and their files
+//	GET  /tables/{name}/schema    the columns and CREATE TABLE of a table
+//	GET  /tables/{name}/rows      ?limit=&offset= rows of a table
+//
+// Results are JSON, CSV, TSV or NDJSON depending on the Accept header.
+type Server struct {
+	c       *csvql.CSVQL
+	timeout time.Duration
+	mux     *http.ServeMux
+}
+
+// New creates a Server for a CSVQL instance
+func New(c *csvql.CSVQL, opts Options) *Server {
+	s := &Server{
+		c:       c,
+		timeout: opts.Timeout,
+		mux:     http.NewServeMux(),
+	}
+	if s.timeout <= 0 {
+		s.timeout = DefaultTimeout
+	}
+	s.mux.HandleFunc("POST /query", s.handleQuery)
+	s.mux.HandleFunc("GET /tables", s.handleTables)
+	s.mux.HandleFunc("GET /tables/{name}/schema", s.handleSchema)
+	s.mux.HandleFunc("GET /tables/{name}/rows", s.handleRows)
+	return s
+}
+
+// ServeHTTP implements http.Handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// queryRequest is the body of POST /query
+type queryRequest struct {
+	SQL    string          `json:"sql"`
+	Params json.RawMessage `json:"params"`
+}
+
+func (s *Server) handleQuery(w http.ResponseWriter, r *http.Request) {
+	format, ok := negotiate(r.Header.Get("Accept"))
+	if !ok {
+		writeError(w, http.StatusNotAcceptable, "supported media types: application/json, text/csv, text/tab-separated-values, application/x-ndjson")
+		return
+	}
+
+	var req queryRequest
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid request body: %v", err))
+		return
+	}
+	if strings.TrimSpace(req.SQL) == "" {
+		writeError(w, http.StatusBadRequest, "missing sql")
+		return
+	}
+	args, err := parseParams(req.Params)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.query(w, r, format, req.SQL, args...)
+}
+
+// tableInfo describes a table in GET /tables
+type tableInfo struct {
+	Name    string `json:"name"`
+	File    string `json:"file"`
+	Columns int    `json:"columns"`
+}
+
+func (s *Server) handleTables(w http.ResponseWriter, r *http.Request) {
+	files, err := s.tableFiles()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	tables := make([]tableInfo, 0, len(files))
+	for name, file := range files {
+		cols, err := s.c.GetTableInfo(name)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		tables = append(tables, tableInfo{Name: name, File: file, Columns: len(cols)})
+	}
+	sort.Slice(tables, func(i, j int) bool { return tables[i].Name < tables[j].Name })
+	writeJSON(w, http.StatusOK, tables)
+}
+
+// tableSchema is the response of GET /tables/{name}/schema
+type tableSchema struct {
+	Name    string      `json:"name"`
+	File    string      `json:"file"`
+	Columns []db.Column `json:"columns"`
+	SQL     string      `json:"sql"`
+}
+
+func (s *Server) handleSchema(w http.ResponseWriter, r *http.Request) {
+	name, file, ok := s.table(w, r)
+	if !ok {
+		return
+	}
+
+	cols, err := s.c.DB.GetTableColumns(name)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	schema, err := s.c.GetTableSchema(name)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, tableSchema{Name: name, File: file, Columns: cols, SQL: schema})
+}
+
+func (s *Server) handleRows(w http.ResponseWriter, r *http.Request) {
+	format, ok := negotiate(r.Header.Get("Accept"))
+	if !ok {
+		writeError(w, http.StatusNotAcceptable, "supported media types: application/json, text/csv, text/tab-separated-values, application/x-ndjson")
+		return
+	}
+	name, _, ok := s.table(w, r)
+	if !ok {
+		return
+	}
+
+	limit, err := queryInt(r, "limit", defaultLimit)
+	if err != nil || limit < 0 || limit > maxLimit {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 0 and %d", maxLimit))
+		return
+	}
+	offset, err := queryInt(r, "offset", 0)
+	if err != nil || offset < 0 {
+		writeError(w, http.StatusBadRequest, "offset must not be negative")
+		return
+	}
+
+	query := fmt.Sprintf("SELECT * FROM %s LIMIT ? OFFSET ?", quoteIdent(name))
+	s.query(w, r, format, query, limit, offset)
+}
+
+// query runs a query within the timeout and streams its result.
+// Errors found before the first row get an error status; a later error
+// truncates the response, since its status has already been sent.
+func (s *Server) query(w http.ResponseWriter, r *http.Request, format, query string, args ...interface{}) {
+	ctx, cancel := context.WithTimeout(r.Context(), s.timeout)
+	defer cancel()
+
+	rows, err := s.c.QueryContext(ctx, query, args...)
+	if err != nil {
+		writeQueryError(ctx, w, err)
+		return
+	}
+	defer rows.Close()
+
+	more := rows.Next()
+	if err := rows.Err(); !more && err != nil {
+		writeQueryError(ctx, w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentTypes[format])
+	out, err := output.New(format, w, output.Options{Header: true})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err := out.WriteHeader(rows.Columns()); err != nil {
+		return
+	}
+	for ; more; more = rows.Next() {
+		if err := out.WriteRow(rows.Values()); err != nil {
+			log.Printf("Error writing response: %v", err)
+			return
+		}
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Error reading query result: %v", err)
+		return
+	}
+	out.Flush()
+}
+
+// table looks up the table named in the path, answering 404 if it is not loaded
+func (s *Server) table(w http.ResponseWriter, r *http.Request) (name, file string, ok bool) {
+	files, err := s.tableFiles()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return "", "", false
+	}
+	name = r.PathValue("name")
+	file, ok = files[name]
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("no such table: %s", name))
+	}
+	return name, file, ok
+}
+
+// tableFiles maps the loaded tables to their files, relative to the root
+// directory; a union table maps to the key of its set
+func (s *Server) tableFiles() (map[string]string, error) {
+	mappings, err := s.c.DB.GetAllTableMappings()
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]string, len(mappings))
+	for path, table := range mappings {
+		if rel, err := filepath.Rel(s.c.RootDir, path); err == nil {
+			path = filepath.ToSlash(rel)
+		}
+		files[table] = path
+	}
+	return files, nil
+}
+
+// negotiate picks the output format for an Accept header, preferring the
+// media types with the highest quality; no header, or */*, means JSON
+func negotiate(accept string) (string, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return "json", true
+	}
+
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		format, ok := mediaTypes[mediaType]
+		if mediaType == "*/*" || mediaType == "application/*" {
+			format, ok = "json", true
+		} else if mediaType == "text/*" {
+			format, ok = "csv", true
+		}
+		if ok && q > bestQ {
+			best, bestQ = format, q
+		}
+	}
+	return best, best != ""
+}
+
+// parseParams decodes bind parameters: an array binds ?1, ?2, ... and an
+// object binds :name, @name and $name
+func parseParams(raw json.RawMessage) ([]interface{}, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	dec := json.NewDecoder(strings.NewReader(string(raw)))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, fmt.Errorf("invalid params: %w", err)
+	}
+
+	switch params := v.(type) {
+	case []interface{}:
+		args := make([]interface{}, len(params))
+		for i, p := range params {
+			arg, err := paramValue(p)
+			if err != nil {
+				return nil, fmt.Errorf("param %d: %w", i+1, err)
+			}
+			args[i] = arg
+		}
+		return args, nil
+	case map[string]interface{}:
+		names := make([]string, 0, len(params))
+		for name := range params {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		args := make([]interface{}, len(names))
+		for i, name := range names {
+			arg, err := paramValue(params[name])
+			if err != nil {
+				return nil, fmt.Errorf("param %s: %w", name, err)
+			}
+			args[i] = sql.Named(strings.TrimLeft(name, ":@$"), arg)
+		}
+		return args, nil
+	}
+	return nil, errors.New("params must be an array or an object")
+}
+
+// paramValue converts a decoded JSON value into a bind value
+func paramValue(v interface{}) (interface{}, error) {
+	switch val := v.(type) {
+	case nil, string, bool:
+		return val, nil
+	case json.Number:
+		if i, err := val.Int64(); err == nil {
+			return i, nil
+		}
+		return val.Float64()
+	}
+	return nil, errors.New("must be a string, number, boolean or null")
+}
+
+// queryInt parses an integer query parameter
+func queryInt(r *http.Request, name string, def int) (int, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return def, nil
+	}
+	return strconv.Atoi(v)
+}
+
+// writeQueryError answers a failed query: 403 for a statement that would
+// write, 504 when the timeout expired and 400 otherwise
+func writeQueryError(ctx context.Context, w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, db.ErrReadOnly):
+		writeError(w, http.StatusForbidden, err.Error())
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		writeError(w, http.StatusGatewayTimeout, "query timed out")
+	default:
+		writeError(w, http.StatusBadRequest, err.Error())
+	}
+}
+
+// writeError answers with {"error": message}
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		log.Printf("Error writing response: %v", err)
+	}
+}
+
+// quoteIdent quotes a table name for SQL
+func quoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
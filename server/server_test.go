@@ -0,0 +1,185 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"csvql"
+)
+
+func newTestServer(t *testing.T, opts Options) *httptest.Server {
+	t.Helper()
+	tmpDir := t.TempDir()
+	os.WriteFile(filepath.Join(tmpDir, "employees.csv"), []byte("id,name,salary\n1,Alice,5000\n2,Bob,4000\n3,Carol,6000\n"), 0644)
+
+	c, err := csvql.New(csvql.Options{RootDir: tmpDir})
+	if err != nil {
+		t.Fatalf("csvql.New failed: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+
+	ts := httptest.NewServer(New(c, opts))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func post(t *testing.T, ts *httptest.Server, accept, body string) (int, string) {
+	t.Helper()
+	req, _ := http.NewRequest("POST", ts.URL+"/query", strings.NewReader(body))
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	return do(t, req)
+}
+
+func get(t *testing.T, ts *httptest.Server, path, accept string) (int, string) {
+	t.Helper()
+	req, _ := http.NewRequest("GET", ts.URL+path, nil)
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	return do(t, req)
+}
+
+func do(t *testing.T, req *http.Request) (int, string) {
+	t.Helper()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Reading response failed: %v", err)
+	}
+	return resp.StatusCode, string(body)
+}
+
+func TestQuery(t *testing.T) {
+	ts := newTestServer(t, Options{})
+
+	tests := []struct {
+		name   string
+		accept string
+		body   string
+		status int
+		want   string
+	}{
+		{"json", "", `{"sql": "SELECT name FROM employees WHERE salary > ? ORDER BY id", "params": [4500]}`,
+			200, "[\n{\"name\":\"Alice\"},\n{\"name\":\"Carol\"}\n]\n"},
+		{"named params", "application/json", `{"sql": "SELECT id FROM employees WHERE name = :name", "params": {"name": "Bob"}}`,
+			200, "[\n{\"id\":2}\n]\n"},
+		{"csv", "text/csv", `{"sql": "SELECT id, name FROM employees ORDER BY id LIMIT 2"}`,
+			200, "id,name\n1,Alice\n2,Bob\n"},
+		{"ndjson by quality", "text/csv;q=0.5, application/x-ndjson", `{"sql": "SELECT id FROM employees ORDER BY id LIMIT 2"}`,
+			200, "{\"id\":1}\n{\"id\":2}\n"},
+		{"write refused", "", `{"sql": "DROP TABLE employees"}`, 403, "read-only"},
+		{"sql error", "", `{"sql": "SELECT * FROM nope"}`, 400, "no such table"},
+		{"missing sql", "", `{}`, 400, "missing sql"},
+		{"not acceptable", "image/png", `{"sql": "SELECT 1"}`, 406, "supported media types"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, body := post(t, ts, tt.accept, tt.body)
+			if status != tt.status {
+				t.Errorf("Expected status %d, got %d: %s", tt.status, status, body)
+			}
+			if tt.status == 200 && body != tt.want {
+				t.Errorf("Expected body %q, got %q", tt.want, body)
+			}
+			if tt.status != 200 && !strings.Contains(body, tt.want) {
+				t.Errorf("Expected error containing %q, got %s", tt.want, body)
+			}
+		})
+	}
+
+	// The table survives the refused write
+	if status, body := post(t, ts, "text/csv", `{"sql": "SELECT COUNT(*) AS n FROM employees"}`); status != 200 || body != "n\n3\n" {
+		t.Errorf("Expected 3 employees, got %d %q", status, body)
+	}
+}
+
+func TestQuery_TransactionLeftOpen(t *testing.T) {
+	for _, allowWrites := range []bool{false, true} {
+		tmpDir := t.TempDir()
+		path := filepath.Join(tmpDir, "employees.csv")
+		os.WriteFile(path, []byte("id,name\n1,Alice\n"), 0644)
+		c, err := csvql.New(csvql.Options{RootDir: tmpDir, AllowWrites: allowWrites})
+		if err != nil {
+			t.Fatalf("csvql.New failed: %v", err)
+		}
+		defer c.Close()
+		ts := httptest.NewServer(New(c, Options{}))
+		defer ts.Close()
+
+		// A transaction must not outlive its request on a pooled connection
+		post(t, ts, "", `{"sql": "BEGIN"}`)
+		post(t, ts, "", `{"sql": "SAVEPOINT sp"}`)
+		post(t, ts, "text/csv", `{"sql": "SELECT COUNT(*) FROM employees"}`)
+
+		os.WriteFile(path, []byte("id,name\n1,Alice\n2,Bob\n"), 0644)
+		os.Chtimes(path, time.Now().Add(time.Second), time.Now().Add(time.Second))
+		if err := c.Scan(); err != nil {
+			t.Fatalf("Scan failed: %v", err)
+		}
+		for i := 0; i < 3; i++ {
+			if status, body := post(t, ts, "text/csv", `{"sql": "SELECT COUNT(*) AS n FROM employees"}`); status != 200 || body != "n\n2\n" {
+				t.Errorf("allow writes %v: expected the reloaded table, got %d %q", allowWrites, status, body)
+			}
+		}
+	}
+}
+
+func TestQuery_Timeout(t *testing.T) {
+	ts := newTestServer(t, Options{Timeout: 50 * time.Millisecond})
+
+	slow := `{"sql": "WITH RECURSIVE n(x) AS (SELECT 1 UNION ALL SELECT x + 1 FROM n) SELECT COUNT(*) FROM n"}`
+	status, body := post(t, ts, "", slow)
+	if status != http.StatusGatewayTimeout {
+		t.Errorf("Expected status 504, got %d: %s", status, body)
+	}
+}
+
+func TestTables(t *testing.T) {
+	ts := newTestServer(t, Options{})
+
+	status, body := get(t, ts, "/tables", "")
+	var tables []tableInfo
+	if err := json.Unmarshal([]byte(body), &tables); status != 200 || err != nil {
+		t.Fatalf("Expected a table list, got %d %s", status, body)
+	}
+	if len(tables) != 1 || tables[0] != (tableInfo{Name: "employees", File: "employees.csv", Columns: 3}) {
+		t.Errorf("Unexpected tables: %+v", tables)
+	}
+
+	status, body = get(t, ts, "/tables/employees/schema", "")
+	var schema tableSchema
+	if err := json.Unmarshal([]byte(body), &schema); status != 200 || err != nil {
+		t.Fatalf("Expected a schema, got %d %s", status, body)
+	}
+	if len(schema.Columns) != 3 || schema.Columns[2].Name != "salary" || schema.Columns[2].Type != "INTEGER" {
+		t.Errorf("Unexpected columns: %+v", schema.Columns)
+	}
+	if !strings.HasPrefix(schema.SQL, "CREATE TABLE") {
+		t.Errorf("Expected a CREATE TABLE statement, got %q", schema.SQL)
+	}
+
+	status, body = get(t, ts, "/tables/employees/rows?limit=1&offset=1", "text/csv")
+	if status != 200 || body != "id,name,salary\n2,Bob,4000\n" {
+		t.Errorf("Expected the second row, got %d %q", status, body)
+	}
+
+	if status, _ := get(t, ts, "/tables/_csvql_metadata/rows", ""); status != http.StatusNotFound {
+		t.Errorf("Expected 404 for an internal table, got %d", status)
+	}
+	if status, _ := get(t, ts, "/tables/employees/rows?limit=-1", ""); status != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a negative limit, got %d", status)
+	}
+}
@@ -17,12 +17,16 @@ import (
 
 // Watcher monitors directory for CSV/TSV file changes
 type Watcher struct {
-	rootDir   string
-	dbManager *db.Manager
-	fsWatcher *fsnotify.Watcher
-	done      chan struct{}
-	wg        sync.WaitGroup
-	onChange  func(event string, path string)
+	rootDir    string
+	dbManager  *db.Manager
+	fsWatcher  *fsnotify.Watcher
+	done       chan struct{}
+	wg         sync.WaitGroup
+	onChange   func(event string, path string)
+	onProgress loader.ProgressFunc
+	fileConfig func(path string) loader.FileConfig
+	unionRules []loader.UnionRule
+	filter     *loader.Filter
 }
 
 // New creates a new file watcher
@@ -37,19 +41,11 @@ func New(rootDir string, dbManager *db.Manager) (*Watcher, error) {
 		dbManager: dbManager,
 		fsWatcher: fsWatcher,
 		done:      make(chan struct{}),
+		filter:    loader.NewFilter(rootDir, nil, nil),
 	}
 
 	// Add all directories to watcher
-	err = filepath.Walk(rootDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if info.IsDir() {
-			return fsWatcher.Add(path)
-		}
-		return nil
-	})
-	if err != nil {
+	if err := w.syncWatches(); err != nil {
 		fsWatcher.Close()
 		return nil, err
 	}
@@ -57,11 +53,55 @@ func New(rootDir string, dbManager *db.Manager) (*Watcher, error) {
 	return w, nil
 }
 
+// syncWatches watches every directory the filter does not skip and stops
+// watching the ones it skips
+func (w *Watcher) syncWatches() error {
+	for _, dir := range w.fsWatcher.WatchList() {
+		if w.filter.SkipDir(dir) {
+			w.fsWatcher.Remove(dir)
+		}
+	}
+	return filepath.Walk(w.rootDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		if w.filter.SkipDir(path) {
+			return filepath.SkipDir
+		}
+		return w.fsWatcher.Add(path)
+	})
+}
+
 // SetOnChange sets callback for file changes
 func (w *Watcher) SetOnChange(fn func(event string, path string)) {
 	w.onChange = fn
 }
 
+// SetOnProgress sets callback for load progress of changed files
+func (w *Watcher) SetOnProgress(fn loader.ProgressFunc) {
+	w.onProgress = fn
+}
+
+// SetFileConfig sets the lookup of per-file format overrides
+func (w *Watcher) SetFileConfig(fn func(path string) loader.FileConfig) {
+	w.fileConfig = fn
+}
+
+// SetUnionRules sets the rules grouping files into union tables
+func (w *Watcher) SetUnionRules(rules []loader.UnionRule) {
+	w.unionRules = rules
+}
+
+// SetFilter sets the include and exclude patterns; directories the filter
+// skips are no longer watched
+func (w *Watcher) SetFilter(filter *loader.Filter) error {
+	w.filter = filter
+	return w.syncWatches()
+}
+
 // Start begins watching for file changes
 func (w *Watcher) Start() {
 	w.wg.Add(1)
@@ -94,20 +134,53 @@ func (w *Watcher) watch() {
 				return
 			}
 
-			// Check if it's a CSV/TSV file
-			ext := strings.ToLower(filepath.Ext(event.Name))
-			if ext != ".csv" && ext != ".tsv" {
+			// A .csvqlignore change re-applies the filter below its directory
+			if loader.IsIgnoreFile(event.Name) {
+				w.filter.Reload()
+				if err := w.syncWatches(); err != nil {
+					log.Printf("Error updating watches: %v", err)
+				}
+				mu.Lock()
+				pending[filepath.Dir(event.Name)] = time.Now()
+				mu.Unlock()
+				continue
+			}
+
+			// A layout or sidecar change reloads the file it describes
+			name := event.Name
+			isConfig := false
+			switch {
+			case loader.IsLayoutFile(name):
+				name, isConfig = loader.LayoutDataPath(name), true
+			case loader.IsSidecarFile(name):
+				name, isConfig = loader.SidecarDataPath(name), true
+				if name == "" {
+					// A directory sidecar reloads every file of its directory
+					mu.Lock()
+					for _, file := range w.dataFiles(filepath.Dir(event.Name)) {
+						pending[file] = time.Now()
+					}
+					mu.Unlock()
+					continue
+				}
+			}
+
+			// Check if it's a CSV/TSV file, a fixed-width file or an archive of them
+			if !isConfig && !loader.IsSupportedFile(name) && !loader.IsFixedWidthFile(name) && !loader.IsArchive(name) {
 				// Check if new directory was created
 				if event.Has(fsnotify.Create) {
-					if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+					if info, err := os.Stat(event.Name); err == nil && info.IsDir() && !w.filter.SkipDir(event.Name) {
 						w.fsWatcher.Add(event.Name)
 					}
 				}
 				continue
 			}
+			if w.filter.Skip(name) {
+				continue
+			}
 
 			mu.Lock()
-			pending[event.Name] = time.Now()
+			pending[name] = time.Now()
 			mu.Unlock()
 
 		case err, ok := <-w.fsWatcher.Errors:
@@ -131,32 +204,88 @@ func (w *Watcher) watch() {
 	}
 }
 
+// dataFiles lists the files of a directory that are loaded as tables,
+// counting archives as one file
+func (w *Watcher) dataFiles(dir string) []string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var files []string
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		if !entry.IsDir() && (loader.IsSupportedFile(path) || loader.IsFixedWidthFile(path) || loader.IsArchive(path)) && !w.filter.Skip(path) {
+			files = append(files, path)
+		}
+	}
+	return files
+}
+
 func (w *Watcher) processFile(path string) {
-	// Get all current files and resolve desired names
-	files, _ := loader.ScanDirectory(w.rootDir)
-	desiredNames := loader.ResolveTableNames(files, w.rootDir)
+	defer loader.ReleaseArchives()
+
+	// Get all current files, group union sets and resolve desired names
+	files, _ := loader.ScanDirectoryFilter(w.rootDir, w.filter)
+	singles, sets := loader.GroupFiles(files, w.rootDir, w.unionRules)
+	desiredNames := loader.ResolveSetNames(singles, sets, w.rootDir)
 
 	// Get current mappings from DB
 	currentMappings, _ := w.dbManager.GetAllTableMappings()
 
-	// Build set of current files
-	currentFiles := make(map[string]bool)
-	for _, f := range files {
-		currentFiles[f] = true
+	isArchive := loader.IsArchive(path)
+	if isArchive {
+		// Keep the current tables while an archive is unreadable, e.g. half-written
+		if _, err := os.Stat(path); err == nil {
+			if _, err := loader.ListArchive(path); err != nil {
+				log.Printf("Error reading archive %s: %v", path, err)
+				return
+			}
+		}
+	}
+
+	// A directory, whose .csvqlignore changed, affects every file below it
+	isDir := false
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		isDir = true
 	}
 
-	// Check if file was deleted
-	if _, err := os.Stat(path); os.IsNotExist(err) {
-		// Remove table for deleted file
-		if err := w.dbManager.RemoveTableByPath(path); err != nil {
-			log.Printf("Error removing table for %s: %v", path, err)
-		} else {
-			if w.onChange != nil {
-				w.onChange("DELETE", path)
+	// affects reports whether a file is the changed path or inside the changed archive or directory
+	affects := func(file string) bool {
+		if file == path || (isDir && strings.HasPrefix(file, path+string(filepath.Separator))) {
+			return true
+		}
+		archive, _, ok := loader.SplitArchivePath(file)
+		return ok && isArchive && archive == path
+	}
+
+	// Union sets holding the changed path now, or when it was last loaded
+	affectedSets := make(map[string]bool)
+	for _, set := range sets {
+		for _, f := range set.Files {
+			if affects(f) {
+				affectedSets[set.Key] = true
+				break
+			}
+		}
+	}
+	if table, ok := w.dbManager.PartitionTable(path); ok {
+		for key, name := range currentMappings {
+			if name == table {
+				affectedSets[key] = true
 			}
-			log.Printf("Removed table for: %s", path)
 		}
+	}
 
+	// Drop tables of deleted files, of members removed from a changed archive
+	// and of union sets left without files
+	removed := false
+	for filePath := range currentMappings {
+		if _, exists := desiredNames[filePath]; !exists && (affects(filePath) || affectedSets[filePath]) {
+			w.removeTable(filePath)
+			removed = true
+		}
+	}
+	if removed {
 		// Refresh mappings after removal
 		currentMappings, _ = w.dbManager.GetAllTableMappings()
 	}
@@ -175,23 +304,115 @@ func (w *Watcher) processFile(path string) {
 		}
 	}
 
-	// If file exists, load or update it
-	if currentFiles[path] {
-		tableName := desiredNames[path]
-		parsed, err := loader.ParseFile(path, w.rootDir, tableName)
-		if err != nil {
-			log.Printf("Error parsing file %s: %v", path, err)
-			return
+	// Reload only the changed files of affected union sets
+	for _, set := range sets {
+		if affectedSets[set.Key] {
+			w.syncSet(set, desiredNames[set.Key])
+		}
+	}
+
+	// Load or update the file, or every member of an archive
+	for _, f := range singles {
+		if affects(f) {
+			w.loadFile(f, desiredNames[f])
 		}
+	}
+}
 
-		if err := w.dbManager.LoadFile(parsed); err != nil {
-			log.Printf("Error loading file %s: %v", path, err)
-			return
+// syncSet reloads the changed files of a union set into its table
+func (w *Watcher) syncSet(set *loader.FileSet, tableName string) {
+	var readers []*loader.Reader
+	open := func(path string) (*loader.Reader, error) {
+		reader, err := loader.OpenFileConfig(path, w.rootDir, w.configFor(path), tableName)
+		if err == nil {
+			readers = append(readers, reader)
+			if w.onProgress != nil {
+				reader.SetProgress(w.onProgress)
+			}
 		}
+		return reader, err
+	}
+
+	loaded, removed, err := w.dbManager.SyncUnion(set, tableName, w.rootDir, open)
+	if err != nil {
+		log.Printf("Error loading %s: %v", set.Key, err)
+		return
+	}
 
-		if w.onChange != nil {
-			w.onChange("UPDATE", path)
+	if w.onChange != nil {
+		for _, f := range loaded {
+			w.onChange("UPDATE", f)
+		}
+		for _, f := range removed {
+			w.onChange("DELETE", f)
 		}
-		log.Printf("Updated table: %s", parsed.Info.TableName)
+	}
+	for _, reader := range readers {
+		w.reportBadRows(reader)
+	}
+	if len(loaded)+len(removed) > 0 {
+		log.Printf("Updated table: %s (%d file(s) reloaded, %d removed)", tableName, len(loaded), len(removed))
+	}
+}
+
+// configFor returns the format overrides for a file
+func (w *Watcher) configFor(path string) loader.FileConfig {
+	if w.fileConfig == nil {
+		return loader.FileConfig{}
+	}
+	return w.fileConfig(path)
+}
+
+// removeTable drops the table loaded from a deleted file
+func (w *Watcher) removeTable(path string) {
+	if err := w.dbManager.RemoveTableByPath(path); err != nil {
+		log.Printf("Error removing table for %s: %v", path, err)
+		return
+	}
+	if w.onChange != nil {
+		w.onChange("DELETE", path)
+	}
+	log.Printf("Removed table for: %s", path)
+}
+
+// loadFile loads or reloads a file into its table
+func (w *Watcher) loadFile(path, tableName string) {
+	reader, err := loader.OpenFileConfig(path, w.rootDir, w.configFor(path), tableName)
+	if err != nil {
+		log.Printf("Error parsing file %s: %v", path, err)
+		return
+	}
+	defer reader.Close()
+
+	// The file is as its table was written back to it
+	if w.dbManager.WrittenBack(path, reader.Info.ModTime) && !w.dbManager.NeedsUpdate(reader.Info.TableName, reader.Info.ModTime) {
+		return
+	}
+
+	if w.onProgress != nil {
+		reader.SetProgress(w.onProgress)
+	}
+
+	if err := w.dbManager.LoadReader(reader); err != nil {
+		log.Printf("Error loading file %s: %v", path, err)
+		return
+	}
+
+	if w.onChange != nil {
+		w.onChange("UPDATE", path)
+	}
+	log.Printf("Updated table: %s", reader.Info.TableName)
+	w.reportBadRows(reader)
+}
+
+// reportBadRows logs the malformed rows left out of a loaded file and sends
+// an ERRORS event for it
+func (w *Watcher) reportBadRows(reader *loader.Reader) {
+	if reader.BadRows() == 0 {
+		return
+	}
+	log.Printf("Left out %d malformed row(s) of %s, see _csvql_errors", reader.BadRows(), reader.Info.Path)
+	if w.onChange != nil {
+		w.onChange("ERRORS", reader.Info.Path)
 	}
 }
@@ -1,10 +1,13 @@
 package watcher
 
 import (
+	"archive/zip"
 	"csvql/db"
 	"csvql/loader"
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -360,3 +363,355 @@ func TestWatcher_Stop(t *testing.T) {
 		t.Error("Stop blocked for too long")
 	}
 }
+
+// writeZip creates a zip archive with the given member names and contents
+func writeZip(t *testing.T, path string, members map[string]string) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	for name, content := range members {
+		mw, _ := zw.Create(name)
+		mw.Write([]byte(content))
+	}
+	zw.Close()
+	f.Close()
+}
+
+func TestWatcher_Archive(t *testing.T) {
+	tmpDir := t.TempDir()
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	archive := filepath.Join(tmpDir, "bundle.zip")
+
+	m, err := db.New(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create db: %v", err)
+	}
+	defer m.Close()
+
+	w, err := New(tmpDir, m)
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	w.Start()
+	defer w.Stop()
+
+	writeZip(t, archive, map[string]string{
+		"orders.csv": "id\n1",
+		"items.csv":  "id\n1",
+	})
+	time.Sleep(1500 * time.Millisecond)
+
+	tables, _ := m.ListTables()
+	if len(tables) != 2 || tables[0] != "bundle_items" || tables[1] != "bundle_orders" {
+		t.Fatalf("Expected bundle_items and bundle_orders, got %v", tables)
+	}
+
+	// Replacing the archive reloads its members and drops removed ones
+	writeZip(t, archive, map[string]string{
+		"orders.csv": "id\n1\n2",
+	})
+	time.Sleep(1500 * time.Millisecond)
+
+	tables, _ = m.ListTables()
+	if len(tables) != 1 || tables[0] != "bundle_orders" {
+		t.Fatalf("Expected only bundle_orders, got %v", tables)
+	}
+	_, rows, _ := m.Query("SELECT COUNT(*) FROM bundle_orders")
+	if rows[0][0] != "2" {
+		t.Errorf("Expected 2 rows after reload, got %s", rows[0][0])
+	}
+
+	os.Remove(archive)
+	time.Sleep(1500 * time.Millisecond)
+
+	if tables, _ = m.ListTables(); len(tables) != 0 {
+		t.Errorf("Expected no tables after deleting the archive, got %v", tables)
+	}
+}
+
+func TestWatcher_Partition(t *testing.T) {
+	tmpDir := t.TempDir()
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+
+	jan := filepath.Join(tmpDir, "events", "month=01", "part-0.csv")
+	feb := filepath.Join(tmpDir, "events", "month=02", "part-0.csv")
+	os.MkdirAll(filepath.Dir(jan), 0755)
+	os.MkdirAll(filepath.Dir(feb), 0755)
+	os.WriteFile(jan, []byte("id\n1\n2"), 0644)
+
+	m, err := db.New(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create db: %v", err)
+	}
+	defer m.Close()
+
+	w, err := New(tmpDir, m)
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+
+	var mu sync.Mutex
+	events := []string{}
+	w.SetOnChange(func(event, path string) {
+		mu.Lock()
+		events = append(events, event+":"+path)
+		mu.Unlock()
+	})
+	w.Start()
+	defer w.Stop()
+
+	// A new partition directory is picked up and only its file is loaded
+	os.WriteFile(feb, []byte("id\n3"), 0644)
+	time.Sleep(1500 * time.Millisecond)
+
+	_, rows, err := m.Query("SELECT month, COUNT(*) FROM events GROUP BY month ORDER BY month")
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(rows) != 2 || rows[0][1] != "2" || rows[1][1] != "1" {
+		t.Errorf("Unexpected partition counts: %v", rows)
+	}
+
+	// Deleting a partition file removes just its rows
+	os.Remove(jan)
+	time.Sleep(1500 * time.Millisecond)
+
+	_, rows, _ = m.Query("SELECT COUNT(*) FROM events")
+	if rows[0][0] != "1" {
+		t.Errorf("Expected 1 row after deleting a partition, got %s", rows[0][0])
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	hasDelete := false
+	for _, e := range events {
+		if e == "DELETE:"+jan {
+			hasDelete = true
+		}
+	}
+	if !hasDelete {
+		t.Errorf("Expected DELETE event for %s, got %v", jan, events)
+	}
+}
+
+func TestWatcher_JSONFile(t *testing.T) {
+	tmpDir := t.TempDir()
+	dbPath := filepath.Join(tmpDir, "test.db")
+
+	m, err := db.New(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create db: %v", err)
+	}
+	defer m.Close()
+
+	w, err := New(tmpDir, m)
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+
+	w.Start()
+	defer w.Stop()
+
+	// Create NDJSON file
+	jsonPath := filepath.Join(tmpDir, "events.ndjson")
+	err = os.WriteFile(jsonPath, []byte("{\"id\":1,\"user\":{\"name\":\"ann\"}}\n"), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	time.Sleep(1500 * time.Millisecond)
+
+	cols, err := m.GetTableInfo("events")
+	if err != nil || len(cols) != 2 || cols[1] != "user_name" {
+		t.Errorf("Expected events table with id, user_name, got %v (%v)", cols, err)
+	}
+}
+
+// workbookParts returns the parts of a workbook with a single sheet named
+// Data holding the given rows of inline strings
+func workbookParts(rows ...[]string) map[string]string {
+	var data strings.Builder
+	for _, row := range rows {
+		data.WriteString("<row>")
+		for _, value := range row {
+			fmt.Fprintf(&data, `<c t="inlineStr"><is><t>%s</t></is></c>`, value)
+		}
+		data.WriteString("</row>")
+	}
+	return map[string]string{
+		"xl/workbook.xml":            `<workbook xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets><sheet name="Data" sheetId="1" r:id="rId1"/></sheets></workbook>`,
+		"xl/_rels/workbook.xml.rels": `<Relationships><Relationship Id="rId1" Target="worksheets/sheet1.xml"/></Relationships>`,
+		"xl/worksheets/sheet1.xml":   `<worksheet><sheetData>` + data.String() + `</sheetData></worksheet>`,
+	}
+}
+
+func TestWatcher_Workbook(t *testing.T) {
+	tmpDir := t.TempDir()
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	book := filepath.Join(tmpDir, "report.xlsx")
+
+	m, err := db.New(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create db: %v", err)
+	}
+	defer m.Close()
+
+	w, err := New(tmpDir, m)
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	w.Start()
+	defer w.Stop()
+
+	writeZip(t, book, workbookParts([]string{"id"}, []string{"1"}))
+	time.Sleep(1500 * time.Millisecond)
+
+	tables, _ := m.ListTables()
+	if len(tables) != 1 || tables[0] != "report_data" {
+		t.Fatalf("Expected report_data, got %v", tables)
+	}
+
+	// Saving the workbook reloads its sheets
+	writeZip(t, book, workbookParts([]string{"id"}, []string{"1"}, []string{"2"}))
+	time.Sleep(1500 * time.Millisecond)
+
+	_, rows, _ := m.Query("SELECT COUNT(*) FROM report_data")
+	if rows[0][0] != "2" {
+		t.Errorf("Expected 2 rows after reload, got %s", rows[0][0])
+	}
+}
+
+func TestWatcher_FixedWidthLayout(t *testing.T) {
+	tmpDir := t.TempDir()
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	data := filepath.Join(tmpDir, "report.txt")
+	layout := data + ".layout"
+
+	m, err := db.New(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create db: %v", err)
+	}
+	defer m.Close()
+
+	w, err := New(tmpDir, m)
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	w.Start()
+	defer w.Stop()
+
+	// The data file alone is not loaded; its layout makes it a table
+	os.WriteFile(data, []byte("001ann\n002bob\n"), 0644)
+	time.Sleep(1500 * time.Millisecond)
+	if tables, _ := m.ListTables(); len(tables) != 0 {
+		t.Fatalf("Expected no tables without a layout, got %v", tables)
+	}
+
+	os.WriteFile(layout, []byte("id 1 3\nname 4 3\n"), 0644)
+	time.Sleep(1500 * time.Millisecond)
+	cols, err := m.GetTableInfo("report")
+	if err != nil || len(cols) != 2 {
+		t.Fatalf("Expected report with 2 columns, got %v (%v)", cols, err)
+	}
+
+	// Changing the layout reloads the data file
+	os.WriteFile(layout, []byte("id 1 3\nname 4 3\ninitial 4 1\n"), 0644)
+	time.Sleep(1500 * time.Millisecond)
+	cols, _ = m.GetTableInfo("report")
+	if len(cols) != 3 || cols[2] != "initial" {
+		t.Errorf("Expected reload with initial column, got %v", cols)
+	}
+
+	// Removing the layout drops the table
+	os.Remove(layout)
+	time.Sleep(1500 * time.Millisecond)
+	if tables, _ := m.ListTables(); len(tables) != 0 {
+		t.Errorf("Expected table dropped with its layout, got %v", tables)
+	}
+}
+
+func TestWatcher_Sidecar(t *testing.T) {
+	tmpDir := t.TempDir()
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	data := filepath.Join(tmpDir, "orders.csv")
+
+	m, err := db.New(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create db: %v", err)
+	}
+	defer m.Close()
+
+	w, err := New(tmpDir, m)
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	w.Start()
+	defer w.Stop()
+
+	os.WriteFile(data, []byte("id;amount\n1;N/A\n2;3.5\n"), 0644)
+	time.Sleep(1500 * time.Millisecond)
+	if _, rows, _ := m.Query("SELECT COUNT(amount) FROM orders"); len(rows) != 1 || rows[0][0] != "2" {
+		t.Fatalf("Expected N/A loaded as text, got %v", rows)
+	}
+
+	// Adding a sidecar reloads and renames the table
+	os.WriteFile(loader.SidecarPath(data), []byte("null_values: [N/A]\ntable: sales\n"), 0644)
+	time.Sleep(1500 * time.Millisecond)
+	_, rows, err := m.Query("SELECT COUNT(amount) FROM sales")
+	if err != nil || rows[0][0] != "1" {
+		t.Errorf("Expected sales with N/A as NULL, got %v (%v)", rows, err)
+	}
+
+	// So does a directory sidecar
+	os.WriteFile(filepath.Join(tmpDir, loader.DirConfigName), []byte("\"*.csv\":\n  header: false\n"), 0644)
+	time.Sleep(1500 * time.Millisecond)
+	_, rows, err = m.Query("SELECT COUNT(*) FROM sales")
+	if err != nil || rows[0][0] != "3" {
+		t.Errorf("Expected header row loaded as data, got %v (%v)", rows, err)
+	}
+}
+
+func TestWatcher_IgnoreFile(t *testing.T) {
+	tmpDir := t.TempDir()
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	scratch := filepath.Join(tmpDir, "scratch")
+	os.MkdirAll(scratch, 0755)
+	os.WriteFile(filepath.Join(tmpDir, loader.IgnoreFileName), []byte("scratch/\n"), 0644)
+
+	m, err := db.New(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create db: %v", err)
+	}
+	defer m.Close()
+
+	w, err := New(tmpDir, m)
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	w.Start()
+	defer w.Stop()
+
+	for _, dir := range w.fsWatcher.WatchList() {
+		if dir == scratch {
+			t.Fatalf("Expected ignored directory not to be watched")
+		}
+	}
+
+	os.WriteFile(filepath.Join(tmpDir, "orders.csv"), []byte("id\n1\n"), 0644)
+	time.Sleep(1500 * time.Millisecond)
+	if tables, _ := m.ListTables(); len(tables) != 1 {
+		t.Fatalf("Expected orders, got %v", tables)
+	}
+
+	// Un-ignoring the directory watches and loads it; ignoring a file drops its table
+	os.WriteFile(filepath.Join(scratch, "notes.csv"), []byte("id\n1\n"), 0644)
+	os.WriteFile(filepath.Join(tmpDir, loader.IgnoreFileName), []byte("orders.csv\n"), 0644)
+	time.Sleep(1500 * time.Millisecond)
+	tables, _ := m.ListTables()
+	if len(tables) != 1 || tables[0] != "notes" {
+		t.Errorf("Expected only notes, got %v", tables)
+	}
+}
@@ -0,0 +1,111 @@
+package csvql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"csvql/loader"
+)
+
+// writeBackInterval is how often edited tables are written back to their files
+const writeBackInterval = 500 * time.Millisecond
+
+// startWriteBack writes edited tables back every writeBackInterval until Close
+func (c *CSVQL) startWriteBack() {
+	c.stopWriteBack = make(chan struct{})
+	c.writeBackDone = make(chan struct{})
+	go func() {
+		defer close(c.writeBackDone)
+		ticker := time.NewTicker(writeBackInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-c.stopWriteBack:
+				return
+			case <-ticker.C:
+				if err := c.WriteBack(); err != nil {
+					fmt.Printf("Warning: failed to write back edits: %v\n", err)
+				}
+			}
+		}
+	}()
+}
+
+// WriteBack writes the tables edited since they were loaded back to their
+// files; see Options.WriteBack. Edits that cannot be written without losing
+// content, such as edits of compressed files or of files with malformed
+// rows, or of files that changed on disk since they were loaded, are
+// dropped with a warning. Other errors leave the edits pending.
+func (c *CSVQL) WriteBack() error {
+	c.writeBackMu.Lock()
+	defer c.writeBackMu.Unlock()
+
+	changes, err := c.DB.Changes()
+	if err != nil || len(changes) == 0 {
+		return err
+	}
+
+	mappings, err := c.DB.GetAllTableMappings()
+	if err != nil {
+		return fmt.Errorf("failed to get table mappings: %w", err)
+	}
+	files := make(map[string]string, len(mappings))
+	for filePath, tableName := range mappings {
+		files[tableName] = filePath
+	}
+
+	var errs []error
+	for tableName := range changes {
+		filePath, ok := files[tableName]
+		if !ok {
+			// The table was dropped by an edit
+			errs = append(errs, c.DB.DiscardChanges(tableName))
+			continue
+		}
+
+		err := c.writeBackTable(tableName, filePath)
+		if errors.Is(err, loader.ErrNotRewritable) {
+			fmt.Printf("Warning: edits to table %s are not written back: %v\n", tableName, err)
+			err = c.DB.DiscardChanges(tableName)
+		}
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// writeBackTable rewrites the file of an edited table from its rows
+func (c *CSVQL) writeBackTable(tableName, filePath string) error {
+	modTime, err := loader.FileModTime(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", filePath, err)
+	}
+	if c.DB.NeedsUpdate(tableName, modTime) {
+		return fmt.Errorf("%w: %s changed on disk since it was loaded", loader.ErrNotRewritable, filePath)
+	}
+	if n, err := c.DB.FileRowErrorCount(filePath); err != nil || n > 0 {
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("%w: %d malformed row(s) of %s are not in its table", loader.ErrNotRewritable, n, filePath)
+	}
+
+	snap, err := c.DB.ReadTable(context.Background(), tableName)
+	if err != nil {
+		return err
+	}
+	modTime, err = loader.RewriteFile(filePath, c.fileConfig(filePath), snap.Columns(), snap.Rows())
+	snap.Close()
+	if err != nil {
+		return err
+	}
+
+	if err := c.DB.MarkWrittenBack(tableName, filePath, modTime, snap); err != nil {
+		return err
+	}
+	if c.OnChange != nil {
+		c.OnChange("WRITE", filePath)
+	}
+	return nil
+}